@@ -48,6 +48,19 @@ type AllocationRequest struct {
 	// podRef is a reference to the gated Pod requesting the allocation
 	// +optional
 	PodRef corev1.ObjectReference `json:"podRef"`
+
+	// priorityClassName is the priority class of the Pod, used to account the allocation against reserved capacity tiers
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// ciEngProfileId is the compute instance engineering profile the daemonset creates the compute instance with
+	// +optional
+	CIEngProfileID int32 `json:"ciEngProfileId,omitempty"`
+
+	// sliceIndex tells apart the slices of a Pod requesting more than one slice of the profile,
+	// the allocation of slice n > 0 is keyed by the Pod UID suffixed with -n
+	// +optional
+	SliceIndex int32 `json:"sliceIndex,omitempty"`
 }
 
 type AllocationStatus struct {
@@ -87,6 +100,14 @@ type AllocationResult struct {
 	// configMapResourceIdentifier represents the UUID used for creating the ConfigMap resource
 	// +required
 	ConfigMapResourceIdentifier types.UID `json:"configMapResourceIdentifier"`
+
+	// policy records the allocation policy that produced the allocation
+	// +optional
+	Policy string `json:"policy,omitempty"`
+
+	// allocatedAt records when the controller made the allocation
+	// +optional
+	AllocatedAt *metav1.Time `json:"allocatedAt,omitempty"`
 }
 
 type DiscoveredGPU struct {
@@ -145,10 +166,70 @@ type Placement struct {
 	Start int32 `json:"start"`
 }
 
+type AllocationSample struct {
+	// timestamp represents the time the sample was taken
+	// +required
+	Timestamp metav1.Time `json:"timestamp"`
+
+	// count represents the number of allocations held on the GPU
+	// +required
+	Count int32 `json:"count"`
+}
+
+// GPUStatus accounts the slice units of a GPU and the pods holding them
+type GPUStatus struct {
+	// totalSlices is the number of slice units of the GPU
+	// +required
+	TotalSlices int32 `json:"totalSlices"`
+
+	// usedSlices is the number of slice units held by allocations
+	// +required
+	UsedSlices int32 `json:"usedSlices"`
+
+	// freeSlices is the number of slice units not held by any allocation
+	// +required
+	FreeSlices int32 `json:"freeSlices"`
+
+	// occupants lists the pods holding slices on the GPU as namespace/name
+	// +optional
+	Occupants []string `json:"occupants,omitempty"`
+}
+
+// ReservationWindow books GPUs of the node for the pods of a namespace over a time window
+type ReservationWindow struct {
+	// gpuUUIDs lists the UUIDs of the booked GPUs
+	// +required
+	GPUUUIDs []string `json:"gpuUUIDs"`
+
+	// namespace owns the booking, only its pods allocate on the booked GPUs during the window
+	// +required
+	Namespace string `json:"namespace"`
+
+	// start is the time the booking begins
+	// +required
+	Start metav1.Time `json:"start"`
+
+	// end is the time the booking ends
+	// +required
+	End metav1.Time `json:"end"`
+}
+
 type InstasliceSpec struct {
 	// podAllocationRequests specifies the allocation requests per pod
 	// +optional
 	PodAllocationRequests map[types.UID]AllocationRequest `json:"podAllocationRequests"`
+
+	// gpuPools partitions the GPUs of the node into named pools, keyed by pool name with the UUIDs of the member GPUs
+	// +optional
+	GPUPools map[string][]string `json:"gpuPools,omitempty"`
+
+	// disabledGPUs lists the UUIDs of GPUs that no new slices are placed on and whose existing slices are drained
+	// +optional
+	DisabledGPUs []string `json:"disabledGPUs,omitempty"`
+
+	// reservationWindows books GPUs of the node for the pods of a namespace over a time window
+	// +optional
+	ReservationWindows []ReservationWindow `json:"reservationWindows,omitempty"`
 }
 
 type InstasliceStatus struct {
@@ -182,10 +263,36 @@ type InstasliceStatus struct {
 	// nodeResources specifies the discovered resources of the node
 	// +optional
 	NodeResources DiscoveredNodeResources `json:"nodeResources"`
+
+	// allocationHistory records a bounded series of allocation counts per GPU UUID
+	// +optional
+	AllocationHistory map[string][]AllocationSample `json:"allocationHistory,omitempty"`
+
+	// gpuStatus accounts the slice units of every GPU, keyed by GPU UUID
+	// +optional
+	GPUStatus map[string]GPUStatus `json:"gpuStatus,omitempty"`
+
+	// totalSlices is the number of slice units of all GPUs of the node
+	// +optional
+	TotalSlices int32 `json:"totalSlices,omitempty"`
+
+	// usedSlices is the number of slice units of the node held by allocations
+	// +optional
+	UsedSlices int32 `json:"usedSlices,omitempty"`
+
+	// freeSlices is the number of slice units of the node not held by any allocation
+	// +optional
+	FreeSlices int32 `json:"freeSlices,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.totalSlices`,priority=1
+//+kubebuilder:printcolumn:name="Used",type=integer,JSONPath=`.status.usedSlices`,priority=1
+//+kubebuilder:printcolumn:name="Free",type=integer,JSONPath=`.status.freeSlices`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Instaslice is the Schema for the instaslices API
 // +kubebuilder:validation:Required
@@ -56,6 +56,10 @@ func (in *AllocationResult) DeepCopyInto(out *AllocationResult) {
 	}
 	out.MigPlacement = in.MigPlacement
 	out.AllocationStatus = in.AllocationStatus
+	if in.AllocatedAt != nil {
+		in, out := &in.AllocatedAt, &out.AllocatedAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllocationResult.
@@ -68,6 +72,22 @@ func (in *AllocationResult) DeepCopy() *AllocationResult {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AllocationSample) DeepCopyInto(out *AllocationSample) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllocationSample.
+func (in *AllocationSample) DeepCopy() *AllocationSample {
+	if in == nil {
+		return nil
+	}
+	out := new(AllocationSample)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AllocationStatus) DeepCopyInto(out *AllocationStatus) {
 	*out = *in
@@ -135,6 +155,26 @@ func (in *DiscoveredNodeResources) DeepCopy() *DiscoveredNodeResources {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GPUStatus) DeepCopyInto(out *GPUStatus) {
+	*out = *in
+	if in.Occupants != nil {
+		in, out := &in.Occupants, &out.Occupants
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GPUStatus.
+func (in *GPUStatus) DeepCopy() *GPUStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GPUStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Instaslice) DeepCopyInto(out *Instaslice) {
 	*out = *in
@@ -204,6 +244,34 @@ func (in *InstasliceSpec) DeepCopyInto(out *InstasliceSpec) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.GPUPools != nil {
+		in, out := &in.GPUPools, &out.GPUPools
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.DisabledGPUs != nil {
+		in, out := &in.DisabledGPUs, &out.DisabledGPUs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReservationWindows != nil {
+		in, out := &in.ReservationWindows, &out.ReservationWindows
+		*out = make([]ReservationWindow, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstasliceSpec.
@@ -234,6 +302,31 @@ func (in *InstasliceStatus) DeepCopyInto(out *InstasliceStatus) {
 		}
 	}
 	in.NodeResources.DeepCopyInto(&out.NodeResources)
+	if in.AllocationHistory != nil {
+		in, out := &in.AllocationHistory, &out.AllocationHistory
+		*out = make(map[string][]AllocationSample, len(*in))
+		for key, val := range *in {
+			var outVal []AllocationSample
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]AllocationSample, len(*in))
+				for i := range *in {
+					(*in)[i].DeepCopyInto(&(*out)[i])
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.GPUStatus != nil {
+		in, out := &in.GPUStatus, &out.GPUStatus
+		*out = make(map[string]GPUStatus, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstasliceStatus.
@@ -280,3 +373,25 @@ func (in *Placement) DeepCopy() *Placement {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReservationWindow) DeepCopyInto(out *ReservationWindow) {
+	*out = *in
+	if in.GPUUUIDs != nil {
+		in, out := &in.GPUUUIDs, &out.GPUUUIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.Start.DeepCopyInto(&out.Start)
+	in.End.DeepCopyInto(&out.End)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReservationWindow.
+func (in *ReservationWindow) DeepCopy() *ReservationWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(ReservationWindow)
+	in.DeepCopyInto(out)
+	return out
+}
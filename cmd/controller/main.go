@@ -21,9 +21,11 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
+	"go.opentelemetry.io/otel"
 	zaplog "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -64,6 +66,12 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var allocationPolicy string
+	var gracefulDeletionTimeout time.Duration
+	var logLevels string
+	var enablePreemption bool
+	var gateName string
+	var finalizerName string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -73,6 +81,24 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&allocationPolicy, "allocation-policy", "",
+		"The policy placing slices: first-fit, left-to-right, right-to-left, best-fit or worst-fit. "+
+			"Overrides the ALLOCATION_POLICY environment variable, first-fit when neither is set.")
+	flag.DurationVar(&gracefulDeletionTimeout, "graceful-deletion-timeout", config.DefaultGracefulDeletionTimeout,
+		"How long the slices of a deleted pod are kept before they are released, e.g. to checkpoint on SIGTERM. "+
+			"Overrides the GRACEFUL_DELETION_TIMEOUT environment variable.")
+	flag.StringVar(&logLevels, "log-levels", "",
+		"Comma separated subsystem=verbosity pairs for the allocation, deletion and readiness subsystems, e.g. allocation=1. "+
+			"Overrides the LOG_LEVELS environment variable.")
+	flag.BoolVar(&enablePreemption, "enable-preemption", false,
+		"If set, the slices of strictly lower priority pods are released for pods that find no free slice. "+
+			"Overrides the ENABLE_PREEMPTION environment variable.")
+	flag.StringVar(&gateName, "gate-name", "",
+		"The scheduling gate holding pods until their slices are ready, e.g. to run a staging operator side by side. "+
+			"Overrides the GATE_NAME environment variable.")
+	flag.StringVar(&finalizerName, "finalizer-name", "",
+		"The finalizer keeping pods until their slices are released. "+
+			"Overrides the FINALIZER_NAME environment variable.")
 	opts := zap.Options{
 		TimeEncoder: zapcore.RFC3339NanoTimeEncoder,
 		ZapOpts:     []zaplog.Option{zaplog.AddCaller()},
@@ -80,7 +106,42 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	var flagLogLevels map[string]int
+	if logLevels != "" {
+		flagLogLevels = config.ParseLogLevels(logLevels)
+	}
+	config := config.ConfigFromEnvironment()
+	if allocationPolicy != "" {
+		config.AllocationPolicy = allocationPolicy
+	}
+	if gateName != "" {
+		config.GateName = gateName
+	}
+	if finalizerName != "" {
+		config.FinalizerName = finalizerName
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "graceful-deletion-timeout":
+			config.GracefulDeletionTimeout = gracefulDeletionTimeout
+		case "enable-preemption":
+			config.EnablePreemption = enablePreemption
+		}
+	})
+	if flagLogLevels != nil {
+		config.LogLevels = flagLogLevels
+	}
+
+	// the subsystems may log more verbosely than the rest, zap has to let their messages through
+	verbosity := logVerbosity(&opts)
+	maxVerbosity := verbosity
+	for _, level := range config.LogLevels {
+		maxVerbosity = max(maxVerbosity, level)
+	}
+	if maxVerbosity > verbosity {
+		opts.Level = zaplog.NewAtomicLevelAt(zapcore.Level(-maxVerbosity))
+	}
+	ctrl.SetLogger(controller.NewSubsystemLogger(zap.New(zap.UseFlagOptions(&opts)), verbosity, config.LogLevels))
 
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
@@ -132,8 +193,15 @@ func main() {
 		os.Exit(1)
 	}
 
-	config := config.ConfigFromEnvironment()
+	policy, err := controller.PolicyFromName(config.AllocationPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid allocation policy")
+		os.Exit(1)
+	}
 	setupLog.Info("using config", "config", config.ToString())
+	if err := mgr.AddMetricsServerExtraHandler("/config", config.Handler()); err != nil {
+		setupLog.Error(err, "unable to serve the config endpoint")
+	}
 	runningOnOpenShift := utils.RunningOnOpenshift(context.Background(), mgr.GetClient())
 	if runningOnOpenShift {
 		setupLog.Info("Running on OpenShift")
@@ -141,19 +209,62 @@ func main() {
 
 	if config.WebhookEnable {
 		mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: &controller.PodAnnotator{
-			Client: mgr.GetClient(), Decoder: admission.NewDecoder(mgr.GetScheme()),
+			Client: mgr.GetClient(), Decoder: admission.NewDecoder(mgr.GetScheme()), Config: config,
 		}})
+		mgr.GetWebhookServer().Register("/validate-v1-pod", &webhook.Admission{Handler: &controller.ProfileValidator{
+			Client: mgr.GetClient(), Decoder: admission.NewDecoder(mgr.GetScheme()), Config: config,
+		}})
+	}
+
+	var accounting *controller.AccountingHook
+	if config.AccountingWebhookURL != "" {
+		accounting = controller.NewAccountingHook(config.AccountingWebhookURL)
+		if err := mgr.Add(accounting); err != nil {
+			setupLog.Error(err, "unable to set up accounting hook")
+			os.Exit(1)
+		}
+	}
+
+	var teardown *controller.TeardownHook
+	if config.TeardownWebhookURL != "" {
+		teardown = controller.NewTeardownHook(config.TeardownWebhookURL, config.TeardownApprovalTimeout)
+	}
+
+	if config.TracingEndpoint != "" {
+		tracerProvider, err := controller.NewTracerProvider(context.Background(), config.TracingEndpoint)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		otel.SetTracerProvider(tracerProvider)
+		// flush the spans still batched once the manager stops
+		defer func() {
+			_ = tracerProvider.Shutdown(context.Background())
+		}()
 	}
 
-	if err = (&controller.InstasliceReconciler{
+	reconciler := &controller.InstasliceReconciler{
 		Client:             mgr.GetClient(),
+		APIReader:          mgr.GetAPIReader(),
 		Scheme:             mgr.GetScheme(),
 		Config:             config,
 		RunningOnOpenShift: runningOnOpenShift,
-	}).SetupWithManager(mgr); err != nil {
+		Recorder:           mgr.GetEventRecorderFor("instaslice-controller"),
+		Accounting:         accounting,
+		Teardown:           teardown,
+		Policy:             policy,
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Instaslice")
 		os.Exit(1)
 	}
+	// external controllers managing pod lifecycles out-of-band release allocations explicitly
+	if err := mgr.AddMetricsServerExtraHandler("/release", reconciler.ReleaseHandler()); err != nil {
+		setupLog.Error(err, "unable to serve the release endpoint")
+	}
+	if err := mgr.AddMetricsServerExtraHandler("/packing", reconciler.PackingHandler()); err != nil {
+		setupLog.Error(err, "unable to serve the packing endpoint")
+	}
 
 	// if err = (&controller.InstaSliceDaemonsetReconciler{
 	// 	Client: mgr.GetClient(),
@@ -190,3 +301,20 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// logVerbosity returns the highest logr verbosity the zap options log, info is verbosity 0
+func logVerbosity(opts *zap.Options) int {
+	level := opts.Level
+	if level == nil {
+		// the development logger logs debug messages by default
+		if opts.Development {
+			return 1
+		}
+		return 0
+	}
+	verbosity := 0
+	for level.Enabled(zapcore.Level(-(verbosity + 1))) && verbosity < 127 {
+		verbosity++
+	}
+	return verbosity
+}
@@ -146,6 +146,7 @@ func main() {
 		setupLog.Error(err, "could not create daemonset reconciler")
 		os.Exit(1)
 	}
+	reconciler.APIReader = mgr.GetAPIReader()
 
 	if err := reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "InstaSliceDaemonsetReconciler")
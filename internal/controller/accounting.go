@@ -0,0 +1,155 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+const (
+	AccountingEventAllocate = "allocate"
+	AccountingEventRelease  = "release"
+
+	// accountingBufferSize bounds the records waiting for delivery, records beyond it are dropped
+	accountingBufferSize = 1000
+	accountingRetries    = 3
+	accountingBackoff    = time.Second
+)
+
+// AccountingRecord is the structured record sent to external accounting and billing systems
+type AccountingRecord struct {
+	Event     string    `json:"event"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Profile   string    `json:"profile"`
+	Node      string    `json:"node"`
+	GPU       string    `json:"gpu"`
+	Timestamp time.Time `json:"timestamp"`
+	// DurationSeconds is how long the slice was held, it is set on release
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+}
+
+// AccountingHook posts accounting records to an external endpoint. Records are queued in a bounded
+// buffer and delivered in the background so that a slow or unavailable endpoint never holds up
+// allocations.
+type AccountingHook struct {
+	URL     string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+	records chan AccountingRecord
+}
+
+func NewAccountingHook(url string) *AccountingHook {
+	return &AccountingHook{
+		URL:     url,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Retries: accountingRetries,
+		Backoff: accountingBackoff,
+		records: make(chan AccountingRecord, accountingBufferSize),
+	}
+}
+
+// Emit queues the record for delivery without blocking, it reports false when the record was dropped
+func (h *AccountingHook) Emit(record AccountingRecord) bool {
+	if h == nil {
+		return false
+	}
+	select {
+	case h.records <- record:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start delivers the queued records until the context is done, it runs as a manager runnable
+func (h *AccountingHook) Start(ctx context.Context) error {
+	log := logr.FromContext(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case record := <-h.records:
+			if err := h.deliver(ctx, record); err != nil {
+				log.Error(err, "unable to deliver accounting record", "event", record.Event, "pod", record.Pod)
+			}
+		}
+	}
+}
+
+// deliver posts the record, retrying with an exponential backoff
+func (h *AccountingHook) deliver(ctx context.Context, record AccountingRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		if err = h.post(ctx, body); err == nil || attempt >= h.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.Backoff << attempt):
+		}
+	}
+}
+
+func (h *AccountingHook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("accounting endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// newAccountingRecord builds the record of an allocation for the event
+func newAccountingRecord(event string, allocRequest *inferencev1alpha1.AllocationRequest, allocResult *inferencev1alpha1.AllocationResult) AccountingRecord {
+	now := time.Now()
+	record := AccountingRecord{
+		Event:     event,
+		Namespace: allocRequest.PodRef.Namespace,
+		Pod:       allocRequest.PodRef.Name,
+		Profile:   allocRequest.Profile,
+		Node:      string(allocResult.Nodename),
+		GPU:       allocResult.GPUUUID,
+		Timestamp: now,
+	}
+	if event == AccountingEventRelease && allocResult.AllocatedAt != nil {
+		record.DurationSeconds = now.Sub(allocResult.AllocatedAt.Time).Seconds()
+	}
+	return record
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestAccountingHook_AllocateAndRelease(t *testing.T) {
+	received := make(chan AccountingRecord, 10)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the endpoint is briefly unavailable, the first delivery is retried
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		record := AccountingRecord{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&record))
+		received <- record
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	hook := NewAccountingHook(server.URL)
+	hook.Backoff = time.Millisecond
+	go func() {
+		_ = hook.Start(ctx)
+	}()
+
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+	r.Accounting = hook
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	nextRecord := func() AccountingRecord {
+		select {
+		case record := <-received:
+			return record
+		case <-time.After(5 * time.Second):
+			t.Fatal("no accounting record delivered")
+			return AccountingRecord{}
+		}
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	allocated := nextRecord()
+	assert.Equal(t, AccountingEventAllocate, allocated.Event)
+	assert.Equal(t, "default", allocated.Namespace)
+	assert.Equal(t, "pod-1", allocated.Pod)
+	assert.Equal(t, "1g.5gb", allocated.Profile)
+	assert.Equal(t, "node-1", allocated.Node)
+	assert.NotEmpty(t, allocated.GPU)
+	assert.Zero(t, allocated.DurationSeconds)
+
+	// the pod completes and its slice is released
+	completed := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, completed))
+	completed.Spec.SchedulingGates = nil
+	assert.NoError(t, fakeClient.Update(ctx, completed))
+	completed.Status.Phase = v1.PodSucceeded
+	assert.NoError(t, fakeClient.Status().Update(ctx, completed))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	released := nextRecord()
+	assert.Equal(t, AccountingEventRelease, released.Event)
+	assert.Equal(t, "pod-1", released.Pod)
+	assert.Equal(t, allocated.GPU, released.GPU)
+	assert.Greater(t, released.DurationSeconds, 0.0)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestAccountingHook_BoundedBuffer(t *testing.T) {
+	hook := NewAccountingHook("http://localhost")
+	for i := 0; i < accountingBufferSize; i++ {
+		assert.True(t, hook.Emit(AccountingRecord{Event: AccountingEventAllocate}))
+	}
+	// nothing drains the buffer, further records are dropped instead of blocking
+	assert.False(t, hook.Emit(AccountingRecord{Event: AccountingEventAllocate}))
+
+	var disabled *AccountingHook
+	assert.False(t, disabled.Emit(AccountingRecord{Event: AccountingEventAllocate}))
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// allocationPodUIDIndex indexes the Instaslice objects by the UIDs of the pods they hold allocations for.
+// The index is kept by the informer of the cache, an Instaslice is re-indexed on every update and dropped
+// on delete, so a lookup never returns allocations that are gone.
+const allocationPodUIDIndex = "podAllocationUID"
+
+// indexAllocationPodUIDs returns the UIDs of the pods holding an allocation on the Instaslice. The
+// allocation keys are indexed as well, the first slice of a pod is keyed by the pod UID, which covers
+// allocations without a pod reference and results left without a request.
+func indexAllocationPodUIDs(obj client.Object) []string {
+	instaslice, ok := obj.(*inferencev1alpha1.Instaslice)
+	if !ok {
+		return nil
+	}
+	seen := map[types.UID]struct{}{}
+	var uids []string
+	add := func(uid types.UID) {
+		if _, ok := seen[uid]; ok || uid == "" {
+			return
+		}
+		seen[uid] = struct{}{}
+		uids = append(uids, string(uid))
+	}
+	for key, allocRequest := range instaslice.Spec.PodAllocationRequests {
+		add(key)
+		add(allocRequest.PodRef.UID)
+	}
+	for key := range instaslice.Status.PodAllocationResults {
+		add(key)
+	}
+	return uids
+}
+
+// listPodInstaslices lists only the Instaslice objects holding an allocation of the pod, instead of
+// scanning the allocations of every node
+func (r *InstasliceReconciler) listPodInstaslices(ctx context.Context, podUID types.UID) ([]inferencev1alpha1.Instaslice, error) {
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.MatchingFields{allocationPodUIDIndex: string(podUID)}); err != nil {
+		return nil, err
+	}
+	return instasliceList.Items, nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestListPodInstaslices(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	other := newTestGatedPod("pod-2", "1g.5gb")
+	r, fakeClient := newTestReconciler(t, pod, other,
+		newTestAllocation("node-a", pod, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}),
+		newTestAllocation("node-b", other, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}))
+
+	instaslices, err := r.listPodInstaslices(ctx, pod.UID)
+	assert.NoError(t, err)
+	if assert.Len(t, instaslices, 1) {
+		assert.Equal(t, "node-a", instaslices[0].Name)
+	}
+	assert.Len(t, podSlices(pod.UID, instaslices), 1)
+
+	// a removed allocation is no longer found
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	delete(instaslice.Spec.PodAllocationRequests, pod.UID)
+	assert.NoError(t, fakeClient.Update(ctx, instaslice))
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	delete(instaslice.Status.PodAllocationResults, pod.UID)
+	assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	instaslices, err = r.listPodInstaslices(ctx, pod.UID)
+	assert.NoError(t, err)
+	assert.Empty(t, instaslices)
+
+	// so is the allocation of a deleted Instaslice
+	assert.NoError(t, fakeClient.Delete(ctx, instaslice))
+	instaslices, err = r.listPodInstaslices(ctx, other.UID)
+	assert.NoError(t, err)
+	assert.Len(t, instaslices, 1)
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-b", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	assert.NoError(t, fakeClient.Delete(ctx, instaslice))
+	instaslices, err = r.listPodInstaslices(ctx, other.UID)
+	assert.NoError(t, err)
+	assert.Empty(t, instaslices)
+}
+
+// benchmarkInstaslices returns Instaslice objects for n nodes, each holding an allocation of its own pod
+func benchmarkInstaslices(n int) []inferencev1alpha1.Instaslice {
+	instaslices := make([]inferencev1alpha1.Instaslice, 0, n)
+	for i := 0; i < n; i++ {
+		pod := newTestGatedPod(fmt.Sprintf("pod-%d", i), "1g.5gb")
+		instaslices = append(instaslices, *newTestAllocation(fmt.Sprintf("node-%d", i), pod,
+			inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusUngated}))
+	}
+	return instaslices
+}
+
+// BenchmarkPodSlices looks up the allocation of a pod among 500 Instaslice objects by scanning all of them
+func BenchmarkPodSlices(b *testing.B) {
+	instaslices := benchmarkInstaslices(500)
+	podUID := types.UID("pod-499-uid")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(podSlices(podUID, instaslices)) != 1 {
+			b.Fatal("allocation not found")
+		}
+	}
+}
+
+// BenchmarkPodSlicesIndexed looks up the allocation of a pod among 500 Instaslice objects through the index
+// the informer of the cache keeps
+func BenchmarkPodSlicesIndexed(b *testing.B) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		allocationPodUIDIndex: func(obj interface{}) ([]string, error) {
+			return indexAllocationPodUIDs(obj.(client.Object)), nil
+		},
+	})
+	for _, instaslice := range benchmarkInstaslices(500) {
+		if err := indexer.Add(instaslice.DeepCopy()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	podUID := types.UID("pod-499-uid")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		objs, err := indexer.ByIndex(allocationPodUIDIndex, string(podUID))
+		if err != nil {
+			b.Fatal(err)
+		}
+		instaslices := make([]inferencev1alpha1.Instaslice, 0, len(objs))
+		for _, obj := range objs {
+			instaslices = append(instaslices, *obj.(*inferencev1alpha1.Instaslice))
+		}
+		if len(podSlices(podUID, instaslices)) != 1 {
+			b.Fatal("allocation not found")
+		}
+	}
+}
+
+// BenchmarkReconcileAllocatedPod reconciles a running pod holding its slice among 500 Instaslice objects. Only
+// the index lookup of its slice is left, the fake client serves it by scanning every object where the cache of
+// the manager looks it up.
+func BenchmarkReconcileAllocatedPod(b *testing.B) {
+	ctx := context.TODO()
+	objs := make([]client.Object, 0, 501)
+	for i, instaslice := range benchmarkInstaslices(500) {
+		for key, allocResult := range instaslice.Status.PodAllocationResults {
+			allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+			instaslice.Status.PodAllocationResults[key] = allocResult
+		}
+		objs = append(objs, instaslice.DeepCopy())
+		if i == 499 {
+			pod := newTestGatedPod(fmt.Sprintf("pod-%d", i), "1g.5gb")
+			pod.Spec.SchedulingGates = nil
+			pod.Finalizers = []string{FinalizerName}
+			objs = append(objs, pod)
+		}
+	}
+	r, _ := newTestReconciler(b, objs...)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "pod-499", Namespace: "default"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// allocationBackoffBase is the delay before allocation is retried for a pod the first time no node fits it
+const allocationBackoffBase = 1 * time.Second
+
+// allocationBackoff doubles per pod the delay before allocation is retried while no node fits the pod, across
+// reconciles, so that transient fullness recovers quickly while persistently unschedulable pods back off
+type allocationBackoff struct {
+	mu     sync.Mutex
+	delays map[types.UID]time.Duration
+}
+
+// next returns the delay before allocation is retried for the pod, twice the previous one capped at max
+func (b *allocationBackoff) next(podUID types.UID, max time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.delays == nil {
+		b.delays = make(map[types.UID]time.Duration)
+	}
+	delay := allocationBackoffBase
+	if previous, ok := b.delays[podUID]; ok {
+		delay = 2 * previous
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	b.delays[podUID] = delay
+	return delay
+}
+
+// forget resets the backoff of the pod once it no longer waits for an allocation
+func (b *allocationBackoff) forget(podUID types.UID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.delays, podUID)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestReconcile_AllocationBackoff(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	// every GPU of the only node is disabled, no node fits the pod
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		instaslice.Spec.DisabledGPUs = append(instaslice.Spec.DisabledGPUs, gpu.GPUUUID)
+	}
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+	r.Config.MaxAllocationBackoff = 4 * time.Second
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	// the retries are spaced out further on every reconcile that finds no node, up to the cap
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		result, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result.RequeueAfter)
+	}
+
+	// the backoff is reset once the pod is allocated
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	instaslice.Spec.DisabledGPUs = nil
+	assert.NoError(t, fakeClient.Update(ctx, instaslice))
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	var instasliceList inferencev1alpha1.InstasliceList
+	assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+	assert.True(t, hasPodAllocation(pod.UID, instasliceList.Items))
+	assert.Equal(t, time.Second, r.allocationBackoff.next(pod.UID, r.Config.MaxAllocationBackoff))
+}
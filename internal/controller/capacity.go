@@ -18,61 +18,370 @@ package controller
 
 import (
 	"context"
+	goerror "errors"
 	"fmt"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+var (
+	// ErrProfileUnknown no GPU offers the requested profile, the pod cannot be allocated until it requests
+	// another profile or a GPU offering the profile joins the cluster
+	ErrProfileUnknown = goerror.New("profile is not offered by any GPU")
+	// ErrProfileFull GPUs offer the requested profile but all its placements are occupied, the pod is
+	// allocated once a slice is released
+	ErrProfileFull = goerror.New("failed to find allocatable node and gpu")
+	// ErrInvalidPlacement the placement computed for a slice exceeds the GPU or overlaps a slice on it, which
+	// points at a faulty policy or a corrupted Instaslice object rather than at missing capacity
+	ErrInvalidPlacement = goerror.New("invalid slice placement")
+)
+
+// noCapacityError tells apart a profile none of the Instaslice objects offers from a profile whose
+// placements are all occupied
+func noCapacityError(instaslices []inferencev1alpha1.Instaslice, profileName string) error {
+	for i := range instaslices {
+		if _, ok := instaslices[i].Status.NodeResources.MigPlacement[profileName]; ok {
+			return fmt.Errorf("%w for profile %s", ErrProfileFull, profileName)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrProfileUnknown, profileName)
+}
+
 // checks the classical resources like CPU and memory and continuous GPU index available
 // before making an allocation.
 
 // find node, gpu and gpu index to place the slice
 func (r *InstasliceReconciler) findNodeAndDeviceForASlice(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult, error) {
-	updatedInstaSliceObject, err := r.getInstasliceObject(ctx, instaslice.Name, instaslice.Namespace)
+	details, err := r.findNodeAndDevicesForSlices(ctx, instaslice, profileName, policy, pod, 1)
 	if err != nil {
 		return nil, nil, err
 	}
+	return details[0].Request, details[0].Result, nil
+}
 
-	availableResources := r.availableClassicalResourcesOnNode(updatedInstaSliceObject)
-	nodeAvailableCpu := availableResources[v1.ResourceCPU]
-	nodeAvailableMemory := availableResources[v1.ResourceMemory]
+// findNodeAndDevicesForSlices finds the GPUs and GPU indexes to place count slices of the profile on the
+// node of the Instaslice object, the slices of a pod always share its node
+func (r *InstasliceReconciler) findNodeAndDevicesForSlices(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod, count int32) ([]*AllocationDetails, error) {
+	updatedInstaSliceObject, err := r.getInstasliceObject(ctx, instaslice.Name, instaslice.Namespace)
+	if err != nil {
+		return nil, err
+	}
 
-	cpuRequest, cpuOk := pod.Spec.Containers[0].Resources.Requests[v1.ResourceCPU]
+	forbidden, err := r.isProfileForbiddenOnNode(ctx, updatedInstaSliceObject.Name, profileName)
+	if err != nil {
+		return nil, err
+	}
+	if forbidden {
+		return nil, fmt.Errorf("profile %s is forbidden on node %s", profileName, updatedInstaSliceObject.Name)
+	}
+
+	excluded, err := r.isPreemptibleNodeExcluded(ctx, updatedInstaSliceObject.Name, pod)
+	if err != nil {
+		return nil, err
+	}
+	if excluded {
+		return nil, fmt.Errorf("pod %s does not tolerate the preemptible node %s", pod.Name, updatedInstaSliceObject.Name)
+	}
+
+	drainStart, windowStart, draining, err := r.upgradeDrainStart(ctx, updatedInstaSliceObject.Name, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if draining {
+		return nil, fmt.Errorf("node %s drains since %s for the upgrade window opening at %s", updatedInstaSliceObject.Name,
+			drainStart.Format(time.RFC3339), windowStart.Format(time.RFC3339))
+	}
+
+	container, err := gpuContainer(pod.Spec.Containers)
+	if err != nil {
+		return nil, fmt.Errorf("%v, pod: %v", err, pod.Name)
+	}
+	cpuRequest, cpuOk := container.Resources.Requests[v1.ResourceCPU]
 	if cpuOk {
 		log.FromContext(ctx).Info("cpu request obtained", "pod", pod.Name, "value", cpuRequest.String())
 	} else {
 		log.FromContext(ctx).Info("cpu request not set for", "pod", pod.Name)
 	}
-	memoryRequest, memOk := pod.Spec.Containers[0].Resources.Requests[v1.ResourceMemory]
+	memoryRequest, memOk := container.Resources.Requests[v1.ResourceMemory]
 	if memOk {
 		log.FromContext(ctx).Info("memory request obtained", "pod", pod.Name, "value", memoryRequest.String())
 	} else {
 		log.FromContext(ctx).Info("memory request not set for", "pod", pod.Name)
 	}
 
+	affinity, err := r.nvlinkAffinity(ctx, updatedInstaSliceObject, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.placeSlicesOnInstaslice(updatedInstaSliceObject, profileName, policy, pod, count, affinity, time.Now())
+}
+
+// placeSlicesOnInstaslice places count slices of the profile for the pod on the Instaslice object, every
+// slice is booked on the object before the next one is placed. The CPU and memory of the pod are
+// accounted on its first slice only. The GPUs are tried in the order of their NVLink affinity for the pod,
+// when it has one.
+func (r *InstasliceReconciler) placeSlicesOnInstaslice(updatedInstaSliceObject *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod, count int32, affinity map[string]int, now time.Time) ([]*AllocationDetails, error) {
+	details := make([]*AllocationDetails, 0, count)
+	for sliceIndex := int32(0); sliceIndex < count; sliceIndex++ {
+		allocRequest, allocResult, err := r.placeOnInstaslice(updatedInstaSliceObject, profileName, policy, pod, affinity, now)
+		if err != nil {
+			return nil, err
+		}
+		allocRequest.SliceIndex = sliceIndex
+		if sliceIndex > 0 {
+			allocRequest.Resources = v1.ResourceRequirements{}
+		}
+		bookAllocation(updatedInstaSliceObject, allocRequest, allocResult)
+		details = append(details, &AllocationDetails{Request: allocRequest, Result: allocResult})
+	}
+	return details, nil
+}
+
+// bookAllocation adds the allocation to the Instaslice object so that later placements see it
+func bookAllocation(instaslice *inferencev1alpha1.Instaslice, allocRequest *inferencev1alpha1.AllocationRequest, allocResult *inferencev1alpha1.AllocationResult) {
+	if instaslice.Spec.PodAllocationRequests == nil {
+		instaslice.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
+	}
+	if instaslice.Status.PodAllocationResults == nil {
+		instaslice.Status.PodAllocationResults = make(map[types.UID]inferencev1alpha1.AllocationResult)
+	}
+	key := utils.SliceAllocationKey(allocRequest.PodRef.UID, allocRequest.SliceIndex)
+	instaslice.Spec.PodAllocationRequests[key] = *allocRequest
+	instaslice.Status.PodAllocationResults[key] = *allocResult
+}
+
+// AllocationDetails is the placement computed for a pod
+type AllocationDetails struct {
+	Request *inferencev1alpha1.AllocationRequest
+	Result  *inferencev1alpha1.AllocationResult
+}
+
+// WhatIf computes the placement the pod would get on the Instaslice objects without reading or mutating the
+// cluster, which lets tests and tooling preview policy decisions. Instaslice objects are tried in the order
+// Reconcile tries them, see orderCandidates. The checks that need the Node objects, like forbidden profiles,
+// are not applied, nor is the GPU generation preferred by the namespace.
+func WhatIf(instaslices []inferencev1alpha1.Instaslice, pod *v1.Pod, policy AllocationPolicy) (*AllocationDetails, error) {
+	r := &InstasliceReconciler{}
+	container, err := gpuContainer(pod.Spec.Containers)
+	if err != nil {
+		return nil, fmt.Errorf("%v, pod: %v", err, pod.Name)
+	}
+	limits, err := requestLimits(pod, container.Resources.Limits)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateProfileRequest(limits); err != nil {
+		return nil, err
+	}
+	profileName, err := extractProfileName(limits)
+	if err != nil {
+		return nil, err
+	}
+	if profileName == "" {
+		return nil, fmt.Errorf("pod %s does not request an InstaSlice profile", pod.Name)
+	}
+	candidates := make([]inferencev1alpha1.Instaslice, 0, len(instaslices))
+	for i := range instaslices {
+		if !matchesPin(pod, &instaslices[i]) {
+			continue
+		}
+		// placement fills in the allocation maps, work on copies to leave the input untouched
+		candidates = append(candidates, *instaslices[i].DeepCopy())
+	}
+	r.orderCandidates(candidates, pod, policy, profileName, strings.TrimSpace(pod.Annotations[PreferredGPUGenerationAnnotation]))
+	err = noCapacityError(instaslices, profileName)
+	now := time.Now()
+	for i := range candidates {
+		allocRequest, allocResult, placementErr := r.placeOnInstaslice(&candidates[i], profileName, policy, pod, nil, now)
+		if placementErr != nil {
+			// a node not offering the profile says nothing about the nodes that do
+			if !goerror.Is(placementErr, ErrProfileUnknown) {
+				err = placementErr
+			}
+			continue
+		}
+		return &AllocationDetails{Request: allocRequest, Result: allocResult}, nil
+	}
+	return nil, err
+}
+
+// orderCandidates sorts the Instaslice objects in the order the slices of the profile are placed on them for
+// the pod. Nodes that did not create the slices of the pod in time come last. First come the node slices were
+// preempted on for the pod, the node it is still pinned to from an earlier allocation, the node of the GPU a
+// checkpoint-restore workload used in its previous run and the nodes with GPUs of the preferred generation.
+// Worst fit then tries the nodes with the widest free region on a GPU first, best fit the tightest, ties go
+// by name.
+func (r *InstasliceReconciler) orderCandidates(instaslices []inferencev1alpha1.Instaslice, pod *v1.Pod, policy AllocationPolicy, profileName, preferredGeneration string) {
+	pinnedNode := pod.Spec.NodeSelector[NodeLabel]
+	nominatedNode := r.preemptionNominations.nominatedNode(pod.UID)
+	preferredGPU := pod.Annotations[PreferredGPUAnnotation]
+	var spans map[string]int32
+	switch policy.(type) {
+	case *WorstFitPolicy:
+		spans = r.emptiestGPUSpans(instaslices, profileName)
+	case *BestFitPolicy:
+		spans = r.tightestGPUSpans(instaslices, profileName)
+	}
+	_, tightestFirst := policy.(*BestFitPolicy)
+	sort.Slice(instaslices, func(i, j int) bool {
+		if timedOutI, timedOutJ := r.creationTimeouts.timedOut(pod.UID, instaslices[i].Name), r.creationTimeouts.timedOut(pod.UID, instaslices[j].Name); timedOutI != timedOutJ {
+			return timedOutJ
+		}
+		if nominatedI, nominatedJ := instaslices[i].Name == nominatedNode, instaslices[j].Name == nominatedNode; nominatedI != nominatedJ {
+			return nominatedI
+		}
+		if isPinnedI, isPinnedJ := instaslices[i].Name == pinnedNode, instaslices[j].Name == pinnedNode; isPinnedI != isPinnedJ {
+			return isPinnedI
+		}
+		if hostsI, hostsJ := hostsGPU(&instaslices[i], preferredGPU), hostsGPU(&instaslices[j], preferredGPU); hostsI != hostsJ {
+			return hostsI
+		}
+		if offersI, offersJ := offersGeneration(&instaslices[i], preferredGeneration), offersGeneration(&instaslices[j], preferredGeneration); offersI != offersJ {
+			return offersI
+		}
+		if spanI, spanJ := spans[instaslices[i].Name], spans[instaslices[j].Name]; spanI != spanJ {
+			return (spanI < spanJ) == tightestFirst
+		}
+		return instaslices[i].Name < instaslices[j].Name
+	})
+}
+
+// placeOnInstaslice finds the GPU and GPU index of the Instaslice object where the slice can be placed, it
+// only looks at the object itself
+func (r *InstasliceReconciler) placeOnInstaslice(updatedInstaSliceObject *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod, affinity map[string]int, now time.Time) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult, error) {
+	if meta.IsStatusConditionFalse(updatedInstaSliceObject.Status.Conditions, NodeAvailableCondition) {
+		return nil, nil, fmt.Errorf("node %s of the instaslice no longer exists", updatedInstaSliceObject.Name)
+	}
+	if !updatedInstaSliceObject.DeletionTimestamp.IsZero() {
+		return nil, nil, fmt.Errorf("instaslice %s is being deleted", updatedInstaSliceObject.Name)
+	}
+
+	availableResources := r.availableClassicalResourcesOnNode(updatedInstaSliceObject)
+	nodeAvailableCpu := availableResources[v1.ResourceCPU]
+	nodeAvailableMemory := availableResources[v1.ResourceMemory]
+	container, err := gpuContainer(pod.Spec.Containers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v, pod: %v", err, pod.Name)
+	}
+	if !hasSliceConfigMap(container) {
+		return nil, nil, fmt.Errorf("%s, pod: %v", noSliceConfigMapErr, pod.Name)
+	}
+	cpuRequest := container.Resources.Requests[v1.ResourceCPU]
+	memoryRequest := container.Resources.Requests[v1.ResourceMemory]
+
+	requestedStart, hasRequestedStart, err := requestedStartOffset(pod)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requestedMemory, hasRequestedMemory := profileMemory(profileName)
+	gpuMemory := make(map[string]resource.Quantity)
+	for _, discoveredGpu := range updatedInstaSliceObject.Status.NodeResources.NodeGPUs {
+		gpuMemory[discoveredGpu.GPUUUID] = discoveredGpu.GPUMemory
+	}
+	var exceedsGPUMemory bool
+	freeIndexes, reservedForOthers := r.tierReservation(updatedInstaSliceObject, pod)
+	var exceedsReservation bool
+	var illegalGeometry bool
+
 	if cpuRequest.Cmp(nodeAvailableCpu) < 0 && memoryRequest.Cmp(nodeAvailableMemory) < 0 {
 		// TODO: Discover GPU UUIDs for selection. (This may work for A100 and H100 for now.)
-		gpuUUIDs := sortGPUs(updatedInstaSliceObject)
+		gpuUUIDs := gpusInPool(updatedInstaSliceObject, sortGPUs(updatedInstaSliceObject), pod.Labels[GPUPoolLabel])
+		gpuUUIDs = pinnedGPUs(gpuUUIDs, pod)
+		// slices of the profile torn down on a GPU are reused before the other GPUs are scanned, best and
+		// worst fit only reuse them between GPUs they rank alike
+		freed := freedSlices(updatedInstaSliceObject, profileName)
+		switch policy.(type) {
+		case *BestFitPolicy:
+			// the GPU with the tightest free region is tried first
+			sort.SliceStable(gpuUUIDs, func(i, j int) bool {
+				_, leftoverI := r.bestFitStart(updatedInstaSliceObject, gpuUUIDs[i], profileName)
+				_, leftoverJ := r.bestFitStart(updatedInstaSliceObject, gpuUUIDs[j], profileName)
+				if leftoverI != leftoverJ {
+					return leftoverI < leftoverJ
+				}
+				return len(freed[gpuUUIDs[i]]) > 0 && len(freed[gpuUUIDs[j]]) == 0
+			})
+		case *WorstFitPolicy:
+			// the GPU with the widest free region is tried first, spreading the slices
+			sort.SliceStable(gpuUUIDs, func(i, j int) bool {
+				_, leftoverI := r.worstFitStart(updatedInstaSliceObject, gpuUUIDs[i], profileName)
+				_, leftoverJ := r.worstFitStart(updatedInstaSliceObject, gpuUUIDs[j], profileName)
+				if leftoverI != leftoverJ {
+					return leftoverI > leftoverJ
+				}
+				return len(freed[gpuUUIDs[i]]) > 0 && len(freed[gpuUUIDs[j]]) == 0
+			})
+		default:
+			gpuUUIDs = freedFirst(gpuUUIDs, freed)
+		}
+		// distributed jobs keep their slices on NVLink-connected GPUs
+		gpuUUIDs = affinityFirst(gpuUUIDs, affinity)
+		// checkpoint-restore workloads return to the GPU of their previous run while it has room
+		gpuUUIDs = preferredGPUFirst(gpuUUIDs, pod.Annotations[PreferredGPUAnnotation])
+		avoidedGPUs := gpusOfAvoidedPods(updatedInstaSliceObject, pod)
+		bookedGPUs := gpusBookedForOthers(updatedInstaSliceObject, pod, now)
 		for _, gpuuuid := range gpuUUIDs {
+			if avoidedGPUs[gpuuuid] || bookedGPUs[gpuuuid] || slices.Contains(updatedInstaSliceObject.Spec.DisabledGPUs, gpuuuid) {
+				continue
+			}
+			// a profile can never be larger than the physical memory of the GPU
+			if memory, ok := gpuMemory[gpuuuid]; ok && hasRequestedMemory && requestedMemory.Cmp(memory) > 0 {
+				exceedsGPUMemory = true
+				continue
+			}
 			if updatedInstaSliceObject.Spec.PodAllocationRequests == nil {
 				updatedInstaSliceObject.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
 			}
 
-			newStart := r.getStartIndexFromPreparedState(updatedInstaSliceObject, gpuuuid, profileName)
-			// For example, a newStart of 9 is considered invalid.
-			notValidIndex := int32(9)
-			if newStart == notValidIndex {
-				// Move to next GPU if the index is not valid.
+			var newStart int32
+			if hasRequestedStart {
+				// advanced users pick the start offset, it is honored only when the placement is free
+				if !r.isPlacementFree(updatedInstaSliceObject, gpuuuid, profileName, requestedStart) {
+					continue
+				}
+				newStart = requestedStart
+			} else {
+				newStart = r.startIndexForPolicy(policy, updatedInstaSliceObject, gpuuuid, profileName)
+				// For example, a newStart of 9 is considered invalid.
+				notValidIndex := int32(9)
+				if newStart == notValidIndex {
+					// Move to next GPU if the index is not valid.
+					continue
+				}
+				if start, ok := r.freedStart(updatedInstaSliceObject, gpuuuid, profileName, freed[gpuuuid]); ok &&
+					r.reusesFreedStart(policy, updatedInstaSliceObject, gpuuuid, profileName, start, newStart) {
+					newStart = start
+				}
+			}
+			// NVIDIA constrains which profiles coexist on a GPU
+			if r.validatesMigGeometry() && !r.isLegalGeometry(updatedInstaSliceObject, gpuuuid, profileName, newStart) {
+				illegalGeometry = true
 				continue
 			}
 
-			size, discoveredGiprofile, Ciprofileid, Ciengprofileid := r.extractGpuProfile(updatedInstaSliceObject, profileName)
-			resourceIdentifier := pod.Spec.Containers[0].EnvFrom[0].ConfigMapRef.Name
+			size, discoveredGiprofile, Ciprofileid, Ciengprofileid := r.extractGpuProfile(updatedInstaSliceObject, gpuuuid, profileName)
+			Ciengprofileid = r.workloadCIEngProfile(pod, Ciengprofileid)
+			// capacity reserved for other priority classes stays free until they use it
+			if freeIndexes-size < reservedForOthers {
+				exceedsReservation = true
+				continue
+			}
+			resourceIdentifier := container.EnvFrom[0].ConfigMapRef.Name
 
 			allocRequest, allocResult := policy.SetAllocationDetails(
 				profileName,
@@ -93,11 +402,419 @@ func (r *InstasliceReconciler) findNodeAndDeviceForASlice(ctx context.Context, i
 					v1.ResourceMemory: memoryRequest,
 				},
 			)
+			// record the policy for auditing packing decisions
+			allocResult.Policy = policyName(policy)
+			allocatedAt := metav1.NewTime(now)
+			allocResult.AllocatedAt = &allocatedAt
+			allocRequest.PriorityClassName = pod.Spec.PriorityClassName
+			// the daemonset cannot realize a slice outside the GPU or on indexes already in use
+			if err := validatePlacement(updatedInstaSliceObject, allocResult); err != nil {
+				return nil, nil, err
+			}
 			return allocRequest, allocResult, nil
 		}
 	}
 
-	return nil, nil, fmt.Errorf("failed to find allocatable node and gpu")
+	if exceedsGPUMemory {
+		return nil, nil, fmt.Errorf("profile %s exceeds the memory of the GPUs on node %s", profileName, updatedInstaSliceObject.Name)
+	}
+	if _, ok := updatedInstaSliceObject.Status.NodeResources.MigPlacement[profileName]; !ok {
+		return nil, nil, fmt.Errorf("%w: %s on node %s", ErrProfileUnknown, profileName, updatedInstaSliceObject.Name)
+	}
+	if exceedsReservation {
+		return nil, nil, fmt.Errorf("capacity on node %s is reserved for other priority classes", updatedInstaSliceObject.Name)
+	}
+	if illegalGeometry {
+		return nil, nil, fmt.Errorf("profile %s does not form a legal MIG geometry on the GPUs of node %s", profileName, updatedInstaSliceObject.Name)
+	}
+	if hasRequestedStart {
+		return nil, nil, fmt.Errorf("requested start offset %d for profile %s is not available", requestedStart, profileName)
+	}
+	return nil, nil, fmt.Errorf("%w for profile %s on node %s", ErrProfileFull, profileName, updatedInstaSliceObject.Name)
+}
+
+// tierReservation returns the free slice indexes of the node and how many of them are reserved
+// for priority classes other than the one of the pod and not yet used by them.
+func (r *InstasliceReconciler) tierReservation(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) (int32, int32) {
+	freeIndexes := utils.GPUSliceSpan(instaslice) * int32(len(instaslice.Status.NodeResources.NodeGPUs))
+	usedByClass := make(map[string]int32)
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		freeIndexes -= allocResult.MigPlacement.Size
+		usedByClass[instaslice.Spec.PodAllocationRequests[podUID].PriorityClassName] += allocResult.MigPlacement.Size
+	}
+	if r.Config == nil {
+		return freeIndexes, 0
+	}
+	var reservedForOthers int32
+	for priorityClassName, reserved := range r.Config.PriorityClassReservations {
+		if priorityClassName == pod.Spec.PriorityClassName {
+			continue
+		}
+		reservedForOthers += max(0, int32(reserved)-usedByClass[priorityClassName])
+	}
+	return freeIndexes, reservedForOthers
+}
+
+// workloadCIEngProfile returns the compute instance engineering profile configured for the workload type the
+// pod is annotated with, pods without a configured workload type keep the discovered profile
+func (r *InstasliceReconciler) workloadCIEngProfile(pod *v1.Pod, discovered int32) int32 {
+	if r.Config == nil {
+		return discovered
+	}
+	workloadType, ok := pod.Annotations[WorkloadTypeAnnotation]
+	if !ok {
+		return discovered
+	}
+	if ciEngProfileID, ok := r.Config.WorkloadCIEngProfiles[workloadType]; ok {
+		return int32(ciEngProfileID)
+	}
+	return discovered
+}
+
+// isPreemptibleNodeExcluded checks whether the node is labeled preemptible and the pod opted out of such nodes.
+// Pods opt in or out through the preemptible-nodes annotation set to allow or avoid, critical pods avoid
+// preemptible nodes unless they opt in.
+func (r *InstasliceReconciler) isPreemptibleNodeExcluded(ctx context.Context, nodeName string, pod *v1.Pod) (bool, error) {
+	avoid := isCriticalPod(pod)
+	switch pod.Annotations[PreemptibleNodesAnnotation] {
+	case "allow":
+		avoid = false
+	case "avoid":
+		avoid = true
+	}
+	if !avoid {
+		return false, nil
+	}
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return node.Labels[PreemptibleNodeLabel] == "true", nil
+}
+
+// isProfileForbiddenOnNode checks the comma separated profile list that operators can annotate
+// on a node to keep, for example, the largest profiles off shared nodes.
+func (r *InstasliceReconciler) isProfileForbiddenOnNode(ctx context.Context, nodeName string, profileName string) (bool, error) {
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	forbiddenProfiles, ok := node.Annotations[ForbiddenProfilesAnnotation]
+	if !ok {
+		return false, nil
+	}
+	for _, forbiddenProfile := range strings.Split(forbiddenProfiles, ",") {
+		if strings.TrimSpace(forbiddenProfile) == profileName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// gpusInPool keeps the GPUs belonging to the pool, pods without a pool are placed only on
+// GPUs that are not part of any pool so that pooled GPUs stay reserved for their pods.
+func gpusInPool(instaslice *inferencev1alpha1.Instaslice, gpuUUIDs []string, pool string) []string {
+	gpuPool := make(map[string]string)
+	for poolName, members := range instaslice.Spec.GPUPools {
+		for _, gpuUUID := range members {
+			gpuPool[gpuUUID] = poolName
+		}
+	}
+	var poolGPUs []string
+	for _, gpuUUID := range gpuUUIDs {
+		if gpuPool[gpuUUID] == pool {
+			poolGPUs = append(poolGPUs, gpuUUID)
+		}
+	}
+	return poolGPUs
+}
+
+// preferredGPUFirst moves the preferred GPU to the front of the GPUs, the others keep their order
+func preferredGPUFirst(gpuUUIDs []string, preferredGPU string) []string {
+	index := slices.Index(gpuUUIDs, preferredGPU)
+	if preferredGPU == "" || index <= 0 {
+		return gpuUUIDs
+	}
+	ordered := append([]string{preferredGPU}, gpuUUIDs[:index]...)
+	return append(ordered, gpuUUIDs[index+1:]...)
+}
+
+// freedSlices returns per GPU the sorted starts of the slices of the profile that the daemonset tore down while
+// their allocations are still recorded. The regions are known to fit the profile, so they form the free list
+// consulted when a start is picked on the GPU.
+func freedSlices(instaslice *inferencev1alpha1.Instaslice, profileName string) map[string][]int32 {
+	freed := make(map[string][]int32)
+	for key, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		if instaslice.Spec.PodAllocationRequests[key].Profile != profileName {
+			continue
+		}
+		freed[allocResult.GPUUUID] = append(freed[allocResult.GPUUUID], allocResult.MigPlacement.Start)
+	}
+	for _, starts := range freed {
+		slices.Sort(starts)
+	}
+	return freed
+}
+
+// freedFirst moves the GPUs holding freed slices of the profile to the front, the others keep their order
+func freedFirst(gpuUUIDs []string, freed map[string][]int32) []string {
+	if len(freed) == 0 {
+		return gpuUUIDs
+	}
+	sort.SliceStable(gpuUUIDs, func(i, j int) bool {
+		return len(freed[gpuUUIDs[i]]) > 0 && len(freed[gpuUUIDs[j]]) == 0
+	})
+	return gpuUUIDs
+}
+
+// freedStart returns the first freed start of the profile on the GPU that no allocation took since and that
+// still forms a legal geometry with the slices around it
+func (r *InstasliceReconciler) freedStart(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string, starts []int32) (int32, bool) {
+	for _, start := range starts {
+		if !r.isPlacementFree(instaslice, gpuUUID, profileName, start) {
+			continue
+		}
+		if r.validatesMigGeometry() && !r.isLegalGeometry(instaslice, gpuUUID, profileName, start) {
+			continue
+		}
+		return start, true
+	}
+	return 0, false
+}
+
+// reusesFreedStart reports whether the freed start is taken over the start the policy picked on the GPU, best
+// and worst fit only take it when it leaves the same free span around the slice
+func (r *InstasliceReconciler) reusesFreedStart(policy AllocationPolicy, instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string, freedStart, policyStart int32) bool {
+	switch policy.(type) {
+	case *BestFitPolicy, *WorstFitPolicy:
+		leftovers := r.placementLeftovers(instaslice, gpuUUID, profileName)
+		return leftovers[freedStart] == leftovers[policyStart]
+	default:
+		return true
+	}
+}
+
+// hostsGPU reports whether the GPU is one of the GPUs of the Instaslice
+func hostsGPU(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	return gpuUUID != "" && slices.ContainsFunc(instaslice.Status.NodeResources.NodeGPUs, func(gpu inferencev1alpha1.DiscoveredGPU) bool {
+		return gpu.GPUUUID == gpuUUID
+	})
+}
+
+// offersGeneration reports whether one of the GPUs of the Instaslice is of the generation, e.g. A100,
+// matched case-insensitively against the GPU name
+func offersGeneration(instaslice *inferencev1alpha1.Instaslice, generation string) bool {
+	return generation != "" && slices.ContainsFunc(instaslice.Status.NodeResources.NodeGPUs, func(gpu inferencev1alpha1.DiscoveredGPU) bool {
+		return strings.Contains(strings.ToUpper(gpu.GPUName), strings.ToUpper(generation))
+	})
+}
+
+// preferredGeneration returns the GPU generation preferred by the pod, the annotation of the pod wins
+// over the default the namespace of the pod declares, an empty pod annotation opts out of the default
+func (r *InstasliceReconciler) preferredGeneration(ctx context.Context, pod *v1.Pod) (string, error) {
+	if generation, ok := pod.Annotations[PreferredGPUGenerationAnnotation]; ok {
+		return strings.TrimSpace(generation), nil
+	}
+	namespace := &v1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(namespace.Annotations[PreferredGPUGenerationAnnotation]), nil
+}
+
+// gpusOfAvoidedPods returns the GPUs holding slices of the pods that the pod names in its
+// anti-colocation annotation, names without a namespace refer to the namespace of the pod.
+func gpusOfAvoidedPods(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) map[string]bool {
+	avoidPods, ok := pod.Annotations[AvoidPodsAnnotation]
+	if !ok {
+		return nil
+	}
+	avoided := make(map[types.NamespacedName]bool)
+	for _, name := range strings.Split(avoidPods, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		namespace := pod.Namespace
+		if ns, podName, found := strings.Cut(name, "/"); found {
+			namespace, name = ns, podName
+		}
+		avoided[types.NamespacedName{Namespace: namespace, Name: name}] = true
+	}
+
+	gpus := make(map[string]bool)
+	for podUID, allocRequest := range instaslice.Spec.PodAllocationRequests {
+		if !avoided[types.NamespacedName{Namespace: allocRequest.PodRef.Namespace, Name: allocRequest.PodRef.Name}] {
+			continue
+		}
+		allocResult, ok := instaslice.Status.PodAllocationResults[podUID]
+		if !ok || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		gpus[allocResult.GPUUUID] = true
+	}
+	return gpus
+}
+
+// gpusBookedForOthers returns the GPUs held by a reservation window that is open at now and
+// owned by a namespace other than the one of the pod.
+func gpusBookedForOthers(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod, now time.Time) map[string]bool {
+	booked := make(map[string]bool)
+	for _, window := range instaslice.Spec.ReservationWindows {
+		if window.Namespace == pod.Namespace || now.Before(window.Start.Time) || !now.Before(window.End.Time) {
+			continue
+		}
+		for _, gpuUUID := range window.GPUUUIDs {
+			booked[gpuUUID] = true
+		}
+	}
+	return booked
+}
+
+// profileMemory returns the memory of a profile such as 3g.20gb or 1g.5gb+me. NVIDIA names profiles in
+// decimal gigabytes, an H100 80GB reports about 79.6GiB.
+func profileMemory(profileName string) (resource.Quantity, bool) {
+	profile, _, _ := strings.Cut(profileName, "+")
+	_, memoryPart, found := strings.Cut(profile, ".")
+	if !found {
+		return resource.Quantity{}, false
+	}
+	memoryValue, err := strconv.Atoi(strings.TrimSuffix(memoryPart, "gb"))
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+	return resource.MustParse(fmt.Sprintf("%dG", memoryValue)), true
+}
+
+// validatePlacement checks that the placement of the allocation lies within the slice indexes of its GPU
+// and overlaps no other slice on the GPU that the daemonset has not deleted
+func validatePlacement(instaslice *inferencev1alpha1.Instaslice, allocResult *inferencev1alpha1.AllocationResult) error {
+	start, size := allocResult.MigPlacement.Start, allocResult.MigPlacement.Size
+	if span := utils.GPUSliceSpan(instaslice); start < 0 || size <= 0 || start+size > span {
+		return fmt.Errorf("%w: start %d and size %d exceed the %d slice indexes of GPU %s on node %s", ErrInvalidPlacement,
+			start, size, span, allocResult.GPUUUID, instaslice.Name)
+	}
+	for key, other := range instaslice.Status.PodAllocationResults {
+		if other.GPUUUID != allocResult.GPUUUID || other.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		otherStart, otherSize := other.MigPlacement.Start, other.MigPlacement.Size
+		if start < otherStart+otherSize && otherStart < start+size {
+			return fmt.Errorf("%w: start %d and size %d overlap the slice %s at start %d and size %d on GPU %s on node %s", ErrInvalidPlacement,
+				start, size, key, otherStart, otherSize, allocResult.GPUUUID, instaslice.Name)
+		}
+	}
+	return nil
+}
+
+// validatesMigGeometry reports whether placements are checked for a legal MIG geometry, they are unless disabled
+func (r *InstasliceReconciler) validatesMigGeometry() bool {
+	return r.Config == nil || r.Config.ValidateMigGeometry
+}
+
+// profileComputeSlices returns the compute slices of a profile such as 3g.20gb or 1g.5gb+me
+func profileComputeSlices(profileName string) (int, bool) {
+	computePart, _, found := strings.Cut(profileName, "g.")
+	if !found {
+		return 0, false
+	}
+	computeSlices, err := strconv.Atoi(computePart)
+	if err != nil {
+		return 0, false
+	}
+	return computeSlices, true
+}
+
+// isLegalGeometry checks that placing the profile at start on the GPU keeps a legal MIG geometry: the
+// slice sits on a placement the GPU advertises for the profile, it shares no memory index with the
+// slices already on the GPU and the compute slices of all profiles fit the GPU, which has as many as
+// its largest profile.
+func (*InstasliceReconciler) isLegalGeometry(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string, start int32) bool {
+	migPlacement := instaslice.Status.NodeResources.MigPlacement
+	var size int32
+	for _, placement := range migPlacement[profileName].Placements {
+		if placement.Start == start {
+			size = placement.Size
+			break
+		}
+	}
+	if size == 0 {
+		return false
+	}
+	var gpuComputeSlices int
+	for profile := range migPlacement {
+		if computeSlices, ok := profileComputeSlices(profile); ok {
+			gpuComputeSlices = max(gpuComputeSlices, computeSlices)
+		}
+	}
+	usedComputeSlices, _ := profileComputeSlices(profileName)
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.GPUUUID != gpuUUID || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		allocStart := allocResult.MigPlacement.Start
+		if start < allocStart+allocResult.MigPlacement.Size && allocStart < start+size {
+			return false
+		}
+		if computeSlices, ok := profileComputeSlices(instaslice.Spec.PodAllocationRequests[podUID].Profile); ok {
+			usedComputeSlices += computeSlices
+		}
+	}
+	return gpuComputeSlices == 0 || usedComputeSlices <= gpuComputeSlices
+}
+
+// requestedStartOffset returns the start offset requested through the pod annotation, if any
+func requestedStartOffset(pod *v1.Pod) (int32, bool, error) {
+	value, ok := pod.Annotations[StartOffsetAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	start, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || start < 0 {
+		return 0, false, fmt.Errorf("invalid %s annotation %q on pod %s", StartOffsetAnnotation, value, pod.Name)
+	}
+	return int32(start), true, nil
+}
+
+// isPlacementFree checks that start is a valid placement of the profile and that none of the
+// indexes it spans on the GPU are held by an allocation.
+func (*InstasliceReconciler) isPlacementFree(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string, start int32) bool {
+	var size int32
+	for _, placement := range instaslice.Status.NodeResources.MigPlacement[profileName].Placements {
+		if placement.Start == start {
+			size = placement.Size
+			break
+		}
+	}
+	if size == 0 {
+		return false
+	}
+	for _, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.GPUUUID != gpuUUID || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		allocStart := allocResult.MigPlacement.Start
+		allocEnd := allocStart + allocResult.MigPlacement.Size
+		if start < allocEnd && allocStart < start+size {
+			return false
+		}
+	}
+	return true
 }
 
 func sortGPUs(updatedInstaSliceObject *inferencev1alpha1.Instaslice) []string {
@@ -109,6 +826,131 @@ func sortGPUs(updatedInstaSliceObject *inferencev1alpha1.Instaslice) []string {
 	return gpuUUIDs
 }
 
+// startIndexForPolicy returns the start of the slice on the GPU in the order the policy scans the
+// placements, 9 when none is free
+func (r *InstasliceReconciler) startIndexForPolicy(policy AllocationPolicy, instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) int32 {
+	switch policy.(type) {
+	case *RightToLeftPolicy:
+		return r.highestFreeStart(instaslice, gpuUUID, profileName)
+	case *BestFitPolicy:
+		start, _ := r.bestFitStart(instaslice, gpuUUID, profileName)
+		return start
+	case *WorstFitPolicy:
+		start, _ := r.worstFitStart(instaslice, gpuUUID, profileName)
+		return start
+	default:
+		return r.getStartIndexFromPreparedState(instaslice, gpuUUID, profileName)
+	}
+}
+
+// highestFreeStart returns the highest free start of the profile on the GPU, 9 when none is free
+func (r *InstasliceReconciler) highestFreeStart(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) int32 {
+	var possiblePlacements []int32
+	for _, placement := range instaslice.Status.NodeResources.MigPlacement[profileName].Placements {
+		possiblePlacements = append(possiblePlacements, placement.Start)
+	}
+	slices.Sort(possiblePlacements)
+	slices.Reverse(possiblePlacements)
+	for _, start := range possiblePlacements {
+		if r.isPlacementFree(instaslice, gpuUUID, profileName, start) {
+			return start
+		}
+	}
+	return int32(9)
+}
+
+// placementLeftovers returns the free starts of the profile on the GPU, each with the free span the slice
+// would leave around it
+func (r *InstasliceReconciler) placementLeftovers(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) map[int32]int32 {
+	gpuAllocatedIndex := make([]bool, utils.GPUSliceSpan(instaslice))
+	for _, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.GPUUUID != gpuUUID || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		for i := allocResult.MigPlacement.Start; i < allocResult.MigPlacement.Start+allocResult.MigPlacement.Size && int(i) < len(gpuAllocatedIndex); i++ {
+			gpuAllocatedIndex[i] = true
+		}
+	}
+	leftovers := make(map[int32]int32)
+	for _, placement := range instaslice.Status.NodeResources.MigPlacement[profileName].Placements {
+		if !r.isPlacementFree(instaslice, gpuUUID, profileName, placement.Start) {
+			continue
+		}
+		// the free indexes on both sides of the slice stay behind as the leftover span
+		var leftover int32
+		for i := placement.Start - 1; i >= 0 && !gpuAllocatedIndex[i]; i-- {
+			leftover++
+		}
+		for i := placement.Start + placement.Size; int(i) < len(gpuAllocatedIndex) && !gpuAllocatedIndex[i]; i++ {
+			leftover++
+		}
+		leftovers[placement.Start] = leftover
+	}
+	return leftovers
+}
+
+// bestFitStart returns the free start of the profile on the GPU that leaves the smallest free span
+// around the slice, and that span. The lowest start wins a tie, 9 and a span beyond any GPU are
+// returned when no start is free.
+func (r *InstasliceReconciler) bestFitStart(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) (int32, int32) {
+	bestStart, bestLeftover := int32(9), int32(9)
+	for start, leftover := range r.placementLeftovers(instaslice, gpuUUID, profileName) {
+		if leftover < bestLeftover || (leftover == bestLeftover && start < bestStart) {
+			bestStart, bestLeftover = start, leftover
+		}
+	}
+	return bestStart, bestLeftover
+}
+
+// worstFitStart returns the free start of the profile on the GPU that leaves the largest free span
+// around the slice, and that span. The lowest start wins a tie, 9 and a span of -1 are returned
+// when no start is free.
+func (r *InstasliceReconciler) worstFitStart(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) (int32, int32) {
+	worstStart, worstLeftover := int32(9), int32(-1)
+	for start, leftover := range r.placementLeftovers(instaslice, gpuUUID, profileName) {
+		if leftover > worstLeftover || (leftover == worstLeftover && start < worstStart) {
+			worstStart, worstLeftover = start, leftover
+		}
+	}
+	return worstStart, worstLeftover
+}
+
+// emptiestGPUSpans returns per Instaslice the free span the worst fit placement of the profile leaves on its
+// emptiest enabled GPU, -1 when no GPU has room. Worst fit tries the Instaslice objects in that order.
+func (r *InstasliceReconciler) emptiestGPUSpans(instaslices []inferencev1alpha1.Instaslice, profileName string) map[string]int32 {
+	spans := make(map[string]int32, len(instaslices))
+	for i := range instaslices {
+		span := int32(-1)
+		for _, gpuUUID := range sortGPUs(&instaslices[i]) {
+			if slices.Contains(instaslices[i].Spec.DisabledGPUs, gpuUUID) {
+				continue
+			}
+			_, leftover := r.worstFitStart(&instaslices[i], gpuUUID, profileName)
+			span = max(span, leftover)
+		}
+		spans[instaslices[i].Name] = span
+	}
+	return spans
+}
+
+// tightestGPUSpans returns per Instaslice the free span the best fit placement of the profile leaves on its
+// tightest enabled GPU, 9 when no GPU has room. Best fit tries the Instaslice objects in that order.
+func (r *InstasliceReconciler) tightestGPUSpans(instaslices []inferencev1alpha1.Instaslice, profileName string) map[string]int32 {
+	spans := make(map[string]int32, len(instaslices))
+	for i := range instaslices {
+		span := int32(9)
+		for _, gpuUUID := range sortGPUs(&instaslices[i]) {
+			if slices.Contains(instaslices[i].Spec.DisabledGPUs, gpuUUID) {
+				continue
+			}
+			_, leftover := r.bestFitStart(&instaslices[i], gpuUUID, profileName)
+			span = min(span, leftover)
+		}
+		spans[instaslices[i].Name] = span
+	}
+	return spans
+}
+
 // accounting logic that finds the correct GPU and index where a slice could be placed.
 func (*InstasliceReconciler) getStartIndexFromPreparedState(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) int32 {
 	//TODO: generalize, A100 and H100 have 8 indexes for 3g and 7g and 7 for rest, so go with 8 and we are bounded by
@@ -120,6 +962,7 @@ func (*InstasliceReconciler) getStartIndexFromPreparedState(instaslice *inferenc
 	}
 	// deleted allocations can be reused
 	// ungated allocations are already counted in prepared
+	// pending allocations whose slice is not created yet hold their indexes as well
 	for _, allocResult := range instaslice.Status.PodAllocationResults {
 		if allocResult.GPUUUID == gpuUUID && allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
 			for i := 0; i < int(allocResult.MigPlacement.Size); i++ {
@@ -150,6 +993,8 @@ func (*InstasliceReconciler) getStartIndexFromPreparedState(instaslice *inferenc
 			break
 		}
 	}
+	// the lowest free start wins so that placements do not depend on the order of the discovered placements
+	slices.Sort(possiblePlacements)
 	//TODO: generalize for other hardware models like A30, no slices can be placed on 9th index
 	//if we return 9 then assume no valid index is found.
 	var newStart = int32(9)
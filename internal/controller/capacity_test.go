@@ -0,0 +1,1103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// newTestGatedPod returns a pod gated by InstaSlice requesting a single slice of the profile,
+// shaped the way the mutating webhook leaves it.
+func newTestGatedPod(name string, profileName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name + "-uid"),
+		},
+		Spec: v1.PodSpec{
+			SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+			Containers: []v1.Container{{
+				Name: "gpu",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						v1.ResourceName(OrgInstaslicePrefix + "mig-" + profileName): resource.MustParse("1"),
+					},
+				},
+				EnvFrom: []v1.EnvFromSource{{
+					ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: name + "-cm"}},
+				}},
+			}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{Message: "blocked"}}},
+	}
+}
+
+func TestFindNodeAndDeviceForASlice_StartOffset(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("valid offset is honored", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		r, _ := newTestReconciler(t, instaslice)
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Annotations = map[string]string{StartOffsetAnnotation: "4"}
+
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(4), allocResult.MigPlacement.Start)
+		assert.Equal(t, int32(1), allocResult.MigPlacement.Size)
+	})
+
+	t.Run("occupied offset is rejected", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		// a 2g.10gb slice at index 4 on every GPU covers the requested offset
+		for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+			uid := types.UID("holder-" + gpu.GPUUUID)
+			instaslice.Spec.PodAllocationRequests[uid] = inferencev1alpha1.AllocationRequest{Profile: "2g.10gb"}
+			instaslice.Status.PodAllocationResults[uid] = inferencev1alpha1.AllocationResult{
+				MigPlacement:     inferencev1alpha1.Placement{Start: 4, Size: 2},
+				GPUUUID:          gpu.GPUUUID,
+				AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+			}
+		}
+		r, _ := newTestReconciler(t, instaslice)
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Annotations = map[string]string{StartOffsetAnnotation: "5"}
+
+		_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+		assert.ErrorContains(t, err, "requested start offset 5 for profile 1g.5gb is not available")
+	})
+
+	t.Run("offset that is not a placement of the profile is rejected", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		r, _ := newTestReconciler(t, instaslice)
+		pod := newTestGatedPod("pod-1", "2g.10gb")
+		pod.Annotations = map[string]string{StartOffsetAnnotation: "1"}
+
+		_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "2g.10gb", &FirstFitPolicy{}, pod)
+		assert.Error(t, err)
+	})
+}
+
+func TestFindNodeAndDeviceForASlice_ReusesFreedSlice(t *testing.T) {
+	ctx := context.TODO()
+	// newFreedInstaslice returns an Instaslice whose second GPU holds the torn down 2g.10gb slice of pod-1 at index 4
+	newFreedInstaslice := func() (*inferencev1alpha1.Instaslice, string) {
+		instaslice := newTestAllocation("node-1", newTestGatedPod("pod-1", "2g.10gb"), inferencev1alpha1.AllocationStatus{
+			AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+			AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusDeleted,
+		})
+		freedGPU := sortGPUs(instaslice)[1]
+		allocRequest := instaslice.Spec.PodAllocationRequests["pod-1-uid"]
+		allocRequest.Profile = "2g.10gb"
+		instaslice.Spec.PodAllocationRequests["pod-1-uid"] = allocRequest
+		allocResult := instaslice.Status.PodAllocationResults["pod-1-uid"]
+		allocResult.GPUUUID = freedGPU
+		allocResult.MigPlacement = inferencev1alpha1.Placement{Start: 4, Size: 2}
+		instaslice.Status.PodAllocationResults["pod-1-uid"] = allocResult
+		return instaslice, freedGPU
+	}
+
+	t.Run("freed slice of the profile is reused", func(t *testing.T) {
+		instaslice, freedGPU := newFreedInstaslice()
+		r, _ := newTestReconciler(t, instaslice)
+
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "2g.10gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "2g.10gb"))
+		assert.NoError(t, err)
+		assert.Equal(t, freedGPU, allocResult.GPUUUID)
+		assert.Equal(t, inferencev1alpha1.Placement{Start: 4, Size: 2}, allocResult.MigPlacement)
+	})
+
+	t.Run("freed slice of another profile is not reused", func(t *testing.T) {
+		instaslice, _ := newFreedInstaslice()
+		r, _ := newTestReconciler(t, instaslice)
+
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "1g.5gb"))
+		assert.NoError(t, err)
+		assert.Equal(t, sortGPUs(instaslice)[0], allocResult.GPUUUID)
+		assert.Equal(t, int32(0), allocResult.MigPlacement.Start)
+	})
+
+	t.Run("best fit prefers a tighter GPU over a freed slice", func(t *testing.T) {
+		instaslice, _ := newFreedInstaslice()
+		// a 3g.20gb slice on the first GPU leaves it tighter than the GPU holding the freed slice
+		instaslice.Spec.PodAllocationRequests["pod-3-uid"] = inferencev1alpha1.AllocationRequest{Profile: "3g.20gb"}
+		instaslice.Status.PodAllocationResults["pod-3-uid"] = inferencev1alpha1.AllocationResult{
+			MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 4},
+			GPUUUID:          sortGPUs(instaslice)[0],
+			AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+		}
+		r, _ := newTestReconciler(t, instaslice)
+
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "2g.10gb", &BestFitPolicy{}, newTestGatedPod("pod-2", "2g.10gb"))
+		assert.NoError(t, err)
+		assert.Equal(t, sortGPUs(instaslice)[0], allocResult.GPUUUID)
+		assert.Equal(t, int32(4), allocResult.MigPlacement.Start)
+	})
+
+	t.Run("best fit reuses a freed slice between GPUs it ranks alike", func(t *testing.T) {
+		instaslice, freedGPU := newFreedInstaslice()
+		r, _ := newTestReconciler(t, instaslice)
+
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "2g.10gb", &BestFitPolicy{}, newTestGatedPod("pod-2", "2g.10gb"))
+		assert.NoError(t, err)
+		assert.Equal(t, freedGPU, allocResult.GPUUUID)
+		assert.Equal(t, inferencev1alpha1.Placement{Start: 4, Size: 2}, allocResult.MigPlacement)
+	})
+
+	t.Run("freed slice taken since is skipped", func(t *testing.T) {
+		instaslice, freedGPU := newFreedInstaslice()
+		instaslice.Spec.PodAllocationRequests["pod-3-uid"] = inferencev1alpha1.AllocationRequest{Profile: "2g.10gb"}
+		instaslice.Status.PodAllocationResults["pod-3-uid"] = inferencev1alpha1.AllocationResult{
+			MigPlacement:     inferencev1alpha1.Placement{Start: 4, Size: 2},
+			GPUUUID:          freedGPU,
+			AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+		}
+		r, _ := newTestReconciler(t, instaslice)
+
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "2g.10gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "2g.10gb"))
+		assert.NoError(t, err)
+		assert.Equal(t, freedGPU, allocResult.GPUUUID)
+		assert.Equal(t, int32(0), allocResult.MigPlacement.Start)
+	})
+}
+
+func TestFindNodeAndDeviceForASlice_RecordsPolicy(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	r, _ := newTestReconciler(t, instaslice)
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, FirstFitPolicyName, allocResult.Policy)
+}
+
+func TestFindNodeAndDeviceForASlice_ForbiddenProfiles(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Annotations: map[string]string{ForbiddenProfilesAnnotation: "7g.40gb, 4g.20gb"},
+	}}
+	r, _ := newTestReconciler(t, instaslice, node)
+
+	_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "4g.20gb", &FirstFitPolicy{}, newTestGatedPod("pod-1", "4g.20gb"))
+	assert.ErrorContains(t, err, "profile 4g.20gb is forbidden on node node-1")
+
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "1g.5gb"))
+	assert.NoError(t, err)
+	assert.Equal(t, types.NodeName("node-1"), allocResult.Nodename)
+}
+
+func TestFindNodeAndDeviceForASlice_PreemptibleNodes(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		excluded    bool
+	}{
+		{name: "regular pod", excluded: false},
+		{name: "pod opting out", annotations: map[string]string{PreemptibleNodesAnnotation: "avoid"}, excluded: true},
+		{name: "critical pod", annotations: map[string]string{CriticalPodAnnotation: "true"}, excluded: true},
+		{name: "critical pod opting in", annotations: map[string]string{CriticalPodAnnotation: "true", PreemptibleNodesAnnotation: "allow"}, excluded: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := utils.GenerateFakeCapacity("spot-1")
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   "spot-1",
+				Labels: map[string]string{PreemptibleNodeLabel: "true"},
+			}}
+			r, _ := newTestReconciler(t, instaslice, node)
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Annotations = tt.annotations
+
+			_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+			if tt.excluded {
+				assert.ErrorContains(t, err, "does not tolerate the preemptible node spot-1")
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, types.NodeName("spot-1"), allocResult.Nodename)
+			}
+		})
+	}
+
+	t.Run("excluded pod is placed on a regular node", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Annotations = map[string]string{PreemptibleNodesAnnotation: "avoid"}
+		pod.Finalizers = []string{FinalizerName}
+		spotNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-a",
+			Labels: map[string]string{PreemptibleNodeLabel: "true"},
+		}}
+		r, fakeClient := newTestReconciler(t, pod, spotNode, utils.GenerateFakeCapacity("node-a"), utils.GenerateFakeCapacity("node-b"))
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.NotContains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-b", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+	})
+}
+
+func TestFindNodeAndDeviceForASlice_AvoidPods(t *testing.T) {
+	ctx := context.TODO()
+	neighbour := newTestGatedPod("neighbour", "1g.5gb")
+	instaslice := newTestAllocation("node-1", neighbour, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	// the neighbour sits on the GPU that is tried first
+	neighbourGPU := sortGPUs(instaslice)[0]
+	neighbourResult := instaslice.Status.PodAllocationResults[neighbour.UID]
+	neighbourResult.GPUUUID = neighbourGPU
+	instaslice.Status.PodAllocationResults[neighbour.UID] = neighbourResult
+	r, _ := newTestReconciler(t, instaslice)
+
+	// without the annotation the slice packs next to the neighbour
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-1", "1g.5gb"))
+	assert.NoError(t, err)
+	assert.Equal(t, neighbourGPU, allocResult.GPUUUID)
+
+	pod := newTestGatedPod("pod-2", "1g.5gb")
+	pod.Annotations = map[string]string{AvoidPodsAnnotation: "other/pod, neighbour"}
+	_, allocResult, err = r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.NotEqual(t, neighbourGPU, allocResult.GPUUUID)
+}
+
+func TestFindNodeAndDeviceForASlice_GPUPools(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpuUUIDs := sortGPUs(instaslice)
+	instaslice.Spec.GPUPools = map[string][]string{
+		"training":  {gpuUUIDs[1]},
+		"inference": {gpuUUIDs[0]},
+	}
+	r, _ := newTestReconciler(t, instaslice)
+
+	t.Run("pod is routed to its pool", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Labels = map[string]string{GPUPoolLabel: "training"}
+		_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+		assert.NoError(t, err)
+		assert.Equal(t, gpuUUIDs[1], allocResult.GPUUUID)
+	})
+
+	t.Run("pod is refused a pool it does not belong to", func(t *testing.T) {
+		// all pooled GPUs are reserved and the node has no pool named batch
+		pod := newTestGatedPod("pod-2", "1g.5gb")
+		pod.Labels = map[string]string{GPUPoolLabel: "batch"}
+		_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+		assert.Error(t, err)
+
+		// the inference pool is full, the pod does not spill onto the training pool
+		full := instaslice.DeepCopy()
+		uid := types.UID("holder")
+		full.Spec.PodAllocationRequests[uid] = inferencev1alpha1.AllocationRequest{Profile: "7g.40gb"}
+		full.Status.PodAllocationResults[uid] = inferencev1alpha1.AllocationResult{
+			MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 8},
+			GPUUUID:          gpuUUIDs[0],
+			AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+		}
+		fullReconciler, _ := newTestReconciler(t, full)
+		pod = newTestGatedPod("pod-3", "1g.5gb")
+		pod.Labels = map[string]string{GPUPoolLabel: "inference"}
+		_, _, err = fullReconciler.findNodeAndDeviceForASlice(ctx, full, "1g.5gb", &FirstFitPolicy{}, pod)
+		assert.Error(t, err)
+	})
+}
+
+func TestFindNodeAndDeviceForASlice_ProfileMemory(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	r, _ := newTestReconciler(t, instaslice)
+
+	_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "7g.80gb", &FirstFitPolicy{}, newTestGatedPod("pod-1", "7g.80gb"))
+	assert.ErrorContains(t, err, "profile 7g.80gb exceeds the memory of the GPUs on node node-1")
+
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "7g.40gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "7g.40gb"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8), allocResult.MigPlacement.Size)
+
+	// an H100 80GB reports 81559MiB, which is short of 80GiB but holds the 80GB profile
+	h100 := utils.GenerateFakeCapacity("node-2")
+	for i := range h100.Status.NodeResources.NodeGPUs {
+		h100.Status.NodeResources.NodeGPUs[i].GPUMemory = resource.MustParse("81559Mi")
+	}
+	h100.Status.NodeResources.MigPlacement["7g.80gb"] = h100.Status.NodeResources.MigPlacement["7g.40gb"]
+	r, _ = newTestReconciler(t, h100)
+	_, allocResult, err = r.findNodeAndDeviceForASlice(ctx, h100, "7g.80gb", &FirstFitPolicy{}, newTestGatedPod("pod-3", "7g.80gb"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8), allocResult.MigPlacement.Size)
+}
+
+func TestFindNodeAndDeviceForASlice_PriorityClassReservation(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	// one GPU is fully used, the remaining 8 indexes are all reserved for the high tier
+	uid := types.UID("holder")
+	instaslice.Spec.PodAllocationRequests[uid] = inferencev1alpha1.AllocationRequest{Profile: "7g.40gb"}
+	instaslice.Status.PodAllocationResults[uid] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 8},
+		GPUUUID:          sortGPUs(instaslice)[0],
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+	}
+	r, _ := newTestReconciler(t, instaslice)
+	r.Config.PriorityClassReservations = map[string]int{"high-priority": 8}
+
+	_, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-1", "1g.5gb"))
+	assert.ErrorContains(t, err, "capacity on node node-1 is reserved for other priority classes")
+
+	pod := newTestGatedPod("pod-2", "1g.5gb")
+	pod.Spec.PriorityClassName = "high-priority"
+	allocRequest, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, sortGPUs(instaslice)[1], allocResult.GPUUUID)
+	assert.Equal(t, "high-priority", allocRequest.PriorityClassName)
+}
+
+func TestFindNodeAndDeviceForASlice_DisabledGPUs(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpuUUIDs := sortGPUs(instaslice)
+	instaslice.Spec.DisabledGPUs = []string{gpuUUIDs[0]}
+	r, _ := newTestReconciler(t, instaslice)
+
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-1", "1g.5gb"))
+	assert.NoError(t, err)
+	assert.Equal(t, gpuUUIDs[1], allocResult.GPUUUID)
+
+	instaslice.Spec.DisabledGPUs = gpuUUIDs
+	disabledReconciler, _ := newTestReconciler(t, instaslice)
+	_, _, err = disabledReconciler.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "1g.5gb"))
+	assert.Error(t, err)
+}
+
+func TestFindNodeAndDeviceForASlice_PendingAllocationHoldsSlot(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpuUUIDs := sortGPUs(instaslice)
+	// the slice of a pending allocation has not been created by the daemonset yet
+	uid := types.UID("pending")
+	instaslice.Spec.PodAllocationRequests[uid] = inferencev1alpha1.AllocationRequest{Profile: "1g.5gb"}
+	instaslice.Status.PodAllocationResults[uid] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 1},
+		GPUUUID:          gpuUUIDs[0],
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	r, _ := newTestReconciler(t, instaslice)
+
+	_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-1", "1g.5gb"))
+	assert.NoError(t, err)
+	assert.False(t, allocResult.GPUUUID == gpuUUIDs[0] && allocResult.MigPlacement.Start == 0)
+
+	pod := newTestGatedPod("pod-2", "1g.5gb")
+	pod.Annotations = map[string]string{StartOffsetAnnotation: "0"}
+	_, allocResult, err = r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, gpuUUIDs[1], allocResult.GPUUUID)
+}
+
+func TestFindNodeAndDeviceForASlice_ReservationWindows(t *testing.T) {
+	ctx := context.TODO()
+	gpuUUIDs := sortGPUs(utils.GenerateFakeCapacity("node-1"))
+	tests := []struct {
+		name        string
+		start       time.Duration
+		end         time.Duration
+		namespace   string
+		expectedGPU string
+	}{
+		{name: "booking owner allocates on the booked GPU", start: -time.Hour, end: time.Hour, namespace: "research", expectedGPU: gpuUUIDs[0]},
+		{name: "other namespaces are kept off during the window", start: -time.Hour, end: time.Hour, namespace: "default", expectedGPU: gpuUUIDs[1]},
+		{name: "booked GPU is free again after the window", start: -2 * time.Hour, end: -time.Hour, namespace: "default", expectedGPU: gpuUUIDs[0]},
+		{name: "booked GPU is free before the window", start: time.Hour, end: 2 * time.Hour, namespace: "default", expectedGPU: gpuUUIDs[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			now := time.Now()
+			instaslice.Spec.ReservationWindows = []inferencev1alpha1.ReservationWindow{{
+				GPUUUIDs:  []string{gpuUUIDs[0]},
+				Namespace: "research",
+				Start:     metav1.NewTime(now.Add(tt.start)),
+				End:       metav1.NewTime(now.Add(tt.end)),
+			}}
+			r, _ := newTestReconciler(t, instaslice)
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Namespace = tt.namespace
+
+			_, allocResult, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedGPU, allocResult.GPUUUID)
+		})
+	}
+}
+
+func TestWhatIf(t *testing.T) {
+	fullNode := func(name string) inferencev1alpha1.Instaslice {
+		instaslice := utils.GenerateFakeCapacity(name)
+		for i, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+			podUID := types.UID(fmt.Sprintf("full-%d", i))
+			instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: "7g.40gb"}
+			instaslice.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+				MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8},
+				GPUUUID:      gpu.GPUUUID,
+			}
+		}
+		return *instaslice
+	}
+	missingNode := func(name string) inferencev1alpha1.Instaslice {
+		instaslice := utils.GenerateFakeCapacity(name)
+		instaslice.Status.Conditions = []metav1.Condition{{Type: NodeAvailableCondition, Status: metav1.ConditionFalse}}
+		return *instaslice
+	}
+	sidecarPod := newTestGatedPod("pod-1", "1g.5gb")
+	sidecarPod.Spec.Containers = append([]v1.Container{{Name: "sidecar"}}, sidecarPod.Spec.Containers...)
+	multiGPUContainerPod := newTestGatedPod("pod-1", "1g.5gb")
+	multiGPUContainerPod.Spec.Containers = append(multiGPUContainerPod.Spec.Containers, *newTestGatedPod("pod-2", "2g.10gb").Spec.Containers[0].DeepCopy())
+	multiGPUContainerPod.Spec.Containers[1].Name = "gpu-2"
+	noProfilePod := newTestGatedPod("pod-1", "1g.5gb")
+	noProfilePod.Spec.Containers[0].Resources.Limits = v1.ResourceList{}
+	withoutProfile := func(name string, profileName string) inferencev1alpha1.Instaslice {
+		instaslice := utils.GenerateFakeCapacity(name)
+		delete(instaslice.Status.NodeResources.MigPlacement, profileName)
+		return *instaslice
+	}
+
+	tests := []struct {
+		name        string
+		instaslices []inferencev1alpha1.Instaslice
+		pod         *v1.Pod
+		wantNode    types.NodeName
+		wantStart   int32
+		wantErr     string
+		wantErrIs   error
+	}{
+		{
+			name:      "no nodes",
+			pod:       newTestGatedPod("pod-1", "1g.5gb"),
+			wantErrIs: ErrProfileUnknown,
+		},
+		{
+			name:        "nodes are tried in name order",
+			instaslices: []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-b"), *utils.GenerateFakeCapacity("node-a")},
+			pod:         newTestGatedPod("pod-1", "1g.5gb"),
+			wantNode:    "node-a",
+		},
+		{
+			name:        "full node is skipped",
+			instaslices: []inferencev1alpha1.Instaslice{fullNode("node-a"), *utils.GenerateFakeCapacity("node-b")},
+			pod:         newTestGatedPod("pod-1", "4g.20gb"),
+			wantNode:    "node-b",
+		},
+		{
+			name:        "missing node is skipped",
+			instaslices: []inferencev1alpha1.Instaslice{missingNode("node-a"), *utils.GenerateFakeCapacity("node-b")},
+			pod:         newTestGatedPod("pod-1", "1g.5gb"),
+			wantNode:    "node-b",
+		},
+		{
+			name:        "no capacity left",
+			instaslices: []inferencev1alpha1.Instaslice{fullNode("node-a")},
+			pod:         newTestGatedPod("pod-1", "1g.5gb"),
+			wantErr:     "failed to find allocatable node and gpu",
+			wantErrIs:   ErrProfileFull,
+		},
+		{
+			name:        "profile not offered by any GPU",
+			instaslices: []inferencev1alpha1.Instaslice{withoutProfile("node-a", "1g.5gb"), withoutProfile("node-b", "1g.5gb")},
+			pod:         newTestGatedPod("pod-1", "1g.5gb"),
+			wantErr:     "profile is not offered by any GPU: 1g.5gb",
+			wantErrIs:   ErrProfileUnknown,
+		},
+		{
+			name:        "profile offered by a full node only",
+			instaslices: []inferencev1alpha1.Instaslice{fullNode("node-a"), withoutProfile("node-b", "1g.5gb")},
+			pod:         newTestGatedPod("pod-1", "1g.5gb"),
+			wantErrIs:   ErrProfileFull,
+		},
+		{
+			name:        "sidecar without a profile is ignored",
+			instaslices: []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-a")},
+			pod:         sidecarPod,
+			wantNode:    "node-a",
+		},
+		{
+			name:        "multiple GPU containers",
+			instaslices: []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-a")},
+			pod:         multiGPUContainerPod,
+			wantErr:     multipleGPUContainersErr,
+		},
+		{
+			name:        "no profile requested",
+			instaslices: []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-a")},
+			pod:         noProfilePod,
+			wantErr:     "does not request an InstaSlice profile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original []inferencev1alpha1.Instaslice
+			for i := range tt.instaslices {
+				original = append(original, *tt.instaslices[i].DeepCopy())
+			}
+
+			details, err := WhatIf(tt.instaslices, tt.pod, &FirstFitPolicy{})
+			// the preview never changes the objects it was given
+			assert.Equal(t, original, tt.instaslices)
+			if tt.wantErr != "" || tt.wantErrIs != nil {
+				if tt.wantErr != "" {
+					assert.ErrorContains(t, err, tt.wantErr)
+				}
+				if tt.wantErrIs != nil {
+					assert.ErrorIs(t, err, tt.wantErrIs)
+				}
+				assert.Nil(t, details)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantNode, details.Result.Nodename)
+				assert.Equal(t, tt.wantStart, details.Result.MigPlacement.Start)
+				assert.Equal(t, tt.pod.UID, details.Request.PodRef.UID)
+				assert.Equal(t, FirstFitPolicyName, details.Result.Policy)
+			}
+		})
+	}
+}
+
+func TestFindNodeAndDeviceForASlice_WorkloadCIEngProfile(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name         string
+		workloadType string
+		want         int32
+	}{
+		{name: "latency workload", workloadType: "latency", want: 1},
+		{name: "throughput workload", workloadType: "throughput", want: 2},
+		{name: "unconfigured workload type keeps the discovered profile", workloadType: "batch", want: 5},
+		{name: "no workload type keeps the discovered profile", want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			mig := instaslice.Status.NodeResources.MigPlacement["1g.5gb"]
+			mig.CIEngProfileID = 5
+			instaslice.Status.NodeResources.MigPlacement["1g.5gb"] = mig
+			r, _ := newTestReconciler(t, instaslice)
+			r.Config.WorkloadCIEngProfiles = map[string]int{"latency": 1, "throughput": 2}
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			if tt.workloadType != "" {
+				pod.Annotations = map[string]string{WorkloadTypeAnnotation: tt.workloadType}
+			}
+
+			allocRequest, _, err := r.findNodeAndDeviceForASlice(ctx, instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, allocRequest.CIEngProfileID)
+			}
+		})
+	}
+}
+
+func TestRightToLeftPolicy(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpuUUID := sortGPUs(instaslice)[0]
+	// indexes 2-3 and 6-7 are held, leaving the free regions 0-1 and 4-5
+	for _, start := range []int32{2, 6} {
+		podUID := types.UID(fmt.Sprintf("held-%d", start))
+		instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: "2g.10gb"}
+		instaslice.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+			MigPlacement: inferencev1alpha1.Placement{Start: start, Size: 2},
+			GPUUUID:      gpuUUID,
+		}
+	}
+	tests := []struct {
+		name      string
+		policy    AllocationPolicy
+		wantStart int32
+	}{
+		{name: "first fit takes the lowest free region", policy: &FirstFitPolicy{}, wantStart: 0},
+		{name: "right to left takes the highest free region", policy: &RightToLeftPolicy{}, wantStart: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := WhatIf([]inferencev1alpha1.Instaslice{*instaslice}, newTestGatedPod("pod-1", "2g.10gb"), tt.policy)
+			if assert.NoError(t, err) {
+				assert.Equal(t, gpuUUID, details.Result.GPUUUID)
+				assert.Equal(t, tt.wantStart, details.Result.MigPlacement.Start)
+				assert.Equal(t, policyName(tt.policy), details.Result.Policy)
+			}
+		})
+	}
+}
+
+func TestBestFitPolicy(t *testing.T) {
+	type placementWant struct {
+		gpu   int
+		start int32
+	}
+	hold := func(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, start, size int32) {
+		podUID := types.UID(fmt.Sprintf("held-%s-%d", gpuUUID, start))
+		instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: "1g.5gb"}
+		instaslice.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+			MigPlacement: inferencev1alpha1.Placement{Start: start, Size: size},
+			GPUUUID:      gpuUUID,
+		}
+	}
+	tests := []struct {
+		name   string
+		layout func(instaslice *inferencev1alpha1.Instaslice, first, second string)
+		// the GPU, by sorted index, and the start each policy places the slice at
+		wantFirstFit placementWant
+		wantBestFit  placementWant
+	}{
+		{
+			// the first GPU has six free indexes, the second a single free index
+			name: "tightest GPU",
+			layout: func(instaslice *inferencev1alpha1.Instaslice, first, second string) {
+				hold(instaslice, first, 0, 2)
+				hold(instaslice, second, 0, 3)
+				hold(instaslice, second, 4, 4)
+			},
+			wantFirstFit: placementWant{gpu: 0, start: 2},
+			wantBestFit:  placementWant{gpu: 1, start: 3},
+		},
+		{
+			// the first GPU has the free regions 0-3 and 6-7, the second GPU is full
+			name: "tightest region on a GPU",
+			layout: func(instaslice *inferencev1alpha1.Instaslice, first, second string) {
+				hold(instaslice, first, 4, 2)
+				hold(instaslice, second, 0, 8)
+			},
+			wantFirstFit: placementWant{gpu: 0, start: 0},
+			wantBestFit:  placementWant{gpu: 0, start: 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			gpus := sortGPUs(instaslice)
+			tt.layout(instaslice, gpus[0], gpus[1])
+			for policy, want := range map[AllocationPolicy]placementWant{&FirstFitPolicy{}: tt.wantFirstFit, &BestFitPolicy{}: tt.wantBestFit} {
+				details, err := WhatIf([]inferencev1alpha1.Instaslice{*instaslice}, newTestGatedPod("pod-1", "1g.5gb"), policy)
+				if assert.NoError(t, err, policyName(policy)) {
+					assert.Equal(t, gpus[want.gpu], details.Result.GPUUUID, policyName(policy))
+					assert.Equal(t, want.start, details.Result.MigPlacement.Start, policyName(policy))
+				}
+			}
+		})
+	}
+
+	t.Run("tightest node", func(t *testing.T) {
+		idle := utils.GenerateFakeCapacity("node-1")
+		// the first GPU of the second node has the free region 6-7
+		busy := utils.GenerateFakeCapacity("node-2")
+		hold(busy, sortGPUs(busy)[0], 0, 6)
+
+		details, err := WhatIf([]inferencev1alpha1.Instaslice{*idle, *busy}, newTestGatedPod("pod-1", "1g.5gb"), &BestFitPolicy{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, types.NodeName("node-2"), details.Result.Nodename)
+		}
+
+		ctx := context.TODO()
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		r, fakeClient := newTestReconciler(t, pod, idle, busy)
+		r.Policy = &BestFitPolicy{}
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-2", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Contains(t, instaslice.Status.PodAllocationResults, pod.UID)
+	})
+}
+
+func TestIsLegalGeometry(t *testing.T) {
+	hold := func(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string, start, size int32) {
+		podUID := types.UID(fmt.Sprintf("held-%s-%d", profileName, start))
+		instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: profileName}
+		instaslice.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+			MigPlacement: inferencev1alpha1.Placement{Start: start, Size: size},
+			GPUUUID:      gpuUUID,
+		}
+	}
+	r := &InstasliceReconciler{}
+
+	t.Run("4g.20gb and 3g.20gb coexist", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		gpuUUID := sortGPUs(instaslice)[0]
+		hold(instaslice, gpuUUID, "4g.20gb", 0, 4)
+		assert.True(t, r.isLegalGeometry(instaslice, gpuUUID, "3g.20gb", 4))
+	})
+
+	t.Run("compute slices exceed the GPU", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		gpuUUID := sortGPUs(instaslice)[0]
+		// a placement advertised beyond the seven compute slices of the GPU
+		mig := instaslice.Status.NodeResources.MigPlacement["1g.5gb"]
+		mig.Placements = append(mig.Placements, inferencev1alpha1.Placement{Size: 1, Start: 7})
+		instaslice.Status.NodeResources.MigPlacement["1g.5gb"] = mig
+		for start := int32(0); start < 7; start++ {
+			hold(instaslice, gpuUUID, "1g.5gb", start, 1)
+		}
+		assert.True(t, r.isPlacementFree(instaslice, gpuUUID, "1g.5gb", 7))
+		assert.False(t, r.isLegalGeometry(instaslice, gpuUUID, "1g.5gb", 7))
+
+		// the placement is rejected on the only GPU of the node
+		instaslice.Status.NodeResources.NodeGPUs = slices.DeleteFunc(instaslice.Status.NodeResources.NodeGPUs, func(gpu inferencev1alpha1.DiscoveredGPU) bool {
+			return gpu.GPUUUID != gpuUUID
+		})
+		_, err := WhatIf([]inferencev1alpha1.Instaslice{*instaslice}, newTestGatedPod("pod-1", "1g.5gb"), &FirstFitPolicy{})
+		assert.ErrorContains(t, err, "does not form a legal MIG geometry")
+	})
+
+	t.Run("start not advertised for the profile", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		gpuUUID := sortGPUs(instaslice)[0]
+		assert.False(t, r.isLegalGeometry(instaslice, gpuUUID, "3g.20gb", 2))
+	})
+}
+
+// shiftingPolicy is a faulty policy moving the placements of first fit by shift indexes
+type shiftingPolicy struct {
+	FirstFitPolicy
+	shift int32
+}
+
+func (p *shiftingPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
+	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
+	namespace string, podName string, gpuUuid string, resourceIndetifier types.UID, nodeResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
+	allocRequest, allocResult := p.FirstFitPolicy.SetAllocationDetails(profileName, newStart, size, podUUID, nodename, allocationStatus,
+		discoveredGiprofile, Ciprofileid, Ciengprofileid, namespace, podName, gpuUuid, resourceIndetifier, nodeResourceList)
+	allocResult.MigPlacement.Start += p.shift
+	return allocRequest, allocResult
+}
+
+func TestValidatePlacement(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	gpuUUID := sortGPUs(instaslice)[0]
+	otherGPU := sortGPUs(instaslice)[1]
+	instaslice.Spec.PodAllocationRequests["held"] = inferencev1alpha1.AllocationRequest{Profile: "2g.10gb"}
+	instaslice.Status.PodAllocationResults["held"] = inferencev1alpha1.AllocationResult{
+		MigPlacement: inferencev1alpha1.Placement{Start: 2, Size: 2},
+		GPUUUID:      gpuUUID,
+	}
+	instaslice.Status.PodAllocationResults["deleted"] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 4, Size: 4},
+		GPUUUID:          gpuUUID,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusDeleted},
+	}
+	tests := []struct {
+		name      string
+		gpuUUID   string
+		placement inferencev1alpha1.Placement
+		errMsg    string
+	}{
+		{name: "free placement", gpuUUID: gpuUUID, placement: inferencev1alpha1.Placement{Start: 0, Size: 2}},
+		{name: "slice deleted by the daemonset", gpuUUID: gpuUUID, placement: inferencev1alpha1.Placement{Start: 4, Size: 4}},
+		{name: "slice on another GPU", gpuUUID: otherGPU, placement: inferencev1alpha1.Placement{Start: 2, Size: 2}},
+		{name: "past the end of the GPU", gpuUUID: gpuUUID, placement: inferencev1alpha1.Placement{Start: 6, Size: 4}, errMsg: "exceed the 8 slice indexes"},
+		{name: "negative start", gpuUUID: gpuUUID, placement: inferencev1alpha1.Placement{Start: -1, Size: 1}, errMsg: "exceed the 8 slice indexes"},
+		{name: "empty slice", gpuUUID: gpuUUID, placement: inferencev1alpha1.Placement{Start: 0, Size: 0}, errMsg: "exceed the 8 slice indexes"},
+		{name: "overlapping slice", gpuUUID: gpuUUID, placement: inferencev1alpha1.Placement{Start: 3, Size: 1}, errMsg: "overlap the slice held"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePlacement(instaslice, &inferencev1alpha1.AllocationResult{MigPlacement: tt.placement, GPUUUID: tt.gpuUUID})
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidPlacement)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestReconcile_InvalidPlacement(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		shift   int32
+		// held places a slice of another pod at the start of the GPU
+		held   bool
+		errMsg string
+	}{
+		// first fit places a 7g.40gb slice at 0, shifted it runs past the end of the GPU
+		{name: "out of range", profile: "7g.40gb", shift: 1, errMsg: "exceed the 8 slice indexes"},
+		// first fit places a 1g.5gb slice next to the held one, shifted back it lands on it
+		{name: "overlapping", profile: "1g.5gb", shift: -1, held: true, errMsg: "overlap the slice held"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			pod := newTestGatedPod("pod-1", tt.profile)
+			pod.Finalizers = []string{FinalizerName}
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			// the only enabled GPU of the node
+			gpuUUID := sortGPUs(instaslice)[0]
+			for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+				if gpu.GPUUUID != gpuUUID {
+					instaslice.Spec.DisabledGPUs = append(instaslice.Spec.DisabledGPUs, gpu.GPUUUID)
+				}
+			}
+			if tt.held {
+				instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{"held": {Profile: "1g.5gb"}}
+				instaslice.Status.PodAllocationResults = map[types.UID]inferencev1alpha1.AllocationResult{"held": {
+					MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1},
+					GPUUUID:      gpuUUID,
+					AllocationStatus: inferencev1alpha1.AllocationStatus{
+						AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated,
+					},
+				}}
+			}
+			r, fakeClient := newTestReconciler(t, pod, instaslice)
+			r.Policy = &shiftingPolicy{shift: tt.shift}
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+			assert.False(t, hasPodAllocation(pod.UID, instasliceList.Items))
+			// the pod stays gated with an event explaining why
+			assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+			assert.True(t, checkIfPodGatedByInstaSlice(pod, GateName))
+			if assert.NotEmpty(t, recorder.Events) {
+				event := <-recorder.Events
+				assert.Contains(t, event, "InvalidPlacement")
+				assert.Contains(t, event, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestReconcile_PreferredGPU(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name          string
+		preferredFull bool
+		wantNode      string
+		wantGPUIndex  int
+	}{
+		{name: "previously used GPU is preferred", wantNode: "node-b", wantGPUIndex: 1},
+		{name: "full GPU falls back to the other GPU of its node", preferredFull: true, wantNode: "node-b", wantGPUIndex: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeA, nodeB := utils.GenerateFakeCapacity("node-a"), utils.GenerateFakeCapacity("node-b")
+			// GPU UUIDs are unique across nodes
+			for i := range nodeB.Status.NodeResources.NodeGPUs {
+				nodeB.Status.NodeResources.NodeGPUs[i].GPUUUID += "-b"
+			}
+			preferredGPU := sortGPUs(nodeB)[1]
+			if tt.preferredFull {
+				nodeB.Spec.PodAllocationRequests["full"] = inferencev1alpha1.AllocationRequest{Profile: "7g.40gb"}
+				nodeB.Status.PodAllocationResults["full"] = inferencev1alpha1.AllocationResult{
+					MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8},
+					GPUUUID:      preferredGPU,
+				}
+			}
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Annotations = map[string]string{PreferredGPUAnnotation: preferredGPU}
+			r, fakeClient := newTestReconciler(t, pod, nodeA, nodeB)
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: tt.wantNode, Namespace: InstaSliceOperatorNamespace}, instaslice))
+			if allocResult, ok := instaslice.Status.PodAllocationResults[pod.UID]; assert.True(t, ok) {
+				assert.Equal(t, sortGPUs(instaslice)[tt.wantGPUIndex], allocResult.GPUUUID)
+			}
+		})
+	}
+}
+
+func TestReconcile_PreferredGPUGeneration(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name                string
+		namespaceGeneration string
+		podAnnotations      map[string]string
+		wantNode            string
+	}{
+		{name: "nodes are tried in name order without a preference", wantNode: "node-a"},
+		{name: "namespace preference steers placement", namespaceGeneration: "H100", wantNode: "node-b"},
+		{name: "generation is matched case-insensitively", namespaceGeneration: "h100", wantNode: "node-b"},
+		{
+			name:                "pod preference wins over the namespace",
+			namespaceGeneration: "H100",
+			podAnnotations:      map[string]string{PreferredGPUGenerationAnnotation: "A100"},
+			wantNode:            "node-a",
+		},
+		{
+			name:                "empty pod preference opts out of the namespace default",
+			namespaceGeneration: "H100",
+			podAnnotations:      map[string]string{PreferredGPUGenerationAnnotation: ""},
+			wantNode:            "node-a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeA, nodeB := utils.GenerateFakeCapacity("node-a"), utils.GenerateFakeCapacity("node-b")
+			for i := range nodeB.Status.NodeResources.NodeGPUs {
+				nodeB.Status.NodeResources.NodeGPUs[i].GPUUUID += "-b"
+				nodeB.Status.NodeResources.NodeGPUs[i].GPUName = "NVIDIA H100 80GB HBM3"
+			}
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			if tt.namespaceGeneration != "" {
+				namespace.Annotations = map[string]string{PreferredGPUGenerationAnnotation: tt.namespaceGeneration}
+			}
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Annotations = tt.podAnnotations
+			r, fakeClient := newTestReconciler(t, pod, namespace, nodeA, nodeB)
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: tt.wantNode, Namespace: InstaSliceOperatorNamespace}, instaslice))
+			assert.Contains(t, instaslice.Status.PodAllocationResults, pod.UID)
+		})
+	}
+}
+
+func TestOrderCandidates(t *testing.T) {
+	names := func(instaslices []inferencev1alpha1.Instaslice) []string {
+		var names []string
+		for _, instaslice := range instaslices {
+			names = append(names, instaslice.Name)
+		}
+		return names
+	}
+	// the GPUs of node-0 have the free region 6-7 only, the other nodes are idle
+	newCandidates := func() []inferencev1alpha1.Instaslice {
+		busy := utils.GenerateFakeCapacity("node-0")
+		for _, gpuUUID := range sortGPUs(busy) {
+			podUID := types.UID("held-" + gpuUUID)
+			busy.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: "1g.5gb"}
+			busy.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+				MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 6},
+				GPUUUID:      gpuUUID,
+			}
+		}
+		return []inferencev1alpha1.Instaslice{*utils.GenerateFakeCapacity("node-b"), *busy, *utils.GenerateFakeCapacity("node-a")}
+	}
+	tests := []struct {
+		name       string
+		policy     AllocationPolicy
+		pinnedNode string
+		want       []string
+	}{
+		{name: "first fit goes by name", policy: &FirstFitPolicy{}, want: []string{"node-0", "node-a", "node-b"}},
+		{name: "best fit tries the tightest node first", policy: &BestFitPolicy{}, want: []string{"node-0", "node-a", "node-b"}},
+		{name: "worst fit tries the tightest node last", policy: &WorstFitPolicy{}, want: []string{"node-a", "node-b", "node-0"}},
+		{name: "the pinned node comes first", policy: &WorstFitPolicy{}, pinnedNode: "node-b", want: []string{"node-b", "node-a", "node-0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			if tt.pinnedNode != "" {
+				pod.Spec.NodeSelector = map[string]string{NodeLabel: tt.pinnedNode}
+			}
+			candidates := newCandidates()
+			(&InstasliceReconciler{}).orderCandidates(candidates, pod, tt.policy, "1g.5gb", "")
+			assert.Equal(t, tt.want, names(candidates))
+		})
+	}
+}
+
+func TestWorstFitPolicy(t *testing.T) {
+	hold := func(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, start, size int32) {
+		podUID := types.UID(fmt.Sprintf("held-%s-%d", gpuUUID, start))
+		instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: "1g.5gb"}
+		instaslice.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+			MigPlacement: inferencev1alpha1.Placement{Start: start, Size: size},
+			GPUUUID:      gpuUUID,
+		}
+	}
+
+	t.Run("emptier GPU of the node", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		gpus := sortGPUs(instaslice)
+		// the first GPU has six free indexes, the second seven
+		hold(instaslice, gpus[0], 0, 2)
+		hold(instaslice, gpus[1], 0, 1)
+
+		details, err := WhatIf([]inferencev1alpha1.Instaslice{*instaslice}, newTestGatedPod("pod-1", "1g.5gb"), &WorstFitPolicy{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, gpus[1], details.Result.GPUUUID)
+			assert.Equal(t, int32(1), details.Result.MigPlacement.Start)
+			assert.Equal(t, WorstFitPolicyName, details.Result.Policy)
+		}
+		// first fit packs the slice onto the first GPU
+		details, err = WhatIf([]inferencev1alpha1.Instaslice{*instaslice}, newTestGatedPod("pod-1", "1g.5gb"), &FirstFitPolicy{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, gpus[0], details.Result.GPUUUID)
+		}
+	})
+
+	t.Run("widest region on a GPU", func(t *testing.T) {
+		instaslice := utils.GenerateFakeCapacity("node-1")
+		gpus := sortGPUs(instaslice)
+		// the first GPU has the free regions 0-1 and 3-7, the second GPU is full
+		hold(instaslice, gpus[0], 2, 1)
+		hold(instaslice, gpus[1], 0, 8)
+
+		details, err := WhatIf([]inferencev1alpha1.Instaslice{*instaslice}, newTestGatedPod("pod-1", "1g.5gb"), &WorstFitPolicy{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, gpus[0], details.Result.GPUUUID)
+			assert.Equal(t, int32(3), details.Result.MigPlacement.Start)
+		}
+	})
+
+	t.Run("emptier node", func(t *testing.T) {
+		busy := utils.GenerateFakeCapacity("node-1")
+		for _, gpuUUID := range sortGPUs(busy) {
+			hold(busy, gpuUUID, 0, 4)
+		}
+		idle := utils.GenerateFakeCapacity("node-2")
+
+		details, err := WhatIf([]inferencev1alpha1.Instaslice{*busy, *idle}, newTestGatedPod("pod-1", "1g.5gb"), &WorstFitPolicy{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, types.NodeName("node-2"), details.Result.Nodename)
+		}
+	})
+}
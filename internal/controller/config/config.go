@@ -2,8 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -12,6 +15,46 @@ const (
 	// TODO fix this image
 	DefaultDaemonsetImage    = "quay.io/amalvank/instaslicev2-daemonset:latest"
 	DefaultManifestConfigDir = "/config"
+	DefaultOperatorNamespace = "instaslice-system"
+	// the scheduling gate and finalizer set on the pods of the installation, installations sharing a cluster
+	// need distinct ones
+	DefaultGateName      = "instaslice.redhat.com/accelerator"
+	DefaultFinalizerName = DefaultGateName
+	// deleted pods keep their slices this long, e.g. to checkpoint on SIGTERM
+	DefaultGracefulDeletionTimeout = 30 * time.Second
+	// the deletion timeout a pod annotation asks for is capped so that a pod cannot hold its slices indefinitely
+	DefaultMaxDeletionTimeout = 10 * time.Minute
+	// failed pods release their slice immediately unless a retention is configured
+	DefaultFailedPodRetention = 0 * time.Second
+	DefaultSweepInterval      = 30 * time.Second
+	DefaultSweepConcurrency   = 4
+	// allocation counts are only sampled into the Instaslice status once a history limit is configured
+	DefaultAllocationHistory  = 0
+	DefaultAllocationGrace    = 0 * time.Second
+	DefaultGiveUpTimeout      = 0 * time.Second
+	DefaultMaxAllocationAge   = 0 * time.Second
+	DefaultMaxRealizationWait = 0 * time.Second
+	// an allocation the daemonset did not start creating within this long is given up
+	DefaultCreationTimeout = 5 * time.Minute
+	// a teardown the teardown webhook did not approve within this long goes ahead anyway
+	DefaultTeardownApprovalTimeout = 2 * time.Minute
+	DefaultValidateMigGeometry     = true
+	// a ready daemonset pod is trusted for this long before the daemonset pods are listed again
+	DefaultDaemonsetReadinessTTL = 10 * time.Second
+	// pods are only ungated onto nodes whose device plugin pod is ready once a namespace is configured
+	DefaultDevicePluginNamespace = ""
+	DefaultDevicePluginPrefix    = "nvidia-device-plugin-daemonset"
+	// conflicts committing an allocation to a node before the next feasible node is tried
+	DefaultAllocationConflictLimit = 3
+	// warm slices of a workload without pods are kept this long before they are released
+	DefaultWarmPoolTTL = 15 * time.Minute
+	// nodes stop taking allocations and release their idle slices this long before their upgrade window opens
+	DefaultUpgradeDrainLead = 1 * time.Hour
+	// the allocation retries of a pod no node fits are spaced out up to this long
+	DefaultMaxAllocationBackoff = 30 * time.Second
+	// the requests of a pod are not checked against the size of its slices unless a threshold is configured
+	DefaultMaxMemoryPerSliceGB   = 0
+	DefaultMaxCPUPerSliceCompute = 0
 )
 
 type Config struct {
@@ -26,14 +69,171 @@ type Config struct {
 
 	// ManifestConfigDir manifest directory
 	ManifestConfigDir string `json:"manifest_config_dir"`
+
+	// OperatorNamespace the namespace the operator runs in, holding the Instaslice objects and the daemonset
+	OperatorNamespace string `json:"operator_namespace"`
+
+	// GateName the scheduling gate holding the pods of the installation until their slices are realized
+	GateName string `json:"gate_name"`
+
+	// FinalizerName the finalizer guarding the slices of the pods of the installation
+	FinalizerName string `json:"finalizer_name"`
+
+	// FailedPodRetention how long the slice of a failed pod is kept before it is released
+	FailedPodRetention time.Duration `json:"failed_pod_retention"`
+
+	// GracefulDeletionTimeout how long the slices of a deleted pod are kept before they are released
+	GracefulDeletionTimeout time.Duration `json:"graceful_deletion_timeout"`
+
+	// MaxDeletionTimeout the longest deletion timeout the annotation of a pod can set
+	MaxDeletionTimeout time.Duration `json:"max_deletion_timeout"`
+
+	// AllocationPolicy the policy placing slices on the GPUs, one of first-fit, left-to-right, right-to-left, best-fit
+	// or worst-fit
+	AllocationPolicy string `json:"allocation_policy,omitempty"`
+
+	// ValidateMigGeometry reject placements whose profiles do not form a legal MIG geometry on the GPU
+	ValidateMigGeometry bool `json:"validate_mig_geometry"`
+
+	// DaemonsetReadinessTTL how long the readiness of the daemonset pods is cached across reconciles, 0 lists
+	// the daemonset pods on every reconcile
+	DaemonsetReadinessTTL time.Duration `json:"daemonset_readiness_ttl"`
+
+	// DevicePluginNamespace the namespace of the GPU device plugin pods, e.g. gpu-operator, pods are only ungated
+	// onto a node whose device plugin pod is running and ready, empty disables the check
+	DevicePluginNamespace string `json:"device_plugin_namespace"`
+
+	// DevicePluginPrefix the name prefix of the GPU device plugin pods
+	DevicePluginPrefix string `json:"device_plugin_prefix"`
+
+	// AllocationConflictLimit how often committing the allocation of a pod to a node may conflict before the next
+	// feasible node is tried, 0 keeps retrying the same node
+	AllocationConflictLimit int `json:"allocation_conflict_limit"`
+
+	// SweepInterval how often the Instaslice objects are checked for consistency
+	SweepInterval time.Duration `json:"sweep_interval"`
+
+	// MaxMemoryPerSliceGB how many GiB of memory a pod may request per GB of memory of its slices, larger
+	// requests are rejected at admission as misconfigured, 0 disables the check
+	MaxMemoryPerSliceGB int `json:"max_memory_per_slice_gb"`
+
+	// MaxCPUPerSliceCompute how many CPU cores a pod may request per compute unit of its slices, larger
+	// requests are rejected at admission as misconfigured, 0 disables the check
+	MaxCPUPerSliceCompute int `json:"max_cpu_per_slice_compute"`
+
+	// SweepConcurrency how many Instaslice objects are checked in parallel by the sweep
+	SweepConcurrency int `json:"sweep_concurrency"`
+
+	// AllocateWithForeignGates allocate for pods that still carry scheduling gates of other controllers,
+	// the pod is ungated only once those gates are cleared
+	AllocateWithForeignGates bool `json:"allocate_with_foreign_gates"`
+
+	// AllocationHistoryLimit how many allocation count samples are retained per GPU, 0 disables sampling
+	AllocationHistoryLimit int `json:"allocation_history_limit"`
+
+	// AllocationGracePeriod how long a newly created pod is left to other admission controllers before allocating
+	AllocationGracePeriod time.Duration `json:"allocation_grace_period"`
+
+	// GiveUpTimeout how long allocation is retried for a pod before giving up, 0 retries forever
+	GiveUpTimeout time.Duration `json:"give_up_timeout"`
+
+	// SchedulerGiveUpTimeouts give-up timeouts overriding GiveUpTimeout for pods of a schedulerName
+	SchedulerGiveUpTimeouts map[string]time.Duration `json:"scheduler_give_up_timeouts,omitempty"`
+
+	// ProfileFallbackTimeouts how long a profile is retried before a pod allowing it falls back to another profile
+	ProfileFallbackTimeouts map[string]time.Duration `json:"profile_fallback_timeouts,omitempty"`
+
+	// PriorityClassReservations slice indexes reserved on every node for pods of a priority class
+	PriorityClassReservations map[string]int `json:"priority_class_reservations,omitempty"`
+
+	// WarmPools how many idle slices are kept realized for a workload, keyed by workload/profile, e.g.
+	// chat/1g.5gb. Pods labeled with the workload claim them instead of waiting for a slice to be created.
+	WarmPools map[string]int `json:"warm_pools,omitempty"`
+
+	// WarmPoolTTL how long a warm slice may stay unclaimed once its workload has no pods before it is released
+	WarmPoolTTL time.Duration `json:"warm_pool_ttl"`
+
+	// WorkloadCIEngProfiles compute instance engineering profile used for pods annotated with a workload type,
+	// e.g. to tune latency and throughput workloads differently on the same GPU instance profile
+	WorkloadCIEngProfiles map[string]int `json:"workload_ci_eng_profiles,omitempty"`
+
+	// LogLevels log verbosity of the allocation, deletion and readiness subsystems, keyed by subsystem, e.g.
+	// allocation=1 logs the allocation decisions while the other subsystems stay at the base verbosity
+	LogLevels map[string]int `json:"log_levels,omitempty"`
+
+	// AccountingWebhookURL endpoint receiving an accounting record on every allocate and release, empty disables it
+	AccountingWebhookURL string `json:"accounting_webhook_url,omitempty"`
+
+	// TeardownWebhookURL endpoint asked to approve the teardown of a slice, giving the workload a chance to flush
+	// or checkpoint its state, empty tears slices down right away
+	TeardownWebhookURL string `json:"teardown_webhook_url,omitempty"`
+
+	// TeardownApprovalTimeout how long the teardown of a slice waits for the approval of the teardown webhook
+	// before it goes ahead anyway
+	TeardownApprovalTimeout time.Duration `json:"teardown_approval_timeout"`
+
+	// UpgradeDrainLead how long before the upgrade window annotated on a node it stops taking allocations and
+	// releases its idle slices, so that the node is empty once its running pods complete
+	UpgradeDrainLead time.Duration `json:"upgrade_drain_lead"`
+
+	// MaxAllocationBackoff caps the delay, doubled on every retry, before allocation is retried for a pod no node fits
+	MaxAllocationBackoff time.Duration `json:"max_allocation_backoff"`
+
+	// ReserveSurgeCapacity hold back capacity for the pending surge pods of Deployments during a rolling update
+	ReserveSurgeCapacity bool `json:"reserve_surge_capacity"`
+
+	// EnablePreemption release the slices of strictly lower priority pods when a pod finds no free slice
+	EnablePreemption bool `json:"enable_preemption"`
+
+	// ScaleUpHints mark pods that no GPU node fits as unschedulable so that the cluster autoscaler provisions a GPU node
+	ScaleUpHints bool `json:"scale_up_hints"`
+
+	// TracingEndpoint OTLP gRPC endpoint URL receiving the reconcile and allocation spans, empty disables tracing
+	TracingEndpoint string `json:"tracing_endpoint,omitempty"`
+
+	// MaxRealizationWait how long an allocation may wait for the daemonset to realize its slice before it is
+	// abandoned and the pod allocated afresh, 0 waits forever
+	MaxRealizationWait time.Duration `json:"max_realization_wait"`
+
+	// CreationTimeout how long an allocation may stay Creating without the daemonset creating its slice, e.g.
+	// after an NVML failure on the node, before it is given up and the pod allocated again, 0 waits forever
+	CreationTimeout time.Duration `json:"creation_timeout"`
+
+	// MaxAllocationAge how long a non-critical pod may hold its slice before it is evicted, 0 disables recycling
+	MaxAllocationAge time.Duration `json:"max_allocation_age"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		EmulatorModeEnable: DefaultEmulatorMode,
-		WebhookEnable:      DefaultWebhookMode,
-		DaemonsetImage:     DefaultDaemonsetImage,
-		ManifestConfigDir:  DefaultManifestConfigDir,
+		EmulatorModeEnable:      DefaultEmulatorMode,
+		WebhookEnable:           DefaultWebhookMode,
+		DaemonsetImage:          DefaultDaemonsetImage,
+		ManifestConfigDir:       DefaultManifestConfigDir,
+		OperatorNamespace:       DefaultOperatorNamespace,
+		GateName:                DefaultGateName,
+		FinalizerName:           DefaultFinalizerName,
+		FailedPodRetention:      DefaultFailedPodRetention,
+		GracefulDeletionTimeout: DefaultGracefulDeletionTimeout,
+		MaxDeletionTimeout:      DefaultMaxDeletionTimeout,
+		SweepInterval:           DefaultSweepInterval,
+		SweepConcurrency:        DefaultSweepConcurrency,
+		AllocationHistoryLimit:  DefaultAllocationHistory,
+		AllocationGracePeriod:   DefaultAllocationGrace,
+		GiveUpTimeout:           DefaultGiveUpTimeout,
+		MaxAllocationAge:        DefaultMaxAllocationAge,
+		MaxRealizationWait:      DefaultMaxRealizationWait,
+		CreationTimeout:         DefaultCreationTimeout,
+		TeardownApprovalTimeout: DefaultTeardownApprovalTimeout,
+		ValidateMigGeometry:     DefaultValidateMigGeometry,
+		DaemonsetReadinessTTL:   DefaultDaemonsetReadinessTTL,
+		DevicePluginNamespace:   DefaultDevicePluginNamespace,
+		DevicePluginPrefix:      DefaultDevicePluginPrefix,
+		AllocationConflictLimit: DefaultAllocationConflictLimit,
+		WarmPoolTTL:             DefaultWarmPoolTTL,
+		UpgradeDrainLead:        DefaultUpgradeDrainLead,
+		MaxAllocationBackoff:    DefaultMaxAllocationBackoff,
+		MaxMemoryPerSliceGB:     DefaultMaxMemoryPerSliceGB,
+		MaxCPUPerSliceCompute:   DefaultMaxCPUPerSliceCompute,
 	}
 }
 
@@ -42,6 +242,16 @@ func (c *Config) ToString() string {
 	return string(bytes)
 }
 
+// Handler serves the effective configuration as JSON for debugging
+func (c *Config) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
 func ConfigFromEnvironment() *Config {
 	config := NewConfig()
 
@@ -61,5 +271,244 @@ func ConfigFromEnvironment() *Config {
 		config.ManifestConfigDir = manifestConfigDir
 	}
 
+	if operatorNamespace, ok := os.LookupEnv("POD_NAMESPACE"); ok && operatorNamespace != "" {
+		config.OperatorNamespace = operatorNamespace
+	}
+
+	if gateName, ok := os.LookupEnv("GATE_NAME"); ok && gateName != "" {
+		config.GateName = gateName
+	}
+
+	if finalizerName, ok := os.LookupEnv("FINALIZER_NAME"); ok && finalizerName != "" {
+		config.FinalizerName = finalizerName
+	}
+
+	if failedPodRetention, ok := os.LookupEnv("FAILED_POD_RETENTION"); ok {
+		if retention, err := time.ParseDuration(failedPodRetention); err == nil {
+			config.FailedPodRetention = retention
+		}
+	}
+
+	if gracefulDeletionTimeout, ok := os.LookupEnv("GRACEFUL_DELETION_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(gracefulDeletionTimeout); err == nil && timeout >= 0 {
+			config.GracefulDeletionTimeout = timeout
+		}
+	}
+
+	if maxDeletionTimeout, ok := os.LookupEnv("MAX_DELETION_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(maxDeletionTimeout); err == nil && timeout >= 0 {
+			config.MaxDeletionTimeout = timeout
+		}
+	}
+
+	if allocationPolicy, ok := os.LookupEnv("ALLOCATION_POLICY"); ok {
+		config.AllocationPolicy = allocationPolicy
+	}
+
+	if validateMigGeometry, ok := os.LookupEnv("VALIDATE_MIG_GEOMETRY"); ok {
+		config.ValidateMigGeometry = validateMigGeometry != "false"
+	}
+
+	if daemonsetReadinessTTL, ok := os.LookupEnv("DAEMONSET_READINESS_TTL"); ok {
+		if ttl, err := time.ParseDuration(daemonsetReadinessTTL); err == nil && ttl >= 0 {
+			config.DaemonsetReadinessTTL = ttl
+		}
+	}
+
+	if devicePluginNamespace, ok := os.LookupEnv("DEVICE_PLUGIN_NAMESPACE"); ok {
+		config.DevicePluginNamespace = devicePluginNamespace
+	}
+
+	if devicePluginPrefix, ok := os.LookupEnv("DEVICE_PLUGIN_PREFIX"); ok && devicePluginPrefix != "" {
+		config.DevicePluginPrefix = devicePluginPrefix
+	}
+
+	if allocationConflictLimit, ok := os.LookupEnv("ALLOCATION_CONFLICT_LIMIT"); ok {
+		if limit, err := strconv.Atoi(allocationConflictLimit); err == nil && limit >= 0 {
+			config.AllocationConflictLimit = limit
+		}
+	}
+
+	if maxMemoryPerSliceGB, ok := os.LookupEnv("MAX_MEMORY_PER_SLICE_GB"); ok {
+		if ratio, err := strconv.Atoi(maxMemoryPerSliceGB); err == nil && ratio >= 0 {
+			config.MaxMemoryPerSliceGB = ratio
+		}
+	}
+
+	if maxCPUPerSliceCompute, ok := os.LookupEnv("MAX_CPU_PER_SLICE_COMPUTE"); ok {
+		if ratio, err := strconv.Atoi(maxCPUPerSliceCompute); err == nil && ratio >= 0 {
+			config.MaxCPUPerSliceCompute = ratio
+		}
+	}
+
+	if sweepInterval, ok := os.LookupEnv("SWEEP_INTERVAL"); ok {
+		if interval, err := time.ParseDuration(sweepInterval); err == nil && interval > 0 {
+			config.SweepInterval = interval
+		}
+	}
+
+	if sweepConcurrency, ok := os.LookupEnv("SWEEP_CONCURRENCY"); ok {
+		if concurrency, err := strconv.Atoi(sweepConcurrency); err == nil && concurrency > 0 {
+			config.SweepConcurrency = concurrency
+		}
+	}
+
+	if allocateWithForeignGates, ok := os.LookupEnv("ALLOCATE_WITH_FOREIGN_GATES"); ok {
+		config.AllocateWithForeignGates = strings.EqualFold(allocateWithForeignGates, "true")
+	}
+
+	if allocationHistoryLimit, ok := os.LookupEnv("ALLOCATION_HISTORY_LIMIT"); ok {
+		if limit, err := strconv.Atoi(allocationHistoryLimit); err == nil && limit >= 0 {
+			config.AllocationHistoryLimit = limit
+		}
+	}
+
+	if allocationGracePeriod, ok := os.LookupEnv("ALLOCATION_GRACE_PERIOD"); ok {
+		if grace, err := time.ParseDuration(allocationGracePeriod); err == nil && grace >= 0 {
+			config.AllocationGracePeriod = grace
+		}
+	}
+
+	if giveUpTimeout, ok := os.LookupEnv("GIVE_UP_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(giveUpTimeout); err == nil && timeout >= 0 {
+			config.GiveUpTimeout = timeout
+		}
+	}
+
+	if maxAllocationAge, ok := os.LookupEnv("MAX_ALLOCATION_AGE"); ok {
+		if age, err := time.ParseDuration(maxAllocationAge); err == nil && age >= 0 {
+			config.MaxAllocationAge = age
+		}
+	}
+
+	if maxRealizationWait, ok := os.LookupEnv("MAX_REALIZATION_WAIT"); ok {
+		if wait, err := time.ParseDuration(maxRealizationWait); err == nil && wait >= 0 {
+			config.MaxRealizationWait = wait
+		}
+	}
+
+	if creationTimeout, ok := os.LookupEnv("CREATION_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(creationTimeout); err == nil && timeout >= 0 {
+			config.CreationTimeout = timeout
+		}
+	}
+
+	if warmPoolTTL, ok := os.LookupEnv("WARM_POOL_TTL"); ok {
+		if ttl, err := time.ParseDuration(warmPoolTTL); err == nil && ttl >= 0 {
+			config.WarmPoolTTL = ttl
+		}
+	}
+
+	if reserveSurgeCapacity, ok := os.LookupEnv("RESERVE_SURGE_CAPACITY"); ok {
+		config.ReserveSurgeCapacity = strings.EqualFold(reserveSurgeCapacity, "true")
+	}
+
+	if enablePreemption, ok := os.LookupEnv("ENABLE_PREEMPTION"); ok {
+		config.EnablePreemption = strings.EqualFold(enablePreemption, "true")
+	}
+
+	if scaleUpHints, ok := os.LookupEnv("SCALE_UP_HINTS"); ok {
+		config.ScaleUpHints = strings.EqualFold(scaleUpHints, "true")
+	}
+
+	if accountingWebhookURL, ok := os.LookupEnv("ACCOUNTING_WEBHOOK_URL"); ok {
+		config.AccountingWebhookURL = accountingWebhookURL
+	}
+
+	if teardownWebhookURL, ok := os.LookupEnv("TEARDOWN_WEBHOOK_URL"); ok {
+		config.TeardownWebhookURL = teardownWebhookURL
+	}
+
+	if teardownApprovalTimeout, ok := os.LookupEnv("TEARDOWN_APPROVAL_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(teardownApprovalTimeout); err == nil && timeout >= 0 {
+			config.TeardownApprovalTimeout = timeout
+		}
+	}
+
+	if upgradeDrainLead, ok := os.LookupEnv("UPGRADE_DRAIN_LEAD"); ok {
+		if lead, err := time.ParseDuration(upgradeDrainLead); err == nil && lead >= 0 {
+			config.UpgradeDrainLead = lead
+		}
+	}
+
+	if maxAllocationBackoff, ok := os.LookupEnv("MAX_ALLOCATION_BACKOFF"); ok {
+		if backoff, err := time.ParseDuration(maxAllocationBackoff); err == nil && backoff > 0 {
+			config.MaxAllocationBackoff = backoff
+		}
+	}
+
+	if tracingEndpoint, ok := os.LookupEnv("TRACING_ENDPOINT"); ok {
+		config.TracingEndpoint = tracingEndpoint
+	}
+
+	// comma separated schedulerName=duration pairs, e.g. default-scheduler=10m,volcano=1h
+	if schedulerGiveUpTimeouts, ok := os.LookupEnv("SCHEDULER_GIVE_UP_TIMEOUTS"); ok {
+		config.SchedulerGiveUpTimeouts = parseDurations(schedulerGiveUpTimeouts)
+	}
+
+	// comma separated profile=duration pairs, e.g. 3g.20gb=5m,7g.40gb=10m
+	if profileFallbackTimeouts, ok := os.LookupEnv("PROFILE_FALLBACK_TIMEOUTS"); ok {
+		config.ProfileFallbackTimeouts = parseDurations(profileFallbackTimeouts)
+	}
+
+	// comma separated priorityClassName=indexes pairs, e.g. high-priority=8
+	if priorityClassReservations, ok := os.LookupEnv("PRIORITY_CLASS_RESERVATIONS"); ok {
+		config.PriorityClassReservations = parseInts(priorityClassReservations)
+	}
+
+	// comma separated workload/profile=count pairs, e.g. chat/1g.5gb=2
+	if warmPools, ok := os.LookupEnv("WARM_POOLS"); ok {
+		config.WarmPools = parseInts(warmPools)
+	}
+
+	// comma separated workloadType=ciEngProfileID pairs, e.g. latency=0,throughput=1
+	if workloadCIEngProfiles, ok := os.LookupEnv("WORKLOAD_CI_ENG_PROFILES"); ok {
+		config.WorkloadCIEngProfiles = parseInts(workloadCIEngProfiles)
+	}
+
+	if logLevels, ok := os.LookupEnv("LOG_LEVELS"); ok {
+		config.LogLevels = ParseLogLevels(logLevels)
+	}
+
 	return config
 }
+
+// ParseLogLevels parses comma separated subsystem=verbosity pairs, e.g. allocation=1,readiness=0
+func ParseLogLevels(value string) map[string]int {
+	return parseInts(value)
+}
+
+// ParseQuota parses comma separated limit=slices pairs, e.g. total=8,1g.5gb=4
+func ParseQuota(value string) map[string]int {
+	return parseInts(value)
+}
+
+// parseInts parses comma separated key=integer pairs, malformed and negative values are skipped
+func parseInts(value string) map[string]int {
+	ints := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		key, rawInt, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+		if parsed, err := strconv.Atoi(rawInt); err == nil && parsed >= 0 {
+			ints[key] = parsed
+		}
+	}
+	return ints
+}
+
+// parseDurations parses comma separated key=duration pairs, malformed pairs are skipped
+func parseDurations(value string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		key, rawDuration, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+		if duration, err := time.ParseDuration(rawDuration); err == nil && duration >= 0 {
+			durations[key] = duration
+		}
+	}
+	return durations
+}
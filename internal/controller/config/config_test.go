@@ -0,0 +1,52 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHandler(t *testing.T) {
+	t.Setenv("EMULATOR_MODE", "true")
+	t.Setenv("SWEEP_INTERVAL", "1m")
+	t.Setenv("GRACEFUL_DELETION_TIMEOUT", "10m")
+	t.Setenv("MAX_DELETION_TIMEOUT", "1h")
+	t.Setenv("SCHEDULER_GIVE_UP_TIMEOUTS", "batch-scheduler=5m")
+	t.Setenv("ALLOCATION_POLICY", "best-fit")
+	config := ConfigFromEnvironment()
+
+	recorder := httptest.NewRecorder()
+	config.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	served := &Config{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), served))
+	assert.True(t, served.EmulatorModeEnable)
+	assert.Equal(t, time.Minute, served.SweepInterval)
+	assert.Equal(t, 10*time.Minute, served.GracefulDeletionTimeout)
+	assert.Equal(t, time.Hour, served.MaxDeletionTimeout)
+	assert.Equal(t, 5*time.Minute, served.SchedulerGiveUpTimeouts["batch-scheduler"])
+	assert.Equal(t, "best-fit", served.AllocationPolicy)
+	assert.Equal(t, DefaultDaemonsetImage, served.DaemonsetImage)
+}
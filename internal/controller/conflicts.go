@@ -0,0 +1,66 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// apiReader returns the reader the Instaslice allocations are read with before they are updated, the cached
+// client when no API reader is set
+func (r *InstasliceReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// allocationConflicts counts per pod and node how often committing an allocation to the Instaslice of the
+// node conflicted, across reconciles
+type allocationConflicts struct {
+	mu     sync.Mutex
+	counts map[types.UID]map[string]int
+}
+
+// record counts a conflict committing the allocation of the pod to the node and reports whether the limit
+// is reached. The count of the node is reset then, the node is tried again once the other nodes were.
+func (c *allocationConflicts) record(podUID types.UID, nodeName string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[types.UID]map[string]int)
+	}
+	if c.counts[podUID] == nil {
+		c.counts[podUID] = make(map[string]int)
+	}
+	c.counts[podUID][nodeName]++
+	if limit <= 0 || c.counts[podUID][nodeName] < limit {
+		return false
+	}
+	delete(c.counts[podUID], nodeName)
+	return true
+}
+
+// forget drops the conflict counts of the pod once it no longer waits for an allocation
+func (c *allocationConflicts) forget(podUID types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, podUID)
+}
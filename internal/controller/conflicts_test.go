@@ -0,0 +1,229 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestReconcile_AllocationConflictFallback(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-a"), utils.GenerateFakeCapacity("node-b"))
+	r.Config.AllocationConflictLimit = 3
+	// every commit to node-a conflicts
+	r.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if _, ok := obj.(*inferencev1alpha1.Instaslice); ok && obj.GetName() == "node-a" {
+				return errors.NewConflict(inferencev1alpha1.GroupVersion.WithResource("instaslices").GroupResource(), obj.GetName(), nil)
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	allocatedOn := func(nodeName string) bool {
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: InstaSliceOperatorNamespace}, instaslice))
+		_, ok := instaslice.Status.PodAllocationResults[pod.UID]
+		return ok
+	}
+
+	// node-a is retried until the conflict limit is reached
+	for i := 0; i < 2; i++ {
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		assert.True(t, result.Requeue)
+		assert.False(t, allocatedOn("node-b"))
+	}
+
+	// the third conflict falls back to node-b
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.False(t, allocatedOn("node-a"))
+	assert.True(t, allocatedOn("node-b"))
+	assert.Empty(t, r.allocationConflicts.counts[pod.UID])
+}
+
+func TestReconcile_AllocationConflictFallbackOnStaleReads(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-a"), utils.GenerateFakeCapacity("node-b"))
+	r.Config.AllocationConflictLimit = 2
+	// node-a is always read at a resource version it was updated past, every patch of it conflicts
+	key := types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}
+	stale := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, stale))
+	assert.NoError(t, fakeClient.Update(ctx, stale.DeepCopy()))
+	r.APIReader = &staleReader{Reader: fakeClient, stale: stale, staleReads: -1}
+
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.True(t, result.Requeue)
+
+	// the second conflict falls back to node-b
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	for nodeName, allocated := range map[string]bool{"node-a": false, "node-b": true} {
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Equal(t, allocated, instaslice.Status.PodAllocationResults[pod.UID].GPUUUID != "", nodeName)
+	}
+}
+
+func TestReconcile_AllocationRetriesConflict(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+	// the first patch of the spec and of the status each hit a concurrent update of the daemonset
+	var specConflicts, statusConflicts int
+	conflict := errors.NewConflict(inferencev1alpha1.GroupVersion.WithResource("instaslices").GroupResource(), "node-1", nil)
+	r.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if _, ok := obj.(*inferencev1alpha1.Instaslice); ok && specConflicts == 0 {
+				specConflicts++
+				return conflict
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			if _, ok := obj.(*inferencev1alpha1.Instaslice); ok && statusConflicts == 0 {
+				statusConflicts++
+				return conflict
+			}
+			return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	})
+
+	// the allocation lands within the same reconcile
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.False(t, result.Requeue)
+	assert.Equal(t, 1, specConflicts)
+	assert.Equal(t, 1, statusConflicts)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+	assert.Empty(t, r.allocationConflicts.counts[pod.UID])
+}
+
+// staleReader reads a stale copy of an Instaslice instead of the current one, the given number of times or
+// always when negative
+type staleReader struct {
+	client.Reader
+	stale      *inferencev1alpha1.Instaslice
+	staleReads int
+	reads      int
+}
+
+func (s *staleReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	s.reads++
+	if instaslice, ok := obj.(*inferencev1alpha1.Instaslice); ok && key.Name == s.stale.Name && s.staleReads != 0 {
+		s.staleReads--
+		s.stale.DeepCopyInto(instaslice)
+		return nil
+	}
+	return s.Reader.Get(ctx, key, obj, opts...)
+}
+
+func TestReconcile_AllocationLockedOnStaleRead(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	running := newTestGatedPod("running", "1g.5gb")
+	instaslice := newTestAllocation("node-1", running, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+	})
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+	stale := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, stale))
+	// the daemonset created the slice of the other pod after the read
+	current := stale.DeepCopy()
+	allocResult := current.Status.PodAllocationResults[running.UID]
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	current.Status.PodAllocationResults[running.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	reader := &staleReader{Reader: fakeClient, stale: stale, staleReads: 1}
+	r.APIReader = reader
+
+	// the patch made on the stale read conflicts and is retried on a fresh read, the update of the daemonset
+	// is kept
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, reader.reads)
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Contains(t, current.Status.PodAllocationResults, pod.UID)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated,
+		current.Status.PodAllocationResults[running.UID].AllocationStatus.AllocationStatusDaemonset)
+}
+
+func TestAddInstasliceAllocations_Concurrent(t *testing.T) {
+	ctx := context.TODO()
+	podA := newTestGatedPod("pod-a", "1g.5gb")
+	podA.Finalizers = []string{FinalizerName}
+	podB := newTestGatedPod("pod-b", "1g.5gb")
+	podB.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, podA, podB, utils.GenerateFakeCapacity("node-1"))
+	instasliceKey := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+	stale := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, stale))
+	// both pods are placed on the same read, before either allocation is committed
+	place := func(pod *v1.Pod) ([]inferencev1alpha1.AllocationResult, []inferencev1alpha1.AllocationRequest) {
+		details, err := r.placeSlicesOnInstaslice(stale.DeepCopy(), "1g.5gb", &FirstFitPolicy{}, pod, 1, nil, time.Now())
+		assert.NoError(t, err)
+		return []inferencev1alpha1.AllocationResult{*details[0].Result}, []inferencev1alpha1.AllocationRequest{*details[0].Request}
+	}
+	resultsA, requestsA := place(podA)
+	resultsB, requestsB := place(podB)
+	assert.Equal(t, resultsA[0].MigPlacement, resultsB[0].MigPlacement)
+
+	// the second commit does not take the slice the first one just committed, even when it first reads the
+	// Instaslice from before the first commit: the patch is locked on that read and checked again on a fresh one
+	assert.NoError(t, utils.AddInstasliceAllocations(ctx, fakeClient, fakeClient, InstaSliceOperatorNamespace, "node-1", resultsA, requestsA))
+	reader := &staleReader{Reader: fakeClient, stale: stale, staleReads: 1}
+	err := utils.AddInstasliceAllocations(ctx, fakeClient, reader, InstaSliceOperatorNamespace, "node-1", resultsB, requestsB)
+	assert.True(t, errors.IsConflict(err))
+	assert.Equal(t, 2, reader.reads)
+
+	// allocated again on a fresh read, both allocations survive
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(podB)})
+	assert.NoError(t, err)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	for _, pod := range []*v1.Pod{podA, podB} {
+		assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+		assert.Contains(t, instaslice.Status.PodAllocationResults, pod.UID)
+	}
+	allocA, allocB := instaslice.Status.PodAllocationResults[podA.UID], instaslice.Status.PodAllocationResults[podB.UID]
+	assert.False(t, allocA.GPUUUID == allocB.GPUUUID && allocA.MigPlacement.Start == allocB.MigPlacement.Start)
+}
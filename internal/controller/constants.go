@@ -16,27 +16,83 @@ limitations under the License.
 
 package controller
 
-import "time"
+import (
+	"time"
+
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+)
 
 const (
-	OrgInstaslicePrefix              = "instaslice.redhat.com/"
-	GateName                         = OrgInstaslicePrefix + "accelerator"
-	FinalizerName                    = GateName
-	QuotaResourceName                = OrgInstaslicePrefix + "accelerator-memory-quota"
-	GPUMemoryLabelName               = "nvidia.com/gpu.memory"
-	GPUCountLabelName                = "nvidia.com/gpu.count"
-	EmulatorModeFalse                = "false"
-	EmulatorModeTrue                 = "true"
-	AttributeMediaExtensions         = "me"
-	InstaSliceOperatorNamespace      = "instaslice-system"
-	NvidiaMIGPrefix                  = "nvidia.com/mig-"
-	NodeLabel                        = "kubernetes.io/hostname"
-	multipleContainersUnsupportedErr = "multiple containers per pod not supported"
-	noContainerInsidePodErr          = "no containers present inside the pod"
-	InstasliceDaemonsetName          = "instaslice-operator-controller-daemonset"
-	daemonSetImageName               = "quay.io/amalvank/instaslicev2-daemonset:latest"
-	daemonSetName                    = "daemonset"
-	serviceAccountName               = "instaslice-operator-controller-manager"
+	OrgInstaslicePrefix          = "instaslice.redhat.com/"
+	GateName                     = config.DefaultGateName              // the default scheduling gate, see Config.GateName
+	FinalizerName                = config.DefaultFinalizerName         // the default pod finalizer, see Config.FinalizerName
+	InstasliceFinalizerName      = OrgInstaslicePrefix + "allocations" // keeps an Instaslice until its slices are torn down
+	QuotaResourceName            = OrgInstaslicePrefix + "accelerator-memory-quota"
+	StartOffsetAnnotation        = OrgInstaslicePrefix + "start-offset"
+	ForbiddenProfilesAnnotation  = OrgInstaslicePrefix + "forbidden-profiles"
+	AvoidPodsAnnotation          = OrgInstaslicePrefix + "avoid-pods"
+	GPUPoolLabel                 = OrgInstaslicePrefix + "gpu-pool"
+	CriticalPodAnnotation        = OrgInstaslicePrefix + "critical"
+	AllocationDecisionAnnotation = OrgInstaslicePrefix + "allocation-decision"
+	AllocationStatusAnnotation   = OrgInstaslicePrefix + "allocation-status" // status, node and GPU of the allocation of a gated pod
+	FallbackProfilesAnnotation   = OrgInstaslicePrefix + "fallback-profiles"
+	QueuePositionAnnotation      = OrgInstaslicePrefix + "queue-position"
+	PreemptibleNodesAnnotation   = OrgInstaslicePrefix + "preemptible-nodes"
+	PreemptibleNodeLabel         = OrgInstaslicePrefix + "preemptible"
+	WorkloadTypeAnnotation       = OrgInstaslicePrefix + "workload-type"
+	PreferredGPUAnnotation       = OrgInstaslicePrefix + "preferred-gpu"
+	PinNodeAnnotation            = OrgInstaslicePrefix + "node"
+	PinGPUAnnotation             = OrgInstaslicePrefix + "gpu-uuid"
+	UpgradeWindowAnnotation      = OrgInstaslicePrefix + "upgrade-window"  // on a node, RFC 3339 start/end of its next upgrade
+	ProfileAnnotation            = OrgInstaslicePrefix + "profile"         // requests a slice when the profile label cannot be set
+	NVLinkAffinityAnnotation     = OrgInstaslicePrefix + "nvlink-affinity" // "true" on a pod to place it near the slices of its job
+	NVLinkTopologyAnnotation     = OrgInstaslicePrefix + "nvlink-topology" // on a node, ;-separated groups of ,-separated NVLink-connected GPU UUIDs
+	// on a pod or, as the default for its pods, on a namespace, e.g. A100 to leave the H100 GPUs to others
+	PreferredGPUGenerationAnnotation = OrgInstaslicePrefix + "preferred-gpu-generation"
+	DeletionTimeoutAnnotation        = OrgInstaslicePrefix + "deletion-timeout"
+	// pods labeled with the name of a workload claim the warm slices kept for it
+	WarmPoolLabel               = OrgInstaslicePrefix + "warm-pool"
+	ProfileLabel                = OrgInstaslicePrefix + "profile"   // requests slices without custom resources, e.g. 1g.5gb
+	SliceCountLabel             = OrgInstaslicePrefix + "count"     // number of slices of the profile label or annotation, 1 when unset
+	GangLabel                   = OrgInstaslicePrefix + "gang"      // pods of a gang are allocated and ungated all together
+	GangSizeLabel               = OrgInstaslicePrefix + "gang-size" // number of pods of the gang
+	GPUMemoryLabelName          = "nvidia.com/gpu.memory"
+	GPUCountLabelName           = "nvidia.com/gpu.count"
+	EmulatorModeFalse           = "false"
+	EmulatorModeTrue            = "true"
+	AttributeMediaExtensions    = "me"
+	InstaSliceOperatorNamespace = config.DefaultOperatorNamespace
+	NvidiaMIGPrefix             = "nvidia.com/mig-"
+	NodeLabel                   = "kubernetes.io/hostname"
+	multipleGPUContainersErr    = "more than one container of the pod requests a MIG profile"
+	noContainerInsidePodErr     = "no containers present inside the pod"
+	noSliceConfigMapErr         = "the GPU container of the pod does not reference a ConfigMap through envFrom"
+	InstasliceDaemonsetName     = "instaslice-operator-controller-daemonset"
+	daemonSetImageName          = "quay.io/amalvank/instaslicev2-daemonset:latest"
+	daemonSetName               = "daemonset"
+	serviceAccountName          = "instaslice-operator-controller-manager"
+	// warmSliceKind is the kind of the reference held by the allocation of a warm slice
+	warmSliceKind = "WarmSlice"
+	// AllocationFreezeConfigMapName names the ConfigMap in the operator namespace whose frozen key
+	// set to true freezes all new allocations, existing allocations are left in place
+	AllocationFreezeConfigMapName = "instaslice-allocation-freeze"
+	// NamespaceQuotaConfigMapName names the ConfigMap in the operator namespace keyed by namespace whose values
+	// limit the slices the namespace may hold, e.g. total=8,1g.5gb=4
+	NamespaceQuotaConfigMapName = "instaslice-namespace-quotas"
+	// quotaTotalKey is the quota limit on the slices of every profile together
+	quotaTotalKey = "total"
+
+	// NodeResourcesConsistentCondition reports whether realized allocations match the node extended resources
+	NodeResourcesConsistentCondition = "NodeResourcesConsistent"
+	// NodeAvailableCondition reports whether the node of the Instaslice object still exists
+	NodeAvailableCondition = "NodeAvailable"
+	// ReadyCondition reports whether the node of the Instaslice object exists and its GPUs were discovered
+	ReadyCondition = "Ready"
+	// DegradedCondition reports whether allocations on the node are impaired, e.g. by disabled GPUs
+	DegradedCondition = "Degraded"
+
+	// maxDecisionCandidates bounds the candidate nodes listed in the allocation decision annotation
+	maxDecisionCandidates = 10
 
 	Requeue1sDelay  = 1 * time.Second
 	Requeue2sDelay  = 2 * time.Second
@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// creationTimeouts remembers per pod the nodes that did not create its slices in time, they are tried last
+// when the pod is allocated again
+type creationTimeouts struct {
+	mu    sync.Mutex
+	nodes map[types.UID]map[string]bool
+}
+
+// record remembers that the node did not create the slices of the pod in time
+func (c *creationTimeouts) record(podUID types.UID, nodeName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes == nil {
+		c.nodes = make(map[types.UID]map[string]bool)
+	}
+	if c.nodes[podUID] == nil {
+		c.nodes[podUID] = make(map[string]bool)
+	}
+	c.nodes[podUID][nodeName] = true
+}
+
+// timedOut reports whether the node did not create the slices of the pod in time
+func (c *creationTimeouts) timedOut(podUID types.UID, nodeName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[podUID][nodeName]
+}
+
+// forget drops the nodes remembered for the pod once it is allocated again
+func (c *creationTimeouts) forget(podUID types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nodes, podUID)
+}
+
+// isCreationTimedOut checks whether the allocation stayed Creating without the daemonset creating its slice
+// for longer than the configured timeout, counted from the allocation
+func (r *InstasliceReconciler) isCreationTimedOut(allocResult *inferencev1alpha1.AllocationResult) bool {
+	if r.Config == nil || r.Config.CreationTimeout <= 0 || allocResult.AllocatedAt == nil {
+		return false
+	}
+	if allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusCreating ||
+		allocResult.AllocationStatus.AllocationStatusDaemonset != "" {
+		return false
+	}
+	return time.Since(allocResult.AllocatedAt.Time) > r.Config.CreationTimeout
+}
+
+// abandonTimedOutCreation gives up the slices of a pod whose creation timed out so that the pod is allocated
+// again, nodes that timed out are tried last then. Every slice goes through the regular deletion, the daemonset
+// destroys whatever part of a slice it created before it marks it deleted.
+func (r *InstasliceReconciler) abandonTimedOutCreation(ctx context.Context, pod *v1.Pod, heldSlices []podSlice) (ctrl.Result, error) {
+	for _, slice := range heldSlices {
+		if r.isCreationTimedOut(&slice.result) {
+			r.creationTimeouts.record(pod.UID, slice.instasliceName)
+			logr.FromContext(ctx).WithName(LogSubsystemAllocation).Info("abandoning allocation that was not created in time",
+				"pod", pod.Name, "node", slice.instasliceName, "timeout", r.Config.CreationTimeout)
+			if r.Recorder != nil {
+				r.Recorder.Event(pod, v1.EventTypeWarning, "AllocationCreationTimedOut",
+					fmt.Sprintf("InstaSlice slice for pod %s was not created on node %s within %s, allocating again", pod.Name, slice.instasliceName, r.Config.CreationTimeout))
+			}
+		}
+	}
+	for _, slice := range heldSlices {
+		if result, err := r.setInstasliceAllocationToDeleting(ctx, slice.instasliceName, &slice.result, &slice.request); err != nil {
+			return teardownPendingResult(result, err)
+		}
+	}
+	// the allocations deleted by the daemonset are removed on a later reconcile and the pod is allocated again
+	return ctrl.Result{Requeue: true}, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestReconcile_CreationTimeout(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	// the daemonset of node-a never picked up the allocation
+	stuck := newTestAllocation("node-a", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+	})
+	allocResult := stuck.Status.PodAllocationResults[pod.UID]
+	allocatedAt := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	allocResult.AllocatedAt = &allocatedAt
+	stuck.Status.PodAllocationResults[pod.UID] = allocResult
+	r, fakeClient := newTestReconciler(t, pod, stuck, utils.GenerateFakeCapacity("node-b"))
+	r.Config.CreationTimeout = 5 * time.Minute
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	allocation := func(nodeName string) (inferencev1alpha1.AllocationResult, bool) {
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: InstaSliceOperatorNamespace}, instaslice))
+		allocResult, ok := instaslice.Status.PodAllocationResults[pod.UID]
+		return allocResult, ok
+	}
+
+	// the timed out allocation is released for the daemonset to destroy whatever it created of the slice
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, result.Requeue)
+	allocResult, _ = allocation("node-a")
+	assert.Equal(t, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting},
+		allocResult.AllocationStatus)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "AllocationCreationTimedOut")
+	}
+
+	// the daemonset reporting the slice created late does not take the allocation back
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	allocRequest := instaslice.Spec.PodAllocationRequests[pod.UID]
+	lateResult := stuck.Status.PodAllocationResults[pod.UID]
+	lateResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	assert.NoError(t, utils.UpdateOrDeleteInstasliceAllocations(ctx, fakeClient, fakeClient, InstaSliceOperatorNamespace, "node-a", &lateResult, &allocRequest))
+	allocResult, _ = allocation("node-a")
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController)
+
+	// once the daemonset tore the slice down the allocation is removed and the pod allocated again, node-a is
+	// tried last
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	assert.NoError(t, utils.UpdateOrDeleteInstasliceAllocations(ctx, fakeClient, fakeClient, InstaSliceOperatorNamespace, "node-a", &allocResult, &allocRequest))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	_, ok := allocation("node-a")
+	assert.False(t, ok)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	_, ok = allocation("node-a")
+	assert.False(t, ok)
+	allocResult, ok = allocation("node-b")
+	if assert.True(t, ok) {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, allocResult.AllocationStatus.AllocationStatusController)
+	}
+}
+
+func TestReconcile_CreationTimeoutForgottenWithPod(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	instaslice := newTestAllocation("node-b", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+	})
+	r, _ := newTestReconciler(t, instaslice)
+	r.creationTimeouts.record(pod.UID, "node-a")
+
+	// the pod is gone, its allocation is released and the node that timed out is forgotten
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.False(t, r.creationTimeouts.timedOut(pod.UID, "node-a"))
+}
+
+func TestIsCreationTimedOut(t *testing.T) {
+	r, _ := newTestReconciler(t)
+	r.Config.CreationTimeout = 5 * time.Minute
+	old := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	recent := metav1.NewTime(time.Now())
+
+	tests := []struct {
+		name     string
+		result   inferencev1alpha1.AllocationResult
+		timedOut bool
+	}{
+		{
+			name: "creating past the timeout",
+			result: inferencev1alpha1.AllocationResult{AllocatedAt: &old,
+				AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}},
+			timedOut: true,
+		},
+		{
+			name: "creating within the timeout",
+			result: inferencev1alpha1.AllocationResult{AllocatedAt: &recent,
+				AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}},
+		},
+		{
+			name: "created by the daemonset",
+			result: inferencev1alpha1.AllocationResult{AllocatedAt: &old,
+				AllocationStatus: inferencev1alpha1.AllocationStatus{
+					AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+					AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+				}},
+		},
+		{
+			name: "allocation time unknown",
+			result: inferencev1alpha1.AllocationResult{
+				AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.timedOut, r.isCreationTimedOut(&tt.result))
+		})
+	}
+}
@@ -3,10 +3,10 @@ package daemonset
 import (
 	context "context"
 	"encoding/json"
-	goerror "errors"
 	"fmt"
 	"math"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,6 +28,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logr "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -39,6 +40,11 @@ type InstaSliceDaemonsetReconciler struct {
 	kubeClient *kubernetes.Clientset
 	NodeName   string
 	Config     *config.Config
+	// NVML creates and destroys the slices on the GPUs of the node
+	NVML NVMLProvider
+	// APIReader reads the Instaslice uncached before its allocations are updated, the cached client is used
+	// when unset
+	APIReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices,verbs=get;list;watch;create;update;patch;delete
@@ -71,15 +77,6 @@ type ResPatchOperation struct {
 	Value string `json:"value"`
 }
 
-// MigDeviceInfo holds MIG device references discovered via NVML.
-type MigDeviceInfo struct {
-	uuid   string
-	giInfo *nvml.GpuInstanceInfo
-	ciInfo *nvml.ComputeInstanceInfo
-	start  int32
-	size   int32
-}
-
 func NewInstasliceDaemonsetReconciler(
 	client client.Client,
 	scheme *runtime.Scheme,
@@ -92,6 +89,7 @@ func NewInstasliceDaemonsetReconciler(
 		Scheme:   scheme,
 		NodeName: nodeName,
 		Config:   config,
+		NVML:     nvmlProvider{},
 	}
 
 	var err error
@@ -99,10 +97,7 @@ func NewInstasliceDaemonsetReconciler(
 		if config.EmulatorModeEnable {
 			return
 		}
-		ret := nvml.Init()
-		if ret != nvml.SUCCESS {
-			err = goerror.New("Unable to initialize NVML")
-		}
+		err = r.NVML.Init()
 	})
 	if err != nil {
 		return nil, err
@@ -120,7 +115,7 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 
 	nsName := types.NamespacedName{
 		Name:      r.NodeName,
-		Namespace: controller.InstaSliceOperatorNamespace,
+		Namespace: r.Config.OperatorNamespace,
 	}
 
 	var instaslice inferencev1alpha1.Instaslice
@@ -133,9 +128,9 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 
 		podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef
 
-		// 1) Handle "deleting"
+		// 1) Handle "deleting", a slice released before it was created may have been created partially
 		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting &&
-			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated &&
+			allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted &&
 			allocResult.Nodename == types.NodeName(r.NodeName) {
 
 			log.Info("Performing cleanup for pod", "podRef", podRef)
@@ -170,14 +165,18 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating &&
 			allocResult.AllocationStatus.AllocationStatusDaemonset == "" &&
 			allocResult.Nodename == types.NodeName(r.NodeName) {
-			exists, err := r.checkConfigMapExists(ctx, string(allocResult.ConfigMapResourceIdentifier), podRef.Namespace)
-			if err != nil {
-				log.Error(err, "error obtianing configmap", string(allocResult.ConfigMapResourceIdentifier))
-				return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
-			}
-			if exists {
-				log.Info("Skipping updating pod", "podRef", podRef)
-				continue
+			// the slices of a pod requesting more than one share the ConfigMap of its first slice
+			sliceIndex := instaslice.Spec.PodAllocationRequests[podUID].SliceIndex
+			if sliceIndex == 0 {
+				exists, err := r.checkConfigMapExists(ctx, string(allocResult.ConfigMapResourceIdentifier), podRef.Namespace)
+				if err != nil {
+					log.Error(err, "error obtianing configmap", string(allocResult.ConfigMapResourceIdentifier))
+					return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
+				}
+				if exists {
+					log.Info("Skipping updating pod", "podRef", podRef)
+					continue
+				}
 			}
 			log.Info("creating allocation for pod", "podRef", podRef)
 			// We can look up the *request* in spec to see the profile or resource demands
@@ -190,8 +189,12 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 
 			if r.Config.EmulatorModeEnable {
 				// configmap with fake MIG uuid
+				fakeMigUUID := string(allocResult.ConfigMapResourceIdentifier)
+				if sliceIndex > 0 {
+					fakeMigUUID = fmt.Sprintf("%s-%d", fakeMigUUID, sliceIndex)
+				}
 				err := r.createConfigMap(ctx,
-					string(allocResult.ConfigMapResourceIdentifier),
+					fakeMigUUID,
 					podRef.Namespace,
 					string(allocResult.ConfigMapResourceIdentifier))
 				if err != nil {
@@ -201,53 +204,33 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 				// Emulating cost to create CI and GI on a GPU
 				time.Sleep(controller.Requeue1sDelay)
 			} else {
-				device, retCode := nvml.DeviceGetHandleByUUID(allocResult.GPUUUID)
-				if retCode != nvml.SUCCESS {
-					log.Error(retCode, "error getting GPU device handle", "gpuUUID", allocResult.GPUUUID)
-					return ctrl.Result{}, goerror.New("error fetching GPU device handle")
-				}
-
-				selectedMig, ok := instaslice.Status.NodeResources.MigPlacement[allocationRequest.Profile]
+				selectedMig, ok := controller.ResolveMigProfile(&instaslice, allocResult.GPUUUID, allocationRequest.Profile)
 				if !ok {
 					log.Info("No suitable MIG profile in NodeResources; skipping creation", podRef, allocResult)
 					continue
 				}
 
-				placement := nvml.GpuInstancePlacement{
-					Start: uint32(allocResult.MigPlacement.Start),
-					Size:  uint32(allocResult.MigPlacement.Size),
-				}
-
-				giProfileInfo, retGI := device.GetGpuInstanceProfileInfo(int(selectedMig.GIProfileID))
-				if retGI != nvml.SUCCESS {
-					log.Error(retGI, "error getting GPU instance profile info", "GIProfileID", selectedMig.GIProfileID)
-					return ctrl.Result{}, goerror.New("cannot get GI profile info")
+				profile := MigProfile{
+					GIProfileID:    int(selectedMig.GIProfileID),
+					CIProfileID:    int(selectedMig.CIProfileID),
+					CIEngProfileID: int(allocationRequest.CIEngProfileID),
 				}
-
-				ciProfileID := selectedMig.CIProfileID
-
-				createdMigInfos, err := r.createSliceAndPopulateMigInfos(
-					ctx, device, &allocResult, giProfileInfo, placement, ciProfileID, podRef.Name)
+				log.Info("creating slice for", "pod", podRef.Name)
+				migUuid, err := r.NVML.CreateSlice(ctx, allocResult.GPUUUID, profile, allocResult.MigPlacement)
 				if err != nil {
 					log.Error(err, "MIG creation not successful", podRef)
 					return ctrl.Result{RequeueAfter: controller.Requeue2sDelay}, err
 				}
-
-				for migUuid, migDevice := range createdMigInfos {
-					if migDevice.start == allocResult.MigPlacement.Start && migDevice.uuid == allocResult.GPUUUID && giProfileInfo.Id == migDevice.giInfo.ProfileId {
-						if err := r.createConfigMap(ctx, migUuid, podRef.Namespace, string(allocResult.ConfigMapResourceIdentifier)); err != nil {
-							return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, err
-						}
-						log.Info("done creating mig slice for ", "pod", podRef.Name, "parentgpu", allocResult.GPUUUID, "miguuid", migUuid)
-						break
-					}
+				if err := r.createConfigMap(ctx, migUuid, podRef.Namespace, string(allocResult.ConfigMapResourceIdentifier)); err != nil {
+					return ctrl.Result{RequeueAfter: controller.Requeue1sDelay}, err
 				}
+				log.Info("done creating mig slice for ", "pod", podRef.Name, "parentgpu", allocResult.GPUUUID, "miguuid", migUuid)
 			}
 
 			newAllocationRequest := instaslice.Spec.PodAllocationRequests[podUID]
 			newAllocationResult := instaslice.Status.PodAllocationResults[podUID]
 			newAllocationResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
-			if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &newAllocationResult, &newAllocationRequest); err != nil {
+			if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, instaslice.Name, &newAllocationResult, &newAllocationRequest); err != nil {
 				return ctrl.Result{Requeue: true}, err
 			}
 
@@ -258,48 +241,22 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 	return ctrl.Result{}, nil
 }
 
+// apiReader returns the reader the Instaslice is read with before its allocations are updated
+func (r *InstaSliceDaemonsetReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
 // cleanUpCiAndGi tears down the MIG compute instance and GPU instance.
 func (r *InstaSliceDaemonsetReconciler) cleanUpCiAndGi(ctx context.Context, allocationResult *inferencev1alpha1.AllocationResult, podRef v1.ObjectReference) error {
 	log := logr.FromContext(ctx)
 
-	parent, ret := nvml.DeviceGetHandleByUUID(allocationResult.GPUUUID)
-	if ret != nvml.SUCCESS {
-		log.Error(ret, "error obtaining GPU handle for cleanup")
-		return fmt.Errorf("unable to get device handle: %v", ret)
-	}
-
-	migInfos, err := populateMigDeviceInfos(parent)
-	if err != nil {
-		return fmt.Errorf("unable to walk MIGs: %v", err)
-	}
-
-	for _, migdevice := range migInfos {
-		if migdevice.uuid == allocationResult.GPUUUID && migdevice.start == allocationResult.MigPlacement.Start {
-			gi, ret := parent.GetGpuInstanceById(int(migdevice.giInfo.Id))
-			if ret != nvml.SUCCESS {
-				log.Error(ret, "error obtaining gpu instance")
-				return fmt.Errorf("unable to find GI: %v", ret)
-			}
-			ci, ret := gi.GetComputeInstanceById(int(migdevice.ciInfo.Id))
-			if ret != nvml.SUCCESS {
-				log.Error(ret, "error obtaining compute instance")
-				return fmt.Errorf("unable to find CI: %v", ret)
-			}
-			// Destroy CI
-			ret = ci.Destroy()
-			if ret != nvml.SUCCESS {
-				return fmt.Errorf("unable to destroy CI: %v", ret)
-			}
-			// Destroy GI
-			ret = gi.Destroy()
-			if ret != nvml.SUCCESS {
-				return fmt.Errorf("unable to destroy GI: %v", ret)
-			}
-
-			log.Info("Successfully destroyed MIG resources", "allocationResult", allocationResult, "podRef", podRef)
-			return nil
-		}
+	if err := r.NVML.DestroySlice(ctx, allocationResult.GPUUUID, allocationResult.MigPlacement); err != nil {
+		return err
 	}
+	log.Info("Successfully destroyed MIG resources", "allocationResult", allocationResult, "podRef", podRef)
 	return nil
 }
 
@@ -325,7 +282,7 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 		var instaslice inferencev1alpha1.Instaslice
 		typeNamespacedName := types.NamespacedName{
 			Name:      r.NodeName,
-			Namespace: controller.InstaSliceOperatorNamespace,
+			Namespace: r.Config.OperatorNamespace,
 		}
 		err := r.Get(ctx, typeNamespacedName, &instaslice)
 		if err != nil {
@@ -334,6 +291,7 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 
 		if r.Config.EmulatorModeEnable {
 			fakeCapacity := utils.GenerateFakeCapacity(r.NodeName)
+			fakeCapacity.Namespace = r.Config.OperatorNamespace
 			err := r.Create(ctx, fakeCapacity)
 			if err != nil && !errors.IsAlreadyExists(err) {
 				log.Error(err, "could not create fake capacity", "node_name", r.NodeName)
@@ -362,7 +320,7 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 			}
 			fakeCapacity = utils.GenerateFakeCapacity(r.NodeName)
 			instaslice.Name = fakeCapacity.Name
-			instaslice.Namespace = fakeCapacity.Namespace
+			instaslice.Namespace = r.Config.OperatorNamespace
 			instaslice.Status = fakeCapacity.Status
 			err = r.Status().Update(ctx, &instaslice)
 			if err != nil {
@@ -452,7 +410,9 @@ func (r *InstaSliceDaemonsetReconciler) discoverMigEnabledGpuWithSlices() ([]str
 
 	instaslice := &inferencev1alpha1.Instaslice{}
 	instaslice.Name = r.NodeName
-	instaslice.Namespace = controller.InstaSliceOperatorNamespace
+	instaslice.Namespace = r.Config.OperatorNamespace
+	// the controller keeps the Instaslice until the slices on its GPUs are torn down
+	controllerutil.AddFinalizer(instaslice, controller.InstasliceFinalizerName)
 
 	customCtx := context.TODO()
 	errToCreate := r.Create(customCtx, instaslice)
@@ -634,56 +594,11 @@ func (r *InstaSliceDaemonsetReconciler) discoverAvailableProfilesOnGpus(instasli
 		nodeGPUs[i].GPUMemory = *resource.NewQuantity(int64(memory.Total), resource.BinarySI)
 		discoveredGpusOnHost = append(discoveredGpusOnHost, uuid)
 		if discoverProfilePerNode {
-
-			for j := 0; j < nvml.GPU_INSTANCE_PROFILE_COUNT; j++ {
-				giProfileInfo, ret := device.GetGpuInstanceProfileInfo(j)
-				if ret == nvml.ERROR_NOT_SUPPORTED {
-					continue
-				}
-				if ret == nvml.ERROR_INVALID_ARGUMENT {
-					continue
-				}
-				if ret != nvml.SUCCESS {
-					return nil, ret, false, ret
-				}
-
-				memory, ret := device.GetMemoryInfo()
-				if ret != nvml.SUCCESS {
-					return nil, ret, false, ret
-				}
-
-				profile := NewMigProfile(j, j, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, giProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memory.Total)
-
-				giPossiblePlacements, ret := device.GetGpuInstancePossiblePlacements(&giProfileInfo)
-				if ret == nvml.ERROR_NOT_SUPPORTED {
-					continue
-				}
-				if ret == nvml.ERROR_INVALID_ARGUMENT {
-					continue
-				}
-				if ret != nvml.SUCCESS {
-					return nil, 0, true, ret
-				}
-				placementsForProfile := []inferencev1alpha1.Placement{}
-				for _, p := range giPossiblePlacements {
-					placement := inferencev1alpha1.Placement{
-						Size:  int32(p.Size),
-						Start: int32(p.Start),
-					}
-					placementsForProfile = append(placementsForProfile, placement)
-				}
-
-				aggregatedPlacementsForProfile := inferencev1alpha1.Mig{
-					Placements:     placementsForProfile,
-					GIProfileID:    int32(j),
-					CIProfileID:    int32(profile.CIProfileID),
-					CIEngProfileID: int32(profile.CIEngProfileID),
-				}
-				if instaslice.Status.NodeResources.MigPlacement == nil {
-					instaslice.Status.NodeResources.MigPlacement = make(map[string]inferencev1alpha1.Mig)
-				}
-				instaslice.Status.NodeResources.MigPlacement[profile.String()] = aggregatedPlacementsForProfile
+			migPlacement, err := r.NVML.DeviceProfiles(uuid)
+			if err != nil {
+				return nil, ret, false, err
 			}
+			instaslice.Status.NodeResources.MigPlacement = migPlacement
 			discoverProfilePerNode = false
 		}
 		instaslice.Status.NodeResources.NodeGPUs = nodeGPUs
@@ -736,7 +651,8 @@ func (m MigProfile) Attributes() []string {
 	return attr
 }
 
-// Create configmap which is used by Pods to consume MIG device
+// Create configmap which is used by Pods to consume MIG device, the MIG device of a further slice of the
+// pod is added to the devices the existing configmap lists
 func (r *InstaSliceDaemonsetReconciler) createConfigMap(ctx context.Context, migGPUUUID string, namespace string, resourceIdentifier string) error {
 	log := logr.FromContext(ctx)
 	var configMap v1.ConfigMap
@@ -757,9 +673,23 @@ func (r *InstaSliceDaemonsetReconciler) createConfigMap(ctx context.Context, mig
 			log.Error(err, "failed to create ConfigMap")
 			return err
 		}
-
+		return nil
 	}
-	return nil
+	var devices []string
+	if visibleDevices := configMap.Data["NVIDIA_VISIBLE_DEVICES"]; visibleDevices != "" {
+		devices = strings.Split(visibleDevices, ",")
+	}
+	if slices.Contains(devices, migGPUUUID) {
+		return nil
+	}
+	devices = append(devices, migGPUUUID)
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data["NVIDIA_VISIBLE_DEVICES"] = strings.Join(devices, ",")
+	configMap.Data["CUDA_VISIBLE_DEVICES"] = strings.Join(devices, ",")
+	log.Info("adding MIG device to ConfigMap", "name", resourceIdentifier, "migGPUUUID", migGPUUUID)
+	return r.Update(ctx, &configMap)
 }
 
 // Manage lifecycle of configmap, delete it once the pod is deleted from the system
@@ -797,160 +727,6 @@ func createPatchData(resourceName string, resourceValue resource.Quantity) ([]by
 	return json.Marshal(patch)
 }
 
-func walkMigDevices(d nvml.Device, f func(i int, d nvml.Device) error) error {
-	count, ret := d.GetMaxMigDeviceCount()
-	if ret != nvml.SUCCESS {
-		return fmt.Errorf("error getting max MIG device count: %v", ret)
-	}
-
-	for i := 0; i < count; i++ {
-		device, ret := d.GetMigDeviceHandleByIndex(i)
-		if ret == nvml.ERROR_NOT_FOUND {
-			continue
-		}
-		if ret == nvml.ERROR_INVALID_ARGUMENT {
-			continue
-		}
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting MIG device handle at index '%v': %v", i, ret)
-		}
-		err := f(i, device)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func populateMigDeviceInfos(device nvml.Device) (map[string]*MigDeviceInfo, error) {
-	migInfos := make(map[string]*MigDeviceInfo)
-
-	err := walkMigDevices(device, func(i int, migDevice nvml.Device) error {
-		parentUuid, ret := device.GetUUID()
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting parent GPU UUID: %v", ret)
-		}
-
-		giID, ret := migDevice.GetGpuInstanceId()
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting GPU instance ID for MIG device: %v", ret)
-		}
-
-		gi, ret := device.GetGpuInstanceById(giID)
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting GPU instance for '%v': %v", giID, ret)
-		}
-
-		giInfo, ret := gi.GetInfo()
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting GPU instance info for '%v': %v", giID, ret)
-		}
-
-		ciID, ret := migDevice.GetComputeInstanceId()
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting Compute instance ID for MIG device: %v", ret)
-		}
-
-		ci, ret := gi.GetComputeInstanceById(ciID)
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting Compute instance for '%v': %v", ciID, ret)
-		}
-
-		ciInfo, ret := ci.GetInfo()
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting Compute instance info for '%v': %v", ciID, ret)
-		}
-
-		uuid, ret := migDevice.GetUUID()
-		if ret != nvml.SUCCESS {
-			return fmt.Errorf("error getting UUID for MIG device: %v", ret)
-		}
-
-		migInfos[uuid] = &MigDeviceInfo{
-			uuid:   parentUuid,
-			giInfo: &giInfo,
-			ciInfo: &ciInfo,
-			start:  int32(giInfo.Placement.Start),
-			size:   int32(giInfo.Placement.Size),
-		}
-
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return migInfos, nil
-}
-
-func (r *InstaSliceDaemonsetReconciler) createSliceAndPopulateMigInfos(ctx context.Context, device nvml.Device, allocationResult *inferencev1alpha1.AllocationResult, giProfileInfo nvml.GpuInstanceProfileInfo, placement nvml.GpuInstancePlacement, ciProfileId int32, podName string) (map[string]*MigDeviceInfo, error) {
-	log := logr.FromContext(ctx)
-
-	log.Info("creating slice for", "pod", podName)
-	var gi nvml.GpuInstance
-	var ret nvml.Return
-	gi, ret = device.CreateGpuInstanceWithPlacement(&giProfileInfo, &placement)
-	if ret != nvml.SUCCESS {
-		switch ret {
-		case nvml.ERROR_INSUFFICIENT_RESOURCES:
-			// Handle insufficient resources case
-			gpuInstances, ret := device.GetGpuInstances(&giProfileInfo)
-			if ret != nvml.SUCCESS {
-				log.Error(ret, "gpu instances cannot be listed")
-				return nil, fmt.Errorf("gpu instances cannot be listed: %v", ret)
-			}
-
-			for _, gpuInstance := range gpuInstances {
-				gpuInstanceInfo, ret := gpuInstance.GetInfo()
-				if ret != nvml.SUCCESS {
-					log.Error(ret, "unable to obtain gpu instance info")
-					return nil, fmt.Errorf("unable to obtain gpu instance info: %v", ret)
-				}
-
-				parentUuid, ret := gpuInstanceInfo.Device.GetUUID()
-				if ret != nvml.SUCCESS {
-					log.Error(ret, "unable to obtain parent gpu uuuid")
-					return nil, fmt.Errorf("unable to obtain parent gpu uuuid: %v", ret)
-				}
-
-				if gpuInstanceInfo.Placement.Start == uint32(allocationResult.MigPlacement.Start) && parentUuid == allocationResult.GPUUUID {
-					gi, ret = device.GetGpuInstanceById(int(gpuInstanceInfo.Id))
-					if ret != nvml.SUCCESS {
-						log.Error(ret, "unable to obtain gi post iteration")
-						return nil, fmt.Errorf("unable to obtain gi post iteration: %v", ret)
-					}
-				}
-			}
-		default:
-			// this case is typically for scenario where ret is not equal to nvml.ERROR_INSUFFICIENT_RESOURCES
-			log.Error(ret, "gpu instance creation errored out with unknown error")
-			return nil, fmt.Errorf("gpu instance creation failed: %v", ret)
-		}
-	}
-
-	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(int(ciProfileId), 0)
-	if ret != nvml.SUCCESS {
-		log.Error(ret, "error getting compute instance profile info", "pod", podName)
-		return nil, fmt.Errorf("error getting compute instance profile info: %v", ret)
-	}
-
-	ci, ret := gi.CreateComputeInstance(&ciProfileInfo)
-	if ret != nvml.SUCCESS {
-		if ret != nvml.ERROR_INSUFFICIENT_RESOURCES {
-			log.Error(ret, "error creating Compute instance", "ci", ci)
-			return nil, fmt.Errorf("error creating compute instance: %v", ret)
-		}
-	}
-
-	migInfos, err := populateMigDeviceInfos(device)
-	if err != nil {
-		log.Error(err, "unable to iterate over newly created MIG devices")
-		return nil, fmt.Errorf("failed to populate MIG device infos: %v", err)
-	}
-
-	return migInfos, nil
-}
-
 func (r *InstaSliceDaemonsetReconciler) checkConfigMapExists(ctx context.Context, name, namespace string) (bool, error) {
 	log := logr.FromContext(ctx)
 	configMap := &v1.ConfigMap{}
@@ -18,6 +18,7 @@ package daemonset
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
 
@@ -75,6 +76,30 @@ func TestDeleteConfigMap(t *testing.T) {
 	assert.NoError(t, err, "expected no error when configmap is not found")
 }
 
+func TestCreateConfigMap(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	client := fake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client: client,
+	}
+	ctx := context.TODO()
+	key := types.NamespacedName{Name: "test-configmap", Namespace: "default"}
+
+	// the first slice of the pod creates the configmap
+	assert.NoError(t, reconciler.createConfigMap(ctx, "MIG-1", key.Namespace, key.Name))
+	cm := &v1.ConfigMap{}
+	assert.NoError(t, client.Get(ctx, key, cm))
+	assert.Equal(t, "MIG-1", cm.Data["NVIDIA_VISIBLE_DEVICES"])
+
+	// a further slice of the pod is added to the devices, a slice already listed is not added twice
+	assert.NoError(t, reconciler.createConfigMap(ctx, "MIG-2", key.Namespace, key.Name))
+	assert.NoError(t, reconciler.createConfigMap(ctx, "MIG-2", key.Namespace, key.Name))
+	assert.NoError(t, client.Get(ctx, key, cm))
+	assert.Equal(t, "MIG-1,MIG-2", cm.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, "MIG-1,MIG-2", cm.Data["CUDA_VISIBLE_DEVICES"])
+}
+
 func TestInstaSliceDaemonsetReconciler_Reconcile_Deleting_Alloc_Status(t *testing.T) {
 	// Set up the scheme for the client
 	s := scheme.Scheme
@@ -474,3 +499,227 @@ func TestCalculateTotalMemoryGB(t *testing.T) {
 		})
 	}
 }
+
+func TestInstaSliceDaemonsetReconciler_Reconcile_Deleting_RetainsNodeCapacity(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	resourceName := v1.ResourceName(controller.OrgInstaslicePrefix + "mig-1g.5gb")
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{resourceName: resource.MustParse("14")}},
+	}
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-configmap", Namespace: "default"}}
+	instaslice := newInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	allocResult := instaslice.Status.PodAllocationResults[podUUID]
+	allocResult.Nodename = nodeName
+	allocResult.ConfigMapResourceIdentifier = types.UID(configMap.Name)
+	instaslice.Status.PodAllocationResults[podUUID] = allocResult
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{
+		podUUID: {Profile: "1g.5gb", PodRef: v1.ObjectReference{Name: "test-pod", Namespace: "default"}},
+	}
+	client := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(node, configMap, instaslice).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{EmulatorModeEnable: true, OperatorNamespace: controller.InstaSliceOperatorNamespace},
+	}
+	ctx := context.Background()
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}}
+	_, err := reconciler.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	// the slice is torn down
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, client.Get(ctx, req.NamespacedName, updated))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, updated.Status.PodAllocationResults[podUUID].AllocationStatus.AllocationStatusDaemonset)
+	err = client.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace}, &v1.ConfigMap{})
+	assert.True(t, errors.IsNotFound(err))
+	// while the extended resource advertised on the node is static and stays in place
+	updatedNode := &v1.Node{}
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: nodeName}, updatedNode))
+	quantity := updatedNode.Status.Capacity[resourceName]
+	assert.Equal(t, int64(14), quantity.Value())
+}
+
+// fakeNVMLProvider keeps the slices of the GPUs in memory, keyed by GPU and placement start
+type fakeNVMLProvider struct {
+	profiles map[string]inferencev1alpha1.Mig
+	slices   map[string]MigProfile
+}
+
+func newFakeNVMLProvider() *fakeNVMLProvider {
+	return &fakeNVMLProvider{slices: make(map[string]MigProfile)}
+}
+
+func fakeSliceKey(gpuUUID string, placement inferencev1alpha1.Placement) string {
+	return fmt.Sprintf("%s/%d", gpuUUID, placement.Start)
+}
+
+func (f *fakeNVMLProvider) Init() error {
+	return nil
+}
+
+func (f *fakeNVMLProvider) DeviceProfiles(string) (map[string]inferencev1alpha1.Mig, error) {
+	return f.profiles, nil
+}
+
+func (f *fakeNVMLProvider) CreateSlice(_ context.Context, gpuUUID string, profile MigProfile, placement inferencev1alpha1.Placement) (string, error) {
+	key := fakeSliceKey(gpuUUID, placement)
+	if _, ok := f.slices[key]; ok {
+		return "", fmt.Errorf("placement %s is in use", key)
+	}
+	f.slices[key] = profile
+	return "MIG-" + key, nil
+}
+
+func (f *fakeNVMLProvider) DestroySlice(_ context.Context, gpuUUID string, placement inferencev1alpha1.Placement) error {
+	delete(f.slices, fakeSliceKey(gpuUUID, placement))
+	return nil
+}
+
+func newTestNVMLInstaslice(nodeName, podUUID string, status inferencev1alpha1.AllocationStatus) *inferencev1alpha1.Instaslice {
+	instaslice := newInstaslice(nodeName, podUUID, status)
+	placement := inferencev1alpha1.Placement{Start: 2, Size: 1}
+	allocResult := instaslice.Status.PodAllocationResults[types.UID(podUUID)]
+	allocResult.Nodename = types.NodeName(nodeName)
+	allocResult.GPUUUID = "GPU-1"
+	allocResult.MigPlacement = placement
+	allocResult.ConfigMapResourceIdentifier = "test-configmap"
+	instaslice.Status.PodAllocationResults[types.UID(podUUID)] = allocResult
+	instaslice.Status.NodeResources.MigPlacement = map[string]inferencev1alpha1.Mig{
+		"1g.5gb": {Placements: []inferencev1alpha1.Placement{placement}, GIProfileID: 19},
+	}
+	instaslice.Spec.PodAllocationRequests = map[types.UID]inferencev1alpha1.AllocationRequest{
+		types.UID(podUUID): {Profile: "1g.5gb", PodRef: v1.ObjectReference{Name: "test-pod", Namespace: "default", UID: types.UID(podUUID)}},
+	}
+	return instaslice
+}
+
+func TestReconcile_CreateSlice(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	instaslice := newTestNVMLInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+	})
+	client := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(instaslice).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		Build()
+	provider := newFakeNVMLProvider()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{OperatorNamespace: controller.InstaSliceOperatorNamespace},
+		NVML:     provider,
+	}
+	ctx := context.Background()
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}}
+	_, err := reconciler.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	// the slice is created with the profile ids advertised for the profile, and its MIG device handed to the pod
+	assert.Equal(t, map[string]MigProfile{"GPU-1/2": {GIProfileID: 19}}, provider.slices)
+	configMap := &v1.ConfigMap{}
+	assert.NoError(t, client.Get(ctx, types.NamespacedName{Name: "test-configmap", Namespace: "default"}, configMap))
+	assert.Equal(t, "MIG-GPU-1/2", configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, client.Get(ctx, req.NamespacedName, updated))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, updated.Status.PodAllocationResults[podUUID].AllocationStatus.AllocationStatusDaemonset)
+}
+
+func TestCleanUp(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	instaslice := newTestNVMLInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-configmap", Namespace: "default"}}
+	client := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(instaslice, configMap).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		Build()
+	provider := newFakeNVMLProvider()
+	provider.slices["GPU-1/2"] = MigProfile{GIProfileID: 19}
+	provider.slices["GPU-1/3"] = MigProfile{GIProfileID: 19}
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{OperatorNamespace: controller.InstaSliceOperatorNamespace},
+		NVML:     provider,
+	}
+	ctx := context.Background()
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}}
+	_, err := reconciler.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	// only the slice of the deleted allocation is destroyed
+	assert.Equal(t, map[string]MigProfile{"GPU-1/3": {GIProfileID: 19}}, provider.slices)
+	err = client.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace}, &v1.ConfigMap{})
+	assert.True(t, errors.IsNotFound(err))
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, client.Get(ctx, req.NamespacedName, updated))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, updated.Status.PodAllocationResults[podUUID].AllocationStatus.AllocationStatusDaemonset)
+}
+
+func TestCleanUp_ReleasedBeforeCreated(t *testing.T) {
+	s := scheme.Scheme
+	_ = v1.AddToScheme(s)
+	_ = inferencev1alpha1.AddToScheme(s)
+	const (
+		nodeName = "test-node"
+		podUUID  = "test-pod-uuid"
+	)
+	// the allocation was released before the daemonset reported its slice, e.g. after a creation that failed
+	// midway
+	instaslice := newTestNVMLInstaslice(nodeName, podUUID, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+	})
+	client := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(instaslice).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		Build()
+	provider := newFakeNVMLProvider()
+	provider.slices["GPU-1/2"] = MigProfile{GIProfileID: 19}
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:   client,
+		NodeName: nodeName,
+		Config:   &config.Config{OperatorNamespace: controller.InstaSliceOperatorNamespace},
+		NVML:     provider,
+	}
+	ctx := context.Background()
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: controller.InstaSliceOperatorNamespace}}
+	_, err := reconciler.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	// whatever was created of the slice is destroyed before the allocation is marked deleted
+	assert.Empty(t, provider.slices)
+	updated := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, client.Get(ctx, req.NamespacedName, updated))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, updated.Status.PodAllocationResults[podUUID].AllocationStatus.AllocationStatusDaemonset)
+}
@@ -0,0 +1,359 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemonset
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// NVMLProvider is how the daemonset reaches the GPUs of its node. The profiles of the GPUs are queried and the
+// GPU and compute instances of slices are created and destroyed through it, so that slice realization can be
+// exercised without GPUs.
+type NVMLProvider interface {
+	// Init initializes the library, it is called once per process
+	Init() error
+	// DeviceProfiles returns the MIG profiles the GPU supports keyed by profile name, with their NVML profile
+	// ids and the placements they can take
+	DeviceProfiles(gpuUUID string) (map[string]inferencev1alpha1.Mig, error)
+	// CreateSlice creates the GPU and compute instance of the profile at the placement on the GPU, reusing a GPU
+	// instance already at the placement, and returns the UUID of the resulting MIG device
+	CreateSlice(ctx context.Context, gpuUUID string, profile MigProfile, placement inferencev1alpha1.Placement) (string, error)
+	// DestroySlice destroys the compute and GPU instance at the placement on the GPU, including a GPU instance a
+	// failed creation left without compute instance. A placement without one is left as is
+	DestroySlice(ctx context.Context, gpuUUID string, placement inferencev1alpha1.Placement) error
+}
+
+// nvmlProvider is the NVMLProvider backed by the NVML library of the node
+type nvmlProvider struct{}
+
+func (nvmlProvider) Init() error {
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to initialize NVML: %v", ret)
+	}
+	return nil
+}
+
+func (nvmlProvider) DeviceProfiles(gpuUUID string) (map[string]inferencev1alpha1.Mig, error) {
+	device, ret := nvml.DeviceGetHandleByUUID(gpuUUID)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to get device handle: %v", ret)
+	}
+	memory, ret := device.GetMemoryInfo()
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	migPlacement := make(map[string]inferencev1alpha1.Mig)
+	for j := 0; j < nvml.GPU_INSTANCE_PROFILE_COUNT; j++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(j)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+
+		profile := NewMigProfile(j, j, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, giProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memory.Total)
+
+		giPossiblePlacements, ret := device.GetGpuInstancePossiblePlacements(&giProfileInfo)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		placementsForProfile := []inferencev1alpha1.Placement{}
+		for _, p := range giPossiblePlacements {
+			placementsForProfile = append(placementsForProfile, inferencev1alpha1.Placement{
+				Size:  int32(p.Size),
+				Start: int32(p.Start),
+			})
+		}
+		migPlacement[profile.String()] = inferencev1alpha1.Mig{
+			Placements:     placementsForProfile,
+			GIProfileID:    int32(j),
+			CIProfileID:    int32(profile.CIProfileID),
+			CIEngProfileID: int32(profile.CIEngProfileID),
+		}
+	}
+	return migPlacement, nil
+}
+
+func (nvmlProvider) CreateSlice(ctx context.Context, gpuUUID string, profile MigProfile, placement inferencev1alpha1.Placement) (string, error) {
+	device, ret := nvml.DeviceGetHandleByUUID(gpuUUID)
+	if ret != nvml.SUCCESS {
+		return "", fmt.Errorf("unable to get device handle: %v", ret)
+	}
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(profile.GIProfileID)
+	if ret != nvml.SUCCESS {
+		return "", fmt.Errorf("cannot get GI profile info: %v", ret)
+	}
+	migInfos, err := createSliceAndPopulateMigInfos(ctx, device, gpuUUID, giProfileInfo, nvml.GpuInstancePlacement{
+		Start: uint32(placement.Start),
+		Size:  uint32(placement.Size),
+	}, profile.CIProfileID, profile.CIEngProfileID)
+	if err != nil {
+		return "", err
+	}
+	for migUUID, migDevice := range migInfos {
+		if migDevice.start == placement.Start && migDevice.uuid == gpuUUID && giProfileInfo.Id == migDevice.giInfo.ProfileId {
+			return migUUID, nil
+		}
+	}
+	return "", fmt.Errorf("no MIG device found at placement %d on GPU %s", placement.Start, gpuUUID)
+}
+
+func (nvmlProvider) DestroySlice(ctx context.Context, gpuUUID string, placement inferencev1alpha1.Placement) error {
+	log := logr.FromContext(ctx)
+
+	parent, ret := nvml.DeviceGetHandleByUUID(gpuUUID)
+	if ret != nvml.SUCCESS {
+		log.Error(ret, "error obtaining GPU handle for cleanup")
+		return fmt.Errorf("unable to get device handle: %v", ret)
+	}
+
+	migInfos, err := populateMigDeviceInfos(parent)
+	if err != nil {
+		return fmt.Errorf("unable to walk MIGs: %v", err)
+	}
+
+	for _, migdevice := range migInfos {
+		if migdevice.uuid == gpuUUID && migdevice.start == placement.Start {
+			gi, ret := parent.GetGpuInstanceById(int(migdevice.giInfo.Id))
+			if ret != nvml.SUCCESS {
+				log.Error(ret, "error obtaining gpu instance")
+				return fmt.Errorf("unable to find GI: %v", ret)
+			}
+			ci, ret := gi.GetComputeInstanceById(int(migdevice.ciInfo.Id))
+			if ret != nvml.SUCCESS {
+				log.Error(ret, "error obtaining compute instance")
+				return fmt.Errorf("unable to find CI: %v", ret)
+			}
+			// Destroy CI
+			ret = ci.Destroy()
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to destroy CI: %v", ret)
+			}
+			// Destroy GI
+			ret = gi.Destroy()
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to destroy GI: %v", ret)
+			}
+			return nil
+		}
+	}
+	// a GPU instance without compute instance is no MIG device
+	return destroyGpuInstanceAt(parent, placement)
+}
+
+// destroyGpuInstanceAt destroys the GPU instance at the placement on the GPU, if any
+func destroyGpuInstanceAt(device nvml.Device, placement inferencev1alpha1.Placement) error {
+	for j := 0; j < nvml.GPU_INSTANCE_PROFILE_COUNT; j++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(j)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("cannot get GI profile info: %v", ret)
+		}
+		gpuInstances, ret := device.GetGpuInstances(&giProfileInfo)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("gpu instances cannot be listed: %v", ret)
+		}
+		for _, gi := range gpuInstances {
+			giInfo, ret := gi.GetInfo()
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to obtain gpu instance info: %v", ret)
+			}
+			if giInfo.Placement.Start != uint32(placement.Start) {
+				continue
+			}
+			if ret := gi.Destroy(); ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to destroy GI: %v", ret)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// MigDeviceInfo holds MIG device references discovered via NVML.
+type MigDeviceInfo struct {
+	uuid   string
+	giInfo *nvml.GpuInstanceInfo
+	ciInfo *nvml.ComputeInstanceInfo
+	start  int32
+	size   int32
+}
+
+func walkMigDevices(d nvml.Device, f func(i int, d nvml.Device) error) error {
+	count, ret := d.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error getting max MIG device count: %v", ret)
+	}
+
+	for i := 0; i < count; i++ {
+		device, ret := d.GetMigDeviceHandleByIndex(i)
+		if ret == nvml.ERROR_NOT_FOUND {
+			continue
+		}
+		if ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting MIG device handle at index '%v': %v", i, ret)
+		}
+		err := f(i, device)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func populateMigDeviceInfos(device nvml.Device) (map[string]*MigDeviceInfo, error) {
+	migInfos := make(map[string]*MigDeviceInfo)
+
+	err := walkMigDevices(device, func(i int, migDevice nvml.Device) error {
+		parentUuid, ret := device.GetUUID()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting parent GPU UUID: %v", ret)
+		}
+
+		giID, ret := migDevice.GetGpuInstanceId()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting GPU instance ID for MIG device: %v", ret)
+		}
+
+		gi, ret := device.GetGpuInstanceById(giID)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting GPU instance for '%v': %v", giID, ret)
+		}
+
+		giInfo, ret := gi.GetInfo()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting GPU instance info for '%v': %v", giID, ret)
+		}
+
+		ciID, ret := migDevice.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting Compute instance ID for MIG device: %v", ret)
+		}
+
+		ci, ret := gi.GetComputeInstanceById(ciID)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting Compute instance for '%v': %v", ciID, ret)
+		}
+
+		ciInfo, ret := ci.GetInfo()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting Compute instance info for '%v': %v", ciID, ret)
+		}
+
+		uuid, ret := migDevice.GetUUID()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting UUID for MIG device: %v", ret)
+		}
+
+		migInfos[uuid] = &MigDeviceInfo{
+			uuid:   parentUuid,
+			giInfo: &giInfo,
+			ciInfo: &ciInfo,
+			start:  int32(giInfo.Placement.Start),
+			size:   int32(giInfo.Placement.Size),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return migInfos, nil
+}
+
+func createSliceAndPopulateMigInfos(ctx context.Context, device nvml.Device, gpuUUID string, giProfileInfo nvml.GpuInstanceProfileInfo, placement nvml.GpuInstancePlacement, ciProfileId int, ciEngProfileId int) (map[string]*MigDeviceInfo, error) {
+	log := logr.FromContext(ctx)
+
+	var gi nvml.GpuInstance
+	var ret nvml.Return
+	gi, ret = device.CreateGpuInstanceWithPlacement(&giProfileInfo, &placement)
+	if ret != nvml.SUCCESS {
+		switch ret {
+		case nvml.ERROR_INSUFFICIENT_RESOURCES:
+			// Handle insufficient resources case
+			gpuInstances, ret := device.GetGpuInstances(&giProfileInfo)
+			if ret != nvml.SUCCESS {
+				log.Error(ret, "gpu instances cannot be listed")
+				return nil, fmt.Errorf("gpu instances cannot be listed: %v", ret)
+			}
+
+			for _, gpuInstance := range gpuInstances {
+				gpuInstanceInfo, ret := gpuInstance.GetInfo()
+				if ret != nvml.SUCCESS {
+					log.Error(ret, "unable to obtain gpu instance info")
+					return nil, fmt.Errorf("unable to obtain gpu instance info: %v", ret)
+				}
+
+				parentUuid, ret := gpuInstanceInfo.Device.GetUUID()
+				if ret != nvml.SUCCESS {
+					log.Error(ret, "unable to obtain parent gpu uuuid")
+					return nil, fmt.Errorf("unable to obtain parent gpu uuuid: %v", ret)
+				}
+
+				if gpuInstanceInfo.Placement.Start == placement.Start && parentUuid == gpuUUID {
+					gi, ret = device.GetGpuInstanceById(int(gpuInstanceInfo.Id))
+					if ret != nvml.SUCCESS {
+						log.Error(ret, "unable to obtain gi post iteration")
+						return nil, fmt.Errorf("unable to obtain gi post iteration: %v", ret)
+					}
+				}
+			}
+		default:
+			// this case is typically for scenario where ret is not equal to nvml.ERROR_INSUFFICIENT_RESOURCES
+			log.Error(ret, "gpu instance creation errored out with unknown error")
+			return nil, fmt.Errorf("gpu instance creation failed: %v", ret)
+		}
+	}
+
+	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(ciProfileId, ciEngProfileId)
+	if ret != nvml.SUCCESS {
+		log.Error(ret, "error getting compute instance profile info", "gpuUUID", gpuUUID)
+		return nil, fmt.Errorf("error getting compute instance profile info: %v", ret)
+	}
+
+	ci, ret := gi.CreateComputeInstance(&ciProfileInfo)
+	if ret != nvml.SUCCESS {
+		if ret != nvml.ERROR_INSUFFICIENT_RESOURCES {
+			log.Error(ret, "error creating Compute instance", "ci", ci)
+			return nil, fmt.Errorf("error creating compute instance: %v", ret)
+		}
+	}
+
+	migInfos, err := populateMigDeviceInfos(device)
+	if err != nil {
+		log.Error(err, "unable to iterate over newly created MIG devices")
+		return nil, fmt.Errorf("failed to populate MIG device infos: %v", err)
+	}
+
+	return migInfos, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// podGang returns the gang of the pod and the number of pods of the gang, it reports false for a pod outside
+// of any gang
+func podGang(pod *v1.Pod) (string, int, bool, error) {
+	name, ok := pod.Labels[GangLabel]
+	if !ok || name == "" {
+		return "", 0, false, nil
+	}
+	value := pod.Labels[GangSizeLabel]
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return "", 0, false, fmt.Errorf("label %s has malformed value %q, the gang %s needs a positive number of pods", GangSizeLabel, value, name)
+	}
+	return name, size, true, nil
+}
+
+// gangMembers returns the pods of the gang in the namespace of the pod, terminating pods left out
+func (r *InstasliceReconciler) gangMembers(ctx context.Context, pod *v1.Pod, gang string) ([]v1.Pod, error) {
+	var podList v1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(pod.Namespace), client.MatchingLabels{GangLabel: gang}); err != nil {
+		return nil, err
+	}
+	members := make([]v1.Pod, 0, len(podList.Items))
+	for _, member := range podList.Items {
+		if member.DeletionTimestamp.IsZero() {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+// gangFits checks that the slices of every gated pod of the gang that holds no allocation yet fit the cluster
+// together. The pods are placed one after the other on copies of the Instaslice objects, so that a pod sees
+// the slices placed for the pods before it. Checks that read the Node objects are left to the allocation.
+func (r *InstasliceReconciler) gangFits(members []v1.Pod, instaslices []inferencev1alpha1.Instaslice, policy AllocationPolicy) bool {
+	candidates := make([]*inferencev1alpha1.Instaslice, 0, len(instaslices))
+	for i := range instaslices {
+		candidates = append(candidates, instaslices[i].DeepCopy())
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+	now := time.Now()
+	for i := range members {
+		member := &members[i]
+		if !checkIfPodGatedByInstaSlice(member, r.gateName()) || podHoldsSlices(member.UID, candidates) {
+			continue
+		}
+		container, err := gpuContainer(member.Spec.Containers)
+		if err != nil {
+			return false
+		}
+		limits, err := requestLimits(member, container.Resources.Limits)
+		if err != nil {
+			return false
+		}
+		profileName, err := extractProfileName(limits)
+		if err != nil || profileName == "" {
+			return false
+		}
+		placed := false
+		for _, instaslice := range candidates {
+			if !matchesPin(member, instaslice) {
+				continue
+			}
+			// the slices are booked on the copy, the next pods are placed around them
+			if _, err := r.placeSlicesOnInstaslice(instaslice, profileName, policy, member, requestedSliceCount(limits, profileName), nil, now); err == nil {
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return false
+		}
+	}
+	return true
+}
+
+// podHoldsSlices reports whether the pod holds an allocation on one of the Instaslice objects
+func podHoldsSlices(podUID types.UID, instaslices []*inferencev1alpha1.Instaslice) bool {
+	for _, instaslice := range instaslices {
+		for key := range instaslice.Status.PodAllocationResults {
+			if utils.IsSliceOfPod(key, podUID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gangRealized reports whether every pod of the gang is ungated or holds slices the daemonset created, the
+// pods of the gang are ungated only then so that a gang never runs partially
+func gangRealized(members []v1.Pod, instaslices []inferencev1alpha1.Instaslice, gateName string) bool {
+	for i := range members {
+		if !checkIfPodGatedByInstaSlice(&members[i], gateName) {
+			continue
+		}
+		heldSlices := podSlices(members[i].UID, instaslices)
+		if len(heldSlices) == 0 || !allSlicesCreated(heldSlices) {
+			return false
+		}
+	}
+	return true
+}
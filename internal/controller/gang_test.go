@@ -0,0 +1,128 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestPodGang(t *testing.T) {
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	_, _, isGang, err := podGang(pod)
+	assert.NoError(t, err)
+	assert.False(t, isGang)
+
+	pod.Labels = map[string]string{GangLabel: "train", GangSizeLabel: "4"}
+	gang, size, isGang, err := podGang(pod)
+	assert.NoError(t, err)
+	assert.True(t, isGang)
+	assert.Equal(t, "train", gang)
+	assert.Equal(t, 4, size)
+
+	for _, value := range []string{"", "0", "four"} {
+		pod.Labels[GangSizeLabel] = value
+		_, _, _, err := podGang(pod)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestReconcile_Gang(t *testing.T) {
+	tests := []struct {
+		name      string
+		pods      int
+		size      int
+		allocated bool
+	}{
+		// the node has two GPUs, each hosting one 7g.40gb slice
+		{name: "all fit", pods: 2, size: 2, allocated: true},
+		{name: "partial fit", pods: 3, size: 3},
+		{name: "incomplete gang", pods: 2, size: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			objs := []client.Object{utils.GenerateFakeCapacity("node-1")}
+			var pods []*v1.Pod
+			for i := 0; i < tt.pods; i++ {
+				pod := newTestGatedPod(fmt.Sprintf("worker-%d", i), "7g.40gb")
+				pod.Finalizers = []string{FinalizerName}
+				pod.Labels = map[string]string{GangLabel: "train", GangSizeLabel: fmt.Sprint(tt.size)}
+				pods = append(pods, pod)
+				objs = append(objs, pod)
+			}
+			r, fakeClient := newTestReconciler(t, objs...)
+			reconcileAll := func() {
+				for _, pod := range pods {
+					_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+					assert.NoError(t, err)
+				}
+			}
+
+			reconcileAll()
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+			for _, pod := range pods {
+				assert.Equal(t, tt.allocated, hasPodAllocation(pod.UID, instasliceList.Items), pod.Name)
+			}
+			if !tt.allocated {
+				return
+			}
+
+			// the daemonset creates the slice of the first pod only, no pod of the gang is ungated yet
+			realize := func(pod *v1.Pod) {
+				instaslice := &inferencev1alpha1.Instaslice{}
+				assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(&instasliceList.Items[0]), instaslice))
+				allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+				allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+				instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+				assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+				assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+					Name: string(allocResult.ConfigMapResourceIdentifier), Namespace: pod.Namespace,
+				}}))
+			}
+			realize(pods[0])
+			reconcileAll()
+			for _, pod := range pods {
+				updated := &v1.Pod{}
+				assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updated))
+				assert.True(t, checkIfPodGatedByInstaSlice(updated, GateName), pod.Name)
+			}
+
+			// the gang is ungated once every slice is created
+			for _, pod := range pods[1:] {
+				realize(pod)
+			}
+			reconcileAll()
+			for _, pod := range pods {
+				updated := &v1.Pod{}
+				assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updated))
+				assert.False(t, checkIfPodGatedByInstaSlice(updated, GateName), pod.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// migProfileIDs are the NVML GPU instance and compute instance profile ids a slice of a profile is created with
+type migProfileIDs struct {
+	giProfileID int32
+	ciProfileID int32
+}
+
+// gpuGeneration maps the MIG profiles of a GPU generation to their NVML profile ids. The generation of a GPU is
+// recognized from the device name NVML reports for it, which contains every one of nameParts.
+type gpuGeneration struct {
+	name      string
+	nameParts []string
+	profiles  map[string]migProfileIDs
+}
+
+// gpuGenerations lists the known GPU generations, a more specific generation comes before the one whose name
+// parts it shares. Profiles of GPUs of other generations keep the ids discovered on the node.
+var gpuGenerations = []gpuGeneration{
+	{name: "A100-40GB", nameParts: []string{"A100", "40GB"}, profiles: migProfileTable(5, 10, 10, 20, 40)},
+	{name: "A100-80GB", nameParts: []string{"A100", "80GB"}, profiles: migProfileTable(10, 20, 20, 40, 80)},
+	{name: "H100-NVL", nameParts: []string{"H100", "NVL"}, profiles: migProfileTable(12, 24, 24, 47, 94)},
+	{name: "H100", nameParts: []string{"H100"}, profiles: migProfileTable(10, 20, 20, 40, 80)},
+	{name: "H200", nameParts: []string{"H200"}, profiles: migProfileTable(18, 35, 35, 71, 141)},
+}
+
+// migProfileTable builds the profiles of a generation from the memory in GB of its 1g, double memory 1g, 2g,
+// 3g and 4g, and 7g profiles. The GPU instance profile ids are the NVML GPU_INSTANCE_PROFILE_* values and the
+// compute instance takes all compute slices of the GPU instance.
+func migProfileTable(oneSlice, oneSliceRev2, twoSlice, fourSlice, sevenSlice int) map[string]migProfileIDs {
+	return map[string]migProfileIDs{
+		fmt.Sprintf("1g.%dgb", oneSlice):     {giProfileID: 0, ciProfileID: 0},
+		fmt.Sprintf("1g.%dgb+me", oneSlice):  {giProfileID: 7, ciProfileID: 7},
+		fmt.Sprintf("1g.%dgb", oneSliceRev2): {giProfileID: 9, ciProfileID: 0},
+		fmt.Sprintf("2g.%dgb", twoSlice):     {giProfileID: 1, ciProfileID: 1},
+		fmt.Sprintf("3g.%dgb", fourSlice):    {giProfileID: 2, ciProfileID: 2},
+		fmt.Sprintf("4g.%dgb", fourSlice):    {giProfileID: 3, ciProfileID: 3},
+		fmt.Sprintf("7g.%dgb", sevenSlice):   {giProfileID: 4, ciProfileID: 4},
+	}
+}
+
+// gpuGenerationOf returns the generation of the GPU with the given device name
+func gpuGenerationOf(gpuName string) (*gpuGeneration, bool) {
+	for i := range gpuGenerations {
+		generation := &gpuGenerations[i]
+		matches := true
+		for _, part := range generation.nameParts {
+			if !strings.Contains(gpuName, part) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return generation, true
+		}
+	}
+	return nil, false
+}
+
+// ResolveMigProfile returns the placements and profile ids a slice of the profile is created with on the GPU of
+// the Instaslice. The ids discovered on the node are replaced by the ones of the generation of the GPU when it
+// is known, an empty gpuUUID stands for the first GPU of the node. It reports false when the node does not
+// advertise the profile.
+func ResolveMigProfile(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) (inferencev1alpha1.Mig, bool) {
+	mig, ok := instaslice.Status.NodeResources.MigPlacement[profileName]
+	if !ok {
+		return mig, false
+	}
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		if gpuUUID != "" && gpu.GPUUUID != gpuUUID {
+			continue
+		}
+		if generation, ok := gpuGenerationOf(gpu.GPUName); ok {
+			if ids, ok := generation.profiles[profileName]; ok {
+				mig.GIProfileID = ids.giProfileID
+				mig.CIProfileID = ids.ciProfileID
+			}
+		}
+		break
+	}
+	return mig, true
+}
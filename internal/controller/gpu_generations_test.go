@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// newTestGenerationInstaslice advertises the profile on a node with a GPU of the given device name, with the
+// profile ids the daemonset discovery reports: the compute instance id repeats the GPU instance id
+func newTestGenerationInstaslice(gpuName, profileName string, giProfileID int32) *inferencev1alpha1.Instaslice {
+	instaslice := &inferencev1alpha1.Instaslice{}
+	instaslice.Status.NodeResources.NodeGPUs = []inferencev1alpha1.DiscoveredGPU{{GPUUUID: "GPU-1", GPUName: gpuName}}
+	instaslice.Status.NodeResources.MigPlacement = map[string]inferencev1alpha1.Mig{
+		profileName: {
+			Placements:  []inferencev1alpha1.Placement{{Start: 0, Size: 1}},
+			GIProfileID: giProfileID,
+			CIProfileID: giProfileID,
+		},
+	}
+	return instaslice
+}
+
+func TestResolveMigProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		gpuName     string
+		profile     string
+		discovered  int32
+		giProfileID int32
+		ciProfileID int32
+	}{
+		{name: "A100 1g", gpuName: "NVIDIA A100-PCIE-40GB", profile: "1g.5gb", discovered: 0, giProfileID: 0, ciProfileID: 0},
+		{name: "A100 1g with double memory", gpuName: "NVIDIA A100-SXM4-40GB", profile: "1g.10gb", discovered: 9, giProfileID: 9, ciProfileID: 0},
+		{name: "A100 80GB 1g", gpuName: "NVIDIA A100 80GB PCIe", profile: "1g.10gb", discovered: 0, giProfileID: 0, ciProfileID: 0},
+		{name: "H100 1g with double memory", gpuName: "NVIDIA H100 80GB HBM3", profile: "1g.20gb", discovered: 9, giProfileID: 9, ciProfileID: 0},
+		{name: "H100 7g", gpuName: "NVIDIA H100 80GB HBM3", profile: "7g.80gb", discovered: 4, giProfileID: 4, ciProfileID: 4},
+		{name: "H100 NVL 3g", gpuName: "NVIDIA H100 NVL", profile: "3g.47gb", discovered: 2, giProfileID: 2, ciProfileID: 2},
+		{name: "H200 1g with media extensions", gpuName: "NVIDIA H200", profile: "1g.18gb+me", discovered: 7, giProfileID: 7, ciProfileID: 7},
+		{name: "unknown generation keeps the discovered ids", gpuName: "NVIDIA B200", profile: "1g.23gb", discovered: 9, giProfileID: 9, ciProfileID: 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := newTestGenerationInstaslice(tt.gpuName, tt.profile, tt.discovered)
+			mig, ok := ResolveMigProfile(instaslice, "GPU-1", tt.profile)
+			assert.True(t, ok)
+			assert.Equal(t, tt.giProfileID, mig.GIProfileID)
+			assert.Equal(t, tt.ciProfileID, mig.CIProfileID)
+
+			// the allocation is made with the same ids
+			size, giProfileID, ciProfileID, _ := (&InstasliceReconciler{}).extractGpuProfile(instaslice, "", tt.profile)
+			assert.Equal(t, int32(1), size)
+			assert.Equal(t, tt.giProfileID, giProfileID)
+			assert.Equal(t, tt.ciProfileID, ciProfileID)
+		})
+	}
+
+	_, ok := ResolveMigProfile(newTestGenerationInstaslice("NVIDIA H100 NVL", "3g.47gb", 2), "GPU-1", "3g.40gb")
+	assert.False(t, ok)
+}
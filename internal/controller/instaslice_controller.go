@@ -18,10 +18,12 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	goerror "errors"
 	"fmt"
-	"math/rand"
-	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,20 +35,26 @@ import (
 	"github.com/openshift/instaslice-operator/internal/controller/config"
 	mf "github.com/openshift/instaslice-operator/internal/controller/manifests"
 
+	"go.opentelemetry.io/otel/attribute"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logr "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -57,6 +65,25 @@ type InstasliceReconciler struct {
 	kubeClient         *kubernetes.Clientset
 	Config             *config.Config
 	RunningOnOpenShift bool
+	Recorder           record.EventRecorder
+	Accounting         *AccountingHook
+	// APIReader reads the Instaslice objects uncached before their allocations are updated, the cached client
+	// is used when unset
+	APIReader client.Reader
+	// Teardown approves the teardown of slices, slices are torn down right away when unset
+	Teardown *TeardownHook
+	// Policy places the slices, first fit when unset
+	Policy AllocationPolicy
+	// daemonsetReadiness caches the readiness of the daemonset pods across reconciles
+	daemonsetReadiness daemonsetReadiness
+	// allocationConflicts counts the conflicts committing allocations across reconciles
+	allocationConflicts allocationConflicts
+	// creationTimeouts remembers the nodes that did not create the slices of a pod in time
+	creationTimeouts creationTimeouts
+	// allocationBackoff spaces out the allocation retries of pods no node fits
+	allocationBackoff allocationBackoff
+	// preemptionNominations holds the capacity freed by preemption for the pods it was freed for
+	preemptionNominations preemptionNominations
 }
 
 // AllocationPolicy interface with a single method
@@ -66,30 +93,104 @@ type AllocationPolicy interface {
 		nodeResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult)
 }
 
-// not implemented
+// RightToLeftPolicy places slices at the highest free start of the GPU, keeping the low end free for
+// other slices so that large long-lived and small transient slices fragment the GPU less
 type RightToLeftPolicy struct{}
 
-// not implemented
+// LeftToRightPolicy places slices at the lowest free start of the GPU
 type LeftToRightPolicy struct{}
 
 // first fit policy is implemented at the moment
 type FirstFitPolicy struct{}
 
+// BestFitPolicy places slices in the free region of the node GPUs that leaves the smallest free span
+// behind, so that mixed profiles fragment the GPUs less
+type BestFitPolicy struct{}
+
+// WorstFitPolicy places slices in the widest free region of the GPUs across all nodes, spreading the slices
+// for thermal and power balance rather than packing them
+type WorstFitPolicy struct{}
+
+// names under which the allocation policies are known
+const (
+	FirstFitPolicyName    = "first-fit"
+	LeftToRightPolicyName = "left-to-right"
+	RightToLeftPolicyName = "right-to-left"
+	BestFitPolicyName     = "best-fit"
+	WorstFitPolicyName    = "worst-fit"
+)
+
+// policyName returns the name recorded on allocations made by the policy
+func policyName(policy AllocationPolicy) string {
+	switch policy.(type) {
+	case *FirstFitPolicy:
+		return FirstFitPolicyName
+	case *LeftToRightPolicy:
+		return LeftToRightPolicyName
+	case *RightToLeftPolicy:
+		return RightToLeftPolicyName
+	case *BestFitPolicy:
+		return BestFitPolicyName
+	case *WorstFitPolicy:
+		return WorstFitPolicyName
+	default:
+		return fmt.Sprintf("%T", policy)
+	}
+}
+
+// PolicyFromName returns the allocation policy known under the name, an empty name selects first fit
+func PolicyFromName(name string) (AllocationPolicy, error) {
+	switch name {
+	case "", FirstFitPolicyName:
+		return &FirstFitPolicy{}, nil
+	case LeftToRightPolicyName:
+		return &LeftToRightPolicy{}, nil
+	case RightToLeftPolicyName:
+		return &RightToLeftPolicy{}, nil
+	case BestFitPolicyName:
+		return &BestFitPolicy{}, nil
+	case WorstFitPolicyName:
+		return &WorstFitPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown allocation policy %q, expected one of %s, %s, %s, %s or %s",
+			name, FirstFitPolicyName, LeftToRightPolicyName, RightToLeftPolicyName, BestFitPolicyName, WorstFitPolicyName)
+	}
+}
+
+// activePolicy returns the policy allocations are made with
+func (r *InstasliceReconciler) activePolicy() AllocationPolicy {
+	if r.Policy == nil {
+		return &FirstFitPolicy{}
+	}
+	return r.Policy
+}
+
 var daemonSetlabel = map[string]string{"app": "controller-daemonset"}
 
 //+kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=inference.redhat.com,resources=instaslices/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups="",resources=pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;update;patch;watch
 //+kubebuilder:rbac:groups="",resources=nodes/status,verbs=get;list;update;patch;watch
 //+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 //+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=list
 //+kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=create;update;get;watch
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 
-func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, reconcileErr error) {
 	log := logr.FromContext(ctx)
+	defer func(start time.Time) {
+		observeReconcile(start, result, reconcileErr)
+	}(time.Now())
+	ctx, span := startSpan(ctx, spanReconcile, attribute.String("namespace", req.Namespace), attribute.String("pod", req.Name))
+	defer span.End()
 
 	if r.RunningOnOpenShift {
 		err := r.ReconcileSCC(ctx)
@@ -99,13 +200,18 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
+	// terminal pods that are fully cleaned up need no further reconciles
+	if r.isTerminalPodCleanedUp(ctx, req.NamespacedName) {
+		return ctrl.Result{}, nil
+	}
+
 	// 1. Ensure DaemonSet is deployed
 	daemonSet := &appsv1.DaemonSet{}
-	err := r.Get(ctx, types.NamespacedName{Name: InstasliceDaemonsetName, Namespace: InstaSliceOperatorNamespace}, daemonSet)
+	err := r.Get(ctx, types.NamespacedName{Name: InstasliceDaemonsetName, Namespace: r.Config.OperatorNamespace}, daemonSet)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// DaemonSet doesn't exist, so create it
-			daemonSet = r.createInstaSliceDaemonSet(InstaSliceOperatorNamespace)
+			daemonSet = r.createInstaSliceDaemonSet(r.Config.OperatorNamespace)
 			err = r.Create(ctx, daemonSet)
 			if err != nil {
 				log.Error(err, "Failed to create DaemonSet")
@@ -119,103 +225,119 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// 2. Check if at least one DaemonSet pod is ready
-	var podList v1.PodList
-	labelSelector := labels.SelectorFromSet(daemonSet.Spec.Selector.MatchLabels)
-
-	listOptions := &client.ListOptions{
-		LabelSelector: labelSelector,
-		Namespace:     InstaSliceOperatorNamespace,
-	}
-
-	if err := r.List(ctx, &podList, listOptions); err != nil {
+	isAnyPodReady, err := r.isAnyDaemonsetPodReady(ctx, daemonSet)
+	if err != nil {
 		log.Error(err, "Failed to list DaemonSet pods")
 		return ctrl.Result{RequeueAfter: time.Minute}, err
 	}
-
-	// Check if at least one daemonset pod is ready
-	isAnyPodReady := false
-	for _, pod := range podList.Items {
-		if pod.Status.Phase == v1.PodRunning && len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].Ready {
-			isAnyPodReady = true
-			break
-		}
-	}
 	if daemonSet.Status.NumberReady == 0 && !isAnyPodReady {
 		log.Info("No DaemonSet pods are ready yet, waiting...")
 		return ctrl.Result{RequeueAfter: requeue10sDelay}, nil
 	}
 
 	// Continue with the rest of the reconciliation logic
-	policy := &FirstFitPolicy{}
+	policy := r.activePolicy()
 	pod := &v1.Pod{}
-	var instasliceList inferencev1alpha1.InstasliceList
-	if err = r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
-		log.Error(err, "Error getting Instaslice object")
-		return ctrl.Result{}, err
-	}
 	err = r.Get(ctx, req.NamespacedName, pod)
 	if err != nil {
 		// Error fetching the Pod
 		if errors.IsNotFound(err) {
-			return ctrl.Result{}, nil
+			// the pod may be gone without the finalizer having guarded its allocation, its UID is unknown
+			// so every Instaslice is scanned for allocations of its name
+			var instasliceList inferencev1alpha1.InstasliceList
+			if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
+				log.Error(err, "Error getting Instaslice object")
+				return ctrl.Result{}, err
+			}
+			return r.releasePodAllocations(ctx, req.NamespacedName, "", instasliceList.Items)
 		}
 		log.Error(err, "unable to fetch pod")
 		return ctrl.Result{}, nil
 	}
-	// Pods with scheduling gates other than the InstaSlice gate are not ready to be scheduled and should be ignored
-	if isPodGatedByOthers(pod) {
+	// the gating span covers deciding whether the pod is handled, it ends early on any return
+	_, gatingSpan := startSpan(ctx, spanGating)
+	defer gatingSpan.End()
+	// Pods with scheduling gates other than the InstaSlice gate are not ready to be scheduled and are ignored
+	// unless the controller is configured to allocate for them ahead of the other gates.
+	isGatedByOthers := isPodGatedByOthers(pod, r.gateName())
+	if isGatedByOthers && !r.Config.AllocateWithForeignGates {
 		return ctrl.Result{}, nil
 	}
 
-	isPodGated := checkIfPodGatedByInstaSlice(pod)
+	// the allocations of the pod are looked up through the index instead of scanning every Instaslice
+	podInstaslices, err := r.listPodInstaslices(ctx, pod.UID)
+	if err != nil {
+		log.Error(err, "error listing the instaslices of pod")
+		return ctrl.Result{}, err
+	}
+	isPodGated := checkIfPodGatedByInstaSlice(pod, r.gateName())
+	podHasAllocation := hasPodAllocation(pod.UID, podInstaslices)
+
+	// a terminating pod that does not carry the finalizer, because it was removed externally or the pod
+	// was deleted while still gated, never gets one added: it would only delay the deletion, finalizers
+	// cannot be added back to a pod under deletion anyway, so any allocation is released right away
+	if !pod.DeletionTimestamp.IsZero() && !controllerutil.ContainsFinalizer(pod, r.finalizerName()) {
+		return r.releasePodAllocations(ctx, req.NamespacedName, pod.UID, podInstaslices)
+	}
 
-	if !isPodGated && !controllerutil.ContainsFinalizer(pod, FinalizerName) {
+	if !isPodGated && !podHasAllocation && !controllerutil.ContainsFinalizer(pod, r.finalizerName()) {
 		return ctrl.Result{}, nil
 	}
 
-	// Add finalizer to the pod gated by InstaSlice
-	if isPodGated && !controllerutil.ContainsFinalizer(pod, FinalizerName) {
-		pod.Finalizers = append(pod.Finalizers, FinalizerName)
+	// Add finalizer to the pod gated by InstaSlice, or re-add a finalizer lost while the pod holds an allocation
+	// AddFinalizer never appends a second copy, RemoveFinalizer clears every copy
+	if (isPodGated || podHasAllocation) && controllerutil.AddFinalizer(pod, r.finalizerName()) {
 		err := r.Update(ctx, pod)
 		if err != nil {
 			log.Error(err, "failed to add finalizer to pod")
 			return ctrl.Result{Requeue: true}, nil
 		}
 	}
+	gatingSpan.End()
 
 	// failed pods are not deleted by InstaSlice, finalizer is removed so that user can
 	// delete the pod.
-	if pod.Status.Phase == v1.PodFailed && controllerutil.ContainsFinalizer(pod, FinalizerName) {
-		for _, instaslice := range instasliceList.Items {
-			for uuid, allocation := range instaslice.Status.PodAllocationResults {
-				if pod.UID == uuid {
-					if allocation.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating && allocation.AllocationStatus.AllocationStatusDaemonset == "" {
-						return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
-					}
-					if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated || allocation.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated {
-						allocRequest := instaslice.Spec.PodAllocationRequests[uuid]
-						resultDeleting, err := r.setInstasliceAllocationToDeleting(ctx, instaslice.Name, &allocation, &allocRequest)
-						if err != nil {
-							return resultDeleting, nil
-						}
-						// return and rely on daemonset to se allocation status to created
-						// this will cause podmap function to wakeup pod and perform clean up
-						return ctrl.Result{}, nil
-					}
-					if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-						err := r.removeInstasliceAllocation(ctx, instaslice.Name, &allocation)
-						if err != nil {
-							return ctrl.Result{}, err
-						}
-						// requeue for the finalizer to be removed
-						return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
-					}
-					return ctrl.Result{}, nil
+	if pod.Status.Phase == v1.PodFailed && controllerutil.ContainsFinalizer(pod, r.finalizerName()) {
+		// every slice of the pod is released, the finalizer is removed once none is left
+		heldSlices := podSlices(pod.UID, podInstaslices)
+		var removed bool
+		for _, slice := range heldSlices {
+			allocation, allocRequest := slice.result, slice.request
+			if allocation.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating && allocation.AllocationStatus.AllocationStatusDaemonset == "" {
+				return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
+			}
+			if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated || allocation.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated {
+				// keep the slice of a failed pod for the configured retention to allow inspection or restart
+				if remaining := r.failedPodRetentionRemaining(pod); remaining > 0 {
+					log.Info("retaining slice of failed pod", "pod", pod.Name, "remaining", remaining)
+					return ctrl.Result{RequeueAfter: remaining}, nil
+				}
+				resultDeleting, err := r.setInstasliceAllocationToDeleting(ctx, slice.instasliceName, &allocation, &allocRequest)
+				if err != nil {
+					return resultDeleting, nil
+				}
+				// rely on daemonset to se allocation status to deleted
+				// this will cause podmap function to wakeup pod and perform clean up
+				continue
+			}
+			if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				err := r.removeInstasliceAllocation(ctx, slice.instasliceName, &allocation)
+				if err != nil {
+					return ctrl.Result{}, err
 				}
+				removed = true
+			}
+		}
+		if len(heldSlices) > 0 {
+			if removed {
+				// requeue for the finalizer to be removed
+				return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
 			}
+			return ctrl.Result{}, nil
 		}
-		// pod can be terminated without any allocation
-		if controllerutil.RemoveFinalizer(pod, FinalizerName) {
+		// pod can be terminated without any allocation, the injected node selector stays: the node selector of a
+		// scheduled pod is immutable and an update dropping it would be rejected together with the finalizer
+		if controllerutil.RemoveFinalizer(pod, r.finalizerName()) {
 			if err := r.Update(ctx, pod); err != nil {
 				log.Error(err, "unable to update removal of finalizer, retrying")
 				// requeing immediately as the finalizer removal gets lost
@@ -227,37 +349,37 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// pod is completed move allocation to deleting state and return
-	if pod.Status.Phase == v1.PodSucceeded && controllerutil.ContainsFinalizer(pod, FinalizerName) {
-		for _, instaslice := range instasliceList.Items {
-			for uuid, allocation := range instaslice.Status.PodAllocationResults {
-				if uuid == pod.UID {
-					if allocation.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
-						allocRequest := instaslice.Spec.PodAllocationRequests[uuid]
-						log.Info("setting status to deleting", "pod", pod.Name)
-						result, err := r.setInstasliceAllocationToDeleting(ctx, instaslice.Name, &allocation, &allocRequest)
-						if err != nil {
-							return result, err
-						}
-						// return and rely on daemonset to se allocation status to created
-						// this will cause podmap function to wakeup pod and perform clean up
-						return ctrl.Result{}, nil
-					}
-
-					if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-						err := r.removeInstasliceAllocation(ctx, instaslice.Name, &allocation)
-						if err != nil {
-							return ctrl.Result{}, err
-						}
-						// requeue for the finalizer to be removed
-						return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
-					}
-					return ctrl.Result{}, nil
+	if pod.Status.Phase == v1.PodSucceeded && controllerutil.ContainsFinalizer(pod, r.finalizerName()) {
+		heldSlices := podSlices(pod.UID, podInstaslices)
+		var removed bool
+		for _, slice := range heldSlices {
+			allocation, allocRequest := slice.result, slice.request
+			if allocation.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
+				log.Info("setting status to deleting", "pod", pod.Name)
+				result, err := r.setInstasliceAllocationToDeleting(ctx, slice.instasliceName, &allocation, &allocRequest)
+				if err != nil {
+					return teardownPendingResult(result, err)
 				}
+				// rely on daemonset to se allocation status to deleted
+				// this will cause podmap function to wakeup pod and perform clean up
+				continue
+			}
+			err := r.removeInstasliceAllocation(ctx, slice.instasliceName, &allocation)
+			if err != nil {
+				return ctrl.Result{}, err
 			}
+			removed = true
+		}
+		if len(heldSlices) > 0 {
+			if removed {
+				// requeue for the finalizer to be removed
+				return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
+			}
+			return ctrl.Result{}, nil
 		}
 
 		// pod can be terminated as allocation was deleted in previous reconcile loop
-		if controllerutil.RemoveFinalizer(pod, FinalizerName) {
+		if controllerutil.RemoveFinalizer(pod, r.finalizerName()) {
 			if err := r.Update(ctx, pod); err != nil {
 				// requeing immediately as the finalizer removal gets lost
 				return ctrl.Result{Requeue: true}, nil
@@ -270,69 +392,74 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// handle deleted pod that never gets ungated
 	// set allocation status to deleting to cleanup resources if any
 	if !pod.DeletionTimestamp.IsZero() && isPodGated {
+		r.allocationBackoff.forget(pod.UID)
+		r.creationTimeouts.forget(pod.UID)
+		r.preemptionNominations.forget(pod.UID)
 		// allocation can be in creating or created while the user deletes the pod.
-		for _, instaslice := range instasliceList.Items {
-			for podUuid, allocation := range instaslice.Status.PodAllocationResults {
-				if podUuid == pod.UID && (allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated) {
-					allocation.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
-					allocRequest := instaslice.Spec.PodAllocationRequests[podUuid]
-					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocation, &allocRequest); err != nil {
-						log.Info("unable to set instaslice to state deleted for ungated", "pod", pod.Name)
-						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
-					}
-					return ctrl.Result{}, nil
+		heldSlices := podSlices(pod.UID, podInstaslices)
+		for _, slice := range heldSlices {
+			allocation, allocRequest := slice.result, slice.request
+			if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated {
+				allocation.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+				if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, slice.instasliceName, &allocation, &allocRequest); err != nil {
+					log.Info("unable to set instaslice to state deleted for ungated", "pod", pod.Name)
+					return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
 				}
-				if podUuid == pod.UID && allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-					err := r.removeInstasliceAllocation(ctx, instaslice.Name, &allocation)
-					if err != nil {
-						return ctrl.Result{}, err
-					}
-					if controllerutil.RemoveFinalizer(pod, FinalizerName) {
-						if err := r.Update(ctx, pod); err != nil {
-							// requeing immediately as the finalizer removal gets lost
-							return ctrl.Result{Requeue: true}, nil
-						}
-						log.Info("finalizer deleted for allocation status deleted ", "pod", pod.Name)
-					}
-					return ctrl.Result{}, nil
+			}
+		}
+		// the finalizer is removed once the daemonset deleted every slice of the pod
+		if len(heldSlices) > 0 && allSlicesDeleted(heldSlices) {
+			for _, slice := range heldSlices {
+				if err := r.removeInstasliceAllocation(ctx, slice.instasliceName, &slice.result); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			if controllerutil.RemoveFinalizer(pod, r.finalizerName()) {
+				if err := r.Update(ctx, pod); err != nil {
+					// requeing immediately as the finalizer removal gets lost
+					return ctrl.Result{Requeue: true}, nil
 				}
+				log.Info("finalizer deleted for allocation status deleted ", "pod", pod.Name)
 			}
 		}
 
 		return ctrl.Result{}, nil
 	}
-	// handle graceful termination of pods, wait for about 30 seconds from the time deletiontimestamp is set on the pod
+	// handle graceful termination of pods, wait for the deletion timeout from the time deletiontimestamp is set on the pod
 	if !pod.DeletionTimestamp.IsZero() {
 		log.Info("set status to deleting for ", "pod", pod.Name)
-		if controllerutil.ContainsFinalizer(pod, FinalizerName) {
-			for _, instaslice := range instasliceList.Items {
-				for podUuid, allocation := range instaslice.Status.PodAllocationResults {
-					if podUuid == pod.UID {
-						if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-							allocRequest := instaslice.Spec.PodAllocationRequests[podUuid]
-							err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocation, &allocRequest)
-							if err != nil {
-								return ctrl.Result{}, err
-							}
-							resultRemove, err := r.removeInstaSliceFinalizer(ctx, req)
-							if err != nil {
-								return resultRemove, err
-							}
-						}
-						elapsed := time.Since(pod.DeletionTimestamp.Time)
-						if elapsed > 30*time.Second {
-							allocation.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
-							allocRequest := instaslice.Spec.PodAllocationRequests[podUuid]
-							if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocation, &allocRequest); err != nil {
-								log.Info("unable to set instaslice to state deleted for ", "pod", pod.Name)
-								return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
-							}
-						} else {
-							remainingTime := 30*time.Second - elapsed
-							return ctrl.Result{RequeueAfter: remainingTime}, nil
+		if controllerutil.ContainsFinalizer(pod, r.finalizerName()) {
+			heldSlices := podSlices(pod.UID, podInstaslices)
+			for _, slice := range heldSlices {
+				allocation, allocRequest := slice.result, slice.request
+				if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+					err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, slice.instasliceName, &allocation, &allocRequest)
+					if err != nil {
+						return ctrl.Result{}, err
+					}
+					// the finalizer guards the pod until the daemonset deleted every slice of it
+					if allSlicesDeleted(heldSlices) {
+						resultRemove, err := r.removeInstaSliceFinalizer(ctx, req)
+						if err != nil {
+							return resultRemove, err
 						}
 					}
 				}
+				elapsed := time.Since(pod.DeletionTimestamp.Time)
+				deletionTimeout := r.deletionTimeout(pod)
+				if elapsed > deletionTimeout {
+					if !r.awaitTeardownApproval(ctx, slice.instasliceName, &allocation, &allocRequest) {
+						return ctrl.Result{RequeueAfter: teardownRetryDelay}, nil
+					}
+					allocation.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, slice.instasliceName, &allocation, &allocRequest); err != nil {
+						log.Info("unable to set instaslice to state deleted for ", "pod", pod.Name)
+						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+					}
+				} else {
+					remainingTime := deletionTimeout - elapsed
+					return ctrl.Result{RequeueAfter: remainingTime}, nil
+				}
 			}
 		}
 		// exit after handling deletion event for a pod.
@@ -348,69 +475,322 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if len(pod.Spec.Containers) == 0 {
 			return ctrl.Result{}, fmt.Errorf(noContainerInsidePodErr+", pod: %v", pod.Name)
 		}
-		// Assume pod only has one container with one GPU requests
-		if len(pod.Spec.Containers) != 1 {
-			return ctrl.Result{}, fmt.Errorf(multipleContainersUnsupportedErr+", pod: %v", pod.Name)
-		}
-		limits := pod.Spec.Containers[0].Resources.Limits
-		profileName := r.extractProfileName(limits)
-		var podHasNodeAllocation bool
-		// search if pod has allocation in any of the instaslice object in the cluster
-		// TODO: allocations may get slower as the cluster size increases
-		for _, instaslice := range instasliceList.Items {
-			for uuid := range instaslice.Spec.PodAllocationRequests {
-				// no matter the state if allocations exists for a pod skip such a pod
-				if uuid == pod.UID {
-					podHasNodeAllocation = true
+		// a single container of the pod may request a GPU slice, sidecars without a MIG resource are
+		// ignored. A pod with more GPU containers cannot be allocated until it is recreated so it is
+		// reported once instead of being retried with an error
+		container, err := gpuContainer(pod.Spec.Containers)
+		if err != nil {
+			log.Info("skipping pod", "pod", pod.Name, "reason", err.Error())
+			if r.Recorder != nil {
+				r.Recorder.Event(pod, v1.EventTypeWarning, "MultipleGPUContainersUnsupported", err.Error())
+			}
+			return ctrl.Result{}, nil
+		}
+		// the slice is handed to the pod through the ConfigMap the webhook references, a pod gated by hand
+		// without it stays gated until it is recreated
+		if !hasSliceConfigMap(container) {
+			log.Info("skipping pod", "pod", pod.Name, "reason", noSliceConfigMapErr)
+			if r.Recorder != nil {
+				r.Recorder.Event(pod, v1.EventTypeWarning, "MissingSliceConfigMap", noSliceConfigMapErr)
+			}
+			return ctrl.Result{}, nil
+		}
+		// the slices are requested through the limits of the container or the profile labels or annotation of the pod
+		limits, err := requestLimits(pod, container.Resources.Limits)
+		if err == nil {
+			err = validateProfileRequest(limits)
+		}
+		var profileName string
+		if err == nil {
+			profileName, err = extractProfileName(limits)
+		}
+		// a corrupt request cannot be allocated, skip the pod rather than retry it
+		if err != nil {
+			log.Error(err, "skipping pod with malformed InstaSlice request", "pod", pod.Name)
+			if r.Recorder != nil {
+				r.Recorder.Event(pod, v1.EventTypeWarning, "MalformedProfileRequest", err.Error())
+			}
+			return ctrl.Result{}, nil
+		}
+		// the pods of a gang are allocated once the whole gang fits and ungated once every pod holds its slices
+		gang, gangSize, isGang, err := podGang(pod)
+		if err != nil {
+			log.Error(err, "skipping pod with malformed gang", "pod", pod.Name)
+			if r.Recorder != nil {
+				r.Recorder.Event(pod, v1.EventTypeWarning, "MalformedGang", err.Error())
+			}
+			return ctrl.Result{}, nil
+		}
+		var gangPods []v1.Pod
+		if isGang {
+			if gangPods, err = r.gangMembers(ctx, pod, gang); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		sliceCount := requestedSliceCount(limits, profileName)
+		heldSlices := podSlices(pod.UID, podInstaslices)
+		// an allocation released while the pod is still gated is removed once the daemonset cleaned it up,
+		// the pod is then allocated again
+		for _, slice := range heldSlices {
+			if slice.result.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				if err := r.removeInstasliceAllocation(ctx, slice.instasliceName, &slice.result); err != nil {
+					return ctrl.Result{}, err
 				}
+				return ctrl.Result{Requeue: true}, nil
 			}
 		}
+		// search if pod has allocation in the Instaslice objects indexed for the pod
+		// no matter the state if allocations exists for a pod skip such a pod
+		podHasNodeAllocation := hasPodAllocation(pod.UID, podInstaslices)
 
-		for _, instaslice := range instasliceList.Items {
-			for uuid, allocations := range instaslice.Status.PodAllocationResults {
-				if allocations.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated && uuid == pod.UID {
-					allocations.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusUngated
-					allocRequest := instaslice.Spec.PodAllocationRequests[uuid]
-					if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocations, &allocRequest); err != nil {
-						return ctrl.Result{Requeue: true}, err
+		// the capacity stays allocated but the pod is ungated only once the gates of other controllers are cleared
+		if podHasNodeAllocation && isGatedByOthers {
+			log.Info("waiting for scheduling gates of other controllers to clear", "pod", pod.Name)
+			return ctrl.Result{}, nil
+		}
+
+		// a slice the daemonset does not create in time, e.g. after an NVML failure on the node, is given up
+		// and the pod allocated again
+		for _, slice := range heldSlices {
+			if r.isCreationTimedOut(&slice.result) {
+				return r.abandonTimedOutCreation(ctx, pod, heldSlices)
+			}
+		}
+
+		// the pod is ungated once the daemonset created every slice it requested
+		if len(heldSlices) > 0 && allSlicesCreated(heldSlices) {
+			if err := r.annotateAllocationStatus(ctx, pod, string(inferencev1alpha1.AllocationStatusCreated), &heldSlices[0].result); err != nil {
+				log.Error(err, "unable to annotate allocation status", "pod", pod.Name)
+			}
+			for _, slice := range heldSlices {
+				// do not ungate onto a slice that the daemonset has not actually realized
+				realized, err := r.isAllocationRealized(ctx, &slice.result, pod.Namespace)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+				if realized {
+					continue
+				}
+				// a slice that never shows up, for example while the GPU stack of the node is not ready,
+				// is given up after the configured wait and the pod is allocated afresh
+				if r.isRealizationWaitExceeded(&slice.result) {
+					log.Info("abandoning allocation that was not realized in time", "pod", pod.Name, "maxWait", r.Config.MaxRealizationWait)
+					if r.Recorder != nil {
+						r.Recorder.Event(pod, v1.EventTypeWarning, "AllocationAbandoned",
+							fmt.Sprintf("InstaSlice slice for pod %s was not realized within %s, allocating again", pod.Name, r.Config.MaxRealizationWait))
 					}
-					result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &allocations)
-					if err != nil {
-						return result, err
+					for _, abandoned := range heldSlices {
+						if result, err := r.setInstasliceAllocationToDeleting(ctx, abandoned.instasliceName, &abandoned.result, &abandoned.request); err != nil {
+							return teardownPendingResult(result, err)
+						}
 					}
-					break
+					return ctrl.Result{}, nil
+				}
+				log.Info("allocation is created but the slice is not realized yet", "pod", pod.Name)
+				return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
+			}
+			// the slices are only usable once the device plugin of the node advertises them
+			pluginReady, err := r.isDevicePluginReady(ctx, string(heldSlices[0].result.Nodename))
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if !pluginReady {
+				log.Info("waiting for the device plugin of the node", "pod", pod.Name, "node", heldSlices[0].result.Nodename)
+				return ctrl.Result{RequeueAfter: requeue10sDelay}, nil
+			}
+			if isGang {
+				var instasliceList inferencev1alpha1.InstasliceList
+				if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
+					log.Error(err, "Error getting Instaslice object")
+					return ctrl.Result{}, err
+				}
+				if len(gangPods) < gangSize || !gangRealized(gangPods, instasliceList.Items, r.gateName()) {
+					log.Info("waiting for the slices of the whole gang", "pod", pod.Name, "gang", gang)
+					return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
+				}
+			}
+			for _, slice := range heldSlices {
+				slice.result.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusUngated
+				if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, slice.instasliceName, &slice.result, &slice.request); err != nil {
+					return ctrl.Result{Requeue: true}, err
 				}
-				// InstaSlice object got updated with ungated status but the controller failed
-				// ungating the pod.
-				if allocations.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated && uuid == pod.UID {
-					result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &allocations)
+			}
+			result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &heldSlices[0].result)
+			if err != nil {
+				return result, err
+			}
+		} else {
+			// InstaSlice object got updated with ungated status but the controller failed
+			// ungating the pod.
+			for _, slice := range heldSlices {
+				if slice.result.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated {
+					result, err := r.addNodeSelectorAndUngatePod(ctx, pod, &slice.result)
 					if err != nil {
 						return result, err
 					}
+					break
 				}
 			}
 		}
 		// pod does not have an allocation yet, make allocation
 		// find the node
+		var instasliceList inferencev1alpha1.InstasliceList
 		if !podHasNodeAllocation {
-			sort.Slice(instasliceList.Items, func(i, j int) bool {
-				// Sort by Name in ascending order
-				return instasliceList.Items[i].Name < instasliceList.Items[j].Name
-			})
-			for _, instaslice := range instasliceList.Items {
-				// find the GPU on the node and the GPU index where the slice can be created
-				allocRequest, allocResult, err := r.findNodeAndDeviceForASlice(ctx, &instaslice, profileName, policy, pod)
-				if err != nil {
-					continue
+			// give other admission controllers time to finish mutating a new pod
+			if remaining := r.allocationGraceRemaining(pod); remaining > 0 {
+				log.Info("deferring allocation within grace period", "pod", pod.Name, "remaining", remaining)
+				return ctrl.Result{RequeueAfter: remaining}, nil
+			}
+			frozen, err := r.isAllocationFrozen(ctx)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if frozen {
+				log.Info("allocations are frozen for maintenance", "pod", pod.Name)
+				if r.Recorder != nil {
+					r.Recorder.Event(pod, v1.EventTypeNormal, "AllocationFrozen",
+						fmt.Sprintf("InstaSlice allocations are frozen, pod %s waits until the freeze is lifted", pod.Name))
+				}
+				return ctrl.Result{RequeueAfter: requeue10sDelay}, nil
+			}
+			// only placing a new allocation needs every Instaslice of the cluster
+			if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
+				log.Error(err, "Error getting Instaslice object")
+				return ctrl.Result{}, err
+			}
+			if isGang {
+				if len(gangPods) < gangSize {
+					log.Info("waiting for the pods of the gang", "pod", pod.Name, "gang", gang, "pods", len(gangPods), "size", gangSize)
+					if r.Recorder != nil {
+						r.Recorder.Event(pod, v1.EventTypeNormal, "GangIncomplete",
+							fmt.Sprintf("InstaSlice gang %s has %d of its %d pods, pod %s waits for the others", gang, len(gangPods), gangSize, pod.Name))
+					}
+					return ctrl.Result{RequeueAfter: requeue10sDelay}, nil
+				}
+				if !r.gangFits(gangPods, instasliceList.Items, policy) {
+					log.Info("the slices of the gang do not fit the cluster", "pod", pod.Name, "gang", gang)
+					if r.Recorder != nil {
+						r.Recorder.Event(pod, v1.EventTypeNormal, "GangDoesNotFit",
+							fmt.Sprintf("InstaSlice capacity cannot host every pod of gang %s, pod %s stays gated", gang, pod.Name))
+					}
+					return ctrl.Result{RequeueAfter: r.allocationBackoff.next(pod.UID, r.Config.MaxAllocationBackoff)}, nil
+				}
+			}
+			// the slices of the pod must fit the quota of its namespace
+			quota, err := r.namespaceQuota(ctx, pod.Namespace)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if limit, exceeded := exceededQuotaLimit(quota, namespaceSliceUsage(instasliceList.Items, pod.Namespace), profileName, int(sliceCount)); exceeded {
+				log.Info("namespace is over its slice quota", "pod", pod.Name, "namespace", pod.Namespace, "limit", limit, "quota", quota[limit])
+				if r.Recorder != nil {
+					r.Recorder.Event(pod, v1.EventTypeNormal, "OverQuota",
+						fmt.Sprintf("InstaSlice quota of namespace %s allows %d %s slices, pod %s stays gated until slices are released or the quota is raised",
+							pod.Namespace, quota[limit], limit, pod.Name))
 				}
-				podHasNodeAllocation = true
-				if podHasNodeAllocation {
-					err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, allocResult, allocRequest)
+				return ctrl.Result{RequeueAfter: requeue10sDelay}, nil
+			}
+			// a realized warm slice of the workload of the pod is handed over, no slice has to be created
+			claimed, err := r.claimWarmSlice(ctx, pod, profileName, sliceCount, instasliceList.Items)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if claimed {
+				r.allocationConflicts.forget(pod.UID)
+				r.allocationBackoff.forget(pod.UID)
+				r.preemptionNominations.forget(pod.UID)
+				return ctrl.Result{}, nil
+			}
+			reservedForSurge, err := r.isCapacityReservedForSurge(ctx, pod, profileName, instasliceList.Items)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if reservedForSurge {
+				log.Info("remaining capacity is reserved for rolling updates", "pod", pod.Name)
+				if r.Recorder != nil {
+					r.Recorder.Event(pod, v1.EventTypeNormal, "CapacityReservedForRollout",
+						fmt.Sprintf("InstaSlice capacity is reserved for surge pods of rolling updates, pod %s waits until they are allocated", pod.Name))
+				}
+				return ctrl.Result{RequeueAfter: requeue10sDelay}, nil
+			}
+			preferredGeneration, err := r.preferredGeneration(ctx, pod)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			r.orderCandidates(instasliceList.Items, pod, policy, profileName, preferredGeneration)
+			allocLog := log.WithName(LogSubsystemAllocation)
+			var candidates []string
+			for _, candidateProfile := range r.allocationProfiles(pod, profileName) {
+				for _, instaslice := range instasliceList.Items {
+					// a pod pinned to a node or GPU is never placed elsewhere
+					if !matchesPin(pod, &instaslice) {
+						continue
+					}
+					if candidateProfile == profileName {
+						candidates = append(candidates, instaslice.Name)
+					}
+					// find the GPUs on the node and the GPU indexes where the slices can be created
+					findCtx, findSpan := startSpan(ctx, spanFindNode, attribute.String("node", instaslice.Name), attribute.String("profile", candidateProfile))
+					allocations, err := r.findNodeAndDevicesForSlices(findCtx, &instaslice, candidateProfile, policy, pod, sliceCount)
+					findSpan.End()
 					if err != nil {
-						return ctrl.Result{Requeue: true}, nil
+						allocLog.V(1).Info("node cannot host the slices", "pod", pod.Name, "node", instaslice.Name, "profile", candidateProfile, "reason", err.Error())
+						// a faulty placement is never handed to the daemonset, the pod stays gated
+						if goerror.Is(err, ErrInvalidPlacement) && r.Recorder != nil {
+							r.Recorder.Event(pod, v1.EventTypeWarning, "InvalidPlacement", err.Error())
+						}
+						continue
+					}
+					// capacity freed by preemption waits for the pods it was freed for
+					if r.takesPreemptedCapacity(&instaslice, pod, allocations, policy) {
+						allocLog.V(1).Info("node capacity is held for a preempting pod", "pod", pod.Name, "node", instaslice.Name)
+						continue
+					}
+					podHasNodeAllocation = true
+					if podHasNodeAllocation {
+						allocResult := allocations[0].Result
+						// the slices of the pod are allocated together or not at all
+						allocResults := make([]inferencev1alpha1.AllocationResult, 0, len(allocations))
+						allocRequests := make([]inferencev1alpha1.AllocationRequest, 0, len(allocations))
+						for _, allocation := range allocations {
+							allocResults = append(allocResults, *allocation.Result)
+							allocRequests = append(allocRequests, *allocation.Request)
+						}
+						commitCtx, commitSpan := startSpan(ctx, spanCommit, attribute.String("node", instaslice.Name), attribute.String("gpu", allocResult.GPUUUID))
+						err := utils.AddInstasliceAllocations(commitCtx, r.Client, r.apiReader(), r.Config.OperatorNamespace, instaslice.Name, allocResults, allocRequests)
+						endSpan(commitSpan, err)
+						if err != nil {
+							// after repeated conflicts on this node, try committing to the next feasible node
+							if errors.IsConflict(err) && r.allocationConflicts.record(pod.UID, instaslice.Name, r.Config.AllocationConflictLimit) {
+								allocLog.Info("allocation conflicts repeatedly, trying another node", "pod", pod.Name, "node", instaslice.Name)
+								podHasNodeAllocation = false
+								continue
+							}
+							return ctrl.Result{Requeue: true}, nil
+						}
+						r.allocationConflicts.forget(pod.UID)
+						r.creationTimeouts.forget(pod.UID)
+						r.allocationBackoff.forget(pod.UID)
+						r.preemptionNominations.forget(pod.UID)
+						allocLog.V(1).Info("allocated slices", "pod", pod.Name, "node", instaslice.Name, "profile", candidateProfile,
+							"gpu", allocResult.GPUUUID, "start", allocResult.MigPlacement.Start, "candidates", candidates)
+						placementLatency.WithLabelValues(allocResult.Policy).Observe(time.Since(pod.CreationTimestamp.Time).Seconds())
+						for _, allocation := range allocations {
+							r.Accounting.Emit(newAccountingRecord(AccountingEventAllocate, allocation.Request, allocation.Result))
+						}
+						if candidateProfile != profileName {
+							allocLog.Info("requested profile timed out, allocated fallback profile", "pod", pod.Name, "requested", profileName, "allocated", candidateProfile)
+						}
+						if err := r.annotateAllocationDecision(ctx, pod, allocResult, candidates); err != nil {
+							// the decision record is informational, the allocation stands
+							log.Error(err, "unable to annotate allocation decision", "pod", pod.Name)
+						}
+						if err := r.annotateAllocationStatus(ctx, pod, string(inferencev1alpha1.AllocationStatusCreating), allocResult); err != nil {
+							log.Error(err, "unable to annotate allocation status", "pod", pod.Name)
+						}
+						// allocation was successful
+						return ctrl.Result{}, nil
 					}
-					// allocation was successful
-					return ctrl.Result{}, nil
 				}
 			}
 		}
@@ -418,9 +798,50 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// if the cluster does not have suitable node, requeue request
 		if !podHasNodeAllocation {
 			log.Info("no suitable node found in cluster for ", "pod", pod.Name)
-			// Generate a random duration between 1 and 10 seconds
-			randomDuration := time.Duration(rand.Intn(10)+1) * time.Second
-			return ctrl.Result{RequeueAfter: randomDuration}, nil
+			// slices of lower priority pods are released for the pod, it is allocated once they are gone
+			if r.Config.EnablePreemption {
+				preempted, err := r.preemptForPod(ctx, pod, profileName, sliceCount, policy, instasliceList.Items)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+				if preempted {
+					return ctrl.Result{RequeueAfter: Requeue2sDelay}, nil
+				}
+			}
+			if pin := podPin(pod); pin != "" {
+				// the pin is a choice of the pod, it is explained on the pod itself
+				if r.Recorder != nil {
+					r.Recorder.Event(pod, v1.EventTypeWarning, "PinnedCapacityUnavailable",
+						fmt.Sprintf("InstaSlice capacity unavailable for profile %s on %s pod %s is pinned to, the pod stays gated", profileName, pin, pod.Name))
+				}
+			} else if goerror.Is(noCapacityError(instasliceList.Items, profileName), ErrProfileUnknown) {
+				// released slices do not help, the pod needs another profile or a GPU offering this one
+				r.recordOwnerEvent(ctx, pod, v1.EventTypeWarning, "ProfileUnknown",
+					fmt.Sprintf("InstaSlice profile %s requested by pod %s is not offered by any GPU", profileName, pod.Name))
+			} else {
+				r.recordOwnerEvent(ctx, pod, v1.EventTypeWarning, "CapacityUnavailable",
+					fmt.Sprintf("InstaSlice capacity unavailable for profile %s requested by pod %s", profileName, pod.Name))
+			}
+			if err := r.updateQueuePosition(ctx, pod, profileName, instasliceList.Items); err != nil {
+				// the position is informational, allocation is retried regardless
+				log.Error(err, "unable to update queue position", "pod", pod.Name)
+			}
+			if r.Config.ScaleUpHints {
+				if err := r.setScaleUpHint(ctx, pod, profileName); err != nil {
+					log.Error(err, "unable to set scale-up hint", "pod", pod.Name)
+				}
+			}
+			if timeout := r.giveUpTimeout(pod); timeout > 0 && time.Since(pod.CreationTimestamp.Time) > timeout {
+				log.Info("giving up allocation", "pod", pod.Name, "schedulerName", pod.Spec.SchedulerName, "timeout", timeout)
+				r.allocationConflicts.forget(pod.UID)
+				r.creationTimeouts.forget(pod.UID)
+				r.allocationBackoff.forget(pod.UID)
+				r.recordOwnerEvent(ctx, pod, v1.EventTypeWarning, "AllocationGaveUp",
+					fmt.Sprintf("InstaSlice gave up allocating pod %s after %s", pod.Name, timeout))
+				return ctrl.Result{}, nil
+			}
+			// the retries of a pod no node fits are spaced out further every time
+			return ctrl.Result{RequeueAfter: r.allocationBackoff.next(pod.UID, r.Config.MaxAllocationBackoff)}, nil
 		}
 
 	}
@@ -491,6 +912,14 @@ func (r *InstasliceReconciler) createInstaSliceDaemonSet(namespace string) *apps
 									Name:  "NVIDIA_MIG_CONFIG_DEVICES",
 									Value: "all",
 								},
+								{
+									Name: "POD_NAMESPACE",
+									ValueFrom: &v1.EnvVarSource{
+										FieldRef: &v1.ObjectFieldSelector{
+											FieldPath: "metadata.namespace",
+										},
+									},
+								},
 								{
 									Name:  "EMULATOR_MODE",
 									Value: fmt.Sprintf("%v", emulatorMode),
@@ -506,78 +935,258 @@ func (r *InstasliceReconciler) createInstaSliceDaemonSet(namespace string) *apps
 	return daemonSet
 }
 
-// Extract profile name from the container limits spec
-func (*InstasliceReconciler) extractProfileName(limits v1.ResourceList) string {
-	profileName := ""
+// extractProfileNames returns the distinct profiles requested by the MIG resources of the container limits
+// spec, sorted by name
+func extractProfileNames(limits v1.ResourceList) []string {
+	var profileNames []string
 	for k := range limits {
 		if strings.Contains(k.String(), "mig-") {
-
-			re := regexp.MustCompile(`(\d+g\.\d+gb)`)
-			match := re.FindStringSubmatch(k.String())
-			if len(match) > 1 {
-				profileName = match[1]
+			if match := profileNamePattern.FindString(k.String()); match != "" && !slices.Contains(profileNames, match) {
+				profileNames = append(profileNames, match)
 			}
 		}
 	}
-	return profileName
+	sort.Strings(profileNames)
+	return profileNames
 }
 
-// Extract NVML specific attributes for GPUs, this will change for different generations of the GPU.
-func (*InstasliceReconciler) extractGpuProfile(instaslice *inferencev1alpha1.Instaslice, profileName string) (int32, int32, int32, int32) {
-	var size int32
-	var discoveredGiprofile int32
-	var Ciprofileid int32
-	var Ciengprofileid int32
-	for profName, placement := range instaslice.Status.NodeResources.MigPlacement {
-		if profName == profileName {
-			for _, aPlacement := range placement.Placements {
-				size = aPlacement.Size
-				discoveredGiprofile = placement.GIProfileID
-				Ciprofileid = placement.CIProfileID
-				Ciengprofileid = placement.CIEngProfileID
-				break
-			}
+// extractProfileName returns the profile requested by the container limits spec, empty when none is. A
+// container requesting more than one distinct profile is ambiguous and yields an error.
+func extractProfileName(limits v1.ResourceList) (string, error) {
+	profileNames := extractProfileNames(limits)
+	switch len(profileNames) {
+	case 0:
+		return "", nil
+	case 1:
+		return profileNames[0], nil
+	default:
+		return "", fmt.Errorf("limits request the distinct profiles %s, a container may request a single profile", strings.Join(profileNames, ", "))
+	}
+}
+
+// hasSliceConfigMap reports whether the first envFrom source of the container is the ConfigMap the slice is
+// handed over in
+func hasSliceConfigMap(container *v1.Container) bool {
+	return len(container.EnvFrom) > 0 && container.EnvFrom[0].ConfigMapRef != nil
+}
+
+// gpuContainer returns the container of the pod requesting a MIG profile, the only container of a
+// pod is returned as is. It fails when more than one container requests a profile.
+func gpuContainer(containers []v1.Container) (*v1.Container, error) {
+	if len(containers) == 0 {
+		return nil, fmt.Errorf(noContainerInsidePodErr)
+	}
+	if len(containers) == 1 {
+		return &containers[0], nil
+	}
+	var found *v1.Container
+	for i := range containers {
+		if len(extractProfileNames(containers[i].Resources.Limits)) == 0 {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s: %s and %s", multipleGPUContainersErr, found.Name, containers[i].Name)
+		}
+		found = &containers[i]
+	}
+	if found == nil {
+		// none of the containers asks for a slice, the first one stands for the pod
+		return &containers[0], nil
+	}
+	return found, nil
+}
+
+// validateProfileRequest checks that every InstaSlice limit names a profile and asks for a
+// positive whole number of slices
+func validateProfileRequest(limits v1.ResourceList) error {
+	for resourceName, quantity := range limits {
+		if !strings.HasPrefix(resourceName.String(), OrgInstaslicePrefix+"mig-") {
+			continue
+		}
+		if !profileNamePattern.MatchString(resourceName.String()) {
+			return fmt.Errorf("resource %s does not name a MIG profile", resourceName)
+		}
+		if count, ok := quantity.AsInt64(); !ok || count <= 0 {
+			return fmt.Errorf("resource %s has malformed quantity %q, a positive whole number of slices is required", resourceName, quantity.String())
+		}
+	}
+	return nil
+}
+
+// extractGpuProfile returns the size of the profile and the NVML profile ids a slice of it is created with on
+// the GPU, resolved for the generation of the GPU
+func (*InstasliceReconciler) extractGpuProfile(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) (int32, int32, int32, int32) {
+	mig, ok := ResolveMigProfile(instaslice, gpuUUID, profileName)
+	if !ok || len(mig.Placements) == 0 {
+		return 0, 0, 0, 0
+	}
+	return mig.Placements[0].Size, mig.GIProfileID, mig.CIProfileID, mig.CIEngProfileID
+}
+
+// isRealizationWaitExceeded checks whether the slice of the allocation has waited longer than allowed to be realized
+func (r *InstasliceReconciler) isRealizationWaitExceeded(allocResult *inferencev1alpha1.AllocationResult) bool {
+	if r.Config == nil || r.Config.MaxRealizationWait <= 0 || allocResult.AllocatedAt == nil {
+		return false
+	}
+	return time.Since(allocResult.AllocatedAt.Time) > r.Config.MaxRealizationWait
+}
+
+// failedPodRetentionRemaining returns how long the slice of a failed pod should still be retained
+func (r *InstasliceReconciler) failedPodRetentionRemaining(pod *v1.Pod) time.Duration {
+	if r.Config == nil || r.Config.FailedPodRetention <= 0 {
+		return 0
+	}
+	failedAt := podFailedAt(pod)
+	if failedAt.IsZero() {
+		return 0
+	}
+	return r.Config.FailedPodRetention - time.Since(failedAt)
+}
+
+// allocationGraceRemaining returns how long allocation is deferred for a newly created pod
+func (r *InstasliceReconciler) allocationGraceRemaining(pod *v1.Pod) time.Duration {
+	if r.Config == nil || r.Config.AllocationGracePeriod <= 0 {
+		return 0
+	}
+	return time.Until(pod.CreationTimestamp.Add(r.Config.AllocationGracePeriod))
+}
+
+// giveUpTimeout returns how long allocation is retried for the pod, pods of a scheduler
+// with a configured timeout use it instead of the global one
+func (r *InstasliceReconciler) giveUpTimeout(pod *v1.Pod) time.Duration {
+	if r.Config == nil {
+		return 0
+	}
+	schedulerName := pod.Spec.SchedulerName
+	if schedulerName == "" {
+		schedulerName = v1.DefaultSchedulerName
+	}
+	if timeout, ok := r.Config.SchedulerGiveUpTimeouts[schedulerName]; ok {
+		return timeout
+	}
+	return r.Config.GiveUpTimeout
+}
+
+// allocationProfiles returns the profiles to try for the pod, the requested profile first followed by
+// the fallback profiles the pod allows once the requested profile timed out
+func (r *InstasliceReconciler) allocationProfiles(pod *v1.Pod, profileName string) []string {
+	profiles := []string{profileName}
+	if r.Config == nil {
+		return profiles
+	}
+	timeout, ok := r.Config.ProfileFallbackTimeouts[profileName]
+	if !ok || time.Since(pod.CreationTimestamp.Time) < timeout {
+		return profiles
+	}
+	for _, fallback := range strings.Split(pod.Annotations[FallbackProfilesAnnotation], ",") {
+		if fallback = strings.TrimSpace(fallback); fallback != "" && fallback != profileName {
+			profiles = append(profiles, fallback)
+		}
+	}
+	return profiles
+}
+
+// deletionTimeout returns how long the slices of a deleted pod are kept before they are released, the
+// annotation of the pod overrides the configured timeout up to the configured maximum
+func (r *InstasliceReconciler) deletionTimeout(pod *v1.Pod) time.Duration {
+	maxTimeout, defaultTimeout := config.DefaultMaxDeletionTimeout, config.DefaultGracefulDeletionTimeout
+	if r.Config != nil {
+		maxTimeout, defaultTimeout = r.Config.MaxDeletionTimeout, r.Config.GracefulDeletionTimeout
+	}
+	if value, ok := pod.Annotations[DeletionTimeoutAnnotation]; ok {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout >= 0 {
+			return min(timeout, maxTimeout)
 		}
 	}
-	return size, discoveredGiprofile, Ciprofileid, Ciengprofileid
+	return defaultTimeout
 }
 
-func checkIfPodGatedByInstaSlice(pod *v1.Pod) bool {
+// podFailedAt returns the time the last container of the pod terminated, falling back
+// to the transition time of the Ready condition when no container state is recorded.
+func podFailedAt(pod *v1.Pod) time.Time {
+	var failedAt time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.FinishedAt.After(failedAt) {
+			failedAt = status.State.Terminated.FinishedAt.Time
+		}
+	}
+	if !failedAt.IsZero() {
+		return failedAt
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady && condition.Status == v1.ConditionFalse {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return failedAt
+}
+
+// checkIfPodGatedByInstaSlice reports whether the pod still carries the InstaSlice gate. The gate is
+// authoritative, a freshly created pod may not have a phase or any status conditions yet.
+func checkIfPodGatedByInstaSlice(pod *v1.Pod, gateName string) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
 	for _, gate := range pod.Spec.SchedulingGates {
-		if gate.Name == GateName {
-			if pod.Status.Phase == v1.PodPending && strings.Contains(pod.Status.Conditions[0].Message, "blocked") {
-				return true
-			}
+		if gate.Name == gateName {
+			return true
 		}
 	}
 	return false
 }
 
 // isPodGatedByOthers looks for scheduling gates distinct from the InstaSlice gate
-func isPodGatedByOthers(pod *v1.Pod) bool {
+func isPodGatedByOthers(pod *v1.Pod, gateName string) bool {
 	for _, gate := range pod.Spec.SchedulingGates {
-		if gate.Name != GateName {
+		if gate.Name != gateName {
 			return true
 		}
 	}
 	return false
 }
 
-// podMapFunc maps pods to instaslice created allocations
+// configuredGateName returns the scheduling gate of the installation, the default gate when none is configured
+func configuredGateName(cfg *config.Config) string {
+	if cfg == nil || cfg.GateName == "" {
+		return GateName
+	}
+	return cfg.GateName
+}
+
+// configuredFinalizerName returns the pod finalizer of the installation, the default one when none is configured
+func configuredFinalizerName(cfg *config.Config) string {
+	if cfg == nil || cfg.FinalizerName == "" {
+		return FinalizerName
+	}
+	return cfg.FinalizerName
+}
+
+// gateName returns the scheduling gate the reconciler holds pods with
+func (r *InstasliceReconciler) gateName() string {
+	return configuredGateName(r.Config)
+}
+
+// finalizerName returns the finalizer the reconciler guards the slices of pods with
+func (r *InstasliceReconciler) finalizerName() string {
+	return configuredFinalizerName(r.Config)
+}
+
+// podMapFunc maps pods to instaslice created allocations, a pod holding several slices is mapped once
 func (r *InstasliceReconciler) podMapFunc(ctx context.Context, obj client.Object) []reconcile.Request {
 	var requests []reconcile.Request
 	instaslice, ok := obj.(*inferencev1alpha1.Instaslice)
 	if ok {
+		mapped := make(map[types.NamespacedName]bool)
 		for uuidAllocResult, allocationResult := range instaslice.Status.PodAllocationResults {
 			if allocationResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated || allocationResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
 				for uuidAllocRequest, allocationRequest := range instaslice.Spec.PodAllocationRequests {
-					if uuidAllocRequest == uuidAllocResult {
-						requests = append(requests, reconcile.Request{
-							NamespacedName: types.NamespacedName{
-								Namespace: allocationRequest.PodRef.Namespace,
-								Name:      allocationRequest.PodRef.Name,
-							},
-						})
+					podKey := types.NamespacedName{
+						Namespace: allocationRequest.PodRef.Namespace,
+						Name:      allocationRequest.PodRef.Name,
+					}
+					if uuidAllocRequest == uuidAllocResult && !mapped[podKey] {
+						mapped[podKey] = true
+						requests = append(requests, reconcile.Request{NamespacedName: podKey})
 					}
 				}
 			}
@@ -588,23 +1197,73 @@ func (r *InstasliceReconciler) podMapFunc(ctx context.Context, obj client.Object
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *InstasliceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	restConfig := mgr.GetConfig()
+	r.setupKubeClient(mgr.GetConfig())
 
-	var err error
-	r.kubeClient, err = kubernetes.NewForConfig(restConfig)
+	// periodically check the Instaslice objects once the manager is elected
+	err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		wait.UntilWithContext(ctx, func(ctx context.Context) {
+			if err := r.sweepInstaslices(ctx); err != nil {
+				logr.FromContext(ctx).Error(err, "error sweeping instaslice objects")
+			}
+		}, r.Config.SweepInterval)
+		return nil
+	}))
 	if err != nil {
 		return err
 	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &inferencev1alpha1.Instaslice{}, allocationPodUIDIndex, indexAllocationPodUIDs); err != nil {
+		return err
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1.Pod{}).Named("InstaSlice-controller").
+		For(&v1.Pod{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			pod, ok := obj.(*v1.Pod)
+			return !ok || !isTerminalPodWithoutFinalizer(pod, r.finalizerName())
+		}))).Named("InstaSlice-controller").
 		Watches(&inferencev1alpha1.Instaslice{}, handler.EnqueueRequestsFromMapFunc(r.podMapFunc)).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.quotaMapFunc), builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetName() == NamespaceQuotaConfigMapName && obj.GetNamespace() == r.Config.OperatorNamespace
+		}))).
 		Complete(r)
 }
 
+// isTerminalPodWithoutFinalizer reports a succeeded or failed pod that InstaSlice already released
+func isTerminalPodWithoutFinalizer(pod *v1.Pod, finalizerName string) bool {
+	return (pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed) &&
+		!controllerutil.ContainsFinalizer(pod, finalizerName)
+}
+
+// isTerminalPodCleanedUp checks that a terminal pod has neither the finalizer nor an allocation left
+func (r *InstasliceReconciler) isTerminalPodCleanedUp(ctx context.Context, podKey types.NamespacedName) bool {
+	pod := &v1.Pod{}
+	if err := r.Get(ctx, podKey, pod); err != nil || !isTerminalPodWithoutFinalizer(pod, r.finalizerName()) {
+		return false
+	}
+	podInstaslices, err := r.listPodInstaslices(ctx, pod.UID)
+	if err != nil {
+		return false
+	}
+	if hasPodAllocation(pod.UID, podInstaslices) {
+		return false
+	}
+	return len(podSlices(pod.UID, podInstaslices)) == 0
+}
+
+// setupKubeClient builds the typed kubernetes client. The controller relies on the controller-runtime
+// client for its work, so a failure is logged and the setup continues without the typed client.
+func (r *InstasliceReconciler) setupKubeClient(restConfig *rest.Config) {
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		ctrl.Log.WithName("setup").Error(err, "unable to create the typed kubernetes client, continuing with the controller-runtime client",
+			"host", restConfig.Host)
+		return
+	}
+	r.kubeClient = kubeClient
+}
+
 func (r *InstasliceReconciler) unGatePod(podUpdate *v1.Pod) *v1.Pod {
 	for i, gate := range podUpdate.Spec.SchedulingGates {
-		if gate.Name == GateName {
+		if gate.Name == r.gateName() {
 			podUpdate.Spec.SchedulingGates = append(podUpdate.Spec.SchedulingGates[:i], podUpdate.Spec.SchedulingGates[i+1:]...)
 		}
 	}
@@ -619,7 +1278,7 @@ func (r *InstasliceReconciler) removeInstaSliceFinalizer(ctx context.Context, re
 		log.Error(err, "error getting latest copy of pod")
 		return ctrl.Result{Requeue: true}, err
 	}
-	ok := controllerutil.RemoveFinalizer(latestPod, FinalizerName)
+	ok := controllerutil.RemoveFinalizer(latestPod, r.finalizerName())
 	if !ok {
 		log.Info("finalizer not deleted for ", "pod", latestPod.Name)
 		return ctrl.Result{Requeue: true}, err
@@ -636,7 +1295,8 @@ func (r *FirstFitPolicy) SetAllocationDetails(profileName string, newStart, size
 	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
 	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
 	return &inferencev1alpha1.AllocationRequest{
-			Profile: profileName,
+			Profile:        profileName,
+			CIEngProfileID: Ciengprofileid,
 			Resources: v1.ResourceRequirements{
 				Requests: map[v1.ResourceName]resource.Quantity{
 					v1.ResourceCPU:    *availableResourceList.Cpu(),
@@ -662,50 +1322,202 @@ func (r *FirstFitPolicy) SetAllocationDetails(profileName string, newStart, size
 		}
 }
 
-// Policy based allocation - LeftToRIght
+// Policy based allocation - LeftToRight, the lowest free start FirstFit picks is the left-most one
 func (l *LeftToRightPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
 	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
-	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) *inferencev1alpha1.AllocationRequest {
-	// Implement the left-to-right policy here
-	return &inferencev1alpha1.AllocationRequest{}
+	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
+	return (&FirstFitPolicy{}).SetAllocationDetails(profileName, newStart, size, podUUID, nodename, allocationStatus, discoveredGiprofile,
+		Ciprofileid, Ciengprofileid, namespace, podName, gpuUuid, resourceIdentifier, availableResourceList)
 }
 
-// Policy based allocation - RigghToLeft
+// Policy based allocation - RightToLeft, the policy only differs from FirstFit in the start it picks,
+// see startIndexForPolicy
 func (l *RightToLeftPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
 	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
-	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) *inferencev1alpha1.AllocationRequest {
-	// Implement the left-to-right policy here
-	return &inferencev1alpha1.AllocationRequest{}
+	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
+	return (&FirstFitPolicy{}).SetAllocationDetails(profileName, newStart, size, podUUID, nodename, allocationStatus, discoveredGiprofile,
+		Ciprofileid, Ciengprofileid, namespace, podName, gpuUuid, resourceIdentifier, availableResourceList)
+}
+
+// Policy based allocation - BestFit, the policy only differs from FirstFit in the GPU and start it
+// picks, see startIndexForPolicy
+func (b *BestFitPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
+	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
+	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
+	return (&FirstFitPolicy{}).SetAllocationDetails(profileName, newStart, size, podUUID, nodename, allocationStatus, discoveredGiprofile,
+		Ciprofileid, Ciengprofileid, namespace, podName, gpuUuid, resourceIdentifier, availableResourceList)
+}
+
+// Policy based allocation - WorstFit, the policy only differs from FirstFit in the node, GPU and start it
+// picks, see startIndexForPolicy
+func (w *WorstFitPolicy) SetAllocationDetails(profileName string, newStart, size int32, podUUID types.UID, nodename types.NodeName,
+	allocationStatus inferencev1alpha1.AllocationStatus, discoveredGiprofile int32, Ciprofileid int32, Ciengprofileid int32,
+	namespace string, podName string, gpuUuid string, resourceIdentifier types.UID, availableResourceList v1.ResourceList) (*inferencev1alpha1.AllocationRequest, *inferencev1alpha1.AllocationResult) {
+	return (&FirstFitPolicy{}).SetAllocationDetails(profileName, newStart, size, podUUID, nodename, allocationStatus, discoveredGiprofile,
+		Ciprofileid, Ciengprofileid, namespace, podName, gpuUuid, resourceIdentifier, availableResourceList)
 }
 
 func (r *InstasliceReconciler) removeInstasliceAllocation(ctx context.Context, instasliceName string, allocation *inferencev1alpha1.AllocationResult) error {
 	if allocation.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-		err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instasliceName, nil, nil)
+		err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, instasliceName, nil, nil)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// hasPodAllocation checks if any Instaslice object holds an allocation for a slice of the pod
+func hasPodAllocation(podUID types.UID, instaslices []inferencev1alpha1.Instaslice) bool {
+	for _, instaslice := range instaslices {
+		for key := range instaslice.Spec.PodAllocationRequests {
+			if utils.IsSliceOfPod(key, podUID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podSlice is the allocation of one of the slices held by a pod
+type podSlice struct {
+	instasliceName string
+	request        inferencev1alpha1.AllocationRequest
+	result         inferencev1alpha1.AllocationResult
+}
+
+// podSlices returns the allocation results of the slices of the pod ordered by slice index
+func podSlices(podUID types.UID, instaslices []inferencev1alpha1.Instaslice) []podSlice {
+	var found []podSlice
+	for _, instaslice := range instaslices {
+		for key, allocResult := range instaslice.Status.PodAllocationResults {
+			if utils.IsSliceOfPod(key, podUID) {
+				found = append(found, podSlice{
+					instasliceName: instaslice.Name,
+					request:        instaslice.Spec.PodAllocationRequests[key],
+					result:         allocResult,
+				})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].request.SliceIndex < found[j].request.SliceIndex
+	})
+	return found
+}
+
+// allSlicesCreated reports whether the daemonset created every slice
+func allSlicesCreated(heldSlices []podSlice) bool {
+	for _, slice := range heldSlices {
+		if slice.result.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusCreated {
+			return false
+		}
+	}
+	return true
+}
+
+// allSlicesDeleted reports whether the daemonset deleted every slice
+func allSlicesDeleted(heldSlices []podSlice) bool {
+	for _, slice := range heldSlices {
+		if slice.result.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedSliceCount returns how many slices of the profile the container limits ask for
+func requestedSliceCount(limits v1.ResourceList, profileName string) int32 {
+	quantity, ok := limits[v1.ResourceName(OrgInstaslicePrefix+"mig-"+profileName)]
+	if !ok {
+		return 1
+	}
+	if count, ok := quantity.AsInt64(); ok && count > 1 {
+		return int32(count)
+	}
+	return 1
+}
+
+// releasePodAllocations releases the allocations of a pod that is no longer guarded by the finalizer,
+// the pod is matched by name as its UID is unknown once it is gone. Allocations are set to deleting
+// and removed once the daemonset reports them deleted.
+func (r *InstasliceReconciler) releasePodAllocations(ctx context.Context, podKey types.NamespacedName, podUID types.UID, instaslices []inferencev1alpha1.Instaslice) (ctrl.Result, error) {
+	for _, instaslice := range instaslices {
+		for uuid, allocRequest := range instaslice.Spec.PodAllocationRequests {
+			if allocRequest.PodRef.Namespace != podKey.Namespace || allocRequest.PodRef.Name != podKey.Name {
+				continue
+			}
+			if podUID != "" && !utils.IsSliceOfPod(uuid, podUID) {
+				continue
+			}
+			// the pod is gone or going, the nodes that did not create its slices in time are not tried again
+			r.creationTimeouts.forget(allocRequest.PodRef.UID)
+			allocResult, ok := instaslice.Status.PodAllocationResults[uuid]
+			if !ok {
+				continue
+			}
+			logr.FromContext(ctx).WithName(LogSubsystemDeletion).Info("releasing allocation of pod without finalizer", "pod", podKey.Name, "instaslice", instaslice.Name)
+			if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				if err := r.removeInstasliceAllocation(ctx, instaslice.Name, &allocResult); err != nil {
+					return ctrl.Result{}, err
+				}
+				continue
+			}
+			if allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusDeleting {
+				if result, err := r.setInstasliceAllocationToDeleting(ctx, instaslice.Name, &allocResult, &allocRequest); err != nil {
+					return teardownPendingResult(result, err)
+				}
+			}
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
 func (r *InstasliceReconciler) setInstasliceAllocationToDeleting(ctx context.Context, instasliceName string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) (ctrl.Result, error) {
-	log := logr.FromContext(ctx)
+	log := logr.FromContext(ctx).WithName(LogSubsystemDeletion)
+	released := allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusDeleting
+	// the workload may flush or checkpoint its state before the slice is torn down
+	if !r.awaitTeardownApproval(ctx, instasliceName, allocResult, allocRequest) {
+		return ctrl.Result{RequeueAfter: teardownRetryDelay}, errTeardownPending
+	}
+	log.V(1).Info("setting allocation to deleting", "pod", allocRequest.PodRef.Name, "instaslice", instasliceName, "gpu", allocResult.GPUUUID)
 	allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
-	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instasliceName, allocResult, allocRequest); err != nil {
+	if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, instasliceName, allocResult, allocRequest); err != nil {
 		log.Info("unable to set instaslice to state ", "state", allocResult.AllocationStatus.AllocationStatusController, "pod", allocRequest.PodRef.Name)
 		return ctrl.Result{Requeue: true}, err
 	}
+	if released {
+		r.Accounting.Emit(newAccountingRecord(AccountingEventRelease, allocRequest, allocResult))
+	}
 
 	return ctrl.Result{}, nil
 }
 
+// isAllocationRealized checks that the daemonset published the ConfigMap exposing the slice to the pod
+func (r *InstasliceReconciler) isAllocationRealized(ctx context.Context, allocResult *inferencev1alpha1.AllocationResult, namespace string) (bool, error) {
+	configMap := &v1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: string(allocResult.ConfigMapResourceIdentifier), Namespace: namespace}, configMap)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (r *InstasliceReconciler) addNodeSelectorAndUngatePod(ctx context.Context, pod *v1.Pod, allocResult *inferencev1alpha1.AllocationResult) (ctrl.Result, error) {
+	ctx, span := startSpan(ctx, spanUngate, attribute.String("node", string(allocResult.Nodename)))
 	if pod.Spec.NodeSelector == nil {
 		pod.Spec.NodeSelector = make(map[string]string)
 	}
 	pod.Spec.NodeSelector[NodeLabel] = string(allocResult.Nodename)
+	// the status of the allocation is only of interest while the pod is gated
+	delete(pod.Annotations, AllocationStatusAnnotation)
 
 	ungatedPod := r.unGatePod(pod)
 	err := r.Update(ctx, ungatedPod)
+	endSpan(span, err)
 	if err != nil {
 		logr.FromContext(ctx).Error(err, "error ungating pod")
 		return ctrl.Result{Requeue: true}, err
@@ -714,6 +1526,179 @@ func (r *InstasliceReconciler) addNodeSelectorAndUngatePod(ctx context.Context,
 	return ctrl.Result{}, nil
 }
 
+// recordOwnerEvent surfaces an event on the workload owning the pod so users see it at the
+// Deployment or Job level, pods owned by a ReplicaSet report to the owning Deployment.
+func (r *InstasliceReconciler) recordOwnerEvent(ctx context.Context, pod *v1.Pod, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return
+	}
+	namespace := pod.Namespace
+	if owner.Kind == "ReplicaSet" {
+		replicaSet := &appsv1.ReplicaSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: namespace}, replicaSet); err == nil {
+			if deployment := metav1.GetControllerOf(replicaSet); deployment != nil {
+				owner = deployment
+			}
+		}
+	}
+	ref := &v1.ObjectReference{
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		Name:       owner.Name,
+		Namespace:  namespace,
+		UID:        owner.UID,
+	}
+	r.Recorder.Event(ref, eventType, reason, message)
+}
+
+// allocationDecision is the compact record of an allocation decision kept on the pod for audit tooling
+type allocationDecision struct {
+	Node       string   `json:"node"`
+	GPU        string   `json:"gpu"`
+	Policy     string   `json:"policy"`
+	Candidates []string `json:"candidates"`
+	// Omitted counts the candidates left out to keep the annotation bounded
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// annotateAllocationDecision records the nodes considered and the chosen node, GPU and policy on the pod
+func (r *InstasliceReconciler) annotateAllocationDecision(ctx context.Context, pod *v1.Pod, allocResult *inferencev1alpha1.AllocationResult, candidates []string) error {
+	decision := allocationDecision{
+		Node:       string(allocResult.Nodename),
+		GPU:        allocResult.GPUUUID,
+		Policy:     allocResult.Policy,
+		Candidates: candidates,
+	}
+	if len(candidates) > maxDecisionCandidates {
+		decision.Candidates = candidates[:maxDecisionCandidates]
+		decision.Omitted = len(candidates) - maxDecisionCandidates
+	}
+	record, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+	original := pod.DeepCopy()
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[AllocationDecisionAnnotation] = string(record)
+	// the pod no longer waits in line
+	delete(pod.Annotations, QueuePositionAnnotation)
+	return r.Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// allocationStatusRecord is the state of the allocation of a gated pod, kept on the pod for users who cannot read
+// the Instaslice objects
+type allocationStatusRecord struct {
+	Status string `json:"status"`
+	Node   string `json:"node"`
+	GPU    string `json:"gpu"`
+}
+
+// annotateAllocationStatus records the status, node and GPU of the allocation on the pod, an unchanged record
+// is not written again
+func (r *InstasliceReconciler) annotateAllocationStatus(ctx context.Context, pod *v1.Pod, status string, allocResult *inferencev1alpha1.AllocationResult) error {
+	record, err := json.Marshal(allocationStatusRecord{
+		Status: status,
+		Node:   string(allocResult.Nodename),
+		GPU:    allocResult.GPUUUID,
+	})
+	if err != nil {
+		return err
+	}
+	if pod.Annotations[AllocationStatusAnnotation] == string(record) {
+		return nil
+	}
+	original := pod.DeepCopy()
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[AllocationStatusAnnotation] = string(record)
+	return r.Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// updateQueuePosition records the approximate position of the pod among the gated pods that wait
+// for a slice of the same profile, the pods are served oldest first.
+func (r *InstasliceReconciler) updateQueuePosition(ctx context.Context, pod *v1.Pod, profileName string, instaslices []inferencev1alpha1.Instaslice) error {
+	var podList v1.PodList
+	if err := r.List(ctx, &podList); err != nil {
+		return err
+	}
+	position := 1
+	for i := range podList.Items {
+		other := &podList.Items[i]
+		if other.UID == pod.UID || !other.DeletionTimestamp.IsZero() ||
+			!checkIfPodGatedByInstaSlice(other, r.gateName()) || hasPodAllocation(other.UID, instaslices) {
+			continue
+		}
+		container, err := gpuContainer(other.Spec.Containers)
+		if err != nil || podProfileName(other, container) != profileName {
+			continue
+		}
+		if other.CreationTimestamp.Before(&pod.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&pod.CreationTimestamp) && other.Name < pod.Name) {
+			position++
+		}
+	}
+	value := strconv.Itoa(position)
+	if pod.Annotations[QueuePositionAnnotation] == value {
+		return nil
+	}
+	original := pod.DeepCopy()
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[QueuePositionAnnotation] = value
+	return r.Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// setScaleUpHint marks the pod unschedulable, the condition the cluster autoscaler looks for when it
+// decides to provision a node for pending pods
+func (r *InstasliceReconciler) setScaleUpHint(ctx context.Context, pod *v1.Pod, profileName string) error {
+	message := fmt.Sprintf("no GPU node has InstaSlice capacity for profile %s", profileName)
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse &&
+			condition.Reason == v1.PodReasonUnschedulable && condition.Message == message {
+			return nil
+		}
+	}
+	original := pod.DeepCopy()
+	condition := v1.PodCondition{
+		Type:               v1.PodScheduled,
+		Status:             v1.ConditionFalse,
+		Reason:             v1.PodReasonUnschedulable,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+	replaced := false
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == v1.PodScheduled {
+			pod.Status.Conditions[i] = condition
+			replaced = true
+		}
+	}
+	if !replaced {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	}
+	return r.Status().Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// isAllocationFrozen reports whether ops froze all new allocations through the freeze ConfigMap
+func (r *InstasliceReconciler) isAllocationFrozen(ctx context.Context) (bool, error) {
+	configMap := &v1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: AllocationFreezeConfigMapName, Namespace: r.Config.OperatorNamespace}, configMap); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.EqualFold(configMap.Data["frozen"], "true"), nil
+}
+
 // TODO move this to utils and refer to common function
 func (r *InstasliceReconciler) getInstasliceObject(ctx context.Context, instasliceName string, namespace string) (*inferencev1alpha1.Instaslice, error) {
 	log := logr.FromContext(ctx)
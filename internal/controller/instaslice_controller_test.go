@@ -18,6 +18,9 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -31,6 +34,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -63,6 +68,7 @@ func TestChangesAllocationDeletionAndFinalizer(t *testing.T) {
 			fakeClient = fake.NewClientBuilder().
 				WithScheme(scheme).
 				WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+				WithIndex(&inferencev1alpha1.Instaslice{}, allocationPodUIDIndex, indexAllocationPodUIDs).
 				Build()
 			config := config.ConfigFromEnvironment()
 
@@ -136,7 +142,7 @@ func TestChangesAllocationDeletionAndFinalizer(t *testing.T) {
 
 			allocationResult := instaslice.Status.PodAllocationResults[pod.GetUID()]
 			allocationRequest := instaslice.Spec.PodAllocationRequests[pod.GetUID()]
-			err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, instaslice.Name, &allocationResult, &allocationRequest)
+			err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, instaslice.Name, &allocationResult, &allocationRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			updatedInstaSlice := &inferencev1alpha1.Instaslice{}
@@ -217,7 +223,9 @@ func TestInstasliceDaemonsetCreation_Reconcile(t *testing.T) {
 			Expect(v1.AddToScheme(scheme)).To(Succeed())
 			Expect(appsv1.AddToScheme(scheme)).To(Succeed()) // Ensure DaemonSet is registered
 
-			fakeClient = fake.NewClientBuilder().WithScheme(scheme).Build()
+			fakeClient = fake.NewClientBuilder().WithScheme(scheme).
+				WithIndex(&inferencev1alpha1.Instaslice{}, allocationPodUIDIndex, indexAllocationPodUIDs).
+				Build()
 
 			config := config.ConfigFromEnvironment()
 			r = &InstasliceReconciler{
@@ -364,6 +372,7 @@ func TestInstasliceReconciler_Reconcile(t *testing.T) {
 			fakeClient = fake.NewClientBuilder().
 				WithScheme(scheme).
 				WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+				WithIndex(&inferencev1alpha1.Instaslice{}, allocationPodUIDIndex, indexAllocationPodUIDs).
 				Build()
 
 			config := config.ConfigFromEnvironment()
@@ -600,7 +609,7 @@ func TestInstasliceReconciler_Reconcile(t *testing.T) {
 			Expect(newPod.Finalizers).ToNot(ContainElement(FinalizerName))
 		})
 
-		It("should return from reconcile when more than 1 container is present in a pod", func() {
+		It("should return from reconcile without an error when more than 1 container is present in a pod", func() {
 			// Define a pod with more than a container
 			pod = &v1.Pod{
 				ObjectMeta: metav1.ObjectMeta{
@@ -623,8 +632,7 @@ func TestInstasliceReconciler_Reconcile(t *testing.T) {
 			// reconcile request over the pod name
 			req.Name = pod.Name
 			result, err := r.Reconcile(ctx, req)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring(multipleContainersUnsupportedErr))
+			Expect(err).NotTo(HaveOccurred())
 			Expect(result).To(Equal(ctrl.Result{}))
 		})
 
@@ -744,6 +752,11 @@ func TestInstasliceReconciler_Reconcile(t *testing.T) {
 			}
 
 			Expect(fakeClient.Update(ctx, currentSlice)).To(Succeed())
+			// the daemonset publishes the configmap of the slice before reporting it created
+			Expect(fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      "fake-configmap-uid",
+				Namespace: InstaSliceOperatorNamespace,
+			}})).To(Succeed())
 			req.Name = pod.Name
 			result, err := r.Reconcile(ctx, req)
 			Expect(err).ToNot(HaveOccurred())
@@ -880,7 +893,7 @@ func TestInstasliceReconciler_extractGpuProfile(t *testing.T) {
 				kubeClient: tt.fields.kubeClient,
 				Config:     config,
 			}
-			got, got1, got2, got3 := in.extractGpuProfile(tt.args.instaslice, tt.args.profileName)
+			got, got1, got2, got3 := in.extractGpuProfile(tt.args.instaslice, "", tt.args.profileName)
 			assert.Equalf(t, tt.want, got, "extractGpuProfile(%v, %v)", tt.args.instaslice, tt.args.profileName)
 			assert.Equalf(t, tt.want1, got1, "extractGpuProfile(%v, %v)", tt.args.instaslice, tt.args.profileName)
 			assert.Equalf(t, tt.want2, got2, "extractGpuProfile(%v, %v)", tt.args.instaslice, tt.args.profileName)
@@ -889,6 +902,57 @@ func TestInstasliceReconciler_extractGpuProfile(t *testing.T) {
 	}
 }
 
+func TestInstasliceReconciler_extractProfileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   v1.ResourceList
+		profiles []string
+		profile  string
+		wantErr  bool
+	}{
+		{
+			name:   "no MIG resource",
+			limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+		},
+		{
+			name:     "one MIG resource",
+			limits:   v1.ResourceList{v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb"): resource.MustParse("1")},
+			profiles: []string{"1g.5gb"},
+			profile:  "1g.5gb",
+		},
+		{
+			name: "one profile under two prefixes",
+			limits: v1.ResourceList{
+				v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb"): resource.MustParse("1"),
+				v1.ResourceName(NvidiaMIGPrefix + "1g.5gb"):         resource.MustParse("1"),
+			},
+			profiles: []string{"1g.5gb"},
+			profile:  "1g.5gb",
+		},
+		{
+			name: "two distinct profiles",
+			limits: v1.ResourceList{
+				v1.ResourceName(OrgInstaslicePrefix + "mig-3g.20gb"): resource.MustParse("1"),
+				v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb"):  resource.MustParse("1"),
+			},
+			profiles: []string{"1g.5gb", "3g.20gb"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.profiles, extractProfileNames(tt.limits))
+			profile, err := extractProfileName(tt.limits)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "1g.5gb, 3g.20gb")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.profile, profile)
+		})
+	}
+}
+
 func TestInstasliceReconciler_podMapFunc(t *testing.T) {
 	type fields struct {
 		Client     client.Client
@@ -1042,6 +1106,36 @@ func TestFirstFitPolicy_SetAllocationDetails(t *testing.T) {
 	}
 }
 
+func TestPolicyFromName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    AllocationPolicy
+		wantErr bool
+	}{
+		{name: "", want: &FirstFitPolicy{}},
+		{name: FirstFitPolicyName, want: &FirstFitPolicy{}},
+		{name: LeftToRightPolicyName, want: &LeftToRightPolicy{}},
+		{name: RightToLeftPolicyName, want: &RightToLeftPolicy{}},
+		{name: BestFitPolicyName, want: &BestFitPolicy{}},
+		{name: WorstFitPolicyName, want: &WorstFitPolicy{}},
+		{name: "round-robin", wantErr: true},
+	}
+	for _, tt := range tests {
+		policy, err := PolicyFromName(tt.name)
+		if tt.wantErr {
+			assert.ErrorContains(t, err, "unknown allocation policy", tt.name)
+			continue
+		}
+		if assert.NoError(t, err, tt.name) {
+			assert.IsType(t, tt.want, policy, tt.name)
+			// the reconciler allocates with the policy it was constructed with
+			r := &InstasliceReconciler{Policy: policy}
+			assert.Same(t, policy, r.activePolicy(), tt.name)
+		}
+	}
+	assert.IsType(t, &FirstFitPolicy{}, (&InstasliceReconciler{}).activePolicy())
+}
+
 func TestGPUSort(t *testing.T) {
 	Describe("SortGPUs", func() {
 		It("should sort GPU UUIDs in ascending order", func() {
@@ -1064,3 +1158,1305 @@ func TestGPUSort(t *testing.T) {
 		})
 	})
 }
+
+// newTestReconciler returns a reconciler backed by a fake client which already has a
+// ready InstaSlice daemonset, so that Reconcile proceeds straight to pod handling.
+func newTestReconciler(t testing.TB, objs ...client.Object) (*InstasliceReconciler, client.Client) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, inferencev1alpha1.AddToScheme(scheme))
+	assert.NoError(t, v1.AddToScheme(scheme))
+	assert.NoError(t, appsv1.AddToScheme(scheme))
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      InstasliceDaemonsetName,
+			Namespace: InstaSliceOperatorNamespace,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: daemonSetlabel},
+		},
+		Status: appsv1.DaemonSetStatus{NumberReady: 1},
+	}
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		WithIndex(&inferencev1alpha1.Instaslice{}, allocationPodUIDIndex, indexAllocationPodUIDs).
+		WithObjects(append([]client.Object{daemonSet}, objs...)...).
+		Build()
+
+	return &InstasliceReconciler{
+		Client: fakeClient,
+		Scheme: scheme,
+		Config: config.NewConfig(),
+	}, fakeClient
+}
+
+// newTestAllocation returns an Instaslice named after the node holding a single allocation for the pod
+func newTestAllocation(nodeName string, pod *v1.Pod, status inferencev1alpha1.AllocationStatus) *inferencev1alpha1.Instaslice {
+	instaslice := utils.GenerateFakeCapacity(nodeName)
+	instaslice.Spec.PodAllocationRequests[pod.UID] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef: v1.ObjectReference{
+			Kind:      "Pod",
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			UID:       pod.UID,
+		},
+	}
+	instaslice.Status.PodAllocationResults[pod.UID] = inferencev1alpha1.AllocationResult{
+		MigPlacement:                inferencev1alpha1.Placement{Start: 0, Size: 1},
+		GPUUUID:                     instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID,
+		Nodename:                    types.NodeName(nodeName),
+		AllocationStatus:            status,
+		ConfigMapResourceIdentifier: types.UID(pod.UID + "-cm"),
+	}
+	return instaslice
+}
+
+func TestReconcile_FailedPodRetention(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "failed-pod",
+			Namespace:  InstaSliceOperatorNamespace,
+			UID:        "failed-pod-uid",
+			Finalizers: []string{FinalizerName},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodFailed,
+			ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: metav1.Now()}},
+			}},
+		},
+	}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+	r.Config.FailedPodRetention = time.Minute
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}}
+
+	// the slice is retained while the failed pod is within the retention window
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Greater(t, result.RequeueAfter, time.Duration(0))
+	assert.LessOrEqual(t, result.RequeueAfter, time.Minute)
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: InstaSliceOperatorNamespace}, current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+
+	// once the retention has elapsed the allocation is released
+	pod.Status.ContainerStatuses[0].State.Terminated.FinishedAt = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	assert.NoError(t, fakeClient.Status().Update(ctx, pod))
+	result, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: InstaSliceOperatorNamespace}, current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+}
+
+func TestReconcile_FailedPodKeepsNodeSelector(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "failed-pod",
+			Namespace:  InstaSliceOperatorNamespace,
+			UID:        "failed-pod-uid",
+			Finalizers: []string{FinalizerName},
+		},
+		Spec:   v1.PodSpec{NodeSelector: map[string]string{NodeLabel: "node-1", "zone": "a"}},
+		Status: v1.PodStatus{Phase: v1.PodFailed},
+	}
+	r, fakeClient := newTestReconciler(t, pod)
+
+	// the slice was already released, the teardown only removes the finalizer and leaves the immutable
+	// node selector of the scheduled pod alone
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+	current := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), current))
+	assert.NotContains(t, current.Finalizers, FinalizerName)
+	assert.Equal(t, map[string]string{NodeLabel: "node-1", "zone": "a"}, current.Spec.NodeSelector)
+}
+
+func TestSetupKubeClient(t *testing.T) {
+	r := &InstasliceReconciler{}
+	// a QPS without burst or rate limiter is rejected by the typed client constructor
+	r.setupKubeClient(&rest.Config{Host: "https://127.0.0.1:6443", QPS: 5, Burst: 0})
+	assert.Nil(t, r.kubeClient)
+
+	r.setupKubeClient(&rest.Config{Host: "https://127.0.0.1:6443"})
+	assert.NotNil(t, r.kubeClient)
+}
+
+func TestReconcile_ForeignSchedulingGates(t *testing.T) {
+	ctx := context.TODO()
+	foreignGate := v1.PodSchedulingGate{Name: "example.com/quota"}
+
+	t.Run("pods gated by others are ignored by default", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, foreignGate)
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		assert.Equal(t, ctrl.Result{}, result)
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		assert.Empty(t, current.Spec.PodAllocationRequests)
+	})
+
+	t.Run("pods gated by others are allocated but not ungated when configured", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, foreignGate)
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		r.Config.AllocateWithForeignGates = true
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+		_, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		assert.Contains(t, current.Spec.PodAllocationRequests, pod.UID)
+
+		// the daemonset realizes the slice, the pod must keep the InstaSlice gate while the foreign gate remains
+		allocResult := current.Status.PodAllocationResults[pod.UID]
+		allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+		current.Status.PodAllocationResults[pod.UID] = allocResult
+		assert.NoError(t, fakeClient.Status().Update(ctx, current))
+		_, err = r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+		assert.Contains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+	})
+}
+
+func TestReconcile_CustomGateName(t *testing.T) {
+	ctx := context.TODO()
+	customGate := "example.com/staging-accelerator"
+	customFinalizer := "example.com/staging-finalizer"
+
+	t.Run("pods carrying the default gate are left to the other installation", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		r.Config.GateName = customGate
+		r.Config.FinalizerName = customFinalizer
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		assert.Equal(t, ctrl.Result{}, result)
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		assert.Empty(t, current.Spec.PodAllocationRequests)
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+		assert.Empty(t, updatedPod.Finalizers)
+	})
+
+	t.Run("pods carrying the configured gate are allocated with the configured finalizer", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: customGate}}
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		r.Config.GateName = customGate
+		r.Config.FinalizerName = customFinalizer
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+		}
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		assert.Contains(t, current.Spec.PodAllocationRequests, pod.UID)
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+		assert.Equal(t, []string{customFinalizer}, updatedPod.Finalizers)
+	})
+}
+
+func TestReconcile_UngateRequiresRealizedSlice(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	// the allocation claims to be created but the slice configmap does not exist
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, Requeue2sDelay, result.RequeueAfter)
+	updatedPod := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+	assert.Contains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+
+	// once the slice is realized the pod is ungated
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      string(allocResult.ConfigMapResourceIdentifier),
+		Namespace: pod.Namespace,
+	}}))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+	assert.NotContains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+	assert.Equal(t, "node-1", updatedPod.Spec.NodeSelector[NodeLabel])
+}
+
+func TestReconcile_MaxRealizationWait(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	allocatedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+	allocResult.AllocatedAt = &allocatedAt
+	instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+	r.Config.MaxRealizationWait = time.Minute
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	// the slice was never realized within the wait, the allocation is abandoned
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "AllocationAbandoned")
+	}
+
+	// once the daemonset cleaned up the abandoned allocation, the pod is allocated again
+	abandoned := current.Status.PodAllocationResults[pod.UID]
+	abandoned.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	current.Status.PodAllocationResults[pod.UID] = abandoned
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.NotContains(t, current.Status.PodAllocationResults, pod.UID)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	if assert.Contains(t, current.Status.PodAllocationResults, pod.UID) {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+	}
+	updatedPod := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+	assert.Contains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+}
+
+func TestReconcile_StaleNodePin(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("pinned node is preferred when it has capacity", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		pod.Spec.NodeSelector = map[string]string{NodeLabel: "node-2"}
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"), utils.GenerateFakeCapacity("node-2"))
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-2", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+		assert.Equal(t, "node-2", updatedPod.Spec.NodeSelector[NodeLabel])
+	})
+
+	t.Run("stale pin is replaced when the pod is ungated on another node", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		pod.Spec.NodeSelector = map[string]string{NodeLabel: "node-2"}
+		// the pinned node can no longer host the profile
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "node-2",
+			Annotations: map[string]string{ForbiddenProfilesAnnotation: "1g.5gb"},
+		}}
+		r, fakeClient := newTestReconciler(t, pod, node, utils.GenerateFakeCapacity("node-1"), utils.GenerateFakeCapacity("node-2"))
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+		// the node selector of a gated pod cannot be removed, it is left alone until the pod is ungated
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+		assert.Equal(t, "node-2", updatedPod.Spec.NodeSelector[NodeLabel])
+		assert.Contains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+
+		// once the slice is realized the pod is ungated with the selector pointing at its new node
+		allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+		allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+		instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+		assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+		assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      string(allocResult.ConfigMapResourceIdentifier),
+			Namespace: pod.Namespace,
+		}}))
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+		assert.NotContains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+		assert.Equal(t, "node-1", updatedPod.Spec.NodeSelector[NodeLabel])
+	})
+}
+
+func TestReconcile_AllocationGracePeriod(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("allocation is deferred within the grace window", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		pod.CreationTimestamp = metav1.Now()
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		r.Config.AllocationGracePeriod = time.Minute
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		assert.Greater(t, result.RequeueAfter, time.Duration(0))
+		assert.LessOrEqual(t, result.RequeueAfter, time.Minute)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.NotContains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+	})
+
+	t.Run("allocation proceeds once the grace window elapsed", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		r.Config.AllocationGracePeriod = time.Minute
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+	})
+}
+
+func TestReconcile_CapacityUnavailableEventOnOwner(t *testing.T) {
+	ctx := context.TODO()
+	isController := true
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "vllm", Namespace: "default", UID: "deployment-uid"},
+	}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      "vllm-5d4f8",
+		Namespace: "default",
+		UID:       "replicaset-uid",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &isController,
+		}},
+	}}
+	pod := newTestGatedPod("vllm-5d4f8-abcde", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	pod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "ReplicaSet", Name: replicaSet.Name, UID: replicaSet.UID, Controller: &isController,
+	}}
+	// the only node cannot host the profile
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Annotations: map[string]string{ForbiddenProfilesAnnotation: "1g.5gb"},
+	}}
+	r, _ := newTestReconciler(t, pod, replicaSet, deployment, node, utils.GenerateFakeCapacity("node-1"))
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	r.Recorder = recorder
+
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.Greater(t, result.RequeueAfter, time.Duration(0))
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "Warning CapacityUnavailable")
+		assert.Contains(t, event, "kind=Deployment")
+	default:
+		t.Fatal("expected an event on the owning deployment")
+	}
+}
+
+func TestReconcile_NoCapacityEvent(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name       string
+		instaslice func() *inferencev1alpha1.Instaslice
+		wantReason string
+	}{
+		{
+			name: "known profile with all placements occupied",
+			instaslice: func() *inferencev1alpha1.Instaslice {
+				instaslice := utils.GenerateFakeCapacity("node-1")
+				for i, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+					podUID := types.UID(fmt.Sprintf("full-%d", i))
+					instaslice.Spec.PodAllocationRequests[podUID] = inferencev1alpha1.AllocationRequest{Profile: "7g.40gb"}
+					instaslice.Status.PodAllocationResults[podUID] = inferencev1alpha1.AllocationResult{
+						MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 8},
+						GPUUUID:      gpu.GPUUUID,
+					}
+				}
+				return instaslice
+			},
+			wantReason: "Warning CapacityUnavailable",
+		},
+		{
+			name: "profile not offered by any GPU",
+			instaslice: func() *inferencev1alpha1.Instaslice {
+				instaslice := utils.GenerateFakeCapacity("node-1")
+				delete(instaslice.Status.NodeResources.MigPlacement, "1g.5gb")
+				return instaslice
+			},
+			wantReason: "Warning ProfileUnknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isController := true
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "batch/v1", Kind: "Job", Name: "job-1", UID: "job-uid", Controller: &isController,
+			}}
+			r, _ := newTestReconciler(t, pod, tt.instaslice())
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Greater(t, result.RequeueAfter, time.Duration(0))
+			select {
+			case event := <-recorder.Events:
+				assert.Contains(t, event, tt.wantReason)
+			default:
+				t.Fatal("expected an event on the owning job")
+			}
+		})
+	}
+}
+
+func TestReconcile_ScaleUpHints(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name         string
+		scaleUpHints bool
+	}{
+		{name: "hints enabled", scaleUpHints: true},
+		{name: "hints disabled", scaleUpHints: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			// the only node cannot host the profile
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-1",
+				Annotations: map[string]string{ForbiddenProfilesAnnotation: "1g.5gb"},
+			}}
+			r, fakeClient := newTestReconciler(t, pod, node, utils.GenerateFakeCapacity("node-1"))
+			r.Config.ScaleUpHints = tt.scaleUpHints
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Greater(t, result.RequeueAfter, time.Duration(0))
+			current := &v1.Pod{}
+			assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), current))
+			var scheduled *v1.PodCondition
+			for i := range current.Status.Conditions {
+				if current.Status.Conditions[i].Type == v1.PodScheduled {
+					scheduled = &current.Status.Conditions[i]
+				}
+			}
+			if !tt.scaleUpHints {
+				assert.Nil(t, scheduled)
+				return
+			}
+			if assert.NotNil(t, scheduled) {
+				assert.Equal(t, v1.ConditionFalse, scheduled.Status)
+				assert.Equal(t, v1.PodReasonUnschedulable, scheduled.Reason)
+				assert.Contains(t, scheduled.Message, "1g.5gb")
+			}
+		})
+	}
+}
+
+func TestReconcile_SchedulerGiveUpTimeouts(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name          string
+		schedulerName string
+		expectGiveUp  bool
+	}{
+		{name: "scheduler with a short timeout gives up", schedulerName: "batch-scheduler", expectGiveUp: true},
+		{name: "default scheduler keeps retrying", schedulerName: "", expectGiveUp: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Spec.SchedulerName = tt.schedulerName
+			pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-5 * time.Minute))
+			// the only node cannot host the profile
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-1",
+				Annotations: map[string]string{ForbiddenProfilesAnnotation: "1g.5gb"},
+			}}
+			r, _ := newTestReconciler(t, pod, node, utils.GenerateFakeCapacity("node-1"))
+			r.Config.SchedulerGiveUpTimeouts = map[string]time.Duration{
+				"batch-scheduler":       time.Minute,
+				v1.DefaultSchedulerName: 10 * time.Minute,
+			}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			if tt.expectGiveUp {
+				assert.Equal(t, ctrl.Result{}, result)
+			} else {
+				assert.Greater(t, result.RequeueAfter, time.Duration(0))
+			}
+		})
+	}
+}
+
+func TestReconcile_ProfileFallbackTimeout(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name            string
+		createdAgo      time.Duration
+		expectedProfile string
+	}{
+		{name: "preferred profile is retried within the timeout", createdAgo: 10 * time.Second},
+		{name: "smaller profile is granted after the timeout", createdAgo: 5 * time.Minute, expectedProfile: "1g.5gb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "3g.20gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Annotations = map[string]string{FallbackProfilesAnnotation: "2g.10gb, 1g.5gb"}
+			pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-tt.createdAgo))
+			// the node has no room for the preferred profile nor the first fallback
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-1",
+				Annotations: map[string]string{ForbiddenProfilesAnnotation: "3g.20gb,2g.10gb"},
+			}}
+			r, fakeClient := newTestReconciler(t, pod, node, utils.GenerateFakeCapacity("node-1"))
+			r.Config.ProfileFallbackTimeouts = map[string]time.Duration{"3g.20gb": time.Minute}
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			allocRequest, ok := instaslice.Spec.PodAllocationRequests[pod.UID]
+			if tt.expectedProfile == "" {
+				assert.False(t, ok)
+				return
+			}
+			if assert.True(t, ok) {
+				assert.Equal(t, tt.expectedProfile, allocRequest.Profile)
+			}
+		})
+	}
+}
+
+func TestReconcile_MultipleContainers(t *testing.T) {
+	ctx := context.TODO()
+	t.Run("sidecar without a profile", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		pod.Spec.Containers = append([]v1.Container{{Name: "sidecar"}}, pod.Spec.Containers...)
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		r.Recorder = record.NewFakeRecorder(10)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		if assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID) {
+			assert.Equal(t, "1g.5gb", instaslice.Spec.PodAllocationRequests[pod.UID].Profile)
+		}
+	})
+	t.Run("more than one GPU container", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		second := newTestGatedPod("pod-2", "2g.10gb").Spec.Containers[0]
+		second.Name = "gpu-2"
+		pod.Spec.Containers = append(pod.Spec.Containers, second)
+		r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		assert.Equal(t, ctrl.Result{}, result)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+		if assert.Len(t, recorder.Events, 1) {
+			event := <-recorder.Events
+			assert.Contains(t, event, "MultipleGPUContainersUnsupported")
+			assert.Contains(t, event, multipleGPUContainersErr)
+		}
+	})
+}
+
+func TestReconcile_MissingSliceConfigMap(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name    string
+		envFrom []v1.EnvFromSource
+	}{
+		{name: "no envFrom"},
+		{name: "secret instead of a ConfigMap", envFrom: []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "credentials"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a pod gated by hand never went through the webhook adding the ConfigMap of its slice
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Spec.Containers[0].EnvFrom = tt.envFrom
+			r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Equal(t, ctrl.Result{}, result)
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+			current := &v1.Pod{}
+			assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), current))
+			assert.True(t, checkIfPodGatedByInstaSlice(current, GateName))
+			if assert.Len(t, recorder.Events, 1) {
+				event := <-recorder.Events
+				assert.Contains(t, event, "MissingSliceConfigMap")
+				assert.Contains(t, event, noSliceConfigMapErr)
+			}
+		})
+	}
+}
+
+func TestReconcile_MultipleSlices(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	pod.Spec.Containers[0].Resources.Limits[v1.ResourceName(OrgInstaslicePrefix+"mig-1g.5gb")] = resource.MustParse("2")
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	instasliceKey := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+	secondSlice := utils.SliceAllocationKey(pod.UID, 1)
+	// setDaemonsetStatus plays the daemonset for the given slices of the pod
+	setDaemonsetStatus := func(status inferencev1alpha1.AllocationStatusDaemonset, keys ...types.UID) {
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+		for _, key := range keys {
+			allocResult := instaslice.Status.PodAllocationResults[key]
+			allocResult.AllocationStatus.AllocationStatusDaemonset = status
+			instaslice.Status.PodAllocationResults[key] = allocResult
+		}
+		assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	}
+	isGated := func() bool {
+		current := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, current))
+		return checkIfPodGatedByInstaSlice(current, GateName)
+	}
+
+	// both slices are allocated on the node at once
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	if !assert.Len(t, instaslice.Status.PodAllocationResults, 2) {
+		return
+	}
+	first, second := instaslice.Status.PodAllocationResults[pod.UID], instaslice.Status.PodAllocationResults[secondSlice]
+	assert.Equal(t, int32(1), instaslice.Spec.PodAllocationRequests[secondSlice].SliceIndex)
+	assert.Equal(t, pod.UID, instaslice.Spec.PodAllocationRequests[secondSlice].PodRef.UID)
+	assert.NotEqual(t, first.MigPlacement, second.MigPlacement)
+	assert.Equal(t, first.ConfigMapResourceIdentifier, second.ConfigMapResourceIdentifier)
+	assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      string(first.ConfigMapResourceIdentifier),
+		Namespace: pod.Namespace,
+	}}))
+
+	// the pod stays gated until every slice is created
+	setDaemonsetStatus(inferencev1alpha1.AllocationStatusCreated, pod.UID)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, isGated())
+	setDaemonsetStatus(inferencev1alpha1.AllocationStatusCreated, secondSlice)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.False(t, isGated())
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	for key, allocResult := range instaslice.Status.PodAllocationResults {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, allocResult.AllocationStatus.AllocationStatusController, key)
+	}
+
+	// every slice is released once the pod completes
+	current := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, current))
+	current.Status.Phase = v1.PodSucceeded
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	for key, allocResult := range instaslice.Status.PodAllocationResults {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController, key)
+	}
+	setDaemonsetStatus(inferencev1alpha1.AllocationStatusDeleted, pod.UID, secondSlice)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+	assert.Empty(t, instaslice.Status.PodAllocationResults)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, current))
+	assert.NotContains(t, current.Finalizers, FinalizerName)
+}
+
+func TestReconcile_OperatorNamespace(t *testing.T) {
+	ctx := context.TODO()
+	const namespace = "gpu-operators"
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	instaslice.Namespace = namespace
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: InstasliceDaemonsetName, Namespace: namespace},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: daemonSetlabel},
+		},
+		Status: appsv1.DaemonSetStatus{NumberReady: 1},
+	}
+	r, fakeClient := newTestReconciler(t, pod, instaslice, daemonSet)
+	r.Config.OperatorNamespace = namespace
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	instasliceKey := types.NamespacedName{Name: "node-1", Namespace: namespace}
+
+	// the slice is allocated on the Instaslice of the operator namespace
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	if !assert.Contains(t, instaslice.Status.PodAllocationResults, pod.UID) {
+		return
+	}
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      string(allocResult.ConfigMapResourceIdentifier),
+		Namespace: pod.Namespace,
+	}}))
+
+	// and the pod is ungated once the daemonset created it
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	current := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, current))
+	assert.False(t, checkIfPodGatedByInstaSlice(current, GateName))
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, instaslice.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+}
+
+func TestReconcile_GatedPodWithoutConditions(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Status = v1.PodStatus{}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+
+	assert.True(t, checkIfPodGatedByInstaSlice(pod, GateName))
+	assert.NotPanics(t, func() {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+	})
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+}
+
+func TestReconcile_MalformedProfileQuantity(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name         string
+		quantity     resource.Quantity
+		resourceName v1.ResourceName
+	}{
+		{name: "fractional", quantity: resource.MustParse("500m")},
+		{name: "negative", quantity: resource.MustParse("-1")},
+		{name: "zero value", quantity: resource.Quantity{}},
+		// a second distinct profile makes the request ambiguous
+		{name: "second profile", quantity: resource.MustParse("1"), resourceName: OrgInstaslicePrefix + "mig-3g.20gb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			resourceName := v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb")
+			if tt.resourceName != "" {
+				resourceName = tt.resourceName
+			}
+			pod.Spec.Containers[0].Resources.Limits[resourceName] = tt.quantity
+			r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Equal(t, ctrl.Result{}, result)
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+			if assert.Len(t, recorder.Events, 1) {
+				assert.Contains(t, <-recorder.Events, "MalformedProfileRequest")
+			}
+		})
+	}
+}
+
+func TestReconcile_QueuePosition(t *testing.T) {
+	ctx := context.TODO()
+	var pods []*v1.Pod
+	for i, name := range []string{"pod-1", "pod-2", "pod-3"} {
+		pod := newTestGatedPod(name, "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		pod.CreationTimestamp = metav1.NewTime(time.Now().Add(time.Duration(i-3) * time.Minute))
+		pods = append(pods, pod)
+	}
+	// a pod waiting for another profile does not compete
+	other := newTestGatedPod("pod-other", "2g.10gb")
+	other.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	// the only node has no capacity for the profile
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Annotations: map[string]string{ForbiddenProfilesAnnotation: "1g.5gb"},
+	}}
+	r, fakeClient := newTestReconciler(t, pods[0], pods[1], pods[2], other, node, utils.GenerateFakeCapacity("node-1"))
+	last := client.ObjectKeyFromObject(pods[2])
+
+	queuePosition := func() string {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: last})
+		assert.NoError(t, err)
+		pod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, last, pod))
+		return pod.Annotations[QueuePositionAnnotation]
+	}
+	assert.Equal(t, "3", queuePosition())
+
+	// the oldest pod is satisfied and leaves the line
+	satisfied := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pods[0]), satisfied))
+	satisfied.Spec.SchedulingGates = nil
+	assert.NoError(t, fakeClient.Update(ctx, satisfied))
+	assert.Equal(t, "2", queuePosition())
+}
+
+func TestReconcile_DeterministicTieBreaking(t *testing.T) {
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Finalizers = []string{FinalizerName}
+		objs := []client.Object{pod}
+		// equally empty nodes whose placements are discovered in reverse order
+		for _, nodeName := range []string{"node-b", "node-a"} {
+			instaslice := utils.GenerateFakeCapacity(nodeName)
+			slices.Reverse(instaslice.Status.NodeResources.MigPlacement["1g.5gb"].Placements)
+			objs = append(objs, instaslice)
+		}
+		r, fakeClient := newTestReconciler(t, objs...)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+		allocResult, ok := instaslice.Status.PodAllocationResults[pod.UID]
+		if assert.True(t, ok) {
+			assert.Equal(t, sortGPUs(instaslice)[0], allocResult.GPUUUID)
+			assert.Equal(t, int32(0), allocResult.MigPlacement.Start)
+		}
+	}
+}
+
+func TestReconcile_AllocationFreeze(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	freeze := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: AllocationFreezeConfigMapName, Namespace: InstaSliceOperatorNamespace},
+		Data:       map[string]string{"frozen": "true"},
+	}
+	r, fakeClient := newTestReconciler(t, pod, freeze, utils.GenerateFakeCapacity("node-1"))
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Greater(t, result.RequeueAfter, time.Duration(0))
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, instaslice))
+	assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "AllocationFrozen")
+	}
+
+	// lifting the freeze resumes allocation
+	freeze.Data["frozen"] = "false"
+	assert.NoError(t, fakeClient.Update(ctx, freeze))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, key, instaslice))
+	assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+}
+
+func TestReconcile_CleanedUpTerminalPod(t *testing.T) {
+	ctx := context.TODO()
+	for _, phase := range []v1.PodPhase{v1.PodSucceeded, v1.PodFailed} {
+		t.Run(string(phase), func(t *testing.T) {
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"},
+				Status:     v1.PodStatus{Phase: phase},
+			}
+			r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+			// a daemonset that is not ready would otherwise requeue every reconcile
+			daemonSet := &appsv1.DaemonSet{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: InstasliceDaemonsetName, Namespace: InstaSliceOperatorNamespace}, daemonSet))
+			daemonSet.Status.NumberReady = 0
+			assert.NoError(t, fakeClient.Update(ctx, daemonSet))
+
+			assert.True(t, isTerminalPodWithoutFinalizer(pod, FinalizerName))
+			for i := 0; i < 2; i++ {
+				result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+				assert.NoError(t, err)
+				assert.Equal(t, ctrl.Result{}, result)
+			}
+		})
+	}
+}
+
+func TestReconcile_LostFinalizer(t *testing.T) {
+	ctx := context.TODO()
+	created := inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	}
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	t.Run("allocation of a pod deleted without the finalizer is released", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		// the pod is already gone, its allocation is still held
+		r, fakeClient := newTestReconciler(t, newTestAllocation("node-1", pod, created))
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+		_, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, key, instaslice))
+		allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController)
+
+		// the daemonset tears the slice down and the allocation is dropped
+		allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+		instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+		assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+		_, err = r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		assert.NoError(t, fakeClient.Get(ctx, key, instaslice))
+		assert.NotContains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+		assert.NotContains(t, instaslice.Status.PodAllocationResults, pod.UID)
+	})
+
+	t.Run("finalizer is re-added to a running pod holding an allocation", func(t *testing.T) {
+		pod := newTestGatedPod("pod-1", "1g.5gb")
+		pod.Spec.SchedulingGates = nil
+		pod.Status.Phase = v1.PodRunning
+		r, fakeClient := newTestReconciler(t, pod, newTestAllocation("node-1", pod, created))
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		assert.NoError(t, err)
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+		assert.Contains(t, updatedPod.Finalizers, FinalizerName)
+	})
+}
+
+func TestReconcile_DeletedPodMappedFromInstaslice(t *testing.T) {
+	ctx := context.TODO()
+	created := inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	}
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Spec.SchedulingGates = nil
+	pod.Status.Phase = v1.PodRunning
+	// a pod of the same name in another namespace keeps its allocation
+	other := newTestGatedPod("pod-1", "1g.5gb")
+	other.Namespace = "other"
+	other.UID = "other-pod-1-uid"
+	other.Spec.SchedulingGates = nil
+	other.Status.Phase = v1.PodRunning
+	instaslice := newTestAllocation("node-1", pod, created)
+	otherAllocation := newTestAllocation("node-1", other, created)
+	instaslice.Spec.PodAllocationRequests[other.UID] = otherAllocation.Spec.PodAllocationRequests[other.UID]
+	otherResult := otherAllocation.Status.PodAllocationResults[other.UID]
+	otherResult.MigPlacement = inferencev1alpha1.Placement{Start: 1, Size: 1}
+	instaslice.Status.PodAllocationResults[other.UID] = otherResult
+	r, fakeClient := newTestReconciler(t, pod, other, instaslice)
+
+	// the pod is deleted without the finalizer, the only trace left is the allocation
+	assert.NoError(t, fakeClient.Delete(ctx, pod))
+	requests := r.podMapFunc(ctx, instaslice)
+	assert.ElementsMatch(t, []ctrl.Request{
+		{NamespacedName: client.ObjectKeyFromObject(pod)},
+		{NamespacedName: client.ObjectKeyFromObject(other)},
+	}, requests)
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(instaslice), current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, current.Status.PodAllocationResults[other.UID].AllocationStatus.AllocationStatusController)
+}
+
+func TestReconcile_FinalizerNotDuplicated(t *testing.T) {
+	ctx := context.TODO()
+	countFinalizers := func(pod *v1.Pod) int {
+		count := 0
+		for _, finalizer := range pod.Finalizers {
+			if finalizer == FinalizerName {
+				count++
+			}
+		}
+		return count
+	}
+
+	// a gated pod that already carries the finalizer does not get a second copy
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	current := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), current))
+	assert.Equal(t, 1, countFinalizers(current))
+
+	// every copy left behind by an earlier race is removed with the allocation
+	completed := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "pod-2",
+			Namespace:  "default",
+			UID:        "pod-2-uid",
+			Finalizers: []string{FinalizerName, "example.com/other", FinalizerName},
+		},
+		Status: v1.PodStatus{Phase: v1.PodSucceeded},
+	}
+	r, fakeClient = newTestReconciler(t, completed)
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(completed)})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(completed), current))
+	assert.Equal(t, []string{"example.com/other"}, current.Finalizers)
+}
+
+func TestReconcile_TerminatingGatedPod(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	now := metav1.Now()
+	pod.DeletionTimestamp = &now
+	// the fake client only keeps deleting objects that still carry a finalizer
+	pod.Finalizers = []string{"example.com/other"}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+	updatedPod := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+	assert.NotContains(t, updatedPod.Finalizers, FinalizerName)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+}
+
+func TestReconcile_GracefulDeletionTimeout(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name           string
+		timeout        time.Duration
+		annotations    map[string]string
+		deletedAgo     time.Duration
+		wantDeleting   bool
+		wantRequeueMax time.Duration
+	}{
+		{name: "default keeps the slice", timeout: config.DefaultGracefulDeletionTimeout, deletedAgo: 10 * time.Second, wantRequeueMax: 20 * time.Second},
+		{name: "default releases the slice", timeout: config.DefaultGracefulDeletionTimeout, deletedAgo: 40 * time.Second, wantDeleting: true},
+		{name: "configured timeout keeps the slice", timeout: 2 * time.Minute, deletedAgo: 40 * time.Second, wantRequeueMax: 80 * time.Second},
+		{
+			name:         "annotation overrides the configured timeout",
+			timeout:      config.DefaultGracefulDeletionTimeout,
+			annotations:  map[string]string{DeletionTimeoutAnnotation: "5s"},
+			deletedAgo:   10 * time.Second,
+			wantDeleting: true,
+		},
+		{
+			name:         "annotation is capped at the maximum timeout",
+			timeout:      config.DefaultGracefulDeletionTimeout,
+			annotations:  map[string]string{DeletionTimeoutAnnotation: "24h"},
+			deletedAgo:   config.DefaultMaxDeletionTimeout + time.Minute,
+			wantDeleting: true,
+		},
+		{
+			name:           "malformed annotation falls back to the configured timeout",
+			timeout:        config.DefaultGracefulDeletionTimeout,
+			annotations:    map[string]string{DeletionTimeoutAnnotation: "soon"},
+			deletedAgo:     10 * time.Second,
+			wantRequeueMax: 20 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Spec.SchedulingGates = nil
+			pod.Finalizers = []string{FinalizerName}
+			pod.Annotations = tt.annotations
+			deletedAt := metav1.NewTime(time.Now().Add(-tt.deletedAgo))
+			pod.DeletionTimestamp = &deletedAt
+			instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+				AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+			})
+			r, fakeClient := newTestReconciler(t, pod, instaslice)
+			r.Config.GracefulDeletionTimeout = tt.timeout
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			status := instaslice.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController
+			if tt.wantDeleting {
+				assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, status)
+				return
+			}
+			assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, status)
+			// the pod is reconciled again once the timeout expires
+			assert.Greater(t, result.RequeueAfter, tt.wantRequeueMax-5*time.Second)
+			assert.LessOrEqual(t, result.RequeueAfter, tt.wantRequeueMax)
+		})
+	}
+}
+
+func TestReconcile_AllocationDecisionAnnotation(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	// node-1 cannot host the profile so the allocation lands on node-2
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Annotations: map[string]string{ForbiddenProfilesAnnotation: "1g.5gb"},
+	}}
+	r, fakeClient := newTestReconciler(t, pod, node, utils.GenerateFakeCapacity("node-1"), utils.GenerateFakeCapacity("node-2"))
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-2", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	updatedPod := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+	decision := allocationDecision{}
+	assert.NoError(t, json.Unmarshal([]byte(updatedPod.Annotations[AllocationDecisionAnnotation]), &decision))
+	assert.Equal(t, allocationDecision{
+		Node:       "node-2",
+		GPU:        instaslice.Status.PodAllocationResults[pod.UID].GPUUUID,
+		Policy:     FirstFitPolicyName,
+		Candidates: []string{"node-1", "node-2"},
+	}, decision)
+}
+
+func TestReconcile_AllocationStatusAnnotation(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	instasliceKey := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+	statusOf := func() allocationStatusRecord {
+		updatedPod := &v1.Pod{}
+		assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+		record := allocationStatusRecord{}
+		if value, ok := updatedPod.Annotations[AllocationStatusAnnotation]; ok {
+			assert.NoError(t, json.Unmarshal([]byte(value), &record))
+		}
+		return record
+	}
+
+	// the first allocation is creating
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, instasliceKey, instaslice))
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	assert.Equal(t, allocationStatusRecord{
+		Status: string(inferencev1alpha1.AllocationStatusCreating),
+		Node:   "node-1",
+		GPU:    allocResult.GPUUUID,
+	}, statusOf())
+
+	// the daemonset created the slice, the pod waits for it to be realized
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, string(inferencev1alpha1.AllocationStatusCreated), statusOf().Status)
+
+	// the annotation is dropped once the pod is ungated
+	assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      string(allocResult.ConfigMapResourceIdentifier),
+		Namespace: pod.Namespace,
+	}}))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	updatedPod := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+	assert.False(t, checkIfPodGatedByInstaSlice(updatedPod, GateName))
+	assert.NotContains(t, updatedPod.Annotations, AllocationStatusAnnotation)
+}
+
+func TestAnnotateAllocationDecision_Bounded(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	r, fakeClient := newTestReconciler(t, pod)
+	var candidates []string
+	for i := 0; i < maxDecisionCandidates+5; i++ {
+		candidates = append(candidates, fmt.Sprintf("node-%d", i))
+	}
+
+	assert.NoError(t, r.annotateAllocationDecision(ctx, pod, &inferencev1alpha1.AllocationResult{Nodename: "node-14"}, candidates))
+	updatedPod := &v1.Pod{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), updatedPod))
+	decision := allocationDecision{}
+	assert.NoError(t, json.Unmarshal([]byte(updatedPod.Annotations[AllocationDecisionAnnotation]), &decision))
+	assert.Len(t, decision.Candidates, maxDecisionCandidates)
+	assert.Equal(t, 5, decision.Omitted)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// reconcileInstasliceFinalizer keeps the finalizer on an Instaslice so that deleting it does not lose track of
+// the slices on its GPUs. An Instaslice being deleted is drained instead and its finalizer is removed once the
+// daemonset tore every slice down. It reports whether the Instaslice is being deleted.
+func (r *InstasliceReconciler) reconcileInstasliceFinalizer(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	if instaslice.DeletionTimestamp.IsZero() {
+		original := instaslice.DeepCopy()
+		if !controllerutil.AddFinalizer(instaslice, InstasliceFinalizerName) {
+			return false, nil
+		}
+		return false, r.Patch(ctx, instaslice, client.MergeFrom(original))
+	}
+	if !controllerutil.ContainsFinalizer(instaslice, InstasliceFinalizerName) {
+		return true, nil
+	}
+	return true, r.releaseDeletedInstaslice(ctx, instaslice)
+}
+
+// releaseDeletedInstaslice drives the cleanup of an Instaslice being deleted. The deletion is blocked while pods
+// run on its slices, they keep their slices until they complete. The allocations no pod runs on yet are released
+// right away, those the daemonset has not picked up are marked deleted as nothing would tear them down. Once the
+// node or the daemonset pod on it is gone no slice can be torn down anymore and every allocation is dropped.
+// The finalizer is removed once every allocation is deleted.
+func (r *InstasliceReconciler) releaseDeletedInstaslice(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	log := logr.FromContext(ctx).WithName(LogSubsystemDeletion)
+	tearDown, err := r.canTearDownSlices(ctx, instaslice.Name)
+	if err != nil {
+		return err
+	}
+	original := instaslice.DeepCopy()
+	var released bool
+	for key, allocResult := range instaslice.Status.PodAllocationResults {
+		status := allocResult.AllocationStatus
+		switch {
+		case status.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted:
+			continue
+		case !tearDown || status.AllocationStatusDaemonset == "":
+			allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+		case status.AllocationStatusController != inferencev1alpha1.AllocationStatusUngated &&
+			status.AllocationStatusController != inferencev1alpha1.AllocationStatusDeleting:
+			allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+		default:
+			continue
+		}
+		instaslice.Status.PodAllocationResults[key] = allocResult
+		released = true
+	}
+	if released {
+		utils.SetGPUStatus(instaslice)
+		if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(original)); err != nil {
+			return err
+		}
+	}
+	if err := r.releaseOrphanedAllocations(ctx, instaslice); err != nil {
+		return err
+	}
+	var remaining, deleted int
+	for _, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			deleted++
+			continue
+		}
+		remaining++
+	}
+	if remaining > 0 {
+		log.Info("instaslice deletion waits for its slices to be released", "instaslice", instaslice.Name, "allocations", remaining)
+		return nil
+	}
+	if deleted > 0 {
+		// removing allocations drops every allocation marked Deleted
+		if err := utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), instaslice.Namespace, instaslice.Name, nil, nil); err != nil {
+			return err
+		}
+	}
+	original = instaslice.DeepCopy()
+	controllerutil.RemoveFinalizer(instaslice, InstasliceFinalizerName)
+	log.Info("instaslice slices released, removing the finalizer", "instaslice", instaslice.Name)
+	return r.Patch(ctx, instaslice, client.MergeFrom(original))
+}
+
+// canTearDownSlices reports whether the slices on the node can still be torn down, i.e. the node exists and a
+// daemonset pod runs on it
+func (r *InstasliceReconciler) canTearDownSlices(ctx context.Context, nodeName string) (bool, error) {
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, &v1.Node{}); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return r.isDaemonsetPodOnNode(ctx, nodeName)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/go-logr/logr"
+)
+
+// subsystems whose log verbosity can be set on their own, the loggers of a subsystem are named after it
+const (
+	LogSubsystemAllocation = "allocation"
+	LogSubsystemDeletion   = "deletion"
+	LogSubsystemReadiness  = "readiness"
+)
+
+// subsystemSink drops the messages logged above the verbosity of their subsystem. Loggers named after a
+// subsystem use the verbosity configured for it, every other logger uses the base verbosity. Errors are
+// always logged.
+type subsystemSink struct {
+	logr.LogSink
+	verbosity int
+	levels    map[string]int
+}
+
+// NewSubsystemLogger wraps the logger so that the subsystems log at the verbosity configured in levels and
+// everything else at the base verbosity. The wrapped logger must be enabled for the highest of them.
+func NewSubsystemLogger(logger logr.Logger, verbosity int, levels map[string]int) logr.Logger {
+	if len(levels) == 0 {
+		return logger
+	}
+	sink := logger.GetSink()
+	// the messages pass through the methods of the subsystem sink, the call site is one frame further up
+	if callDepthSink, ok := sink.(logr.CallDepthLogSink); ok {
+		sink = callDepthSink.WithCallDepth(1)
+	}
+	return logr.New(&subsystemSink{LogSink: sink, verbosity: verbosity, levels: levels})
+}
+
+// Init is a no-op, the wrapped sink was initialized by the logger it was taken from
+func (s *subsystemSink) Init(logr.RuntimeInfo) {}
+
+func (s *subsystemSink) Enabled(level int) bool {
+	return level <= s.verbosity && s.LogSink.Enabled(level)
+}
+
+func (s *subsystemSink) Info(level int, msg string, keysAndValues ...any) {
+	s.LogSink.Info(level, msg, keysAndValues...)
+}
+
+func (s *subsystemSink) Error(err error, msg string, keysAndValues ...any) {
+	s.LogSink.Error(err, msg, keysAndValues...)
+}
+
+func (s *subsystemSink) WithName(name string) logr.LogSink {
+	verbosity := s.verbosity
+	if level, ok := s.levels[name]; ok {
+		verbosity = level
+	}
+	return &subsystemSink{LogSink: s.LogSink.WithName(name), verbosity: verbosity, levels: s.levels}
+}
+
+func (s *subsystemSink) WithValues(keysAndValues ...any) logr.LogSink {
+	return &subsystemSink{LogSink: s.LogSink.WithValues(keysAndValues...), verbosity: s.verbosity, levels: s.levels}
+}
+
+func (s *subsystemSink) WithCallDepth(depth int) logr.LogSink {
+	sink, ok := s.LogSink.(logr.CallDepthLogSink)
+	if !ok {
+		return s
+	}
+	return &subsystemSink{LogSink: sink.WithCallDepth(depth), verbosity: s.verbosity, levels: s.levels}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSubsystemLogger(t *testing.T) {
+	var logged []string
+	sink := funcr.New(func(prefix, args string) {
+		logged = append(logged, prefix+" "+args)
+	}, funcr.Options{Verbosity: 2})
+	logger := NewSubsystemLogger(sink, 0, map[string]int{LogSubsystemAllocation: 1, LogSubsystemDeletion: 0})
+	// the controller names its logger, the subsystems are named below it
+	controllerLogger := logger.WithName("InstaSlice-controller").WithValues("pod", "pod-1")
+
+	controllerLogger.WithName(LogSubsystemAllocation).V(1).Info("allocation decision")
+	controllerLogger.WithName(LogSubsystemAllocation).V(2).Info("allocation trace")
+	controllerLogger.WithName(LogSubsystemDeletion).V(1).Info("deletion debug")
+	controllerLogger.WithName(LogSubsystemDeletion).Info("deletion info")
+	controllerLogger.WithName(LogSubsystemReadiness).V(1).Info("readiness debug")
+	controllerLogger.V(1).Info("controller debug")
+	controllerLogger.WithName(LogSubsystemDeletion).Error(errors.New("boom"), "deletion error")
+
+	if assert.Len(t, logged, 3) {
+		assert.Contains(t, logged[0], `"msg"="allocation decision"`)
+		assert.Contains(t, logged[0], "InstaSlice-controller/allocation")
+		assert.Contains(t, logged[1], `"msg"="deletion info"`)
+		assert.Contains(t, logged[2], `"msg"="deletion error"`)
+	}
+
+	// without subsystem levels the logger is used as is
+	assert.Equal(t, sink, NewSubsystemLogger(sink, 0, nil))
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// allocation metrics are labeled with the allocation policy so that policies can be compared across clusters
+var (
+	placementLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "instaslice_placement_latency_seconds",
+		Help:    "Time from the creation of a pod until a slice is allocated for it.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"policy"})
+
+	gpusUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "instaslice_gpus_used",
+		Help: "Number of GPUs holding at least one allocation.",
+	}, []string{"policy"})
+
+	gpuFragmentation = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "instaslice_gpu_fragmentation_ratio",
+		Help: "Share of the free slice indexes that lie outside the largest free run of their GPU.",
+	}, []string{"policy"})
+)
+
+// the slice gauges let operators alert on GPU saturation per node, GPU and profile
+var (
+	reconcileLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "instaslice_reconcile_duration_seconds",
+		Help:    "Time taken to reconcile a pod, by outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"outcome"})
+
+	slicesByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "instaslice_slices",
+		Help: "Number of slices of the profile allocated on the GPU, or still fitting it when free.",
+	}, []string{"node", "gpu", "profile", "state"})
+
+	gatedPods = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "instaslice_gated_pods",
+		Help: "Number of pods gated by InstaSlice waiting for an allocation.",
+	})
+)
+
+// the outcomes of a reconcile
+const (
+	reconcileOutcomeDone    = "done"
+	reconcileOutcomeRequeue = "requeue"
+	reconcileOutcomeError   = "error"
+)
+
+// the states of the slice gauge
+const (
+	sliceStateFree      = "free"
+	sliceStateAllocated = "allocated"
+)
+
+func init() {
+	metrics.Registry.MustRegister(placementLatency, gpusUsed, gpuFragmentation, reconcileLatency, slicesByState, gatedPods)
+}
+
+// observeReconcile records the latency of a reconcile that started at the given time by its outcome
+func observeReconcile(start time.Time, result ctrl.Result, err error) {
+	outcome := reconcileOutcomeDone
+	if err != nil {
+		outcome = reconcileOutcomeError
+	} else if result.Requeue || result.RequeueAfter > 0 {
+		outcome = reconcileOutcomeRequeue
+	}
+	reconcileLatency.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+// recordSliceMetrics sets the free and allocated slice gauges of every GPU and profile, the series of nodes
+// and GPUs that are gone are dropped
+func recordSliceMetrics(instaslices []inferencev1alpha1.Instaslice) {
+	type series struct{ node, gpu, profile, state string }
+	values := make(map[series]float64)
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+			for profile, free := range freeSlicesOnGPU(instaslice, gpu.GPUUUID) {
+				values[series{instaslice.Name, gpu.GPUUUID, profile, sliceStateFree}] = float64(free)
+				values[series{instaslice.Name, gpu.GPUUUID, profile, sliceStateAllocated}] = 0
+			}
+		}
+		for key, allocResult := range instaslice.Status.PodAllocationResults {
+			allocRequest, ok := instaslice.Spec.PodAllocationRequests[key]
+			if !ok || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			values[series{instaslice.Name, allocResult.GPUUUID, allocRequest.Profile, sliceStateAllocated}]++
+		}
+	}
+	slicesByState.Reset()
+	for s, value := range values {
+		slicesByState.WithLabelValues(s.node, s.gpu, s.profile, s.state).Set(value)
+	}
+}
+
+// recordGatedPods sets the gauge of the pods gated by InstaSlice that hold no allocation yet
+func recordGatedPods(pods []v1.Pod, instaslices []inferencev1alpha1.Instaslice, gateName string) {
+	allocated := make(map[types.UID]bool)
+	for _, instaslice := range instaslices {
+		for _, allocRequest := range instaslice.Spec.PodAllocationRequests {
+			allocated[allocRequest.PodRef.UID] = true
+		}
+	}
+	var waiting int
+	for i := range pods {
+		if pods[i].DeletionTimestamp.IsZero() && checkIfPodGatedByInstaSlice(&pods[i], gateName) && !allocated[pods[i].UID] {
+			waiting++
+		}
+	}
+	gatedPods.Set(float64(waiting))
+}
+
+// recordAllocationMetrics sets the cluster wide GPU usage and fragmentation gauges of the policy
+func recordAllocationMetrics(policy string, instaslices []inferencev1alpha1.Instaslice) {
+	var usedGPUs, freeIndexes, largestFreeRuns int
+	for _, instaslice := range instaslices {
+		span := utils.GPUSliceSpan(&instaslice)
+		occupied := make(map[string][]bool)
+		for _, allocResult := range instaslice.Status.PodAllocationResults {
+			if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			indexes, ok := occupied[allocResult.GPUUUID]
+			if !ok {
+				indexes = make([]bool, span)
+				occupied[allocResult.GPUUUID] = indexes
+			}
+			for i := allocResult.MigPlacement.Start; i < allocResult.MigPlacement.Start+allocResult.MigPlacement.Size && int(i) < len(indexes); i++ {
+				indexes[i] = true
+			}
+		}
+		for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+			indexes, ok := occupied[gpu.GPUUUID]
+			if !ok {
+				indexes = make([]bool, span)
+			} else {
+				usedGPUs++
+			}
+			run, largestRun := 0, 0
+			for _, taken := range indexes {
+				if taken {
+					run = 0
+					continue
+				}
+				freeIndexes++
+				run++
+				largestRun = max(largestRun, run)
+			}
+			largestFreeRuns += largestRun
+		}
+	}
+	fragmentation := 0.0
+	if freeIndexes > 0 {
+		fragmentation = 1 - float64(largestFreeRuns)/float64(freeIndexes)
+	}
+	gpusUsed.WithLabelValues(policy).Set(float64(usedGPUs))
+	gpuFragmentation.WithLabelValues(policy).Set(fragmentation)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// policyLabels returns the policy label of every series emitted for the metric
+func policyLabels(t *testing.T, metricName string) []string {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+	var policies []string
+	for _, family := range families {
+		if family.GetName() != metricName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "policy" {
+					policies = append(policies, label.GetValue())
+				}
+			}
+		}
+	}
+	return policies
+}
+
+// gaugeValue returns the value of the gauge series of the policy
+func gaugeValue(t *testing.T, metricName string, policy string) float64 {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != metricName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "policy" && label.GetValue() == policy {
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("no %s series for policy %s", metricName, policy)
+	return 0
+}
+
+func TestAllocationMetrics_PolicyLabel(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, _ := newTestReconciler(t, pod, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, utils.GenerateFakeCapacity("node-1"))
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.NoError(t, r.sweepInstaslices(ctx))
+
+	for _, metricName := range []string{"instaslice_placement_latency_seconds", "instaslice_gpus_used", "instaslice_gpu_fragmentation_ratio"} {
+		assert.Contains(t, policyLabels(t, metricName), FirstFitPolicyName, metricName)
+	}
+}
+
+func TestRecordAllocationMetrics(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	// a second slice in the middle of the same GPU splits its free indexes into runs of 1 and 5
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	allocResult.MigPlacement = inferencev1alpha1.Placement{Start: 2, Size: 1}
+	instaslice.Status.PodAllocationResults["pod-2-uid"] = allocResult
+
+	recordAllocationMetrics("test-policy", []inferencev1alpha1.Instaslice{*instaslice})
+	gpuCount := len(instaslice.Status.NodeResources.NodeGPUs)
+	// the idle GPUs contribute 8 free indexes in a single run each
+	freeIndexes := 6 + 8*(gpuCount-1)
+	largestFreeRuns := 5 + 8*(gpuCount-1)
+	assert.Equal(t, 1.0, gaugeValue(t, "instaslice_gpus_used", "test-policy"))
+	assert.InDelta(t, 1-float64(largestFreeRuns)/float64(freeIndexes), gaugeValue(t, "instaslice_gpu_fragmentation_ratio", "test-policy"), 1e-9)
+}
+
+// metricValue returns the value of the counter or gauge series carrying the labels, 0 when there is none
+func metricValue(t *testing.T, metricName string, labels map[string]string) float64 {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != metricName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := 0
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			if metric.GetCounter() != nil {
+				return metric.GetCounter().GetValue()
+			}
+			return metric.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+// reconcileCount returns how many reconciles with the outcome were observed
+func reconcileCount(t *testing.T, outcome string) uint64 {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "instaslice_reconcile_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == outcome {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestAllocationMetrics_Allocation(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	waiting := newTestGatedPod("pod-2", "1g.5gb")
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	r, fakeClient := newTestReconciler(t, pod, waiting, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, instaslice)
+	created := map[string]string{"event": "created", "profile": "1g.5gb"}
+	createdBefore := metricValue(t, "instaslice_allocations_total", created)
+	doneBefore := reconcileCount(t, reconcileOutcomeDone)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	gpuUUID := instaslice.Status.PodAllocationResults[pod.UID].GPUUUID
+
+	assert.Equal(t, createdBefore+1, metricValue(t, "instaslice_allocations_total", created))
+	assert.Equal(t, doneBefore+1, reconcileCount(t, reconcileOutcomeDone))
+	assert.Equal(t, 1.0, metricValue(t, "instaslice_slices", map[string]string{"node": "node-1", "gpu": gpuUUID, "profile": "1g.5gb", "state": "allocated"}))
+	assert.Equal(t, 6.0, metricValue(t, "instaslice_slices", map[string]string{"node": "node-1", "gpu": gpuUUID, "profile": "1g.5gb", "state": "free"}))
+	// the allocated pod waits for its slice to be realized, the other one for an allocation
+	assert.Equal(t, 1.0, metricValue(t, "instaslice_gated_pods", nil))
+
+	// the allocation is deleted once the daemonset deleted the slice
+	deleted := map[string]string{"event": "deleted", "profile": "1g.5gb"}
+	deletedBefore := metricValue(t, "instaslice_allocations_total", deleted)
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	assert.NoError(t, utils.UpdateOrDeleteInstasliceAllocations(ctx, fakeClient, fakeClient, InstaSliceOperatorNamespace, instaslice.Name, nil, nil))
+	assert.Equal(t, deletedBefore+1, metricValue(t, "instaslice_allocations_total", deleted))
+}
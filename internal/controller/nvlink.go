@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// the preference of a GPU for a pod asking for NVLink affinity, GPUs without any keep the order of the policy
+const (
+	affinityLinked = 1 // the GPU is NVLink-connected to a GPU hosting a slice of the job of the pod
+	affinityJob    = 2 // the GPU hosts a slice of the job of the pod
+)
+
+// gpuTopology maps each GPU of a node to the GPUs it is NVLink-connected to
+type gpuTopology map[string][]string
+
+// parseNVLinkTopology parses the NVLink topology annotation of a node, groups of NVLink-connected GPUs
+// separated by ; with the GPU UUIDs of a group separated by , e.g. GPU-a,GPU-b;GPU-c,GPU-d
+func parseNVLinkTopology(value string) gpuTopology {
+	topology := make(gpuTopology)
+	for _, group := range strings.Split(value, ";") {
+		var gpus []string
+		for _, gpu := range strings.Split(group, ",") {
+			if gpu = strings.TrimSpace(gpu); gpu != "" {
+				gpus = append(gpus, gpu)
+			}
+		}
+		for _, gpu := range gpus {
+			for _, peer := range gpus {
+				if peer != gpu {
+					topology[gpu] = append(topology[gpu], peer)
+				}
+			}
+		}
+	}
+	return topology
+}
+
+// nvlinkAffinity returns the preference of the GPUs of the Instaslice for a pod asking for NVLink affinity:
+// the GPUs hosting slices of the other pods of its job, the pods with the same controller, come first and the
+// GPUs NVLink-connected to them next. It returns nil, leaving the order to the policy, when the pod does not
+// ask for affinity, has no controller or the node does not publish its NVLink topology.
+func (r *InstasliceReconciler) nvlinkAffinity(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) (map[string]int, error) {
+	if pod.Annotations[NVLinkAffinityAnnotation] != "true" {
+		return nil, nil
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil, nil
+	}
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	value, ok := node.Annotations[NVLinkTopologyAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var podList v1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, err
+	}
+	jobPods := make(map[types.UID]bool)
+	for i := range podList.Items {
+		if controller := metav1.GetControllerOf(&podList.Items[i]); controller != nil && controller.UID == owner.UID && podList.Items[i].UID != pod.UID {
+			jobPods[podList.Items[i].UID] = true
+		}
+	}
+	return jobGPUAffinity(instaslice, jobPods, parseNVLinkTopology(value)), nil
+}
+
+// jobGPUAffinity ranks the GPUs of the Instaslice hosting slices of the job pods and the GPUs linked to them
+func jobGPUAffinity(instaslice *inferencev1alpha1.Instaslice, jobPods map[types.UID]bool, topology gpuTopology) map[string]int {
+	affinity := make(map[string]int)
+	for key, allocRequest := range instaslice.Spec.PodAllocationRequests {
+		allocResult, ok := instaslice.Status.PodAllocationResults[key]
+		if !ok || !jobPods[allocRequest.PodRef.UID] ||
+			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		affinity[allocResult.GPUUUID] = affinityJob
+		for _, peer := range topology[allocResult.GPUUUID] {
+			affinity[peer] = max(affinity[peer], affinityLinked)
+		}
+	}
+	return affinity
+}
+
+// affinityFirst orders the GPUs by their NVLink affinity, GPUs of the same affinity keep their order
+func affinityFirst(gpuUUIDs []string, affinity map[string]int) []string {
+	if len(affinity) == 0 {
+		return gpuUUIDs
+	}
+	sort.SliceStable(gpuUUIDs, func(i, j int) bool {
+		return affinity[gpuUUIDs[i]] > affinity[gpuUUIDs[j]]
+	})
+	return gpuUUIDs
+}
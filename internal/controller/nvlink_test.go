@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestParseNVLinkTopology(t *testing.T) {
+	topology := parseNVLinkTopology("GPU-a, GPU-b ;GPU-c,GPU-d,GPU-e;")
+	assert.Equal(t, []string{"GPU-b"}, topology["GPU-a"])
+	assert.Equal(t, []string{"GPU-c", "GPU-e"}, topology["GPU-d"])
+	assert.Empty(t, topology["GPU-f"])
+}
+
+func TestJobGPUAffinity(t *testing.T) {
+	sibling := newTestGatedPod("sibling", "1g.5gb")
+	instaslice := newTestAllocation("node-1", sibling, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	allocResult := instaslice.Status.PodAllocationResults[sibling.UID]
+	allocResult.GPUUUID = "GPU-c"
+	instaslice.Status.PodAllocationResults[sibling.UID] = allocResult
+	// GPU-c is linked to GPU-d only, GPU-a and GPU-b form another NVLink domain
+	topology := parseNVLinkTopology("GPU-a,GPU-b;GPU-c,GPU-d")
+
+	affinity := jobGPUAffinity(instaslice, map[types.UID]bool{sibling.UID: true}, topology)
+	assert.Equal(t, map[string]int{"GPU-c": affinityJob, "GPU-d": affinityLinked}, affinity)
+	assert.Equal(t, []string{"GPU-c", "GPU-d", "GPU-a", "GPU-b"}, affinityFirst([]string{"GPU-a", "GPU-b", "GPU-c", "GPU-d"}, affinity))
+
+	// the slices of pods of other jobs do not attract the pod
+	assert.Empty(t, jobGPUAffinity(instaslice, map[types.UID]bool{}, topology))
+}
+
+func TestReconcile_NVLinkAffinity(t *testing.T) {
+	isController := true
+	owner := metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: "train", UID: "train-uid", Controller: &isController}
+	tests := []struct {
+		name     string
+		hint     bool
+		topology bool
+		onJobGPU bool
+	}{
+		{name: "affinity", hint: true, topology: true, onJobGPU: true},
+		{name: "no hint", topology: true},
+		{name: "no topology", hint: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			sibling := newTestGatedPod("sibling", "1g.5gb")
+			sibling.Spec.SchedulingGates = nil
+			sibling.OwnerReferences = []metav1.OwnerReference{owner}
+			instaslice := newTestAllocation("node-1", sibling, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			})
+			// the sibling runs on the GPU the policy tries last
+			gpus := sortGPUs(instaslice)
+			jobGPU := gpus[len(gpus)-1]
+			allocResult := instaslice.Status.PodAllocationResults[sibling.UID]
+			allocResult.GPUUUID = jobGPU
+			instaslice.Status.PodAllocationResults[sibling.UID] = allocResult
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{}}}
+			if tt.topology {
+				node.Annotations[NVLinkTopologyAnnotation] = gpus[0] + "," + jobGPU
+			}
+			pod := newTestGatedPod("worker", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.OwnerReferences = []metav1.OwnerReference{owner}
+			if tt.hint {
+				pod.Annotations = map[string]string{NVLinkAffinityAnnotation: "true"}
+			}
+			r, fakeClient := newTestReconciler(t, node, sibling, pod, instaslice)
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+			if slices := podSlices(pod.UID, instasliceList.Items); assert.Len(t, slices, 1) {
+				if tt.onJobGPU {
+					assert.Equal(t, jobGPU, slices[0].result.GPUUUID)
+				} else {
+					assert.Equal(t, gpus[0], slices[0].result.GPUUUID)
+				}
+			}
+		})
+	}
+}
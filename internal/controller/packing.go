@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// PackingModel is the current packing of the slices on the GPUs of the cluster, nested nodes, GPUs and
+// slices so that dashboards can render it without joining the Instaslice maps themselves
+type PackingModel struct {
+	Nodes []PackingNode `json:"nodes"`
+}
+
+// PackingNode lists the GPUs of a node
+type PackingNode struct {
+	Name string       `json:"name"`
+	GPUs []PackingGPU `json:"gpus"`
+}
+
+// PackingGPU lists the slices placed on a GPU ordered by start
+type PackingGPU struct {
+	UUID        string         `json:"uuid"`
+	Model       string         `json:"model"`
+	TotalSlices int32          `json:"totalSlices"`
+	FreeSlices  int32          `json:"freeSlices"`
+	Slices      []PackingSlice `json:"slices"`
+}
+
+// PackingSlice is a slice placed on a GPU and the pod occupying it
+type PackingSlice struct {
+	Profile string                             `json:"profile"`
+	Start   int32                              `json:"start"`
+	Size    int32                              `json:"size"`
+	Status  inferencev1alpha1.AllocationStatus `json:"status"`
+	Pod     PackingPod                         `json:"pod"`
+}
+
+// PackingPod references the pod occupying a slice
+type PackingPod struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+}
+
+// packingModel aggregates the Instaslice objects into the packing model, nodes and GPUs are sorted by name
+// and UUID so that the model is stable across calls. Slices already deleted by the daemonset are left out.
+func packingModel(instaslices []inferencev1alpha1.Instaslice) PackingModel {
+	model := PackingModel{Nodes: make([]PackingNode, 0, len(instaslices))}
+	for i := range instaslices {
+		instaslice := instaslices[i].DeepCopy()
+		utils.SetGPUStatus(instaslice)
+		gpus := make(map[string]*PackingGPU, len(instaslice.Status.NodeResources.NodeGPUs))
+		for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+			status := instaslice.Status.GPUStatus[gpu.GPUUUID]
+			gpus[gpu.GPUUUID] = &PackingGPU{
+				UUID:        gpu.GPUUUID,
+				Model:       gpu.GPUName,
+				TotalSlices: status.TotalSlices,
+				FreeSlices:  status.FreeSlices,
+				Slices:      []PackingSlice{},
+			}
+		}
+		for key, allocResult := range instaslice.Status.PodAllocationResults {
+			gpu, ok := gpus[allocResult.GPUUUID]
+			if !ok || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			allocRequest := instaslice.Spec.PodAllocationRequests[key]
+			gpu.Slices = append(gpu.Slices, PackingSlice{
+				Profile: allocRequest.Profile,
+				Start:   allocResult.MigPlacement.Start,
+				Size:    allocResult.MigPlacement.Size,
+				Status:  allocResult.AllocationStatus,
+				Pod: PackingPod{
+					Namespace: allocRequest.PodRef.Namespace,
+					Name:      allocRequest.PodRef.Name,
+					UID:       allocRequest.PodRef.UID,
+				},
+			})
+		}
+		node := PackingNode{Name: instaslice.Name, GPUs: make([]PackingGPU, 0, len(gpus))}
+		for _, gpuUUID := range sortGPUs(instaslice) {
+			gpu := gpus[gpuUUID]
+			sort.Slice(gpu.Slices, func(i, j int) bool {
+				return gpu.Slices[i].Start < gpu.Slices[j].Start
+			})
+			node.GPUs = append(node.GPUs, *gpu)
+		}
+		model.Nodes = append(model.Nodes, node)
+	}
+	sort.Slice(model.Nodes, func(i, j int) bool {
+		return model.Nodes[i].Name < model.Nodes[j].Name
+	})
+	return model
+}
+
+// PackingHandler serves the packing model of the cluster as JSON for dashboards
+func (r *InstasliceReconciler) PackingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		var instasliceList inferencev1alpha1.InstasliceList
+		if err := r.List(req.Context(), &instasliceList, client.InNamespace(r.Config.OperatorNamespace)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(packingModel(instasliceList.Items)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestPackingHandler(t *testing.T) {
+	podA := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "default", UID: "pod-a-uid"}}
+	ungated := inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	}
+	node2 := newTestAllocation("node-2", podA, ungated)
+	// a 2g.10gb slice behind pod-a on the same GPU
+	node2.Spec.PodAllocationRequests["pod-b-uid"] = inferencev1alpha1.AllocationRequest{
+		Profile: "2g.10gb",
+		PodRef:  v1.ObjectReference{Kind: "Pod", Name: "pod-b", Namespace: "team-b", UID: "pod-b-uid"},
+	}
+	node2.Status.PodAllocationResults["pod-b-uid"] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 2, Size: 2},
+		GPUUUID:          node2.Status.NodeResources.NodeGPUs[0].GPUUUID,
+		Nodename:         types.NodeName("node-2"),
+		AllocationStatus: ungated,
+	}
+	// a slice already deleted by the daemonset is not part of the packing
+	node2.Spec.PodAllocationRequests["pod-c-uid"] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Kind: "Pod", Name: "pod-c", Namespace: "default", UID: "pod-c-uid"},
+	}
+	node2.Status.PodAllocationResults["pod-c-uid"] = inferencev1alpha1.AllocationResult{
+		MigPlacement: inferencev1alpha1.Placement{Start: 0, Size: 1},
+		GPUUUID:      node2.Status.NodeResources.NodeGPUs[1].GPUUUID,
+		Nodename:     types.NodeName("node-2"),
+		AllocationStatus: inferencev1alpha1.AllocationStatus{
+			AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusDeleted,
+			AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+		},
+	}
+	node1 := utils.GenerateFakeCapacity("node-1")
+	r, _ := newTestReconciler(t, node2, node1)
+	handler := r.PackingHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/packing", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/packing", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"nodes": [
+			{
+				"name": "node-1",
+				"gpus": [
+					{"uuid": "GPU-31cfe05c-ed13-cd17-d7aa-c63db5108c24", "model": "NVIDIA A100-PCIE-40GB", "totalSlices": 8, "freeSlices": 8, "slices": []},
+					{"uuid": "GPU-8d042338-e67f-9c48-92b4-5b55c7e5133c", "model": "NVIDIA A100-PCIE-40GB", "totalSlices": 8, "freeSlices": 8, "slices": []}
+				]
+			},
+			{
+				"name": "node-2",
+				"gpus": [
+					{"uuid": "GPU-31cfe05c-ed13-cd17-d7aa-c63db5108c24", "model": "NVIDIA A100-PCIE-40GB", "totalSlices": 8, "freeSlices": 8, "slices": []},
+					{
+						"uuid": "GPU-8d042338-e67f-9c48-92b4-5b55c7e5133c", "model": "NVIDIA A100-PCIE-40GB", "totalSlices": 8, "freeSlices": 5,
+						"slices": [
+							{
+								"profile": "1g.5gb", "start": 0, "size": 1,
+								"status": {"allocationStatusDaemonset": "created", "allocationStatusController": "ungated"},
+								"pod": {"namespace": "default", "name": "pod-a", "uid": "pod-a-uid"}
+							},
+							{
+								"profile": "2g.10gb", "start": 2, "size": 2,
+								"status": {"allocationStatusDaemonset": "created", "allocationStatusController": "ungated"},
+								"pod": {"namespace": "team-b", "name": "pod-b", "uid": "pod-b-uid"}
+							}
+						]
+					}
+				]
+			}
+		]
+	}`, recorder.Body.String())
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// matchesPin reports whether the pod may be placed on the Instaslice. A pod annotated with a node, or with a
+// GPU UUID e.g. for NVLink locality or debugging, is placed on that node or the node hosting that GPU only.
+// Pods without the annotations may be placed on any Instaslice.
+func matchesPin(pod *v1.Pod, instaslice *inferencev1alpha1.Instaslice) bool {
+	if node := strings.TrimSpace(pod.Annotations[PinNodeAnnotation]); node != "" && node != instaslice.Name {
+		return false
+	}
+	if gpuUUID := strings.TrimSpace(pod.Annotations[PinGPUAnnotation]); gpuUUID != "" && !hostsGPU(instaslice, gpuUUID) {
+		return false
+	}
+	return true
+}
+
+// pinnedGPUs keeps the GPU the pod is pinned to, every GPU is kept for pods not pinned to one
+func pinnedGPUs(gpuUUIDs []string, pod *v1.Pod) []string {
+	gpuUUID := strings.TrimSpace(pod.Annotations[PinGPUAnnotation])
+	if gpuUUID == "" {
+		return gpuUUIDs
+	}
+	if slices.Contains(gpuUUIDs, gpuUUID) {
+		return []string{gpuUUID}
+	}
+	return nil
+}
+
+// podPin describes the node and GPU the pod is pinned to, empty when it is not pinned
+func podPin(pod *v1.Pod) string {
+	var pins []string
+	if node := strings.TrimSpace(pod.Annotations[PinNodeAnnotation]); node != "" {
+		pins = append(pins, fmt.Sprintf("node %s", node))
+	}
+	if gpuUUID := strings.TrimSpace(pod.Annotations[PinGPUAnnotation]); gpuUUID != "" {
+		pins = append(pins, fmt.Sprintf("GPU %s", gpuUUID))
+	}
+	return strings.Join(pins, " and ")
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestReconcile_Pinning(t *testing.T) {
+	const secondGPU = "GPU-31cfe05c-ed13-cd17-d7aa-c63db5108c24"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		// full leaves no room on node-b
+		full bool
+		node string
+		gpu  string
+	}{
+		{
+			name: "not pinned",
+			node: "node-a",
+		},
+		{
+			name:        "pinned to a node",
+			annotations: map[string]string{PinNodeAnnotation: "node-b"},
+			node:        "node-b",
+		},
+		{
+			name:        "pinned to a GPU",
+			annotations: map[string]string{PinNodeAnnotation: "node-b", PinGPUAnnotation: secondGPU},
+			node:        "node-b",
+			gpu:         secondGPU,
+		},
+		{
+			name:        "pinned node is full",
+			annotations: map[string]string{PinNodeAnnotation: "node-b"},
+			full:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Annotations = tt.annotations
+			nodeB := utils.GenerateFakeCapacity("node-b")
+			if tt.full {
+				for _, gpu := range nodeB.Status.NodeResources.NodeGPUs {
+					nodeB.Spec.DisabledGPUs = append(nodeB.Spec.DisabledGPUs, gpu.GPUUUID)
+				}
+			}
+			r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-a"), nodeB)
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+			slices := podSlices(pod.UID, instasliceList.Items)
+			if tt.full {
+				// the pod stays gated rather than landing on another node
+				assert.Empty(t, slices)
+				if assert.Len(t, recorder.Events, 1) {
+					event := <-recorder.Events
+					assert.Contains(t, event, "PinnedCapacityUnavailable")
+					assert.Contains(t, event, "node node-b")
+				}
+				return
+			}
+			if assert.Len(t, slices, 1) {
+				assert.Equal(t, tt.node, slices[0].instasliceName)
+				if tt.gpu != "" {
+					assert.Equal(t, tt.gpu, slices[0].result.GPUUUID)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchesPin(t *testing.T) {
+	instaslice := utils.GenerateFakeCapacity("node-a")
+	gpuUUID := instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+
+	assert.True(t, matchesPin(pod, instaslice))
+	pod.Annotations = map[string]string{PinGPUAnnotation: gpuUUID}
+	assert.True(t, matchesPin(pod, instaslice))
+	assert.Equal(t, []string{gpuUUID}, pinnedGPUs([]string{"GPU-other", gpuUUID}, pod))
+	pod.Annotations = map[string]string{PinGPUAnnotation: "GPU-other"}
+	assert.False(t, matchesPin(pod, instaslice))
+	assert.Empty(t, pinnedGPUs([]string{gpuUUID}, pod))
+	pod.Annotations = map[string]string{PinNodeAnnotation: "node-b"}
+	assert.False(t, matchesPin(pod, instaslice))
+	assert.Equal(t, "node node-b", podPin(pod))
+}
@@ -28,7 +28,10 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/openshift/instaslice-operator/internal/controller/config"
 )
 
 //+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=instaslice.redhat.com,admissionReviewVersions=v1
@@ -36,6 +39,8 @@ import (
 type PodAnnotator struct {
 	Client  client.Client
 	Decoder admission.Decoder
+	// Config names the scheduling gate and finalizer set on the pods, the defaults are used without it
+	Config *config.Config
 }
 
 func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admission.Response {
@@ -46,17 +51,24 @@ func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admiss
 		return admission.Errored(400, fmt.Errorf("could not decode pod: %v", err))
 	}
 
-	if !hasMIGResource(pod) {
+	if !hasMIGResource(pod) && !requestsMIGProfile(pod) {
 		return admission.Allowed("No nvidia.com/mig-* resource found, skipping mutation.")
 	}
+	// a pod gated with nothing left to transform, e.g. when the webhook is invoked again, is left as is
+	if !hasMIGResource(pod) && checkIfPodGatedByInstaSlice(pod, configuredGateName(a.Config)) {
+		return admission.Allowed("Pod is already gated by InstaSlice, skipping mutation.")
+	}
 
 	performQuotaArithmetic(pod, req)
 
+	// sidecars without a MIG resource are left untouched
+	gpuContainer := &pod.Spec.Containers[migContainerIndex(pod)]
+
 	// Transform resource requests from nvidia.com/mig-* to instaslice.redhat.com/mig-*
-	transformResources(&pod.Spec.Containers[0].Resources)
+	transformResources(&gpuContainer.Resources)
 
 	// Add scheduling
-	schedulingGateName := GateName
+	schedulingGateName := configuredGateName(a.Config)
 	found := false
 	for _, gate := range pod.Spec.SchedulingGates {
 		if gate.Name == schedulingGateName {
@@ -67,14 +79,16 @@ func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admiss
 	if !found {
 		pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: schedulingGateName})
 	}
+	// the finalizer guards the allocation from the start, the controller would add it on the first reconcile
+	controllerutil.AddFinalizer(pod, configuredFinalizerName(a.Config))
 
 	// Generate an extended resource name based on the pod name
 	uuidStr := uuid.New().String()
 
 	// Add envFrom with a unique ConfigMap name derived from the pod name
 	configMapName := uuidStr
-	// Support for only one pod workloads
-	pod.Spec.Containers[0].EnvFrom = append(pod.Spec.Containers[0].EnvFrom, v1.EnvFromSource{
+	// Support for only one GPU container per pod
+	gpuContainer.EnvFrom = append(gpuContainer.EnvFrom, v1.EnvFromSource{
 		ConfigMapRef: &v1.ConfigMapEnvSource{
 			LocalObjectReference: v1.LocalObjectReference{Name: configMapName},
 		},
@@ -89,6 +103,21 @@ func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admiss
 	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
 }
 
+// migContainerIndex returns the index of the first container of the pod with a `nvidia.com/mig-*` or
+// `instaslice.redhat.com/mig-*` resource, 0 when there is none
+func migContainerIndex(pod *v1.Pod) int {
+	for i, container := range pod.Spec.Containers {
+		for _, resources := range []v1.ResourceList{container.Resources.Limits, container.Resources.Requests} {
+			for resourceName := range resources {
+				if strings.HasPrefix(string(resourceName), NvidiaMIGPrefix) || strings.HasPrefix(string(resourceName), OrgInstaslicePrefix+"mig-") {
+					return i
+				}
+			}
+		}
+	}
+	return 0
+}
+
 // hasMIGResource checks if a pod has resource requests or limits with a key that matches `nvidia.com/mig-*`
 func hasMIGResource(pod *v1.Pod) bool {
 	for _, container := range pod.Spec.Containers {
@@ -108,12 +137,27 @@ func hasMIGResource(pod *v1.Pod) bool {
 	return false
 }
 
+// requestsMIGProfile checks if a container of the pod requests a MIG profile under any resource prefix, e.g.
+// instaslice.redhat.com/mig-* requested directly, or the pod requests one through the profile label or annotation
+func requestsMIGProfile(pod *v1.Pod) bool {
+	if _, _, ok := metadataProfile(pod); ok {
+		return true
+	}
+	// the profile is read the way the controller reads it when allocating
+	for _, container := range pod.Spec.Containers {
+		if len(extractProfileNames(container.Resources.Limits)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func performQuotaArithmetic(pod *v1.Pod, req admission.Request) admission.Response {
 	// assumption is that workloads will have 1 container where
 	// MIG is requested.
 	// TODO instead of only iterating over regular containers,
 	// we should also consider other types of containers (such as init containers) in future
-	for _, container := range pod.Spec.Containers {
+	for i, container := range pod.Spec.Containers {
 		// dont bother checking requests section. Nvidia supports only limits
 		// if requests is added by user, it should be equal to limits.
 		for resourceName, quantity := range container.Resources.Limits {
@@ -127,10 +171,10 @@ func performQuotaArithmetic(pod *v1.Pod, req admission.Request) admission.Respon
 					return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to parse memory value: %v", err))
 				}
 				acceleratorMemory := memoryValue * int(quantity.Value())
-				// assume 1 container workload
+				// the quota is charged to the container requesting the slice
 				// Convert the string to ResourceName
 				resourceName := v1.ResourceName(QuotaResourceName)
-				pod.Spec.Containers[0].Resources.Limits[resourceName] = resource.MustParse(fmt.Sprintf("%dGi", acceleratorMemory))
+				pod.Spec.Containers[i].Resources.Limits[resourceName] = resource.MustParse(fmt.Sprintf("%dGi", acceleratorMemory))
 			}
 		}
 	}
@@ -31,6 +31,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/openshift/instaslice-operator/internal/controller/config"
 )
 
 func TestHandle(t *testing.T) {
@@ -50,6 +52,8 @@ func TestHandle(t *testing.T) {
 		pod           *v1.Pod
 		expectMut     bool
 		expectedLimit string
+		// gpuContainer is the index of the container requesting the MIG profile
+		gpuContainer int
 	}{
 		{
 			name: "Pod without nvidia.com/mig-* resource",
@@ -93,6 +97,32 @@ func TestHandle(t *testing.T) {
 			expectMut:     true,
 			expectedLimit: "5Gi",
 		},
+		{
+			name: "Pod with a sidecar before the nvidia.com/mig-1g.5gb container",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pod-with-sidecar",
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Name: "sidecar",
+						},
+						{
+							Name: "gpu",
+							Resources: v1.ResourceRequirements{
+								Limits: v1.ResourceList{
+									"nvidia.com/mig-1g.5gb": resource.MustParse("1"),
+								},
+							},
+						},
+					},
+				},
+			},
+			expectMut:     true,
+			expectedLimit: "5Gi",
+			gpuContainer:  1,
+		},
 	}
 
 	for _, tt := range tests {
@@ -128,7 +158,7 @@ func TestHandle(t *testing.T) {
 				modifiedPod := &v1.Pod{}
 				g.Expect(json.Unmarshal(patchedPodBytes, modifiedPod)).To(Succeed(), "Failed to unmarshal patched pod")
 
-				actualMemory, found := modifiedPod.Spec.Containers[0].Resources.Limits[v1.ResourceName(instasliceQuotaResourceName)]
+				actualMemory, found := modifiedPod.Spec.Containers[tt.gpuContainer].Resources.Limits[v1.ResourceName(instasliceQuotaResourceName)]
 				g.Expect(found).To(BeTrue(), fmt.Sprintf("%s limit not found in the modified pod", instasliceQuotaResourceName))
 				expectedMemory := resource.MustParse(tt.expectedLimit)
 				g.Expect(actualMemory.Cmp(expectedMemory)).To(Equal(0), fmt.Sprintf("Expected %s to be %s", instasliceQuotaResourceName, tt.expectedLimit))
@@ -215,3 +245,125 @@ func TestTransformResources(t *testing.T) {
 		})
 	}
 }
+
+func TestHandle_SchedulingGate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	annotator := &PodAnnotator{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+	migPod := func(resourceName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{v1.ResourceName(resourceName): resource.MustParse("1")},
+					},
+				}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		pod       *v1.Pod
+		expectMut bool
+	}{
+		{name: "nvidia.com/mig-* resource", pod: migPod(NvidiaMIGPrefix + "1g.5gb"), expectMut: true},
+		{name: "instaslice.redhat.com/mig-* resource", pod: migPod(OrgInstaslicePrefix + "mig-1g.5gb"), expectMut: true},
+		{
+			name: "already gated",
+			pod: func() *v1.Pod {
+				pod := migPod(OrgInstaslicePrefix + "mig-1g.5gb")
+				pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: GateName}}
+				pod.Finalizers = []string{FinalizerName}
+				return pod
+			}(),
+		},
+		{name: "no MIG resource", pod: migPod("cpu")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			rawPod, err := json.Marshal(tt.pod)
+			g.Expect(err).NotTo(HaveOccurred())
+			resp := annotator.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Object: runtime.RawExtension{Raw: rawPod},
+				},
+			})
+			g.Expect(resp.Allowed).To(BeTrue())
+			if !tt.expectMut {
+				g.Expect(resp.Patches).To(BeEmpty(), "Expected no patches but found some")
+				return
+			}
+
+			patchBytes, err := json.Marshal(resp.Patches)
+			g.Expect(err).NotTo(HaveOccurred())
+			patch, err := jsonpatch.DecodePatch(patchBytes)
+			g.Expect(err).NotTo(HaveOccurred())
+			patchedPodBytes, err := patch.Apply(rawPod)
+			g.Expect(err).NotTo(HaveOccurred())
+			modifiedPod := &v1.Pod{}
+			g.Expect(json.Unmarshal(patchedPodBytes, modifiedPod)).To(Succeed())
+			g.Expect(modifiedPod.Spec.SchedulingGates).To(ConsistOf(v1.PodSchedulingGate{Name: GateName}))
+			g.Expect(modifiedPod.Finalizers).To(ConsistOf(FinalizerName))
+			g.Expect(modifiedPod.Spec.Containers[0].Resources.Limits).To(HaveKey(v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb")))
+
+			// the mutated pod is left as is when the webhook is invoked again
+			resp = annotator.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Object: runtime.RawExtension{Raw: patchedPodBytes},
+				},
+			})
+			g.Expect(resp.Allowed).To(BeTrue())
+			g.Expect(resp.Patches).To(BeEmpty(), "Expected no patches on reinvocation but found some")
+		})
+	}
+}
+
+func TestHandle_ConfiguredGateName(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	cfg := config.NewConfig()
+	cfg.GateName = "example.com/staging-accelerator"
+	cfg.FinalizerName = "example.com/staging-finalizer"
+	annotator := &PodAnnotator{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Decoder: admission.NewDecoder(scheme),
+		Config:  cfg,
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceName(OrgInstaslicePrefix + "mig-1g.5gb"): resource.MustParse("1")},
+				},
+			}},
+		},
+	}
+	rawPod, err := json.Marshal(pod)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	resp := annotator.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: rawPod},
+		},
+	})
+	g.Expect(resp.Allowed).To(BeTrue())
+	patchBytes, err := json.Marshal(resp.Patches)
+	g.Expect(err).NotTo(HaveOccurred())
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	g.Expect(err).NotTo(HaveOccurred())
+	patchedPodBytes, err := patch.Apply(rawPod)
+	g.Expect(err).NotTo(HaveOccurred())
+	modifiedPod := &v1.Pod{}
+	g.Expect(json.Unmarshal(patchedPodBytes, modifiedPod)).To(Succeed())
+	g.Expect(modifiedPod.Spec.SchedulingGates).To(ConsistOf(v1.PodSchedulingGate{Name: cfg.GateName}))
+	g.Expect(modifiedPod.Finalizers).To(ConsistOf(cfg.FinalizerName))
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// preemptionVictim is a pod of strictly lower priority holding slices on a node
+type preemptionVictim struct {
+	pod *v1.Pod
+	// keys are the allocations of the pod on the node
+	keys []types.UID
+}
+
+// preemptionNomination is the node slices were preempted on for a pod and the slices the pod waits for there
+type preemptionNomination struct {
+	nodeName    string
+	pod         *v1.Pod
+	profileName string
+	sliceCount  int32
+}
+
+// preemptionNominations remembers per pod the node slices were preempted on for it, the freed capacity is
+// held for the pod until it is allocated so that no other pod takes it
+type preemptionNominations struct {
+	mu          sync.Mutex
+	nominations map[types.UID]preemptionNomination
+}
+
+// nominate holds the capacity of the node freed for the pod
+func (n *preemptionNominations) nominate(nomination preemptionNomination) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.nominations == nil {
+		n.nominations = make(map[types.UID]preemptionNomination)
+	}
+	n.nominations[nomination.pod.UID] = nomination
+}
+
+// nominatedNode returns the node slices were preempted on for the pod, empty when none were
+func (n *preemptionNominations) nominatedNode(podUID types.UID) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.nominations[podUID].nodeName
+}
+
+// heldFrom returns the nominations on the node of other pods of at least the priority of the pod, highest
+// priority first
+func (n *preemptionNominations) heldFrom(nodeName string, pod *v1.Pod) []preemptionNomination {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var held []preemptionNomination
+	for podUID, nomination := range n.nominations {
+		if podUID != pod.UID && nomination.nodeName == nodeName && podPriority(nomination.pod) >= podPriority(pod) {
+			held = append(held, nomination)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool {
+		if priorityI, priorityJ := podPriority(held[i].pod), podPriority(held[j].pod); priorityI != priorityJ {
+			return priorityI > priorityJ
+		}
+		return held[i].pod.Name < held[j].pod.Name
+	})
+	return held
+}
+
+// forget releases the capacity held for the pod once it is allocated or gone
+func (n *preemptionNominations) forget(podUID types.UID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.nominations, podUID)
+}
+
+// podPriority returns the priority of the pod, pods without one have priority 0
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// takesPreemptedCapacity reports whether booking the allocations of the pod on the Instaslice would leave no
+// room for the slices of a pod of at least its priority that preempted slices there
+func (r *InstasliceReconciler) takesPreemptedCapacity(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod, allocations []*AllocationDetails, policy AllocationPolicy) bool {
+	held := r.preemptionNominations.heldFrom(instaslice.Name, pod)
+	if len(held) == 0 {
+		return false
+	}
+	booked := instaslice.DeepCopy()
+	for _, allocation := range allocations {
+		bookAllocation(booked, allocation.Request, allocation.Result)
+	}
+	for _, nomination := range held {
+		placed, err := r.placeSlicesOnInstaslice(booked, nomination.profileName, policy, nomination.pod, nomination.sliceCount, nil, time.Now())
+		if err != nil {
+			return true
+		}
+		for _, allocation := range placed {
+			bookAllocation(booked, allocation.Request, allocation.Result)
+		}
+	}
+	return false
+}
+
+// preemptForPod releases the slices of strictly lower priority pods so that the slices of the profile the
+// pod asks for fit a node on the next loop. The victims are picked on a single node as the slices of a pod
+// share it, lowest priority first, until the indexes they free let the slices be placed there. Victims whose
+// slices turn out not to be needed are spared. Slices already being released count as freed, no further pod
+// is preempted while they are torn down. The freed capacity is held for the pod until it is allocated. It
+// reports whether slices are freed for the pod.
+func (r *InstasliceReconciler) preemptForPod(ctx context.Context, pod *v1.Pod, profileName string, sliceCount int32, policy AllocationPolicy, instaslices []inferencev1alpha1.Instaslice) (bool, error) {
+	priority := podPriority(pod)
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		if !matchesPin(pod, instaslice) {
+			continue
+		}
+		victims, err := r.preemptionVictims(ctx, instaslice, priority)
+		if err != nil {
+			return false, err
+		}
+		// the slices being released are gone by the time the pod is allocated
+		released := instaslice.DeepCopy()
+		for key, allocResult := range released.Status.PodAllocationResults {
+			if isReleasing(allocResult) {
+				dropAllocation(released, key)
+			}
+		}
+		if r.slicesFit(released, profileName, policy, pod, sliceCount) {
+			r.preemptionNominations.nominate(preemptionNomination{nodeName: instaslice.Name, pod: pod, profileName: profileName, sliceCount: sliceCount})
+			return true, nil
+		}
+		for n := range victims {
+			for _, key := range victims[n].keys {
+				dropAllocation(released, key)
+			}
+			if !r.slicesFit(released, profileName, policy, pod, sliceCount) {
+				continue
+			}
+			chosen := r.spareVictims(instaslice, released, victims[:n+1], profileName, policy, pod, sliceCount)
+			for _, victim := range chosen {
+				if err := r.preempt(ctx, pod, victim.pod, profileName); err != nil {
+					return false, err
+				}
+			}
+			r.preemptionNominations.nominate(preemptionNomination{nodeName: instaslice.Name, pod: pod, profileName: profileName, sliceCount: sliceCount})
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// spareVictims returns the victims whose slices are needed for the slices of the pod to fit the Instaslice
+// the slices of every victim are dropped from. The highest priority victims are spared first.
+func (r *InstasliceReconciler) spareVictims(instaslice, released *inferencev1alpha1.Instaslice, victims []preemptionVictim, profileName string, policy AllocationPolicy, pod *v1.Pod, sliceCount int32) []preemptionVictim {
+	chosen := make([]preemptionVictim, 0, len(victims))
+	for n := len(victims) - 1; n >= 0; n-- {
+		spared := released.DeepCopy()
+		for _, key := range victims[n].keys {
+			spared.Spec.PodAllocationRequests[key] = instaslice.Spec.PodAllocationRequests[key]
+			spared.Status.PodAllocationResults[key] = instaslice.Status.PodAllocationResults[key]
+		}
+		if r.slicesFit(spared, profileName, policy, pod, sliceCount) {
+			released = spared
+			continue
+		}
+		chosen = append(chosen, victims[n])
+	}
+	return chosen
+}
+
+// slicesFit reports whether the slices of the profile for the pod can be placed on the Instaslice, it is
+// left untouched
+func (r *InstasliceReconciler) slicesFit(instaslice *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod, sliceCount int32) bool {
+	_, err := r.placeSlicesOnInstaslice(instaslice.DeepCopy(), profileName, policy, pod, sliceCount, nil, time.Now())
+	return err == nil
+}
+
+// isReleasing reports whether the slice of the allocation is being torn down or already is
+func isReleasing(allocResult inferencev1alpha1.AllocationResult) bool {
+	return allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+		allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted
+}
+
+// dropAllocation removes the allocation from the Instaslice
+func dropAllocation(instaslice *inferencev1alpha1.Instaslice, key types.UID) {
+	delete(instaslice.Spec.PodAllocationRequests, key)
+	delete(instaslice.Status.PodAllocationResults, key)
+}
+
+// preemptionVictims returns the pods of strictly lower priority than the given one holding slices on the
+// Instaslice, lowest priority first. Warm slices and slices being released are left alone.
+func (r *InstasliceReconciler) preemptionVictims(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, priority int32) ([]preemptionVictim, error) {
+	victimKeys := make(map[types.UID][]types.UID)
+	podRefs := make(map[types.UID]v1.ObjectReference)
+	for key, allocRequest := range instaslice.Spec.PodAllocationRequests {
+		allocResult, ok := instaslice.Status.PodAllocationResults[key]
+		if !ok || isWarmSlice(allocRequest) || isReleasing(allocResult) {
+			continue
+		}
+		victimKeys[allocRequest.PodRef.UID] = append(victimKeys[allocRequest.PodRef.UID], key)
+		podRefs[allocRequest.PodRef.UID] = allocRequest.PodRef
+	}
+	victims := make([]preemptionVictim, 0, len(victimKeys))
+	for podUID, podRef := range podRefs {
+		victim := &v1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Name: podRef.Name, Namespace: podRef.Namespace}, victim); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if victim.UID != podUID || !victim.DeletionTimestamp.IsZero() || isCriticalPod(victim) || podPriority(victim) >= priority {
+			continue
+		}
+		victims = append(victims, preemptionVictim{pod: victim, keys: victimKeys[podUID]})
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		if priorityI, priorityJ := podPriority(victims[i].pod), podPriority(victims[j].pod); priorityI != priorityJ {
+			return priorityI < priorityJ
+		}
+		return victims[i].pod.Name < victims[j].pod.Name
+	})
+	return victims, nil
+}
+
+// preempt releases the slices of the victim for the pod, a running victim is evicted and a gated one is
+// allocated afresh once its slices are gone
+func (r *InstasliceReconciler) preempt(ctx context.Context, pod, victim *v1.Pod, profileName string) error {
+	logr.FromContext(ctx).WithName(LogSubsystemDeletion).Info("preempting lower priority pod", "pod", pod.Name, "priority", podPriority(pod),
+		"victim", victim.Name, "victimPriority", podPriority(victim), "profile", profileName)
+	// a teardown awaiting approval is asked for again on the next loop
+	if err := r.ReleaseAllocation(ctx, victim.UID); err != nil && !errors.IsNotFound(err) && err != errTeardownPending {
+		return err
+	}
+	if r.Recorder != nil {
+		r.Recorder.Event(pod, v1.EventTypeNormal, "Preempting",
+			fmt.Sprintf("InstaSlice preempted pod %s/%s with priority %d to free %s slices for pod %s with priority %d",
+				victim.Namespace, victim.Name, podPriority(victim), profileName, pod.Name, podPriority(pod)))
+		r.Recorder.Event(victim, v1.EventTypeWarning, "Preempted",
+			fmt.Sprintf("InstaSlice slices of pod %s were preempted by pod %s/%s with higher priority %d",
+				victim.Name, pod.Namespace, pod.Name, podPriority(pod)))
+	}
+	return nil
+}
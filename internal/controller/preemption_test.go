@@ -0,0 +1,206 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestReconcile_Preemption(t *testing.T) {
+	tests := []struct {
+		name             string
+		enabled          bool
+		priority         int32
+		victimPriority   int32
+		expectPreemption bool
+	}{
+		{name: "higher priority pod preempts", enabled: true, priority: 1000, victimPriority: 0, expectPreemption: true},
+		{name: "equal priority pod waits", enabled: true, priority: 1000, victimPriority: 1000},
+		{name: "preemption disabled", priority: 1000, victimPriority: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			// the victim runs on the only enabled GPU of the node, taking all of it
+			victim := newTestGatedPod("dev", "7g.40gb")
+			victim.Spec.SchedulingGates = nil
+			victim.Spec.Priority = &tt.victimPriority
+			instaslice := newTestAllocation("node-1", victim, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			})
+			allocRequest := instaslice.Spec.PodAllocationRequests[victim.UID]
+			allocRequest.Profile = "7g.40gb"
+			instaslice.Spec.PodAllocationRequests[victim.UID] = allocRequest
+			allocResult := instaslice.Status.PodAllocationResults[victim.UID]
+			allocResult.MigPlacement = inferencev1alpha1.Placement{Start: 0, Size: 8}
+			instaslice.Status.PodAllocationResults[victim.UID] = allocResult
+			instaslice.Spec.DisabledGPUs = []string{instaslice.Status.NodeResources.NodeGPUs[1].GPUUUID}
+
+			pod := newTestGatedPod("inference", "7g.40gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Spec.Priority = &tt.priority
+			r, fakeClient := newTestReconciler(t, pod, victim, instaslice)
+			r.Config.EnablePreemption = tt.enabled
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			victimResult := instaslice.Status.PodAllocationResults[victim.UID]
+			assert.False(t, hasPodAllocation(pod.UID, []inferencev1alpha1.Instaslice{*instaslice}))
+			if !tt.expectPreemption {
+				assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, victimResult.AllocationStatus.AllocationStatusController)
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, victimResult.AllocationStatus.AllocationStatusController)
+			assert.Equal(t, Requeue2sDelay, result.RequeueAfter)
+			if assert.Len(t, recorder.Events, 2) {
+				assert.Contains(t, <-recorder.Events, "Preempting")
+				assert.Contains(t, <-recorder.Events, "Preempted")
+			}
+			// the victim is evicted from its slice
+			assert.Error(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(victim), &v1.Pod{}))
+
+			// no further pod is preempted while the slice is torn down
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Empty(t, recorder.Events)
+		})
+	}
+}
+
+func TestReconcile_PreemptionVictimsFit(t *testing.T) {
+	type holder struct {
+		name     string
+		priority int32
+		start    int32
+	}
+	tests := []struct {
+		name      string
+		holders   []holder
+		preempted []string
+	}{
+		{
+			name:      "slices of another profile are preempted",
+			holders:   []holder{{name: "low", start: 0}, {name: "high", priority: 2000, start: 4}},
+			preempted: []string{"low"},
+		},
+		{
+			name:      "victims not in the way of the slice are spared",
+			holders:   []holder{{name: "low", start: 0}, {name: "mid", priority: 500, start: 4}},
+			preempted: []string{"low"},
+		},
+		{
+			name:      "the lowest priority victim is spared when freeing it does not fit the slice",
+			holders:   []holder{{name: "low", start: 0}, {name: "mid", priority: 500, start: 4}, {name: "high", priority: 2000, start: 1}},
+			preempted: []string{"mid"},
+		},
+		{
+			name:    "no victim frees a fitting span",
+			holders: []holder{{name: "low", start: 0}, {name: "high", priority: 2000, start: 1}, {name: "top", priority: 2000, start: 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			// every holder runs a 1g.5gb slice on the only enabled GPU of the node
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			instaslice.Spec.DisabledGPUs = []string{instaslice.Status.NodeResources.NodeGPUs[1].GPUUUID}
+			objs := []client.Object{instaslice}
+			for _, holder := range tt.holders {
+				holderPod := newTestGatedPod(holder.name, "1g.5gb")
+				holderPod.Spec.SchedulingGates = nil
+				holderPod.Spec.Priority = &holder.priority
+				allocation := newTestAllocation("node-1", holderPod, inferencev1alpha1.AllocationStatus{
+					AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+					AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+				})
+				allocResult := allocation.Status.PodAllocationResults[holderPod.UID]
+				allocResult.MigPlacement.Start = holder.start
+				instaslice.Spec.PodAllocationRequests[holderPod.UID] = allocation.Spec.PodAllocationRequests[holderPod.UID]
+				instaslice.Status.PodAllocationResults[holderPod.UID] = allocResult
+				objs = append(objs, holderPod)
+			}
+			// the pod asks for a 3g.20gb slice, placed at index 0 or 4 only
+			pod := newTestGatedPod("inference", "3g.20gb")
+			pod.Finalizers = []string{FinalizerName}
+			priority := int32(1000)
+			pod.Spec.Priority = &priority
+			objs = append(objs, pod)
+			r, fakeClient := newTestReconciler(t, objs...)
+			r.Config.EnablePreemption = true
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			var preempted []string
+			for _, holder := range tt.holders {
+				allocResult := instaslice.Status.PodAllocationResults[types.UID(holder.name+"-uid")]
+				if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting {
+					preempted = append(preempted, holder.name)
+				}
+			}
+			assert.Equal(t, tt.preempted, preempted)
+			if len(tt.preempted) > 0 {
+				assert.Equal(t, "node-1", r.preemptionNominations.nominatedNode(pod.UID))
+			}
+		})
+	}
+}
+
+func TestReconcile_PreemptionNomination(t *testing.T) {
+	ctx := context.TODO()
+	// the slices of the victim are torn down already, the capacity they free is held for the preemptor
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	instaslice.Spec.DisabledGPUs = []string{instaslice.Status.NodeResources.NodeGPUs[1].GPUUUID}
+	pod := newTestGatedPod("inference", "7g.40gb")
+	pod.Finalizers = []string{FinalizerName}
+	priority := int32(1000)
+	pod.Spec.Priority = &priority
+	other := newTestGatedPod("batch", "1g.5gb")
+	other.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, other, instaslice)
+	r.Config.EnablePreemption = true
+	r.preemptionNominations.nominate(preemptionNomination{nodeName: "node-1", pod: pod, profileName: "7g.40gb", sliceCount: 1})
+
+	// a lower priority pod does not take the freed capacity
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(other)})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	assert.False(t, hasPodAllocation(other.UID, []inferencev1alpha1.Instaslice{*instaslice}))
+
+	// the preemptor is allocated on them and its nomination is dropped
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	assert.True(t, hasPodAllocation(pod.UID, []inferencev1alpha1.Instaslice{*instaslice}))
+	assert.Empty(t, r.preemptionNominations.nominatedNode(pod.UID))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// profileNamePattern matches a profile name such as 1g.5gb, within a resource name as well
+var profileNamePattern = regexp.MustCompile(`\d+g\.\d+gb`)
+
+// isProfileName reports whether the value is a profile name and nothing else
+func isProfileName(value string) bool {
+	return value != "" && profileNamePattern.FindString(value) == value
+}
+
+// metadataProfile returns the profile the pod requests through its profile label, or else its profile
+// annotation, and where it was read from
+func metadataProfile(pod *v1.Pod) (string, string, bool) {
+	if profileName, ok := pod.Labels[ProfileLabel]; ok {
+		return profileName, "label " + ProfileLabel, true
+	}
+	if profileName, ok := pod.Annotations[ProfileAnnotation]; ok {
+		return profileName, "annotation " + ProfileAnnotation, true
+	}
+	return "", "", false
+}
+
+// requestLimits returns the limits the slices of the pod are read from. A pod that cannot set custom resources
+// requests its slices through the profile label or annotation and the count label instead, they are added to a
+// copy of the limits of its GPU container as the InstaSlice limit asking for the same slices. A profile
+// requested through the limits takes precedence over the label and annotation.
+func requestLimits(pod *v1.Pod, limits v1.ResourceList) (v1.ResourceList, error) {
+	profileName, source, ok := metadataProfile(pod)
+	if !ok {
+		return limits, nil
+	}
+	if len(extractProfileNames(limits)) > 0 {
+		return limits, nil
+	}
+	if !isProfileName(profileName) {
+		return nil, fmt.Errorf("%s=%s does not name a MIG profile", source, profileName)
+	}
+	count := int64(1)
+	if value, ok := pod.Labels[SliceCountLabel]; ok {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("label %s has malformed value %q, a positive whole number of slices is required", SliceCountLabel, value)
+		}
+		count = parsed
+	}
+	labelLimits := make(v1.ResourceList, len(limits)+1)
+	for name, quantity := range limits {
+		labelLimits[name] = quantity
+	}
+	labelLimits[v1.ResourceName(OrgInstaslicePrefix+"mig-"+profileName)] = *resource.NewQuantity(count, resource.DecimalSI)
+	return labelLimits, nil
+}
+
+// podProfileName returns the profile the GPU container of the pod asks for, through its limits or the profile
+// label or annotation of the pod, empty when it asks for none or the request is malformed
+func podProfileName(pod *v1.Pod, container *v1.Container) string {
+	limits, err := requestLimits(pod, container.Resources.Limits)
+	if err != nil {
+		return ""
+	}
+	profileName, err := extractProfileName(limits)
+	if err != nil {
+		return ""
+	}
+	return profileName
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// newTestLabeledPod returns a gated pod requesting its slices through the profile labels only
+func newTestLabeledPod(name string, labels map[string]string) *v1.Pod {
+	pod := newTestGatedPod(name, "1g.5gb")
+	pod.Labels = labels
+	pod.Spec.Containers[0].Resources.Limits = nil
+	return pod
+}
+
+func TestRequestLimits(t *testing.T) {
+	migResource := func(profileName string) v1.ResourceName {
+		return v1.ResourceName(OrgInstaslicePrefix + "mig-" + profileName)
+	}
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		limits      v1.ResourceList
+		profile     string
+		count       int32
+		wantErr     bool
+	}{
+		{
+			name:    "profile label",
+			labels:  map[string]string{ProfileLabel: "1g.5gb"},
+			limits:  v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+			profile: "1g.5gb",
+			count:   1,
+		},
+		{
+			name:    "profile and count labels",
+			labels:  map[string]string{ProfileLabel: "2g.10gb", SliceCountLabel: "3"},
+			profile: "2g.10gb",
+			count:   3,
+		},
+		{
+			name:    "limits take precedence over the labels",
+			labels:  map[string]string{ProfileLabel: "2g.10gb", SliceCountLabel: "3"},
+			limits:  v1.ResourceList{migResource("1g.5gb"): resource.MustParse("1")},
+			profile: "1g.5gb",
+			count:   1,
+		},
+		{
+			name:        "profile annotation only",
+			annotations: map[string]string{ProfileAnnotation: "3g.20gb"},
+			profile:     "3g.20gb",
+			count:       1,
+		},
+		{
+			name:        "profile annotation and count label",
+			labels:      map[string]string{SliceCountLabel: "2"},
+			annotations: map[string]string{ProfileAnnotation: "1g.5gb"},
+			profile:     "1g.5gb",
+			count:       2,
+		},
+		{
+			name:    "limits only",
+			limits:  v1.ResourceList{migResource("2g.10gb"): resource.MustParse("2")},
+			profile: "2g.10gb",
+			count:   2,
+		},
+		{
+			name:        "limits take precedence over the annotation",
+			annotations: map[string]string{ProfileAnnotation: "3g.20gb"},
+			limits:      v1.ResourceList{migResource("1g.5gb"): resource.MustParse("1")},
+			profile:     "1g.5gb",
+			count:       1,
+		},
+		{
+			name:        "malformed profile annotation",
+			annotations: map[string]string{ProfileAnnotation: "1g.5gb-large"},
+			wantErr:     true,
+		},
+		{
+			name:    "no labels",
+			limits:  v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+			profile: "",
+			count:   1,
+		},
+		{
+			name:    "malformed profile",
+			labels:  map[string]string{ProfileLabel: "large"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed count",
+			labels:  map[string]string{ProfileLabel: "1g.5gb", SliceCountLabel: "0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestLabeledPod("pod-1", tt.labels)
+			pod.Annotations = tt.annotations
+			containerLimits := len(tt.limits)
+			limits, err := requestLimits(pod, tt.limits)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			profileName, err := extractProfileName(limits)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.profile, profileName)
+			assert.Equal(t, tt.count, requestedSliceCount(limits, profileName))
+			if cpu, ok := tt.limits[v1.ResourceCPU]; ok {
+				assert.Equal(t, cpu, limits[v1.ResourceCPU])
+			}
+			// the limits of the container are left untouched
+			assert.Len(t, tt.limits, containerLimits)
+		})
+	}
+}
+
+func TestReconcile_LabelRequest(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestLabeledPod("pod-1", map[string]string{ProfileLabel: "1g.5gb", SliceCountLabel: "2"})
+	pod.Finalizers = []string{FinalizerName}
+	malformed := newTestLabeledPod("pod-2", map[string]string{ProfileLabel: "1g.5gb", SliceCountLabel: "many"})
+	malformed.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, malformed, utils.GenerateFakeCapacity("node-1"))
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	// both slices asked for through the labels are allocated
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	slices := podSlices(pod.UID, []inferencev1alpha1.Instaslice{*instaslice})
+	if assert.Len(t, slices, 2) {
+		for _, slice := range slices {
+			assert.Equal(t, "1g.5gb", slice.request.Profile)
+		}
+	}
+
+	// a malformed label request is reported instead of being allocated
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(malformed)})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(instaslice), instaslice))
+	assert.False(t, hasPodAllocation(malformed.UID, []inferencev1alpha1.Instaslice{*instaslice}))
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "MalformedProfileRequest")
+	}
+}
+
+func TestHandle_LabelRequest(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = v1.AddToScheme(scheme)
+	annotator := &PodAnnotator{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+	pod := newTestLabeledPod("pod-1", map[string]string{ProfileLabel: "1g.5gb"})
+	pod.Spec.SchedulingGates = nil
+	rawPod, err := json.Marshal(pod)
+	assert.NoError(t, err)
+
+	// a pod requesting its slices through the labels is gated like one requesting a MIG resource
+	resp := annotator.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: rawPod}},
+	})
+	assert.True(t, resp.Allowed)
+	gated := false
+	for _, patch := range resp.Patches {
+		if patch.Path == "/spec/schedulingGates" {
+			gated = true
+		}
+	}
+	assert.True(t, gated)
+}
+
+func TestReconcile_AnnotationRequest(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestLabeledPod("pod-1", nil)
+	pod.Annotations = map[string]string{ProfileAnnotation: "2g.10gb"}
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+
+	// the slice asked for through the annotation is allocated like one asked for through the limits
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	slices := podSlices(pod.UID, []inferencev1alpha1.Instaslice{*instaslice})
+	if assert.Len(t, slices, 1) {
+		assert.Equal(t, "2g.10gb", slices[0].request.Profile)
+	}
+	assert.True(t, requestsMIGProfile(pod))
+}
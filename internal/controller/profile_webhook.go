@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+)
+
+//+kubebuilder:webhook:path=/validate-v1-pod,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=vpod.instaslice.redhat.com,admissionReviewVersions=v1
+
+// profileSizePattern matches the compute units and the memory in GB of a profile name, e.g. 1g.5gb
+var profileSizePattern = regexp.MustCompile(`^(\d+)g\.(\d+)gb`)
+
+// ProfileValidator rejects gated pods requesting a MIG profile no GPU of the cluster offers, such pods
+// would otherwise stay gated forever. With thresholds configured it also rejects pods whose memory or
+// CPU requests are implausibly large for the slices they ask for.
+type ProfileValidator struct {
+	Client  client.Client
+	Decoder admission.Decoder
+	Config  *config.Config
+}
+
+func (a *ProfileValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &v1.Pod{}
+	if err := a.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("could not decode pod: %v", err))
+	}
+	if !checkIfPodGatedByInstaSlice(pod, configuredGateName(a.Config)) {
+		return admission.Allowed("pod is not gated by instaslice")
+	}
+	// the profile is read the way the controller reads it when allocating
+	container, err := gpuContainer(pod.Spec.Containers)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	limits, err := requestLimits(pod, container.Resources.Limits)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	profileName, err := extractProfileName(limits)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	if profileName == "" {
+		return admission.Allowed("no MIG profile requested")
+	}
+	if err := a.validateRequests(container, int64(requestedSliceCount(limits, profileName)), profileName); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := a.Client.List(ctx, &instasliceList); err != nil {
+		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("could not list instaslices: %v", err))
+	}
+	// until the daemonset discovered the GPUs there is nothing to validate against
+	if len(instasliceList.Items) == 0 {
+		return admission.Allowed("no GPU discovered yet")
+	}
+	profiles := map[string]struct{}{}
+	for _, instaslice := range instasliceList.Items {
+		for name := range instaslice.Status.NodeResources.MigPlacement {
+			profiles[name] = struct{}{}
+		}
+	}
+	if _, ok := profiles[profileName]; ok {
+		return admission.Allowed("")
+	}
+	validProfiles := make([]string, 0, len(profiles))
+	for name := range profiles {
+		validProfiles = append(validProfiles, name)
+	}
+	sort.Strings(validProfiles)
+	return admission.Denied(fmt.Sprintf("profile %s is not offered by any GPU of the cluster, valid profiles are: %s",
+		profileName, strings.Join(validProfiles, ", ")))
+}
+
+// validateRequests rejects memory and CPU requests of the container that exceed the configured thresholds
+// for the slices it asks for, a tiny slice next to a huge memory request is a sign of misconfiguration
+func (a *ProfileValidator) validateRequests(container *v1.Container, sliceCount int64, profileName string) error {
+	if a.Config == nil {
+		return nil
+	}
+	match := profileSizePattern.FindStringSubmatch(profileName)
+	if match == nil {
+		return nil
+	}
+	computeUnits, _ := strconv.ParseInt(match[1], 10, 64)
+	memoryGB, _ := strconv.ParseInt(match[2], 10, 64)
+
+	if a.Config.MaxMemoryPerSliceGB > 0 {
+		maxMemory := resource.NewQuantity(int64(a.Config.MaxMemoryPerSliceGB)*memoryGB*sliceCount<<30, resource.BinarySI)
+		if memory, ok := container.Resources.Requests[v1.ResourceMemory]; ok && memory.Cmp(*maxMemory) > 0 {
+			return fmt.Errorf("memory request %s of container %s is implausibly large for %d slice(s) of profile %s, at most %s is accepted",
+				memory.String(), container.Name, sliceCount, profileName, maxMemory.String())
+		}
+	}
+	if a.Config.MaxCPUPerSliceCompute > 0 {
+		maxCPU := resource.NewQuantity(int64(a.Config.MaxCPUPerSliceCompute)*computeUnits*sliceCount, resource.DecimalSI)
+		if cpu, ok := container.Resources.Requests[v1.ResourceCPU]; ok && cpu.Cmp(*maxCPU) > 0 {
+			return fmt.Errorf("cpu request %s of container %s is implausibly large for %d slice(s) of profile %s, at most %s is accepted",
+				cpu.String(), container.Name, sliceCount, profileName, maxCPU.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestProfileValidatorHandle(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	g.Expect(v1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(inferencev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	gatedPod := func(resourceName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: v1.PodSpec{
+				SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+				Containers: []v1.Container{{
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{v1.ResourceName(resourceName): resource.MustParse("1")},
+					},
+				}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		pod         *v1.Pod
+		instaslices bool
+		allowed     bool
+		message     string
+	}{
+		{
+			name:        "profile offered by a GPU",
+			pod:         gatedPod(OrgInstaslicePrefix + "mig-1g.5gb"),
+			instaslices: true,
+			allowed:     true,
+		},
+		{
+			name:        "profile not offered by any GPU",
+			pod:         gatedPod(OrgInstaslicePrefix + "mig-9g.99gb"),
+			instaslices: true,
+			allowed:     false,
+			message:     "profile 9g.99gb is not offered by any GPU of the cluster, valid profiles are: 1g.10gb, 1g.5gb, 1g.5gb+me, 2g.10gb, 3g.20gb, 4g.20gb, 7g.40gb",
+		},
+		{
+			name:    "GPUs not discovered yet",
+			pod:     gatedPod(OrgInstaslicePrefix + "mig-9g.99gb"),
+			allowed: true,
+		},
+		{
+			name: "pod not gated by instaslice",
+			pod: func() *v1.Pod {
+				pod := gatedPod(NvidiaMIGPrefix + "9g.99gb")
+				pod.Spec.SchedulingGates = nil
+				return pod
+			}(),
+			instaslices: true,
+			allowed:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tt.instaslices {
+				builder = builder.WithObjects(utils.GenerateFakeCapacity("node-1"))
+			}
+			validator := &ProfileValidator{Client: builder.Build(), Decoder: admission.NewDecoder(scheme)}
+
+			rawPod, err := json.Marshal(tt.pod)
+			g.Expect(err).NotTo(HaveOccurred())
+			resp := validator.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Object: runtime.RawExtension{Raw: rawPod},
+				},
+			})
+			g.Expect(resp.Allowed).To(Equal(tt.allowed))
+			if tt.message != "" {
+				g.Expect(resp.Result.Message).To(Equal(tt.message))
+			}
+		})
+	}
+}
+
+func TestProfileValidatorResourceRequests(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	g.Expect(v1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(inferencev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	gatedPod := func(profileName, slices string, requests v1.ResourceList) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: v1.PodSpec{
+				SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+				Containers: []v1.Container{{
+					Name: "inference",
+					Resources: v1.ResourceRequirements{
+						Limits:   v1.ResourceList{v1.ResourceName(OrgInstaslicePrefix + "mig-" + profileName): resource.MustParse(slices)},
+						Requests: requests,
+					},
+				}},
+			},
+		}
+	}
+	cfg := config.NewConfig()
+	cfg.MaxMemoryPerSliceGB = 4
+	cfg.MaxCPUPerSliceCompute = 8
+
+	tests := []struct {
+		name    string
+		pod     *v1.Pod
+		config  *config.Config
+		allowed bool
+		message string
+	}{
+		{
+			name:    "tiny slice with huge memory",
+			pod:     gatedPod("1g.5gb", "1", v1.ResourceList{v1.ResourceMemory: resource.MustParse("512Gi")}),
+			config:  cfg,
+			allowed: false,
+			message: "memory request 512Gi of container inference is implausibly large for 1 slice(s) of profile 1g.5gb, at most 20Gi is accepted",
+		},
+		{
+			name:    "memory within the threshold",
+			pod:     gatedPod("1g.5gb", "1", v1.ResourceList{v1.ResourceMemory: resource.MustParse("16Gi")}),
+			config:  cfg,
+			allowed: true,
+		},
+		{
+			name:    "threshold scales with the slice count",
+			pod:     gatedPod("1g.5gb", "2", v1.ResourceList{v1.ResourceMemory: resource.MustParse("40Gi")}),
+			config:  cfg,
+			allowed: true,
+		},
+		{
+			name:    "too many CPUs for the compute units",
+			pod:     gatedPod("2g.10gb", "1", v1.ResourceList{v1.ResourceCPU: resource.MustParse("32")}),
+			config:  cfg,
+			allowed: false,
+			message: "cpu request 32 of container inference is implausibly large for 1 slice(s) of profile 2g.10gb, at most 16 is accepted",
+		},
+		{
+			name:    "thresholds disabled",
+			pod:     gatedPod("1g.5gb", "1", v1.ResourceList{v1.ResourceMemory: resource.MustParse("512Gi")}),
+			config:  config.NewConfig(),
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			validator := &ProfileValidator{
+				Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(utils.GenerateFakeCapacity("node-1")).Build(),
+				Decoder: admission.NewDecoder(scheme),
+				Config:  tt.config,
+			}
+
+			rawPod, err := json.Marshal(tt.pod)
+			g.Expect(err).NotTo(HaveOccurred())
+			resp := validator.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Object: runtime.RawExtension{Raw: rawPod},
+				},
+			})
+			g.Expect(resp.Allowed).To(Equal(tt.allowed))
+			if tt.message != "" {
+				g.Expect(resp.Result.Message).To(Equal(tt.message))
+			}
+		})
+	}
+}
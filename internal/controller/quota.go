@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
+)
+
+// Admins of shared clusters cap the slices a namespace holds through the quota ConfigMap, so that one team
+// cannot take every MIG slice. A pod whose slices would exceed the quota of its namespace stays gated until
+// slices of the namespace are released or the quota is raised.
+
+// namespaceQuota returns the slice limits of the namespace keyed by profile or quotaTotalKey, nil when the
+// namespace has no quota
+func (r *InstasliceReconciler) namespaceQuota(ctx context.Context, namespace string) (map[string]int, error) {
+	configMap := &v1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: NamespaceQuotaConfigMapName, Namespace: r.Config.OperatorNamespace}, configMap); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	value, ok := configMap.Data[namespace]
+	if !ok {
+		return nil, nil
+	}
+	return config.ParseQuota(value), nil
+}
+
+// namespaceSliceUsage counts the slices held by the pods of the namespace keyed by profile and quotaTotalKey,
+// slices the daemonset tore down and warm slices kept for the workloads are not counted
+func namespaceSliceUsage(instaslices []inferencev1alpha1.Instaslice, namespace string) map[string]int {
+	usage := make(map[string]int)
+	for _, instaslice := range instaslices {
+		for key, allocRequest := range instaslice.Spec.PodAllocationRequests {
+			if allocRequest.PodRef.Namespace != namespace || isWarmSlice(allocRequest) {
+				continue
+			}
+			if allocResult, ok := instaslice.Status.PodAllocationResults[key]; ok &&
+				allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			usage[allocRequest.Profile]++
+			usage[quotaTotalKey]++
+		}
+	}
+	return usage
+}
+
+// exceededQuotaLimit returns the limit of the quota that count more slices of the profile would exceed
+func exceededQuotaLimit(quota, usage map[string]int, profileName string, count int) (string, bool) {
+	for _, limit := range []string{quotaTotalKey, profileName} {
+		if maxSlices, ok := quota[limit]; ok && usage[limit]+count > maxSlices {
+			return limit, true
+		}
+	}
+	return "", false
+}
+
+// quotaMapFunc enqueues the pods gated by InstaSlice in the namespaces of the quota ConfigMap, so that pods
+// held over quota are allocated as soon as the quota is raised
+func (r *InstasliceReconciler) quotaMapFunc(ctx context.Context, obj client.Object) []reconcile.Request {
+	configMap, ok := obj.(*v1.ConfigMap)
+	if !ok || configMap.Name != NamespaceQuotaConfigMapName || configMap.Namespace != r.Config.OperatorNamespace {
+		return nil
+	}
+	var requests []reconcile.Request
+	for namespace := range configMap.Data {
+		var podList v1.PodList
+		if err := r.List(ctx, &podList, client.InNamespace(namespace)); err != nil {
+			logr.FromContext(ctx).Error(err, "unable to list the pods of the namespace for its quota", "namespace", namespace)
+			continue
+		}
+		for i := range podList.Items {
+			if checkIfPodGatedByInstaSlice(&podList.Items[i], r.gateName()) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&podList.Items[i])})
+			}
+		}
+	}
+	return requests
+}
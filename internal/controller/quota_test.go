@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// newTestQuota returns the quota ConfigMap limiting the namespaces
+func newTestQuota(quotas map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: NamespaceQuotaConfigMapName, Namespace: InstaSliceOperatorNamespace},
+		Data:       quotas,
+	}
+}
+
+func TestReconcile_NamespaceQuota(t *testing.T) {
+	ctx := context.TODO()
+	created := inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	}
+	deleted := inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusDeleting,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusDeleted,
+	}
+
+	tests := []struct {
+		name        string
+		quotas      map[string]string
+		holderState inferencev1alpha1.AllocationStatus
+		allocated   bool
+	}{
+		{name: "no quota", holderState: created, allocated: true},
+		{name: "under the total quota", quotas: map[string]string{"default": "total=2"}, holderState: created, allocated: true},
+		{name: "at the total quota", quotas: map[string]string{"default": "total=1"}, holderState: created},
+		{name: "at the profile quota", quotas: map[string]string{"default": "1g.5gb=1"}, holderState: created},
+		{name: "quota of another profile", quotas: map[string]string{"default": "2g.10gb=0"}, holderState: created, allocated: true},
+		{name: "quota of another namespace", quotas: map[string]string{"other": "total=0"}, holderState: created, allocated: true},
+		{name: "torn down slices do not count", quotas: map[string]string{"default": "total=1"}, holderState: deleted, allocated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holder := newTestGatedPod("holder", "1g.5gb")
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			objs := []client.Object{pod, newTestAllocation("node-1", holder, tt.holderState)}
+			if tt.quotas != nil {
+				objs = append(objs, newTestQuota(tt.quotas))
+			}
+			r, fakeClient := newTestReconciler(t, objs...)
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			if tt.allocated {
+				assert.Contains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+				return
+			}
+			assert.NotContains(t, instaslice.Spec.PodAllocationRequests, pod.UID)
+			assert.Equal(t, requeue10sDelay, result.RequeueAfter)
+			if assert.Len(t, recorder.Events, 1) {
+				assert.Contains(t, <-recorder.Events, "OverQuota")
+			}
+		})
+	}
+}
+
+func TestQuotaMapFunc(t *testing.T) {
+	ctx := context.TODO()
+	gated := newTestGatedPod("gated", "1g.5gb")
+	ungated := newTestGatedPod("ungated", "1g.5gb")
+	ungated.Spec.SchedulingGates = nil
+	elsewhere := newTestGatedPod("elsewhere", "1g.5gb")
+	elsewhere.Namespace = "other"
+	quota := newTestQuota(map[string]string{"default": "total=1"})
+	r, _ := newTestReconciler(t, gated, ungated, elsewhere)
+
+	assert.Equal(t, []ctrl.Request{{NamespacedName: client.ObjectKeyFromObject(gated)}}, r.quotaMapFunc(ctx, quota))
+
+	// ConfigMaps other than the quota are ignored
+	unrelated := quota.DeepCopy()
+	unrelated.Name = "unrelated"
+	assert.Empty(t, r.quotaMapFunc(ctx, unrelated))
+}
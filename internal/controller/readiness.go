@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// daemonsetReadiness remembers until when a ready daemonset pod was last seen. Only readiness is
+// cached, a daemonset without ready pods is checked again on the next reconcile.
+type daemonsetReadiness struct {
+	mu         sync.Mutex
+	readyUntil time.Time
+}
+
+// isAnyDaemonsetPodReady reports whether at least one pod of the daemonset is running and ready. The
+// pods are listed at most once per DaemonsetReadinessTTL while they are ready, concurrent reconciles
+// wait for the list in flight rather than listing again.
+func (r *InstasliceReconciler) isAnyDaemonsetPodReady(ctx context.Context, daemonSet *appsv1.DaemonSet) (bool, error) {
+	var ttl time.Duration
+	if r.Config != nil {
+		ttl = r.Config.DaemonsetReadinessTTL
+	}
+	log := logr.FromContext(ctx).WithName(LogSubsystemReadiness)
+	r.daemonsetReadiness.mu.Lock()
+	defer r.daemonsetReadiness.mu.Unlock()
+	if ttl > 0 && time.Now().Before(r.daemonsetReadiness.readyUntil) {
+		log.V(1).Info("daemonset readiness cached", "readyUntil", r.daemonsetReadiness.readyUntil)
+		return true, nil
+	}
+
+	var podList v1.PodList
+	listOptions := &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(daemonSet.Spec.Selector.MatchLabels),
+		Namespace:     daemonSet.Namespace,
+	}
+	if err := r.List(ctx, &podList, listOptions); err != nil {
+		return false, err
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == v1.PodRunning && len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].Ready {
+			if ttl > 0 {
+				r.daemonsetReadiness.readyUntil = time.Now().Add(ttl)
+			}
+			log.V(1).Info("daemonset pod is ready", "pod", pod.Name)
+			return true, nil
+		}
+	}
+	log.V(1).Info("no daemonset pod is ready", "pods", len(podList.Items))
+	return false, nil
+}
+
+// isDevicePluginReady reports whether the GPU device plugin pod on the node is running and ready, a healthy
+// device plugin on another node does not count. Without a configured device plugin namespace every node
+// is considered ready.
+func (r *InstasliceReconciler) isDevicePluginReady(ctx context.Context, nodeName string) (bool, error) {
+	if r.Config == nil || r.Config.DevicePluginNamespace == "" {
+		return true, nil
+	}
+	var podList v1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(r.Config.DevicePluginNamespace)); err != nil {
+		return false, err
+	}
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != nodeName || !strings.HasPrefix(pod.Name, r.Config.DevicePluginPrefix) {
+			continue
+		}
+		if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+				return true, nil
+			}
+		}
+	}
+	logr.FromContext(ctx).WithName(LogSubsystemReadiness).V(1).Info("device plugin is not ready", "node", nodeName)
+	return false, nil
+}
+
+// isDaemonsetPodOnNode reports whether a pod of the InstaSlice daemonset runs on the node, i.e. whether the
+// slices of the node can still be torn down
+func (r *InstasliceReconciler) isDaemonsetPodOnNode(ctx context.Context, nodeName string) (bool, error) {
+	daemonSet := &appsv1.DaemonSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: InstasliceDaemonsetName, Namespace: r.Config.OperatorNamespace}, daemonSet); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	var podList v1.PodList
+	listOptions := &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(daemonSet.Spec.Selector.MatchLabels),
+		Namespace:     daemonSet.Namespace,
+	}
+	if err := r.List(ctx, &podList, listOptions); err != nil {
+		return false, err
+	}
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName == nodeName && pod.DeletionTimestamp.IsZero() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestIsAnyDaemonsetPodReady(t *testing.T) {
+	ctx := context.TODO()
+	daemonSetPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "daemonset-pod", Namespace: InstaSliceOperatorNamespace, Labels: daemonSetlabel},
+		Status: v1.PodStatus{
+			Phase:             v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{{Name: daemonSetName, Ready: true}},
+		},
+	}
+	r, fakeClient := newTestReconciler(t, daemonSetPod)
+	lists := 0
+	r.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*v1.PodList); ok {
+				lists++
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+	daemonSet := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKey{Name: InstasliceDaemonsetName, Namespace: InstaSliceOperatorNamespace}, daemonSet))
+
+	r.Config.DaemonsetReadinessTTL = time.Minute
+	for i := 0; i < 3; i++ {
+		ready, err := r.isAnyDaemonsetPodReady(ctx, daemonSet)
+		assert.NoError(t, err)
+		assert.True(t, ready)
+	}
+	// the readiness is listed once within the TTL
+	assert.Equal(t, 1, lists)
+
+	// once the TTL expired the pods are listed again
+	r.daemonsetReadiness.readyUntil = time.Now().Add(-time.Second)
+	ready, err := r.isAnyDaemonsetPodReady(ctx, daemonSet)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, 2, lists)
+
+	// a daemonset without ready pods is not cached
+	assert.NoError(t, fakeClient.Delete(ctx, daemonSetPod))
+	r.daemonsetReadiness.readyUntil = time.Time{}
+	for i := 0; i < 2; i++ {
+		ready, err := r.isAnyDaemonsetPodReady(ctx, daemonSet)
+		assert.NoError(t, err)
+		assert.False(t, ready)
+	}
+	assert.Equal(t, 4, lists)
+
+	// without a TTL every check lists the pods
+	r.Config.DaemonsetReadinessTTL = 0
+	_, err = r.isAnyDaemonsetPodReady(ctx, daemonSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, lists)
+}
+
+// newTestDevicePluginPod returns a running device plugin pod in the gpu-operator namespace scheduled to the node
+func newTestDevicePluginPod(name, nodeName string, ready bool) *v1.Pod {
+	readyStatus := v1.ConditionFalse
+	if ready {
+		readyStatus = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "gpu-operator"},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: readyStatus}},
+		},
+	}
+}
+
+func TestIsDevicePluginReady(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name      string
+		namespace string
+		pods      []client.Object
+		ready     bool
+	}{
+		{name: "check disabled", ready: true},
+		{
+			name:      "ready on the target node",
+			namespace: "gpu-operator",
+			pods:      []client.Object{newTestDevicePluginPod("nvidia-device-plugin-daemonset-a", "node-1", true)},
+			ready:     true,
+		},
+		{
+			name:      "unready on the target node while ready on another",
+			namespace: "gpu-operator",
+			pods: []client.Object{
+				newTestDevicePluginPod("nvidia-device-plugin-daemonset-a", "node-1", false),
+				newTestDevicePluginPod("nvidia-device-plugin-daemonset-b", "node-2", true),
+			},
+		},
+		{
+			name:      "other pods on the target node",
+			namespace: "gpu-operator",
+			pods:      []client.Object{newTestDevicePluginPod("gpu-feature-discovery-a", "node-1", true)},
+		},
+		{
+			name:      "no device plugin pod",
+			namespace: "gpu-operator",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReconciler(t, tt.pods...)
+			r.Config.DevicePluginNamespace = tt.namespace
+			ready, err := r.isDevicePluginReady(ctx, "node-1")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ready, ready)
+		})
+	}
+}
+
+func TestReconcile_DevicePluginReadiness(t *testing.T) {
+	ctx := context.TODO()
+	for _, tt := range []struct {
+		name    string
+		ready   bool
+		ungated bool
+	}{
+		{name: "healthy on the target node", ready: true, ungated: true},
+		{name: "unhealthy on the target node", ready: false, ungated: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			})
+			configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      string(instaslice.Status.PodAllocationResults[pod.UID].ConfigMapResourceIdentifier),
+				Namespace: pod.Namespace,
+			}}
+			// the device plugin of another node is healthy either way
+			r, fakeClient := newTestReconciler(t, pod, instaslice, configMap,
+				newTestDevicePluginPod("nvidia-device-plugin-daemonset-a", "node-1", tt.ready),
+				newTestDevicePluginPod("nvidia-device-plugin-daemonset-b", "node-2", true))
+			r.Config.DevicePluginNamespace = "gpu-operator"
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+			result, err := r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+			updatedPod := &v1.Pod{}
+			assert.NoError(t, fakeClient.Get(ctx, req.NamespacedName, updatedPod))
+			if tt.ungated {
+				assert.NotContains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+				return
+			}
+			assert.Equal(t, requeue10sDelay, result.RequeueAfter)
+			assert.Contains(t, updatedPod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: GateName})
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// ReleaseAllocation releases the allocation of the pod UID on behalf of external controllers that manage
+// pod lifecycles out-of-band. Every slice of the pod is set to deleting for the daemonset to tear it down,
+// a pod already running on the slices is evicted while a pod still gated is allocated afresh once the
+// slices are gone. Releasing an allocation that is already deleting is a no-op. errTeardownPending is
+// returned while the teardown webhook has not approved the teardown of a slice.
+func (r *InstasliceReconciler) ReleaseAllocation(ctx context.Context, podUID types.UID) error {
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, client.InNamespace(r.Config.OperatorNamespace)); err != nil {
+		return err
+	}
+	var held, ungated bool
+	var podRef v1.ObjectReference
+	for _, slice := range podSlices(podUID, instasliceList.Items) {
+		// a result left without its request is compacted by the sweep
+		if slice.request.PodRef.UID == "" {
+			continue
+		}
+		held = true
+		podRef = slice.request.PodRef
+		allocResult, allocRequest := slice.result, slice.request
+		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		ungated = ungated || allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated
+		logr.FromContext(ctx).Info("releasing allocation on request", "pod", allocRequest.PodRef.Name, "instaslice", slice.instasliceName)
+		if _, err := r.setInstasliceAllocationToDeleting(ctx, slice.instasliceName, &allocResult, &allocRequest); err != nil {
+			return err
+		}
+	}
+	if !held {
+		return errors.NewNotFound(schema.GroupResource{Group: inferencev1alpha1.GroupVersion.Group, Resource: "allocations"}, string(podUID))
+	}
+	if !ungated {
+		return nil
+	}
+	pod := &v1.Pod{}
+	if err := r.Get(ctx, types.NamespacedName{Name: podRef.Name, Namespace: podRef.Namespace}, pod); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if pod.UID != podUID || !pod.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	return r.evictPod(ctx, pod)
+}
+
+// ReleaseHandler serves ReleaseAllocation, the pod UID is passed in the uid query parameter of a POST
+func (r *InstasliceReconciler) ReleaseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		podUID := req.URL.Query().Get("uid")
+		if podUID == "" {
+			http.Error(w, "missing uid query parameter", http.StatusBadRequest)
+			return
+		}
+		if err := r.ReleaseAllocation(req.Context(), types.UID(podUID)); err != nil {
+			if errors.IsNotFound(err) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			// the caller retries the release once the teardown webhook approved it
+			if err == errTeardownPending {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
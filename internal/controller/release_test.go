@@ -0,0 +1,66 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestReleaseHandler(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+	handler := r.ReleaseHandler()
+
+	release := func(method, uid string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, "/release?uid="+uid, nil))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, release(http.MethodGet, string(pod.UID)))
+	assert.Equal(t, http.StatusNotFound, release(http.MethodPost, "unknown-uid"))
+
+	assert.Equal(t, http.StatusAccepted, release(http.MethodPost, string(pod.UID)))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: InstaSliceOperatorNamespace}, current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+	// the pod running on the released slice is evicted
+	assert.True(t, errors.IsNotFound(fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), &v1.Pod{})))
+
+	// releasing again is a no-op
+	assert.Equal(t, http.StatusAccepted, release(http.MethodPost, string(pod.UID)))
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// ReplayDivergence is a recorded allocation the policy placed differently on replay
+type ReplayDivergence struct {
+	Record AccountingRecord
+	// Node and GPU are where the replay placed the pod, both are empty when it found no capacity
+	Node string
+	GPU  string
+}
+
+// ReplayResult is the outcome of replaying recorded allocation events
+type ReplayResult struct {
+	// Allocations holds the allocations still in place at the end of the replay, keyed by namespace/pod
+	Allocations map[string]*AllocationDetails
+	Divergences []ReplayDivergence
+}
+
+// DecodeAccountingRecords reads the accounting records delivered by the accounting webhook, one JSON
+// record per line as collected by the receiving endpoint
+func DecodeAccountingRecords(reader io.Reader) ([]AccountingRecord, error) {
+	var records []AccountingRecord
+	decoder := json.NewDecoder(reader)
+	for {
+		var record AccountingRecord
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// ReplayAllocations replays the recorded allocate and release events in timestamp order against the
+// policy, starting from the Instaslice objects, to reproduce packing decisions for debugging. Like
+// WhatIf it never reads or mutates the cluster, placements that differ from the recorded node and GPU
+// are reported as divergences.
+func ReplayAllocations(instaslices []inferencev1alpha1.Instaslice, records []AccountingRecord, policy AllocationPolicy) *ReplayResult {
+	state := make([]inferencev1alpha1.Instaslice, 0, len(instaslices))
+	for i := range instaslices {
+		state = append(state, *instaslices[i].DeepCopy())
+	}
+	ordered := append([]AccountingRecord(nil), records...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Timestamp.Before(ordered[j].Timestamp)
+	})
+
+	result := &ReplayResult{Allocations: make(map[string]*AllocationDetails)}
+	for _, record := range ordered {
+		key := record.Namespace + "/" + record.Pod
+		switch record.Event {
+		case AccountingEventAllocate:
+			if _, allocated := result.Allocations[key]; allocated {
+				continue
+			}
+			details, err := WhatIf(state, replayPod(record), policy)
+			if err != nil {
+				result.Divergences = append(result.Divergences, ReplayDivergence{Record: record})
+				continue
+			}
+			details.Result.AllocatedAt = &metav1.Time{Time: record.Timestamp}
+			if string(details.Result.Nodename) != record.Node || details.Result.GPUUUID != record.GPU {
+				result.Divergences = append(result.Divergences, ReplayDivergence{
+					Record: record,
+					Node:   string(details.Result.Nodename),
+					GPU:    details.Result.GPUUUID,
+				})
+			}
+			applyReplayedAllocation(state, details)
+			result.Allocations[key] = details
+		case AccountingEventRelease:
+			details, allocated := result.Allocations[key]
+			if !allocated {
+				continue
+			}
+			for i := range state {
+				if state[i].Name == string(details.Result.Nodename) {
+					delete(state[i].Spec.PodAllocationRequests, details.Request.PodRef.UID)
+					delete(state[i].Status.PodAllocationResults, details.Request.PodRef.UID)
+				}
+			}
+			delete(result.Allocations, key)
+		}
+	}
+	return result
+}
+
+// replayPod rebuilds a gated pod requesting the recorded profile
+func replayPod(record AccountingRecord) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      record.Pod,
+			Namespace: record.Namespace,
+			UID:       types.UID(record.Namespace + "/" + record.Pod),
+		},
+		Spec: v1.PodSpec{
+			SchedulingGates: []v1.PodSchedulingGate{{Name: GateName}},
+			Containers: []v1.Container{{
+				Name: record.Pod,
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						v1.ResourceName(OrgInstaslicePrefix + "mig-" + record.Profile): resource.MustParse("1"),
+					},
+				},
+				EnvFrom: []v1.EnvFromSource{{
+					ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: record.Pod}},
+				}},
+			}},
+		},
+	}
+}
+
+// applyReplayedAllocation books the placement on the replay state so that later placements see it
+func applyReplayedAllocation(state []inferencev1alpha1.Instaslice, details *AllocationDetails) {
+	for i := range state {
+		if state[i].Name == string(details.Result.Nodename) {
+			bookAllocation(&state[i], details.Request, details.Result)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestReplayAllocations(t *testing.T) {
+	// records as collected from the accounting webhook, out of order on purpose
+	recorded := `{"event":"allocate","namespace":"default","pod":"pod-b","profile":"3g.20gb","node":"node-1","gpu":"GPU-31cfe05c-ed13-cd17-d7aa-c63db5108c24","timestamp":"2024-10-01T10:01:00Z"}
+{"event":"allocate","namespace":"default","pod":"pod-a","profile":"3g.20gb","node":"node-1","gpu":"GPU-31cfe05c-ed13-cd17-d7aa-c63db5108c24","timestamp":"2024-10-01T10:00:00Z"}
+{"event":"release","namespace":"default","pod":"pod-a","profile":"3g.20gb","node":"node-1","gpu":"GPU-31cfe05c-ed13-cd17-d7aa-c63db5108c24","timestamp":"2024-10-01T10:02:00Z"}
+{"event":"allocate","namespace":"default","pod":"pod-c","profile":"1g.5gb","node":"node-1","gpu":"GPU-8d042338-e67f-9c48-92b4-5b55c7e5133c","timestamp":"2024-10-01T10:03:00Z"}
+`
+	records, err := DecodeAccountingRecords(strings.NewReader(recorded))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, records, 4)
+	instaslice := utils.GenerateFakeCapacity("node-1")
+
+	result := ReplayAllocations([]inferencev1alpha1.Instaslice{*instaslice}, records, &FirstFitPolicy{})
+	placements := make(map[string]inferencev1alpha1.Placement)
+	gpus := make(map[string]string)
+	for key, details := range result.Allocations {
+		placements[key] = details.Result.MigPlacement
+		gpus[key] = details.Result.GPUUUID
+	}
+	// pod-a left the start of the first GPU free again, pod-c is packed into it
+	assert.Equal(t, map[string]inferencev1alpha1.Placement{
+		"default/pod-b": {Start: 4, Size: 4},
+		"default/pod-c": {Start: 0, Size: 1},
+	}, placements)
+	assert.Equal(t, gpus["default/pod-b"], gpus["default/pod-c"])
+	// pod-c was recorded on the other GPU
+	if assert.Len(t, result.Divergences, 1) {
+		assert.Equal(t, "pod-c", result.Divergences[0].Record.Pod)
+		assert.Equal(t, gpus["default/pod-c"], result.Divergences[0].GPU)
+	}
+	// the input is left untouched
+	assert.Empty(t, instaslice.Spec.PodAllocationRequests)
+}
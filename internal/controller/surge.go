@@ -0,0 +1,173 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultMaxSurge is the maxSurge the API server defaults a RollingUpdate Deployment to
+var defaultMaxSurge = intstr.FromString("25%")
+
+// isCapacityReservedForSurge reports whether allocating the profile for the pod would take slice
+// indexes held back for the surge pods of Deployments that are rolling out. The surge pods of a
+// rollout are pending next to the pods they replace, other pods must not take their capacity.
+func (r *InstasliceReconciler) isCapacityReservedForSurge(ctx context.Context, pod *v1.Pod, profileName string, instaslices []inferencev1alpha1.Instaslice) (bool, error) {
+	if r.Config == nil || !r.Config.ReserveSurgeCapacity {
+		return false, nil
+	}
+	reserved, err := r.surgeReservation(ctx, pod, instaslices)
+	if err != nil || reserved == 0 {
+		return false, err
+	}
+	var freeIndexes int32
+	for i := range instaslices {
+		free, _ := r.tierReservation(&instaslices[i], pod)
+		freeIndexes += free
+	}
+	return freeIndexes-r.profileSize(profileName, instaslices) < reserved, nil
+}
+
+// surgeReservation returns the slice indexes reserved for the pending surge pods of the Deployments
+// rolling out, other than the Deployment of the pod itself. A rollout reserves at most its maxSurge
+// pods, each sized by the profile of the pod template.
+func (r *InstasliceReconciler) surgeReservation(ctx context.Context, pod *v1.Pod, instaslices []inferencev1alpha1.Instaslice) (int32, error) {
+	ownDeployment, err := r.owningDeployment(ctx, pod)
+	if err != nil {
+		return 0, err
+	}
+	var deployments appsv1.DeploymentList
+	if err := r.List(ctx, &deployments); err != nil {
+		return 0, err
+	}
+	var reserved int32
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		if deployment.Namespace == ownDeployment.Namespace && deployment.Name == ownDeployment.Name {
+			continue
+		}
+		surge := rolloutSurge(deployment)
+		if surge == 0 {
+			continue
+		}
+		container, err := gpuContainer(deployment.Spec.Template.Spec.Containers)
+		if err != nil {
+			continue
+		}
+		profileName, err := extractProfileName(container.Resources.Limits)
+		if err != nil {
+			continue
+		}
+		size := r.profileSize(profileName, instaslices)
+		if size == 0 {
+			continue
+		}
+		pending, err := r.pendingDeploymentPods(ctx, deployment, instaslices)
+		if err != nil {
+			return 0, err
+		}
+		reserved += int32(min(surge, pending)) * size
+	}
+	return reserved, nil
+}
+
+// rolloutSurge returns how many surge pods the Deployment may run above its replicas, it is 0 unless
+// a rolling update is in progress
+func rolloutSurge(deployment *appsv1.Deployment) int {
+	if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType || deployment.Spec.Paused {
+		return 0
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if deployment.Status.ObservedGeneration >= deployment.Generation && deployment.Status.UpdatedReplicas >= replicas {
+		return 0
+	}
+	maxSurge := &defaultMaxSurge
+	if deployment.Spec.Strategy.RollingUpdate != nil && deployment.Spec.Strategy.RollingUpdate.MaxSurge != nil {
+		maxSurge = deployment.Spec.Strategy.RollingUpdate.MaxSurge
+	}
+	surge, err := intstr.GetScaledValueFromIntOrPercent(maxSurge, int(replicas), true)
+	if err != nil {
+		return 0
+	}
+	return surge
+}
+
+// pendingDeploymentPods counts the pods of the Deployment gated by InstaSlice that have no allocation yet
+func (r *InstasliceReconciler) pendingDeploymentPods(ctx context.Context, deployment *appsv1.Deployment, instaslices []inferencev1alpha1.Instaslice) (int, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return 0, err
+	}
+	var podList v1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(deployment.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return 0, err
+	}
+	var pending int
+	for i := range podList.Items {
+		other := &podList.Items[i]
+		if other.DeletionTimestamp.IsZero() && checkIfPodGatedByInstaSlice(other, r.gateName()) && !hasPodAllocation(other.UID, instaslices) {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// owningDeployment returns the Deployment managing the pod through its ReplicaSet, the name is empty
+// for pods that are not part of a Deployment
+func (r *InstasliceReconciler) owningDeployment(ctx context.Context, pod *v1.Pod) (types.NamespacedName, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return types.NamespacedName{}, nil
+	}
+	replicaSet := &appsv1.ReplicaSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: pod.Namespace}, replicaSet); err != nil {
+		if errors.IsNotFound(err) {
+			return types.NamespacedName{}, nil
+		}
+		return types.NamespacedName{}, err
+	}
+	deployment := metav1.GetControllerOf(replicaSet)
+	if deployment == nil || deployment.Kind != "Deployment" {
+		return types.NamespacedName{}, nil
+	}
+	return types.NamespacedName{Name: deployment.Name, Namespace: pod.Namespace}, nil
+}
+
+// profileSize returns the slice indexes of the profile as discovered on any of the nodes
+func (r *InstasliceReconciler) profileSize(profileName string, instaslices []inferencev1alpha1.Instaslice) int32 {
+	if profileName == "" {
+		return 0
+	}
+	for i := range instaslices {
+		if size, _, _, _ := r.extractGpuProfile(&instaslices[i], "", profileName); size > 0 {
+			return size
+		}
+	}
+	return 0
+}
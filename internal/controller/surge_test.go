@@ -0,0 +1,167 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestRolloutSurge(t *testing.T) {
+	replicas := int32(4)
+	maxSurge := intstr.FromInt32(2)
+	tests := []struct {
+		name       string
+		deployment appsv1.Deployment
+		want       int
+	}{
+		{
+			name: "rollout completed",
+			deployment: appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{UpdatedReplicas: 4},
+			},
+			want: 0,
+		},
+		{
+			name: "default max surge",
+			deployment: appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{UpdatedReplicas: 1},
+			},
+			want: 1,
+		},
+		{
+			name: "new generation not observed yet",
+			deployment: appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Spec: appsv1.DeploymentSpec{Replicas: &replicas, Strategy: appsv1.DeploymentStrategy{
+					Type:          appsv1.RollingUpdateDeploymentStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge},
+				}},
+				Status: appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 4},
+			},
+			want: 2,
+		},
+		{
+			name: "recreate strategy",
+			deployment: appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{Replicas: &replicas, Strategy: appsv1.DeploymentStrategy{
+					Type: appsv1.RecreateDeploymentStrategyType,
+				}},
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rolloutSurge(&tt.deployment))
+		})
+	}
+}
+
+func TestReconcile_SurgeReservation(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(2)
+	maxSurge := intstr.FromInt32(1)
+	labels := map[string]string{"app": "web"}
+	// the Deployment is rolling out a new revision of its 7g.40gb pods
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Strategy: appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge},
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{Containers: []v1.Container{{
+					Name: "gpu",
+					Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						v1.ResourceName(OrgInstaslicePrefix + "mig-7g.40gb"): resource.MustParse("1"),
+					}},
+				}}},
+			},
+		},
+		Status: appsv1.DeploymentStatus{UpdatedReplicas: 1},
+	}
+	isController := true
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      "web-new",
+		Namespace: "default",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &isController,
+		}},
+	}}
+	surgePod := newTestGatedPod("web-new-1", "7g.40gb")
+	surgePod.Finalizers = []string{FinalizerName}
+	surgePod.Labels = labels
+	surgePod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "ReplicaSet", Name: replicaSet.Name, UID: "web-new-uid", Controller: &isController,
+	}}
+	// a batch pod of another workload competes for the single GPU left
+	otherPod := newTestGatedPod("batch", "1g.5gb")
+	otherPod.Finalizers = []string{FinalizerName}
+
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	instaslice.Spec.PodAllocationRequests["old-uid"] = inferencev1alpha1.AllocationRequest{Profile: "7g.40gb"}
+	instaslice.Status.PodAllocationResults["old-uid"] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 0, Size: 8},
+		GPUUUID:          instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID,
+		Nodename:         "node-1",
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+	}
+	r, fakeClient := newTestReconciler(t, deployment, replicaSet, surgePod, otherPod, instaslice)
+	r.Config.ReserveSurgeCapacity = true
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	// the capacity of the surge pod is held back from the batch pod
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(otherPod)})
+	assert.NoError(t, err)
+	assert.Greater(t, result.RequeueAfter, time.Duration(0))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.NotContains(t, current.Spec.PodAllocationRequests, otherPod.UID)
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "CapacityReservedForRollout")
+	}
+
+	// the surge pod of the rollout takes the reserved capacity
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(surgePod)})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Contains(t, current.Spec.PodAllocationRequests, surgePod.UID)
+}
@@ -0,0 +1,627 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The pod reconcile only wakes up for pods, checks that concern an Instaslice object
+// as a whole are performed periodically by the sweep below.
+
+// sweepInstaslices runs the periodic checks over every Instaslice object in the cluster, the objects
+// are checked in parallel by a bounded number of workers
+func (r *InstasliceReconciler) sweepInstaslices(ctx context.Context) error {
+	var instasliceList inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
+		return err
+	}
+	workers := make(chan struct{}, max(1, r.Config.SweepConcurrency))
+	var wg sync.WaitGroup
+	for i := range instasliceList.Items {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(instaslice *inferencev1alpha1.Instaslice) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			r.sweepInstaslice(ctx, instaslice)
+		}(&instasliceList.Items[i])
+	}
+	wg.Wait()
+	if err := r.reconcileWarmPools(ctx, instasliceList.Items); err != nil {
+		logr.FromContext(ctx).Error(err, "unable to reconcile warm pools")
+	}
+	recordAllocationMetrics(policyName(r.activePolicy()), instasliceList.Items)
+	recordSliceMetrics(instasliceList.Items)
+	var podList v1.PodList
+	if err := r.List(ctx, &podList); err != nil {
+		return err
+	}
+	recordGatedPods(podList.Items, instasliceList.Items, r.gateName())
+	return nil
+}
+
+// sweepInstaslice runs the periodic checks over one Instaslice object, a failed check is logged
+// and does not prevent the others from running
+func (r *InstasliceReconciler) sweepInstaslice(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) {
+	log := logr.FromContext(ctx)
+	// an Instaslice being deleted is only cleaned up
+	deleting, err := r.reconcileInstasliceFinalizer(ctx, instaslice)
+	if err != nil {
+		log.Error(err, "unable to reconcile the instaslice finalizer", "instaslice", instaslice.Name)
+	}
+	if deleting {
+		return
+	}
+	if err := r.reconcileNodeResourceConsistency(ctx, instaslice); err != nil {
+		log.Error(err, "unable to check node resource consistency", "instaslice", instaslice.Name)
+	}
+	if err := r.reconcileHealthConditions(ctx, instaslice); err != nil {
+		log.Error(err, "unable to update health conditions", "instaslice", instaslice.Name)
+	}
+	if err := r.compactAllocations(ctx, instaslice); err != nil {
+		log.Error(err, "unable to compact allocations", "instaslice", instaslice.Name)
+	}
+	if err := r.reconcileNodeCapacity(ctx, instaslice); err != nil {
+		log.Error(err, "unable to reconcile node capacity", "instaslice", instaslice.Name)
+	}
+	if err := r.reconcileGPUStatus(ctx, instaslice); err != nil {
+		log.Error(err, "unable to update GPU status", "instaslice", instaslice.Name)
+	}
+	if err := r.recordAllocationHistory(ctx, instaslice); err != nil {
+		log.Error(err, "unable to record allocation history", "instaslice", instaslice.Name)
+	}
+	if err := r.recycleAgedAllocations(ctx, instaslice); err != nil {
+		log.Error(err, "unable to recycle aged allocations", "instaslice", instaslice.Name)
+	}
+	if err := r.drainDisabledGPUs(ctx, instaslice); err != nil {
+		log.Error(err, "unable to drain disabled GPUs", "instaslice", instaslice.Name)
+	}
+	if err := r.drainForUpgradeWindow(ctx, instaslice); err != nil {
+		log.Error(err, "unable to drain for the upgrade window", "instaslice", instaslice.Name)
+	}
+	if err := r.releaseOrphanedAllocations(ctx, instaslice); err != nil {
+		log.Error(err, "unable to release orphaned allocations", "instaslice", instaslice.Name)
+	}
+}
+
+// reconcileNodeResourceConsistency cross-checks the realized allocations of an Instaslice against
+// the InstaSlice extended resources advertised by its node and records the outcome as a condition.
+func (r *InstasliceReconciler) reconcileNodeResourceConsistency(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return r.flagMissingNode(ctx, instaslice)
+		}
+		return err
+	}
+
+	mismatches := nodeResourceMismatches(instaslice, node)
+	condition := metav1.Condition{
+		Type:    NodeResourcesConsistentCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Consistent",
+		Message: "realized allocations match the node extended resources",
+	}
+	if len(mismatches) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Mismatch"
+		condition.Message = strings.Join(mismatches, "; ")
+	}
+
+	original := instaslice.DeepCopy()
+	nodeAvailableChanged := meta.SetStatusCondition(&instaslice.Status.Conditions, metav1.Condition{
+		Type:    NodeAvailableCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "NodeFound",
+		Message: "the node of the instaslice exists",
+	})
+	if !meta.SetStatusCondition(&instaslice.Status.Conditions, condition) && !nodeAvailableChanged {
+		return nil
+	}
+	return r.Status().Patch(ctx, instaslice, client.MergeFrom(original))
+}
+
+// reconcileHealthConditions summarizes the node and the discovered GPUs of the Instaslice as the Ready
+// condition and the checks impairing allocations as the Degraded condition
+func (r *InstasliceReconciler) reconcileHealthConditions(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	ready := metav1.Condition{
+		Type:    ReadyCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "GPUsDiscovered",
+		Message: fmt.Sprintf("%d GPUs discovered on the node", len(instaslice.Status.NodeResources.NodeGPUs)),
+	}
+	if meta.IsStatusConditionFalse(instaslice.Status.Conditions, NodeAvailableCondition) {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "NodeMissing", "the node of the instaslice does not exist"
+	} else if len(instaslice.Status.NodeResources.NodeGPUs) == 0 {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "NoGPUsDiscovered", "no GPU was discovered on the node yet"
+	}
+
+	degraded := metav1.Condition{
+		Type:    DegradedCondition,
+		Status:  metav1.ConditionFalse,
+		Reason:  "AsExpected",
+		Message: "allocations on the node are not impaired",
+	}
+	if consistent := meta.FindStatusCondition(instaslice.Status.Conditions, NodeResourcesConsistentCondition); consistent != nil && consistent.Status == metav1.ConditionFalse {
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, "NodeResourcesMismatch", consistent.Message
+	} else if len(instaslice.Spec.DisabledGPUs) > 0 {
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, "GPUsDisabled",
+			"no slices are placed on the disabled GPUs "+strings.Join(instaslice.Spec.DisabledGPUs, ", ")
+	}
+
+	original := instaslice.DeepCopy()
+	readyChanged := meta.SetStatusCondition(&instaslice.Status.Conditions, ready)
+	if !meta.SetStatusCondition(&instaslice.Status.Conditions, degraded) && !readyChanged {
+		return nil
+	}
+	return r.Status().Patch(ctx, instaslice, client.MergeFrom(original))
+}
+
+// reconcileNodeCapacity advertises the slices of every profile the enabled GPUs of the node can hold as the
+// InstaSlice extended resources of the node. The kubelet subtracts the slices requested by the pods bound to the
+// node, the default scheduler does not oversubscribe the node for pods it places itself. Allocations are not
+// deducted here, they would be counted twice, and the count matches the capacity the daemonset advertises when
+// no GPU is disabled.
+func (r *InstasliceReconciler) reconcileNodeCapacity(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name}, node); err != nil {
+		// a missing node is flagged by the consistency check
+		return client.IgnoreNotFound(err)
+	}
+	original := node.DeepCopy()
+	if node.Status.Capacity == nil {
+		node.Status.Capacity = make(v1.ResourceList)
+	}
+	if node.Status.Allocatable == nil {
+		node.Status.Allocatable = make(v1.ResourceList)
+	}
+	var changed bool
+	for profile, placeable := range placeableSlicesByProfile(instaslice) {
+		resourceName := v1.ResourceName(OrgInstaslicePrefix + "mig-" + profile)
+		quantity := *resource.NewQuantity(placeable, resource.DecimalSI)
+		for _, resources := range []v1.ResourceList{node.Status.Capacity, node.Status.Allocatable} {
+			if current, ok := resources[resourceName]; !ok || current.Cmp(quantity) != 0 {
+				resources[resourceName] = quantity
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return r.Status().Patch(ctx, node, client.MergeFrom(original))
+}
+
+// placeableSlicesByProfile counts for every profile the slices of it the enabled GPUs of the Instaslice can
+// hold, regardless of the allocations in place
+func placeableSlicesByProfile(instaslice *inferencev1alpha1.Instaslice) map[string]int64 {
+	placeable := make(map[string]int64)
+	var enabledGPUs int64
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		if !slices.Contains(instaslice.Spec.DisabledGPUs, gpu.GPUUUID) {
+			enabledGPUs++
+		}
+	}
+	for profile, migPlacement := range instaslice.Status.NodeResources.MigPlacement {
+		var perGPU int64
+		for _, placement := range migPlacement.Placements {
+			if placement.Size > 0 {
+				perGPU++
+			}
+		}
+		placeable[profile] = perGPU * enabledGPUs
+	}
+	return placeable
+}
+
+// freeSlicesOnGPU counts for every profile how many more slices of it fit the GPU around the allocations in
+// place, none fit a disabled GPU
+func freeSlicesOnGPU(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) map[string]int64 {
+	free := make(map[string]int64)
+	disabled := slices.Contains(instaslice.Spec.DisabledGPUs, gpuUUID)
+	for profile, migPlacement := range instaslice.Status.NodeResources.MigPlacement {
+		free[profile] = 0
+		if disabled {
+			continue
+		}
+		placements := slices.Clone(migPlacement.Placements)
+		sort.Slice(placements, func(i, j int) bool {
+			return placements[i].Start < placements[j].Start
+		})
+		used := make(map[int32]bool)
+		for _, allocResult := range instaslice.Status.PodAllocationResults {
+			if allocResult.GPUUUID != gpuUUID || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			for i := allocResult.MigPlacement.Start; i < allocResult.MigPlacement.Start+allocResult.MigPlacement.Size; i++ {
+				used[i] = true
+			}
+		}
+		for _, placement := range placements {
+			fits := placement.Size > 0
+			for i := placement.Start; fits && i < placement.Start+placement.Size; i++ {
+				fits = !used[i]
+			}
+			if !fits {
+				continue
+			}
+			for i := placement.Start; i < placement.Start+placement.Size; i++ {
+				used[i] = true
+			}
+			free[profile]++
+		}
+	}
+	return free
+}
+
+// flagMissingNode marks an Instaslice whose node was deleted so that its phantom capacity is
+// not used for placement, and removes its allocations. No daemonset is left on the node to tear
+// the slices down, they went away with it.
+func (r *InstasliceReconciler) flagMissingNode(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	original := instaslice.DeepCopy()
+	changed := meta.SetStatusCondition(&instaslice.Status.Conditions, metav1.Condition{
+		Type:    NodeAvailableCondition,
+		Status:  metav1.ConditionFalse,
+		Reason:  "NodeNotFound",
+		Message: fmt.Sprintf("node %s no longer exists", instaslice.Name),
+	})
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+		instaslice.Status.PodAllocationResults[podUID] = allocResult
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	logr.FromContext(ctx).Info("node of instaslice no longer exists, removing its allocations", "instaslice", instaslice.Name)
+	utils.SetGPUStatus(instaslice)
+	if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	// removing allocations drops every allocation marked Deleted
+	return utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), instaslice.Namespace, instaslice.Name, nil, nil)
+}
+
+// nodeResourceMismatches lists realized allocations whose profile is not advertised on the node
+// and advertised InstaSlice resources whose profile the Instaslice object does not know about.
+func nodeResourceMismatches(instaslice *inferencev1alpha1.Instaslice, node *v1.Node) []string {
+	resourcePrefix := OrgInstaslicePrefix + "mig-"
+	advertised := make(map[string]bool)
+	for resourceName := range node.Status.Capacity {
+		if profile, ok := strings.CutPrefix(string(resourceName), resourcePrefix); ok {
+			advertised[profile] = true
+		}
+	}
+
+	var mismatches []string
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusCreated {
+			continue
+		}
+		profile := instaslice.Spec.PodAllocationRequests[podUID].Profile
+		if !advertised[profile] {
+			mismatches = append(mismatches, fmt.Sprintf("allocation %s uses profile %s which is not advertised by node %s", podUID, profile, node.Name))
+		}
+	}
+	for profile := range advertised {
+		if _, ok := instaslice.Status.NodeResources.MigPlacement[profile]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("node %s advertises %s%s which has no placement on the instaslice", node.Name, resourcePrefix, profile))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// recordAllocationHistory samples the number of allocations held by each GPU of the Instaslice,
+// keeping at most the configured number of samples per GPU so the status stays bounded.
+func (r *InstasliceReconciler) recordAllocationHistory(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	limit := r.Config.AllocationHistoryLimit
+	if limit <= 0 {
+		return nil
+	}
+
+	counts := make(map[string]int32)
+	for _, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		counts[allocResult.GPUUUID]++
+	}
+
+	original := instaslice.DeepCopy()
+	if instaslice.Status.AllocationHistory == nil {
+		instaslice.Status.AllocationHistory = make(map[string][]inferencev1alpha1.AllocationSample)
+	}
+	now := metav1.Now()
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		samples := append(instaslice.Status.AllocationHistory[gpu.GPUUUID], inferencev1alpha1.AllocationSample{
+			Timestamp: now,
+			Count:     counts[gpu.GPUUUID],
+		})
+		if len(samples) > limit {
+			samples = samples[len(samples)-limit:]
+		}
+		instaslice.Status.AllocationHistory[gpu.GPUUUID] = samples
+	}
+	return r.Status().Patch(ctx, instaslice, client.MergeFrom(original))
+}
+
+// compactAllocations removes allocation entries that are not keyed by the slice of their pod and results
+// left without a request, so stale keys cannot accumulate in the allocation maps. A stale entry whose
+// slice was realized is released first and removed once the daemonset cleaned it up.
+func (r *InstasliceReconciler) compactAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	var staleKeys []types.UID
+	for podUID, allocRequest := range instaslice.Spec.PodAllocationRequests {
+		if allocRequest.PodRef.UID != "" && utils.SliceAllocationKey(allocRequest.PodRef.UID, allocRequest.SliceIndex) != podUID {
+			staleKeys = append(staleKeys, podUID)
+		}
+	}
+	for podUID := range instaslice.Status.PodAllocationResults {
+		if _, ok := instaslice.Spec.PodAllocationRequests[podUID]; !ok {
+			staleKeys = append(staleKeys, podUID)
+		}
+	}
+	if len(staleKeys) == 0 {
+		return nil
+	}
+
+	var removedKeys []types.UID
+	statusOriginal := instaslice.DeepCopy()
+	for _, podUID := range staleKeys {
+		allocResult, ok := instaslice.Status.PodAllocationResults[podUID]
+		if ok && allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated {
+			allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+			instaslice.Status.PodAllocationResults[podUID] = allocResult
+			continue
+		}
+		delete(instaslice.Status.PodAllocationResults, podUID)
+		removedKeys = append(removedKeys, podUID)
+	}
+	logr.FromContext(ctx).Info("compacting stale allocation keys", "instaslice", instaslice.Name, "removed", removedKeys)
+	utils.SetGPUStatus(instaslice)
+	if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(statusOriginal)); err != nil {
+		return err
+	}
+	for _, podUID := range staleKeys {
+		allocResult, ok := statusOriginal.Status.PodAllocationResults[podUID]
+		if !ok {
+			continue
+		}
+		allocRequest, hasRequest := statusOriginal.Spec.PodAllocationRequests[podUID]
+		if _, kept := instaslice.Status.PodAllocationResults[podUID]; !kept {
+			utils.RecordAllocationDeleted(allocRequest.Profile)
+		}
+		if hasRequest && !isWarmSlice(allocRequest) &&
+			allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusDeleting {
+			r.Accounting.Emit(newAccountingRecord(AccountingEventRelease, &allocRequest, &allocResult))
+		}
+	}
+
+	specOriginal := instaslice.DeepCopy()
+	for _, podUID := range removedKeys {
+		delete(instaslice.Spec.PodAllocationRequests, podUID)
+	}
+	if equality.Semantic.DeepEqual(specOriginal.Spec, instaslice.Spec) {
+		return nil
+	}
+	return r.Patch(ctx, instaslice, client.MergeFrom(specOriginal))
+}
+
+// reconcileGPUStatus refreshes the per GPU slice accounting, it is kept current on every allocation
+// change made by the controller and caught up here after changes made by the daemonset.
+func (r *InstasliceReconciler) reconcileGPUStatus(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	original := instaslice.DeepCopy()
+	utils.SetGPUStatus(instaslice)
+	if equality.Semantic.DeepEqual(original.Status, instaslice.Status) {
+		return nil
+	}
+	return r.Status().Patch(ctx, instaslice, client.MergeFrom(original))
+}
+
+// recycleAgedAllocations evicts non-critical pods that held their slice for longer than the configured
+// maximum age to give other workloads a turn, the slice is released by the regular deletion flow.
+func (r *InstasliceReconciler) recycleAgedAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	maxAge := r.Config.MaxAllocationAge
+	if maxAge <= 0 {
+		return nil
+	}
+	log := logr.FromContext(ctx)
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusUngated {
+			continue
+		}
+		podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef
+		pod := &v1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Name: podRef.Name, Namespace: podRef.Namespace}, pod); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !utils.IsSliceOfPod(podUID, pod.UID) || !pod.DeletionTimestamp.IsZero() || isCriticalPod(pod) {
+			continue
+		}
+		if pod.Status.StartTime == nil || time.Since(pod.Status.StartTime.Time) < maxAge {
+			continue
+		}
+		log.Info("evicting pod holding its slice beyond the maximum allocation age", "pod", pod.Name, "maxAge", maxAge)
+		if err := r.evictPod(ctx, pod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainDisabledGPUs evicts the pods running on slices of disabled GPUs and releases the
+// allocations on them that have not been ungated yet.
+func (r *InstasliceReconciler) drainDisabledGPUs(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	if len(instaslice.Spec.DisabledGPUs) == 0 {
+		return nil
+	}
+	return r.drainAllocations(ctx, instaslice, "disabled GPU", func(allocResult inferencev1alpha1.AllocationResult) bool {
+		return slices.Contains(instaslice.Spec.DisabledGPUs, allocResult.GPUUUID)
+	})
+}
+
+// drainAllocations evicts the pods running on the slices the filter selects and releases the selected
+// allocations that have not been ungated yet, the reason is logged with every eviction.
+func (r *InstasliceReconciler) drainAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, reason string, drains func(inferencev1alpha1.AllocationResult) bool) error {
+	log := logr.FromContext(ctx)
+	original := instaslice.DeepCopy()
+	var released []types.UID
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		if !drains(allocResult) ||
+			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted ||
+			allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting {
+			continue
+		}
+		if allocResult.AllocationStatus.AllocationStatusController != inferencev1alpha1.AllocationStatusUngated {
+			// the daemonset only tears down the slices it created, one it has not picked up yet is deleted right away
+			if allocResult.AllocationStatus.AllocationStatusDaemonset == "" {
+				allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+			} else {
+				allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+			}
+			instaslice.Status.PodAllocationResults[podUID] = allocResult
+			released = append(released, podUID)
+			continue
+		}
+		podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef
+		pod := &v1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Name: podRef.Name, Namespace: podRef.Namespace}, pod); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !utils.IsSliceOfPod(podUID, pod.UID) || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		log.Info("evicting pod", "pod", pod.Name, "gpuUUID", allocResult.GPUUUID, "reason", reason)
+		if err := r.evictPod(ctx, pod); err != nil {
+			return err
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	for _, podUID := range released {
+		allocRequest, allocResult := instaslice.Spec.PodAllocationRequests[podUID], instaslice.Status.PodAllocationResults[podUID]
+		if !isWarmSlice(allocRequest) {
+			r.Accounting.Emit(newAccountingRecord(AccountingEventRelease, &allocRequest, &allocResult))
+		}
+	}
+	return nil
+}
+
+// releaseOrphanedAllocations releases the allocations whose pod is gone, e.g. a pod force deleted or deleted
+// while the controller was down. The deletion of such a pod is never reconciled, its slice would otherwise
+// stay allocated. Released allocations go through Deleting, the daemonset tears the slice down and marks it
+// Deleted, the following sweep removes it. Warm slices have no pod and are left to the warm pools.
+func (r *InstasliceReconciler) releaseOrphanedAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	original := instaslice.DeepCopy()
+	var released []types.UID
+	var deleted bool
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		allocRequest, ok := instaslice.Spec.PodAllocationRequests[podUID]
+		if !ok || isWarmSlice(allocRequest) {
+			continue
+		}
+		status := allocResult.AllocationStatus
+		// the daemonset did not pick up the allocation yet, it is released once it answered
+		if status.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating && status.AllocationStatusDaemonset == "" {
+			continue
+		}
+		if status.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting && status.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		pod := &v1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Name: allocRequest.PodRef.Name, Namespace: allocRequest.PodRef.Namespace}, pod); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+		} else if utils.IsSliceOfPod(podUID, pod.UID) {
+			continue
+		}
+		if status.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			deleted = true
+			continue
+		}
+		allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+		instaslice.Status.PodAllocationResults[podUID] = allocResult
+		released = append(released, podUID)
+	}
+	if len(released) > 0 {
+		logr.FromContext(ctx).WithName(LogSubsystemDeletion).Info("releasing allocations without a pod", "instaslice", instaslice.Name, "released", released)
+		utils.SetGPUStatus(instaslice)
+		if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(original)); err != nil {
+			return err
+		}
+		for _, podUID := range released {
+			allocRequest, allocResult := instaslice.Spec.PodAllocationRequests[podUID], instaslice.Status.PodAllocationResults[podUID]
+			r.Accounting.Emit(newAccountingRecord(AccountingEventRelease, &allocRequest, &allocResult))
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	// removing allocations drops every allocation the daemonset marked Deleted
+	logr.FromContext(ctx).WithName(LogSubsystemDeletion).Info("removing deleted allocations without a pod", "instaslice", instaslice.Name)
+	return utils.UpdateOrDeleteInstasliceAllocations(ctx, r.Client, r.apiReader(), instaslice.Namespace, instaslice.Name, nil, nil)
+}
+
+// evictPod evicts the pod through the eviction API so that disruption budgets are honored
+func (r *InstasliceReconciler) evictPod(ctx context.Context, pod *v1.Pod) error {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	return r.SubResource("eviction").Create(ctx, pod, eviction)
+}
+
+// isCriticalPod reports pods that are exempt from recycling
+func isCriticalPod(pod *v1.Pod) bool {
+	if pod.Annotations[CriticalPodAnnotation] == "true" {
+		return true
+	}
+	return pod.Spec.PriorityClassName == "system-cluster-critical" || pod.Spec.PriorityClassName == "system-node-critical"
+}
@@ -0,0 +1,615 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func TestSweep_NodeResourceConsistency(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	// the node neither advertises the realized 1g.5gb profile nor knows of a 9g.99gb placement
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Capacity: v1.ResourceList{
+			OrgInstaslicePrefix + "mig-2g.10gb": resource.MustParse("6"),
+			OrgInstaslicePrefix + "mig-9g.99gb": resource.MustParse("1"),
+		}},
+	}
+	r, fakeClient := newTestReconciler(t, instaslice, node)
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	condition := meta.FindStatusCondition(current.Status.Conditions, NodeResourcesConsistentCondition)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Mismatch", condition.Reason)
+		assert.Contains(t, condition.Message, "profile 1g.5gb which is not advertised")
+		assert.Contains(t, condition.Message, "mig-9g.99gb which has no placement")
+	}
+
+	// advertising the realized profile and dropping the unknown one resolves the mismatch
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(node), node))
+	node.Status.Capacity = v1.ResourceList{OrgInstaslicePrefix + "mig-1g.5gb": resource.MustParse("14")}
+	assert.NoError(t, fakeClient.Status().Update(ctx, node))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	assert.True(t, meta.IsStatusConditionTrue(current.Status.Conditions, NodeResourcesConsistentCondition))
+}
+
+func TestSweep_NodeCapacity(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, instaslice, node)
+	nodeSlices := func(profile string) int64 {
+		current := &v1.Node{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(node), current))
+		capacity := current.Status.Capacity[v1.ResourceName(OrgInstaslicePrefix+"mig-"+profile)]
+		allocatable := current.Status.Allocatable[v1.ResourceName(OrgInstaslicePrefix+"mig-"+profile)]
+		assert.Equal(t, capacity.Value(), allocatable.Value(), profile)
+		return capacity.Value()
+	}
+
+	// the node advertises every slice its two GPUs can hold, the kubelet deducts the allocated ones
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.Equal(t, int64(14), nodeSlices("1g.5gb"))
+	assert.Equal(t, int64(6), nodeSlices("2g.10gb"))
+	assert.Equal(t, int64(4), nodeSlices("3g.20gb"))
+	assert.Equal(t, int64(2), nodeSlices("7g.40gb"))
+
+	// a disabled GPU holds no slice
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	current.Spec.DisabledGPUs = []string{current.Status.NodeResources.NodeGPUs[1].GPUUUID}
+	assert.NoError(t, fakeClient.Update(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.Equal(t, int64(7), nodeSlices("1g.5gb"))
+	assert.Equal(t, int64(3), nodeSlices("2g.10gb"))
+	assert.Equal(t, int64(2), nodeSlices("3g.20gb"))
+	assert.Equal(t, int64(1), nodeSlices("7g.40gb"))
+}
+
+func TestSweep_AllocationHistory(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	gpuUUID := instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID
+	idleGPUUUID := instaslice.Status.NodeResources.NodeGPUs[1].GPUUUID
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, instaslice, node)
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	// nothing is sampled unless a history limit is configured
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Empty(t, current.Status.AllocationHistory)
+
+	r.Config.AllocationHistoryLimit = 3
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, r.sweepInstaslices(ctx))
+	}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Len(t, current.Status.AllocationHistory[gpuUUID], 2)
+
+	// a second allocation lands on the same GPU
+	current.Status.PodAllocationResults["pod-2-uid"] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 1, Size: 1},
+		GPUUUID:          gpuUUID,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusDaemonset: inferencev1alpha1.AllocationStatusCreated},
+	}
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, r.sweepInstaslices(ctx))
+	}
+
+	// the oldest sample rolled over once the cap was reached
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	var counts []int32
+	for _, sample := range current.Status.AllocationHistory[gpuUUID] {
+		counts = append(counts, sample.Count)
+	}
+	assert.Equal(t, []int32{1, 2, 2}, counts)
+	assert.Len(t, current.Status.AllocationHistory[idleGPUUUID], 3)
+	assert.Equal(t, int32(0), current.Status.AllocationHistory[idleGPUUUID][2].Count)
+}
+
+func TestSweep_RecycleAgedAllocations(t *testing.T) {
+	ctx := context.TODO()
+	ungated := inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	}
+	tests := []struct {
+		name        string
+		startedAgo  time.Duration
+		critical    bool
+		expectEvict bool
+	}{
+		{name: "over-age allocation is recycled", startedAgo: 2 * time.Hour, expectEvict: true},
+		{name: "young allocation is kept", startedAgo: 10 * time.Minute},
+		{name: "critical pod is kept", startedAgo: 2 * time.Hour, critical: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startTime := metav1.NewTime(time.Now().Add(-tt.startedAgo))
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, StartTime: &startTime},
+			}
+			if tt.critical {
+				pod.Annotations = map[string]string{CriticalPodAnnotation: "true"}
+			}
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+			r, fakeClient := newTestReconciler(t, pod, node, newTestAllocation("node-1", pod, ungated))
+			r.Config.MaxAllocationAge = time.Hour
+
+			assert.NoError(t, r.sweepInstaslices(ctx))
+			err := fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), &v1.Pod{})
+			if tt.expectEvict {
+				assert.True(t, errors.IsNotFound(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSweep_MissingNode(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	r, fakeClient := newTestReconciler(t, instaslice)
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	assert.True(t, meta.IsStatusConditionFalse(current.Status.Conditions, NodeAvailableCondition))
+	// the slices went away with the node, no daemonset is left to tear them down
+	assert.NotContains(t, current.Spec.PodAllocationRequests, pod.UID)
+	assert.NotContains(t, current.Status.PodAllocationResults, pod.UID)
+
+	// the flagged instaslice is no longer offered for placement
+	_, _, err := r.findNodeAndDeviceForASlice(ctx, current, "1g.5gb", &FirstFitPolicy{}, newTestGatedPod("pod-2", "1g.5gb"))
+	assert.ErrorContains(t, err, "node node-1 of the instaslice no longer exists")
+
+	// the node coming back clears the flag
+	assert.NoError(t, fakeClient.Create(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	assert.True(t, meta.IsStatusConditionTrue(current.Status.Conditions, NodeAvailableCondition))
+}
+
+func TestSweep_DrainDisabledGPUs(t *testing.T) {
+	ctx := context.TODO()
+	running := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", running, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	disabledGPU := instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID
+	instaslice.Spec.DisabledGPUs = []string{disabledGPU}
+	// a second pod is still waiting for its slice on the disabled GPU
+	pending := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default", UID: "pod-2-uid"}}
+	instaslice.Spec.PodAllocationRequests[pending.UID] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: pending.Name, Namespace: pending.Namespace, UID: pending.UID},
+	}
+	instaslice.Status.PodAllocationResults[pending.UID] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 1, Size: 1},
+		GPUUUID:          disabledGPU,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, running, pending, node, instaslice)
+	r.Accounting = NewAccountingHook("http://localhost")
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	err := fakeClient.Get(ctx, client.ObjectKeyFromObject(running), &v1.Pod{})
+	assert.True(t, errors.IsNotFound(err))
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(pending), &v1.Pod{}))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	// the daemonset has not picked the pending slice up, it is deleted right away
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, current.Status.PodAllocationResults[pending.UID].AllocationStatus.AllocationStatusDaemonset)
+	// both slices are accounted as released, the one of the evicted pod once the pod is gone
+	var releasedPods []string
+	for len(r.Accounting.records) > 0 {
+		record := <-r.Accounting.records
+		assert.Equal(t, AccountingEventRelease, record.Event)
+		releasedPods = append(releasedPods, record.Pod)
+	}
+	assert.ElementsMatch(t, []string{running.Name, pending.Name}, releasedPods)
+}
+
+func TestSweep_OrphanedAllocations(t *testing.T) {
+	ctx := context.TODO()
+	ungated := inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	}
+	// the pod was deleted right after it was ungated, it is not in the cluster
+	deleted := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", deleted, ungated)
+	running := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default", UID: "pod-2-uid"}}
+	// a pod recreated under the same name does not own the allocation of its predecessor
+	recreated := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-3", Namespace: "default", UID: "pod-3-new-uid"}}
+	for i, pod := range []*v1.Pod{running, {ObjectMeta: metav1.ObjectMeta{Name: "pod-3", Namespace: "default", UID: "pod-3-uid"}}} {
+		instaslice.Spec.PodAllocationRequests[pod.UID] = inferencev1alpha1.AllocationRequest{
+			Profile: "1g.5gb",
+			PodRef:  v1.ObjectReference{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID},
+		}
+		instaslice.Status.PodAllocationResults[pod.UID] = inferencev1alpha1.AllocationResult{
+			MigPlacement:     inferencev1alpha1.Placement{Start: int32(i + 1), Size: 1},
+			GPUUUID:          instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID,
+			AllocationStatus: ungated,
+		}
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, running, recreated, node, instaslice)
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, current.Status.PodAllocationResults[deleted.UID].AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, current.Status.PodAllocationResults["pod-3-uid"].AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, current.Status.PodAllocationResults[running.UID].AllocationStatus.AllocationStatusController)
+}
+
+func TestSweep_OrphanedAllocationCleanup(t *testing.T) {
+	ctx := context.TODO()
+	// the pod was force deleted while its slice was being created
+	deleted := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", deleted, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+	})
+	// an allocation the daemonset did not answer yet and a warm slice are left alone
+	pending := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default", UID: "pod-2-uid"}}
+	instaslice.Spec.PodAllocationRequests[pending.UID] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: pending.Name, Namespace: pending.Namespace, UID: pending.UID},
+	}
+	instaslice.Status.PodAllocationResults[pending.UID] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 1, Size: 1},
+		GPUUUID:          instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	newTestWarmSlice(instaslice, "chat", "warm-1", 2, time.Now())
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, node, instaslice)
+	r.Config.WarmPools = map[string]int{warmPoolKey("chat", "1g.5gb"): 1}
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	allocResult := current.Status.PodAllocationResults[deleted.UID]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, current.Status.PodAllocationResults[pending.UID].AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, current.Status.PodAllocationResults["warm-1"].AllocationStatus.AllocationStatusController)
+
+	// the slice stays allocated until the daemonset tore it down
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Contains(t, current.Spec.PodAllocationRequests, deleted.UID)
+
+	// once it did, the following sweep removes the allocation
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	current.Status.PodAllocationResults[deleted.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.NotContains(t, current.Spec.PodAllocationRequests, deleted.UID)
+	assert.NotContains(t, current.Status.PodAllocationResults, deleted.UID)
+	assert.Contains(t, current.Status.PodAllocationResults, pending.UID)
+	assert.Contains(t, current.Status.PodAllocationResults, types.UID("warm-1"))
+}
+
+func TestSweep_GPUStatus(t *testing.T) {
+	ctx := context.TODO()
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, pod, node, utils.GenerateFakeCapacity("node-1"))
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	// the accounting follows the allocation made by the controller
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	allocResult := current.Status.PodAllocationResults[pod.UID]
+	assert.Equal(t, inferencev1alpha1.GPUStatus{
+		TotalSlices: 8,
+		UsedSlices:  1,
+		FreeSlices:  7,
+		Occupants:   []string{"default/pod-1"},
+	}, current.Status.GPUStatus[allocResult.GPUUUID])
+	assert.Len(t, current.Status.GPUStatus, len(current.Status.NodeResources.NodeGPUs))
+	// the node totals add up the two GPUs
+	assert.Equal(t, []int32{16, 1, 15}, []int32{current.Status.TotalSlices, current.Status.UsedSlices, current.Status.FreeSlices})
+
+	// the sweep catches up with the slice torn down by the daemonset
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+	current.Status.PodAllocationResults[pod.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Equal(t, inferencev1alpha1.GPUStatus{TotalSlices: 8, FreeSlices: 8}, current.Status.GPUStatus[allocResult.GPUUUID])
+	assert.Equal(t, []int32{16, 0, 16}, []int32{current.Status.TotalSlices, current.Status.UsedSlices, current.Status.FreeSlices})
+
+	// GPUs advertising four slice indexes, like the A30, count four
+	current.Status.NodeResources.MigPlacement = map[string]inferencev1alpha1.Mig{
+		"1g.6gb": {Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 1}, {Start: 1, Size: 1}, {Start: 2, Size: 1}, {Start: 3, Size: 1}}},
+	}
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Equal(t, inferencev1alpha1.GPUStatus{TotalSlices: 4, FreeSlices: 4}, current.Status.GPUStatus[allocResult.GPUUUID])
+	assert.Equal(t, []int32{8, 0, 8}, []int32{current.Status.TotalSlices, current.Status.UsedSlices, current.Status.FreeSlices})
+}
+
+func TestSweep_HealthConditions(t *testing.T) {
+	ctx := context.TODO()
+	undiscovered := utils.GenerateFakeCapacity("node-2")
+	undiscovered.Status.NodeResources.NodeGPUs = nil
+	r, fakeClient := newTestReconciler(t,
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		utils.GenerateFakeCapacity("node-1"), undiscovered, utils.GenerateFakeCapacity("node-3"))
+	conditions := func(name string) (ready, degraded *metav1.Condition) {
+		instaslice := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: name, Namespace: InstaSliceOperatorNamespace}, instaslice))
+		return meta.FindStatusCondition(instaslice.Status.Conditions, ReadyCondition), meta.FindStatusCondition(instaslice.Status.Conditions, DegradedCondition)
+	}
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	ready, degraded := conditions("node-1")
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, metav1.ConditionFalse, degraded.Status)
+	ready, _ = conditions("node-2")
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "NoGPUsDiscovered", ready.Reason)
+	// the node of node-3 is gone
+	ready, _ = conditions("node-3")
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "NodeMissing", ready.Reason)
+
+	// disabling a GPU degrades the node
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	instaslice.Spec.DisabledGPUs = []string{instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID}
+	assert.NoError(t, fakeClient.Update(ctx, instaslice))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	ready, degraded = conditions("node-1")
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+	assert.Equal(t, "GPUsDisabled", degraded.Reason)
+}
+
+func TestSweep_CompactAllocations(t *testing.T) {
+	ctx := context.TODO()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	gpuUUID := instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID
+	// an allocation stored under a key other than the UID of its pod
+	instaslice.Spec.PodAllocationRequests["allocation-1"] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Name: "pod-2", Namespace: "default", UID: "pod-2-uid"},
+	}
+	instaslice.Status.PodAllocationResults["allocation-1"] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 1, Size: 1},
+		GPUUUID:          gpuUUID,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	// results left behind without a request, one of them realized on the GPU
+	instaslice.Status.PodAllocationResults["orphan-uid"] = inferencev1alpha1.AllocationResult{
+		MigPlacement:     inferencev1alpha1.Placement{Start: 2, Size: 1},
+		GPUUUID:          gpuUUID,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating},
+	}
+	instaslice.Status.PodAllocationResults["realized-orphan-uid"] = inferencev1alpha1.AllocationResult{
+		MigPlacement: inferencev1alpha1.Placement{Start: 3, Size: 1},
+		GPUUUID:      gpuUUID,
+		AllocationStatus: inferencev1alpha1.AllocationStatus{
+			AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+		},
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	r, fakeClient := newTestReconciler(t, pod, node, instaslice)
+	deleted := map[string]string{"event": "deleted", "profile": "1g.5gb"}
+	deletedBefore := metricValue(t, "instaslice_allocations_total", deleted)
+	orphans := map[string]string{"event": "deleted", "profile": ""}
+	orphansBefore := metricValue(t, "instaslice_allocations_total", orphans)
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	// the removed allocations are counted, the realized one once the daemonset deleted it
+	assert.Equal(t, deletedBefore+1, metricValue(t, "instaslice_allocations_total", deleted))
+	assert.Equal(t, orphansBefore+1, metricValue(t, "instaslice_allocations_total", orphans))
+	assert.NotContains(t, current.Spec.PodAllocationRequests, types.UID("allocation-1"))
+	assert.NotContains(t, current.Status.PodAllocationResults, types.UID("allocation-1"))
+	assert.NotContains(t, current.Status.PodAllocationResults, types.UID("orphan-uid"))
+	// the realized slice is released through the daemonset instead of being dropped
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting,
+		current.Status.PodAllocationResults["realized-orphan-uid"].AllocationStatus.AllocationStatusController)
+	// the allocation keyed by its pod UID is untouched
+	assert.Contains(t, current.Spec.PodAllocationRequests, pod.UID)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, current.Status.PodAllocationResults[pod.UID].AllocationStatus.AllocationStatusController)
+	assert.Equal(t, int32(2), current.Status.GPUStatus[gpuUUID].UsedSlices)
+}
+
+func TestSweep_Concurrency(t *testing.T) {
+	ctx := context.TODO()
+	var objs []client.Object
+	for i := 0; i < 6; i++ {
+		nodeName := fmt.Sprintf("node-%d", i)
+		objs = append(objs, utils.GenerateFakeCapacity(nodeName), &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}})
+	}
+	r, fakeClient := newTestReconciler(t, objs...)
+	r.Config.SweepConcurrency = 2
+	// every Instaslice check starts by reading its node, the reads are held to observe the overlap
+	var inFlight, maxInFlight atomic.Int32
+	r.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if _, ok := obj.(*v1.Node); ok {
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					observed := maxInFlight.Load()
+					if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.Equal(t, int32(2), maxInFlight.Load())
+	// every object was checked
+	for i := 0; i < 6; i++ {
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("node-%d", i), Namespace: InstaSliceOperatorNamespace}, current))
+		assert.True(t, meta.IsStatusConditionTrue(current.Status.Conditions, NodeAvailableCondition))
+	}
+}
+
+func TestSweep_InstasliceFinalizer(t *testing.T) {
+	ctx := context.TODO()
+	running := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", running, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	// a slice the daemonset created for a gated pod and one it has not picked up yet
+	created := newTestGatedPod("created", "1g.5gb")
+	unpicked := newTestGatedPod("unpicked", "1g.5gb")
+	for _, gated := range []*v1.Pod{created, unpicked} {
+		gatedInstaslice := newTestAllocation("node-1", gated, inferencev1alpha1.AllocationStatus{
+			AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+		})
+		instaslice.Spec.PodAllocationRequests[gated.UID] = gatedInstaslice.Spec.PodAllocationRequests[gated.UID]
+		instaslice.Status.PodAllocationResults[gated.UID] = gatedInstaslice.Status.PodAllocationResults[gated.UID]
+	}
+	createdResult := instaslice.Status.PodAllocationResults[created.UID]
+	createdResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Status.PodAllocationResults[created.UID] = createdResult
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	daemonsetPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "daemonset-node-1", Namespace: InstaSliceOperatorNamespace, Labels: daemonSetlabel},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+	}
+	r, fakeClient := newTestReconciler(t, running, created, unpicked, node, daemonsetPod, instaslice)
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	// the finalizer is added to an Instaslice created without it
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.Contains(t, current.Finalizers, InstasliceFinalizerName)
+
+	// deleting the Instaslice is blocked while a pod runs on its slice, the pod keeps running, the slices
+	// no pod runs on are released
+	assert.NoError(t, fakeClient.Delete(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.False(t, current.DeletionTimestamp.IsZero())
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(running), &v1.Pod{}))
+	allocResult := current.Status.PodAllocationResults[running.UID]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, allocResult.AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting,
+		current.Status.PodAllocationResults[created.UID].AllocationStatus.AllocationStatusController)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted,
+		current.Status.PodAllocationResults[unpicked.UID].AllocationStatus.AllocationStatusDaemonset)
+
+	// no new slice is placed on it meanwhile
+	pod := newTestGatedPod("pod-2", "1g.5gb")
+	_, _, err := r.placeOnInstaslice(current, "1g.5gb", &FirstFitPolicy{}, pod, nil, time.Now())
+	assert.ErrorContains(t, err, "is being deleted")
+
+	// the Instaslice is deleted once the pod completed and the daemonset tore every slice down
+	for _, podUID := range []types.UID{running.UID, created.UID} {
+		allocResult := current.Status.PodAllocationResults[podUID]
+		allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+		current.Status.PodAllocationResults[podUID] = allocResult
+	}
+	assert.NoError(t, fakeClient.Status().Update(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.True(t, errors.IsNotFound(fakeClient.Get(ctx, key, current)))
+}
+
+func TestSweep_InstasliceFinalizerNodeGone(t *testing.T) {
+	ctx := context.TODO()
+	running := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1-uid"}}
+	instaslice := newTestAllocation("node-1", running, inferencev1alpha1.AllocationStatus{
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+	})
+	instaslice.Finalizers = []string{InstasliceFinalizerName}
+	r, fakeClient := newTestReconciler(t, running, instaslice)
+	key := types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}
+
+	// nothing is left to tear the slices of a gone node down, the deletion does not wait for them
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, key, current))
+	assert.NoError(t, fakeClient.Delete(ctx, current))
+	assert.NoError(t, r.sweepInstaslices(ctx))
+	assert.True(t, errors.IsNotFound(fakeClient.Get(ctx, key, current)))
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+// teardownRetryDelay is how long a teardown that was not approved waits before the webhook is asked again
+const teardownRetryDelay = 5 * time.Second
+
+// errTeardownPending is returned while the teardown webhook has not approved the teardown of a slice yet
+var errTeardownPending = errors.New("teardown of the slice is awaiting approval")
+
+// TeardownRequest is sent to the teardown webhook before a slice is torn down, giving the workload a chance
+// to flush or checkpoint its state
+type TeardownRequest struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	PodUID    string `json:"podUID"`
+	Profile   string `json:"profile"`
+	Node      string `json:"node"`
+	GPU       string `json:"gpu"`
+}
+
+// TeardownResponse is the answer of the teardown webhook, the slice is kept until the teardown is approved
+type TeardownResponse struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// teardownKey identifies the slice of a pod on a node awaiting approval
+type teardownKey struct {
+	podUID     types.UID
+	instaslice string
+}
+
+// TeardownHook asks an external endpoint for approval before a slice is torn down. A teardown that is not
+// approved within the timeout goes ahead anyway so that a stuck endpoint never holds a slice forever.
+type TeardownHook struct {
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+
+	mu sync.Mutex
+	// pending remembers when the approval of a teardown was first asked for
+	pending map[teardownKey]time.Time
+}
+
+func NewTeardownHook(url string, timeout time.Duration) *TeardownHook {
+	return &TeardownHook{
+		URL:     url,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Timeout: timeout,
+		pending: make(map[teardownKey]time.Time),
+	}
+}
+
+// Approve posts the teardown request to the endpoint and returns its answer
+func (h *TeardownHook) Approve(ctx context.Context, request TeardownRequest) (TeardownResponse, error) {
+	response := TeardownResponse{}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return response, fmt.Errorf("teardown endpoint returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	return response, err
+}
+
+// pendingSince returns when the approval of the teardown was first asked for, starting the wait now if it
+// was not asked for before
+func (h *TeardownHook) pendingSince(key teardownKey) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since, ok := h.pending[key]
+	if !ok {
+		since = time.Now()
+		h.pending[key] = since
+	}
+	return since
+}
+
+// forget drops the wait of a teardown that goes ahead
+func (h *TeardownHook) forget(key teardownKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.pending, key)
+}
+
+// awaitTeardownApproval asks the teardown webhook whether the slice may be torn down, it reports false while
+// the teardown is denied or the webhook cannot be reached and the approval timeout has not passed yet. Only
+// slices the daemonset created hold workload state, every other slice is torn down right away.
+func (r *InstasliceReconciler) awaitTeardownApproval(ctx context.Context, instasliceName string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) bool {
+	if r.Teardown == nil ||
+		allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+		allocResult.AllocationStatus.AllocationStatusDaemonset != inferencev1alpha1.AllocationStatusCreated {
+		return true
+	}
+	log := logr.FromContext(ctx).WithName(LogSubsystemDeletion)
+	key := teardownKey{podUID: allocRequest.PodRef.UID, instaslice: instasliceName}
+	since := r.Teardown.pendingSince(key)
+	response, err := r.Teardown.Approve(ctx, TeardownRequest{
+		Namespace: allocRequest.PodRef.Namespace,
+		Pod:       allocRequest.PodRef.Name,
+		PodUID:    string(allocRequest.PodRef.UID),
+		Profile:   allocRequest.Profile,
+		Node:      string(allocResult.Nodename),
+		GPU:       allocResult.GPUUUID,
+	})
+	podRef := &v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: allocRequest.PodRef.Namespace,
+		Name: allocRequest.PodRef.Name, UID: allocRequest.PodRef.UID}
+	switch {
+	case err == nil && response.Approved:
+		r.Teardown.forget(key)
+		log.V(1).Info("teardown of slice approved", "pod", allocRequest.PodRef.Name, "instaslice", instasliceName)
+		return true
+	case time.Since(since) >= r.Teardown.Timeout:
+		r.Teardown.forget(key)
+		log.Info("tearing down slice without approval", "pod", allocRequest.PodRef.Name, "instaslice", instasliceName, "timeout", r.Teardown.Timeout)
+		if r.Recorder != nil {
+			r.Recorder.Event(podRef, v1.EventTypeWarning, "TeardownApprovalTimedOut",
+				fmt.Sprintf("InstaSlice slice of pod %s on node %s was not approved for teardown within %s, tearing it down", allocRequest.PodRef.Name, instasliceName, r.Teardown.Timeout))
+		}
+		return true
+	case err != nil:
+		log.Error(err, "unable to ask for approval of the teardown", "pod", allocRequest.PodRef.Name, "instaslice", instasliceName)
+	default:
+		log.Info("teardown of slice denied", "pod", allocRequest.PodRef.Name, "instaslice", instasliceName, "reason", response.Reason)
+		if r.Recorder != nil {
+			r.Recorder.Event(podRef, v1.EventTypeNormal, "TeardownDenied",
+				fmt.Sprintf("InstaSlice slice of pod %s on node %s is kept, teardown denied: %s", allocRequest.PodRef.Name, instasliceName, response.Reason))
+		}
+	}
+	return false
+}
+
+// teardownPendingResult turns a teardown awaiting approval into a requeue, other errors are returned as is
+func teardownPendingResult(result ctrl.Result, err error) (ctrl.Result, error) {
+	if errors.Is(err, errTeardownPending) {
+		return result, nil
+	}
+	return result, err
+}
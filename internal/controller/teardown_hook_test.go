@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+)
+
+func TestTeardownHook(t *testing.T) {
+	tests := []struct {
+		name     string
+		response TeardownResponse
+		// waited is how long the teardown already waited for approval
+		waited   time.Duration
+		deleting bool
+		event    string
+	}{
+		{
+			name:     "approved",
+			response: TeardownResponse{Approved: true},
+			deleting: true,
+		},
+		{
+			name:     "denied",
+			response: TeardownResponse{Reason: "checkpoint in progress"},
+			event:    "TeardownDenied",
+		},
+		{
+			name:     "approval timed out",
+			response: TeardownResponse{Reason: "checkpoint in progress"},
+			waited:   time.Hour,
+			deleting: true,
+			event:    "TeardownApprovalTimedOut",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			var received TeardownRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				assert.NoError(t, json.NewDecoder(req.Body).Decode(&received))
+				assert.NoError(t, json.NewEncoder(w).Encode(tt.response))
+			}))
+			defer server.Close()
+
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Spec.SchedulingGates = nil
+			pod.Status.Phase = v1.PodSucceeded
+			r, fakeClient := newTestReconciler(t, pod, newTestAllocation("node-a", pod, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			}))
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+			r.Teardown = NewTeardownHook(server.URL, 2*time.Minute)
+			if tt.waited > 0 {
+				r.Teardown.pending[teardownKey{podUID: pod.UID, instaslice: "node-a"}] = time.Now().Add(-tt.waited)
+			}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Equal(t, "pod-1", received.Pod)
+			assert.Equal(t, "1g.5gb", received.Profile)
+			assert.Equal(t, "node-a", received.Node)
+
+			instaslice := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+			if tt.deleting {
+				assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, allocResult.AllocationStatus.AllocationStatusController)
+				assert.Empty(t, r.Teardown.pending)
+			} else {
+				// the slice is kept and the webhook asked again later
+				assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, allocResult.AllocationStatus.AllocationStatusController)
+				assert.Equal(t, teardownRetryDelay, result.RequeueAfter)
+				assert.Len(t, r.Teardown.pending, 1)
+			}
+			if tt.event == "" {
+				assert.Empty(t, recorder.Events)
+			} else if assert.Len(t, recorder.Events, 1) {
+				assert.Contains(t, <-recorder.Events, tt.event)
+			}
+		})
+	}
+}
+
+func TestTeardownHook_Unreachable(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	created := newTestAllocation("node-a", pod, inferencev1alpha1.AllocationStatus{
+		AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+		AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+	})
+	r, _ := newTestReconciler(t, pod, created)
+	r.Teardown = NewTeardownHook(server.URL, 2*time.Minute)
+	allocResult, allocRequest := created.Status.PodAllocationResults[pod.UID], created.Spec.PodAllocationRequests[pod.UID]
+
+	// an endpoint that cannot answer holds the teardown like a denial
+	assert.False(t, r.awaitTeardownApproval(ctx, "node-a", &allocResult, &allocRequest))
+	assert.Equal(t, errTeardownPending, r.ReleaseAllocation(ctx, pod.UID))
+
+	// a slice the daemonset did not create holds no workload state, the webhook is not asked
+	allocResult.AllocationStatus.AllocationStatusDaemonset = ""
+	assert.True(t, r.awaitTeardownApproval(ctx, "node-a", &allocResult, &allocRequest))
+}
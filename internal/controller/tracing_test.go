@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func spanNames(recorder *tracetest.SpanRecorder) []string {
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	return names
+}
+
+func TestReconcile_AllocationSpans(t *testing.T) {
+	ctx := context.TODO()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	r, fakeClient := newTestReconciler(t, pod, utils.GenerateFakeCapacity("node-1"))
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{spanGating, spanFindNode, spanCommit, spanReconcile}, spanNames(recorder))
+	reconcileSpan := recorder.Ended()[3]
+	for _, span := range recorder.Ended()[:3] {
+		assert.Equal(t, reconcileSpan.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+
+	// the daemonset realizes the slice and the pod is ungated
+	instaslice := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, instaslice))
+	allocResult := instaslice.Status.PodAllocationResults[pod.UID]
+	allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Status.PodAllocationResults[pod.UID] = allocResult
+	assert.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	assert.NoError(t, fakeClient.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "pod-1-cm", Namespace: pod.Namespace}}))
+
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{spanGating, spanUngate, spanReconcile}, spanNames(recorder)[4:])
+}
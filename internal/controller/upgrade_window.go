@@ -0,0 +1,120 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// parseUpgradeWindow parses the upgrade window annotated on a node as an RFC 3339 start/end interval, e.g.
+// 2024-11-02T01:00:00Z/2024-11-02T03:00:00Z
+func parseUpgradeWindow(value string) (time.Time, time.Time, error) {
+	startValue, endValue, found := strings.Cut(value, "/")
+	if !found {
+		return time.Time{}, time.Time{}, fmt.Errorf("upgrade window %q is not a start/end interval", value)
+	}
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(startValue))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("upgrade window %q has a malformed start: %w", value, err)
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(endValue))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("upgrade window %q has a malformed end: %w", value, err)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("upgrade window %q ends before it starts", value)
+	}
+	return start, end, nil
+}
+
+// upgradeDrainStart returns when the node starts draining for the upgrade window annotated on it, the configured
+// drain lead ahead of the window so that the node is empty when the window opens. It reports false when the node
+// is not draining at the given time, i.e. it has no valid window or the window is not near or is over.
+func (r *InstasliceReconciler) upgradeDrainStart(ctx context.Context, nodeName string, now time.Time) (time.Time, time.Time, bool, error) {
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return time.Time{}, time.Time{}, false, nil
+		}
+		return time.Time{}, time.Time{}, false, err
+	}
+	value, ok := node.Annotations[UpgradeWindowAnnotation]
+	if !ok {
+		return time.Time{}, time.Time{}, false, nil
+	}
+	start, end, err := parseUpgradeWindow(value)
+	if err != nil {
+		// a malformed window does not keep the node from serving allocations
+		logr.FromContext(ctx).Error(err, "ignoring upgrade window", "node", nodeName)
+		return time.Time{}, time.Time{}, false, nil
+	}
+	drainStart := start.Add(-r.Config.UpgradeDrainLead)
+	return drainStart, start, !now.Before(drainStart) && now.Before(end), nil
+}
+
+// drainForUpgradeWindow releases the slices on a node draining for its upgrade window that no pod runs on yet,
+// warm slices included, so that their pods are allocated on other nodes. Running pods keep their slices until
+// they complete, no new slice is allocated on the node meanwhile. A slice the daemonset created is torn down by
+// it, one it has not picked up yet is marked deleted right away as nothing on the node would finish it.
+func (r *InstasliceReconciler) drainForUpgradeWindow(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	_, windowStart, draining, err := r.upgradeDrainStart(ctx, instaslice.Name, time.Now())
+	if err != nil || !draining {
+		return err
+	}
+	original := instaslice.DeepCopy()
+	var released []types.UID
+	for key, allocResult := range instaslice.Status.PodAllocationResults {
+		if allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusUngated ||
+			allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+			allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		if allocResult.AllocationStatus.AllocationStatusDaemonset == "" {
+			allocResult.AllocationStatus.AllocationStatusDaemonset = inferencev1alpha1.AllocationStatusDeleted
+		} else {
+			allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+		}
+		instaslice.Status.PodAllocationResults[key] = allocResult
+		released = append(released, key)
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	logr.FromContext(ctx).WithName(LogSubsystemDeletion).Info("releasing slices ahead of the upgrade window", "instaslice", instaslice.Name,
+		"windowStart", windowStart, "released", released)
+	utils.SetGPUStatus(instaslice)
+	if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	for _, key := range released {
+		allocRequest, allocResult := instaslice.Spec.PodAllocationRequests[key], instaslice.Status.PodAllocationResults[key]
+		r.Accounting.Emit(newAccountingRecord(AccountingEventRelease, &allocRequest, &allocResult))
+	}
+	return nil
+}
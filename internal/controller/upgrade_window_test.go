@@ -0,0 +1,141 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+func newTestUpgradeNode(name string, start, end time.Time) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: name,
+		Annotations: map[string]string{
+			UpgradeWindowAnnotation: fmt.Sprintf("%s/%s", start.Format(time.RFC3339), end.Format(time.RFC3339)),
+		},
+	}}
+}
+
+func TestReconcile_UpgradeWindow(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		start    time.Time
+		lead     time.Duration
+		draining bool
+	}{
+		{name: "window ahead", start: now.Add(time.Hour)},
+		{name: "window opened", start: now.Add(-time.Minute), draining: true},
+		{name: "within the drain lead", start: now.Add(30 * time.Minute), lead: time.Hour, draining: true},
+		{name: "window over", start: now.Add(-3 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			node := newTestUpgradeNode("node-a", tt.start, tt.start.Add(2*time.Hour))
+			// the running pod keeps its slice, the gated one is allocated afresh elsewhere
+			running := newTestGatedPod("running", "1g.5gb")
+			running.Spec.SchedulingGates = nil
+			instaslice := newTestAllocation("node-a", running, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusUngated,
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			})
+			gated := newTestGatedPod("gated", "1g.5gb")
+			gatedInstaslice := newTestAllocation("node-a", gated, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+			})
+			instaslice.Spec.PodAllocationRequests[gated.UID] = gatedInstaslice.Spec.PodAllocationRequests[gated.UID]
+			instaslice.Status.PodAllocationResults[gated.UID] = gatedInstaslice.Status.PodAllocationResults[gated.UID]
+			// a slice the daemonset created for a pod not ungated yet is torn down by the daemonset
+			created := newTestGatedPod("created", "1g.5gb")
+			createdInstaslice := newTestAllocation("node-a", created, inferencev1alpha1.AllocationStatus{
+				AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+				AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+			})
+			instaslice.Spec.PodAllocationRequests[created.UID] = createdInstaslice.Spec.PodAllocationRequests[created.UID]
+			instaslice.Status.PodAllocationResults[created.UID] = createdInstaslice.Status.PodAllocationResults[created.UID]
+
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			r, fakeClient := newTestReconciler(t, node, pod, running, gated, created, instaslice, utils.GenerateFakeCapacity("node-b"))
+			r.Config.UpgradeDrainLead = tt.lead
+
+			// draining begins as the window comes near, no slice is allocated on the node from then on
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			var instasliceList inferencev1alpha1.InstasliceList
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+			if slices := podSlices(pod.UID, instasliceList.Items); assert.Len(t, slices, 1) {
+				if tt.draining {
+					assert.Equal(t, "node-b", slices[0].instasliceName)
+				} else {
+					assert.Equal(t, "node-a", slices[0].instasliceName)
+				}
+			}
+
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			assert.NoError(t, r.drainForUpgradeWindow(ctx, instaslice))
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-a", Namespace: InstaSliceOperatorNamespace}, instaslice))
+			assert.Equal(t, inferencev1alpha1.AllocationStatusUngated,
+				instaslice.Status.PodAllocationResults[running.UID].AllocationStatus.AllocationStatusController)
+			gatedStatus := instaslice.Status.PodAllocationResults[gated.UID].AllocationStatus
+			createdStatus := instaslice.Status.PodAllocationResults[created.UID].AllocationStatus
+			if !tt.draining {
+				assert.Equal(t, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}, gatedStatus)
+				assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, createdStatus.AllocationStatusController)
+				return
+			}
+			// nothing on the node would finish a slice the daemonset has not picked up, it is deleted right away
+			assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, gatedStatus.AllocationStatusDaemonset)
+			assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, createdStatus.AllocationStatusController)
+			assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, createdStatus.AllocationStatusDaemonset)
+
+			// the released allocation is removed and the gated pod is allocated on another node
+			for i := 0; i < 2; i++ {
+				_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(gated)})
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, fakeClient.List(ctx, &instasliceList))
+			if slices := podSlices(gated.UID, instasliceList.Items); assert.Len(t, slices, 1) {
+				assert.Equal(t, "node-b", slices[0].instasliceName)
+				assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, slices[0].result.AllocationStatus.AllocationStatusController)
+			}
+		})
+	}
+}
+
+func TestParseUpgradeWindow(t *testing.T) {
+	start, end, err := parseUpgradeWindow("2024-11-02T01:00:00Z/2024-11-02T03:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, end.Sub(start))
+	for _, value := range []string{"2024-11-02T01:00:00Z", "tomorrow/2024-11-02T03:00:00Z", "2024-11-02T03:00:00Z/2024-11-02T01:00:00Z"} {
+		_, _, err := parseUpgradeWindow(value)
+		assert.Error(t, err, value)
+	}
+}
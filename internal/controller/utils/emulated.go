@@ -2,6 +2,7 @@ package utils
 
 import (
 	"github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/config"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -12,7 +13,7 @@ func GenerateFakeCapacity(nodeName string) *v1alpha1.Instaslice {
 	return &v1alpha1.Instaslice{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      nodeName,
-			Namespace: "instaslice-system",
+			Namespace: config.DefaultOperatorNamespace,
 		},
 		Spec: v1alpha1.InstasliceSpec{
 			PodAllocationRequests: map[types.UID]v1alpha1.AllocationRequest{},
@@ -0,0 +1,49 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// the allocation events of the counter
+const (
+	allocationEventCreated = "created"
+	allocationEventDeleted = "deleted"
+)
+
+// allocationsTotal counts the allocations stored on and removed from the Instaslice objects, allocation changes
+// made outside the helpers of this package are counted with RecordAllocationCreated and RecordAllocationDeleted
+var allocationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "instaslice_allocations_total",
+	Help: "Number of slice allocations created and deleted.",
+}, []string{"event", "profile"})
+
+func init() {
+	metrics.Registry.MustRegister(allocationsTotal)
+}
+
+// RecordAllocationCreated counts an allocation of the profile stored on an Instaslice
+func RecordAllocationCreated(profile string) {
+	allocationsTotal.WithLabelValues(allocationEventCreated, profile).Inc()
+}
+
+// RecordAllocationDeleted counts an allocation of the profile removed from an Instaslice
+func RecordAllocationDeleted(profile string) {
+	allocationsTotal.WithLabelValues(allocationEventDeleted, profile).Inc()
+}
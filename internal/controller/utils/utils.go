@@ -19,10 +19,15 @@ package utils
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
 
 	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/types"
@@ -31,68 +36,213 @@ import (
 	logr "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-const InstaSliceOperatorNamespace = "instaslice-system"
+func UpdateOrDeleteInstasliceAllocations(ctx context.Context, kubeClient client.Client, apiReader client.Reader, namespace, name string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) error {
+	if allocRequest == nil || allocRequest.PodRef.UID == "" {
+		return updateInstasliceAllocations(ctx, kubeClient, apiReader, namespace, name, nil, nil)
+	}
+	return updateInstasliceAllocations(ctx, kubeClient, apiReader, namespace, name, []inferencev1alpha1.AllocationResult{*allocResult}, []inferencev1alpha1.AllocationRequest{*allocRequest})
+}
+
+// AddInstasliceAllocations stores the allocations of all slices of a pod in one update, so that the
+// slices of the pod are never partially allocated. The results and requests are matched by position.
+func AddInstasliceAllocations(ctx context.Context, kubeClient client.Client, apiReader client.Reader, namespace, name string, allocResults []inferencev1alpha1.AllocationResult, allocRequests []inferencev1alpha1.AllocationRequest) error {
+	return updateInstasliceAllocations(ctx, kubeClient, apiReader, namespace, name, allocResults, allocRequests)
+}
+
+// SliceAllocationKey returns the key of the allocation of a slice of the pod, the first slice is keyed by the
+// pod UID and slice n > 0 by the pod UID suffixed with -n
+func SliceAllocationKey(podUID types.UID, sliceIndex int32) types.UID {
+	if sliceIndex == 0 {
+		return podUID
+	}
+	return types.UID(fmt.Sprintf("%s-%d", podUID, sliceIndex))
+}
 
-func UpdateOrDeleteInstasliceAllocations(ctx context.Context, kubeClient client.Client, name string, allocResult *inferencev1alpha1.AllocationResult, allocRequest *inferencev1alpha1.AllocationRequest) error {
+// IsSliceOfPod reports whether the allocation key belongs to a slice of the pod
+func IsSliceOfPod(key types.UID, podUID types.UID) bool {
+	return key == podUID || strings.HasPrefix(string(key), string(podUID)+"-")
+}
+
+// updateInstasliceAllocations patches the spec and then the status of the Instaslice. The Instaslice is read
+// through the API reader, bypassing the cache, and each patch is locked on the resource version read, so that a
+// concurrent update of the daemonset or of another reconcile is a conflict instead of being overwritten. Each
+// patch is retried on a conflict on a fresh read, so that a single reconcile converges instead of restarting.
+func updateInstasliceAllocations(ctx context.Context, kubeClient client.Client, apiReader client.Reader, namespace, name string, allocResults []inferencev1alpha1.AllocationResult, allocRequests []inferencev1alpha1.AllocationRequest) error {
 	var newInstaslice inferencev1alpha1.Instaslice
 	typeNamespacedName := types.NamespacedName{
 		Name:      name,
-		Namespace: InstaSliceOperatorNamespace,
-	}
-	err := kubeClient.Get(ctx, typeNamespacedName, &newInstaslice)
-	if err != nil {
-		return fmt.Errorf("error fetching the instaslice object: %s", name)
+		Namespace: namespace,
 	}
+	var keysToDelete []types.UID
+	var deletedProfiles map[types.UID]string
+	var originalInstaSliceObj *inferencev1alpha1.Instaslice
+	// a concurrent placement is a conflict of the placement, not of the write, and is never retried here
+	var placementErr error
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := apiReader.Get(ctx, typeNamespacedName, &newInstaslice); err != nil {
+			return fmt.Errorf("error fetching the instaslice object: %s", name)
+		}
 
-	originalInstaSliceObj := newInstaslice.DeepCopy()
+		// the allocations were placed on a possibly stale read, a slice placed concurrently on the same GPU indexes
+		// in the meantime makes the placement invalid and the pod is allocated again
+		if placementErr = checkConcurrentPlacements(&newInstaslice, allocResults, allocRequests); placementErr != nil {
+			return nil
+		}
 
-	if newInstaslice.Spec.PodAllocationRequests == nil {
-		newInstaslice.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
-	}
-	var keysToDelete []types.UID
-	for uuid, alloc := range newInstaslice.Status.PodAllocationResults {
-		if alloc.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
-			keysToDelete = append(keysToDelete, uuid)
+		originalInstaSliceObj = newInstaslice.DeepCopy()
+
+		if newInstaslice.Spec.PodAllocationRequests == nil {
+			newInstaslice.Spec.PodAllocationRequests = make(map[types.UID]inferencev1alpha1.AllocationRequest)
+		}
+		keysToDelete = nil
+		deletedProfiles = make(map[types.UID]string)
+		for uuid, alloc := range newInstaslice.Status.PodAllocationResults {
+			if alloc.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				keysToDelete = append(keysToDelete, uuid)
+				deletedProfiles[uuid] = newInstaslice.Spec.PodAllocationRequests[uuid].Profile
+			}
 		}
-	}
 
-	for _, uuid := range keysToDelete {
-		delete(newInstaslice.Spec.PodAllocationRequests, uuid)
-	}
-	if allocRequest != nil && allocRequest.PodRef.UID != "" {
-		newInstaslice.Spec.PodAllocationRequests[allocRequest.PodRef.UID] = *allocRequest
+		for _, uuid := range keysToDelete {
+			delete(newInstaslice.Spec.PodAllocationRequests, uuid)
+		}
+		for _, allocRequest := range allocRequests {
+			newInstaslice.Spec.PodAllocationRequests[SliceAllocationKey(allocRequest.PodRef.UID, allocRequest.SliceIndex)] = allocRequest
+		}
+		return kubeClient.Patch(ctx, &newInstaslice, client.MergeFromWithOptions(originalInstaSliceObj, client.MergeFromWithOptimisticLock{}))
+	})
+	if placementErr != nil {
+		return placementErr
 	}
-	err = kubeClient.Patch(ctx, &newInstaslice, client.MergeFrom(originalInstaSliceObj))
 	if err != nil {
-		return fmt.Errorf("error updating the instaslie object, %s, err: %v", name, err)
+		return fmt.Errorf("error updating the instaslie object, %s, err: %w", name, err)
 	}
 
-	err = kubeClient.Get(ctx, typeNamespacedName, &newInstaslice)
+	var createdProfiles []string
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := apiReader.Get(ctx, typeNamespacedName, &newInstaslice); err != nil {
+			return fmt.Errorf("error fetching the instaslice object: %s", name)
+		}
+
+		originalInstaSliceObj = newInstaslice.DeepCopy()
+
+		if newInstaslice.Status.PodAllocationResults == nil {
+			newInstaslice.Status.PodAllocationResults = make(map[types.UID]inferencev1alpha1.AllocationResult)
+		}
+		createdProfiles = nil
+		for _, allocRequest := range allocRequests {
+			if _, ok := newInstaslice.Status.PodAllocationResults[SliceAllocationKey(allocRequest.PodRef.UID, allocRequest.SliceIndex)]; !ok {
+				createdProfiles = append(createdProfiles, allocRequest.Profile)
+			}
+		}
+		for i, allocRequest := range allocRequests {
+			key := SliceAllocationKey(allocRequest.PodRef.UID, allocRequest.SliceIndex)
+			allocResult := allocResults[i]
+			// a released allocation is never taken back, e.g. by the daemonset reporting late a slice it created
+			if current, ok := newInstaslice.Status.PodAllocationResults[key]; ok &&
+				current.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting {
+				allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+			}
+			newInstaslice.Status.PodAllocationResults[key] = allocResult
+		}
+		for _, uuid := range keysToDelete {
+			delete(newInstaslice.Status.PodAllocationResults, uuid)
+		}
+		for i, allocRequest := range allocRequests {
+			log.FromContext(ctx).Info("setting status ", "controller", allocResults[i].AllocationStatus.AllocationStatusController, "podid", allocRequest.PodRef.UID)
+			log.FromContext(ctx).Info("setting status ", "daemonset", allocResults[i].AllocationStatus.AllocationStatusDaemonset, "podid", allocRequest.PodRef.UID)
+		}
+		SetGPUStatus(&newInstaslice)
+		return kubeClient.Status().Patch(ctx, &newInstaslice, client.MergeFromWithOptions(originalInstaSliceObj, client.MergeFromWithOptimisticLock{}))
+	})
 	if err != nil {
-		return fmt.Errorf("error fetching the instaslice object: %s", name)
+		log.FromContext(ctx).Info("error patching allocation result ", err, "instaslice", name)
+		return fmt.Errorf("error updating the instaslie object status, %s, err: %w", name, err)
+	}
+	for _, profile := range createdProfiles {
+		RecordAllocationCreated(profile)
 	}
+	for _, uuid := range keysToDelete {
+		if _, ok := originalInstaSliceObj.Status.PodAllocationResults[uuid]; ok {
+			RecordAllocationDeleted(deletedProfiles[uuid])
+		}
+	}
+	return nil
+}
 
-	originalInstaSliceObj = newInstaslice.DeepCopy()
+// checkConcurrentPlacements returns a conflict when a slice being added overlaps the GPU indexes of an
+// allocation of another pod on the freshly read Instaslice, i.e. one committed after the slice was placed on a
+// stale read. Allocations already present are updates and are not checked. The patches are locked on the read
+// checked here, an allocation committed in between fails the patch and is checked on the next read.
+func checkConcurrentPlacements(instaslice *inferencev1alpha1.Instaslice, allocResults []inferencev1alpha1.AllocationResult, allocRequests []inferencev1alpha1.AllocationRequest) error {
+	for i, allocRequest := range allocRequests {
+		key := SliceAllocationKey(allocRequest.PodRef.UID, allocRequest.SliceIndex)
+		if _, ok := instaslice.Status.PodAllocationResults[key]; ok {
+			continue
+		}
+		placement := allocResults[i].MigPlacement
+		for otherKey, other := range instaslice.Status.PodAllocationResults {
+			if IsSliceOfPod(otherKey, allocRequest.PodRef.UID) || other.GPUUUID != allocResults[i].GPUUUID ||
+				other.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			if placement.Start < other.MigPlacement.Start+other.MigPlacement.Size && other.MigPlacement.Start < placement.Start+placement.Size {
+				return apierrors.NewConflict(inferencev1alpha1.GroupVersion.WithResource("instaslices").GroupResource(), instaslice.Name,
+					fmt.Errorf("slice of pod %s overlaps allocation %s committed concurrently on GPU %s", allocRequest.PodRef.Name, otherKey, other.GPUUUID))
+			}
+		}
+	}
+	return nil
+}
 
-	if newInstaslice.Status.PodAllocationResults == nil {
-		newInstaslice.Status.PodAllocationResults = make(map[types.UID]inferencev1alpha1.AllocationResult)
+// GPUSliceSpan returns how many memory slice indexes the GPUs of the Instaslice span, the end of the
+// furthest placement they advertise. A node advertising no placements yet is taken to have the 8 indexes
+// of the A100 and H100.
+func GPUSliceSpan(instaslice *inferencev1alpha1.Instaslice) int32 {
+	var span int32
+	for _, migPlacement := range instaslice.Status.NodeResources.MigPlacement {
+		for _, placement := range migPlacement.Placements {
+			span = max(span, placement.Start+placement.Size)
+		}
 	}
-	if allocRequest != nil && allocRequest.PodRef.UID != "" {
-		newInstaslice.Status.PodAllocationResults[allocRequest.PodRef.UID] = *allocResult
+	if span == 0 {
+		return 8
 	}
-	for _, uuid := range keysToDelete {
-		delete(newInstaslice.Status.PodAllocationResults, uuid)
+	return span
+}
+
+// SetGPUStatus recomputes the per GPU slice accounting of the Instaslice from its allocations, along with
+// the totals of the node
+func SetGPUStatus(instaslice *inferencev1alpha1.Instaslice) {
+	gpuStatus := make(map[string]inferencev1alpha1.GPUStatus, len(instaslice.Status.NodeResources.NodeGPUs))
+	span := GPUSliceSpan(instaslice)
+	for _, gpu := range instaslice.Status.NodeResources.NodeGPUs {
+		gpuStatus[gpu.GPUUUID] = inferencev1alpha1.GPUStatus{TotalSlices: span, FreeSlices: span}
 	}
-	if allocRequest != nil && allocResult != nil {
-		log.FromContext(ctx).Info("setting status ", "controller", allocResult.AllocationStatus.AllocationStatusController, "podid", allocRequest.PodRef.UID)
-		log.FromContext(ctx).Info("setting status ", "daemonset", allocResult.AllocationStatus.AllocationStatusDaemonset, "podid", allocRequest.PodRef.UID)
+	for podUID, allocResult := range instaslice.Status.PodAllocationResults {
+		status, ok := gpuStatus[allocResult.GPUUUID]
+		if !ok || allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		status.UsedSlices += allocResult.MigPlacement.Size
+		status.FreeSlices = max(0, status.TotalSlices-status.UsedSlices)
+		if podRef := instaslice.Spec.PodAllocationRequests[podUID].PodRef; podRef.Name != "" {
+			status.Occupants = append(status.Occupants, podRef.Namespace+"/"+podRef.Name)
+		}
+		gpuStatus[allocResult.GPUUUID] = status
 	}
-	err = kubeClient.Status().Patch(ctx, &newInstaslice, client.MergeFrom(originalInstaSliceObj)) // TODO - try with update
-	if err != nil {
-		log.FromContext(ctx).Info("error patching allocation result ", err, "pod uuid", allocRequest.PodRef.UID)
-		return fmt.Errorf("error updating the instaslie object status, %s, err: %v", name, err)
+	var totalSlices, usedSlices, freeSlices int32
+	for gpuUUID, status := range gpuStatus {
+		sort.Strings(status.Occupants)
+		// a pod holding several slices of the GPU is listed once
+		status.Occupants = slices.Compact(status.Occupants)
+		gpuStatus[gpuUUID] = status
+		totalSlices += status.TotalSlices
+		usedSlices += status.UsedSlices
+		freeSlices += status.FreeSlices
 	}
-	return nil
+	instaslice.Status.GPUStatus = gpuStatus
+	instaslice.Status.TotalSlices, instaslice.Status.UsedSlices, instaslice.Status.FreeSlices = totalSlices, usedSlices, freeSlices
 }
 
 func RunningOnOpenshift(ctx context.Context, cl client.Client) bool {
@@ -0,0 +1,298 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logr "sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// Warm slices are allocated ahead of demand for a workload and realized by the daemonset like any other
+// allocation. A pod of the workload takes over a realized warm slice instead of waiting for its slice to be
+// created, which keeps the scale-up of autoscaled inference workloads fast.
+
+// warmPoolKey returns the key of the pool of the workload and profile in the warm pool configuration
+func warmPoolKey(workload, profileName string) string {
+	return workload + "/" + profileName
+}
+
+// isWarmSlice reports whether the allocation request holds a warm slice, its reference names the workload
+func isWarmSlice(allocRequest inferencev1alpha1.AllocationRequest) bool {
+	return allocRequest.PodRef.Kind == warmSliceKind
+}
+
+// warmSlicePod returns the pod standing in for a warm slice of the workload while it is placed, the
+// ConfigMap the daemonset creates for the slice is named after the key of the allocation
+func warmSlicePod(namespace, workload, profileName string, key types.UID) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      workload,
+			Namespace: namespace,
+			UID:       key,
+			Labels:    map[string]string{WarmPoolLabel: workload},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						v1.ResourceName(OrgInstaslicePrefix + "mig-" + profileName): resource.MustParse("1"),
+					},
+				},
+				EnvFrom: []v1.EnvFromSource{{
+					ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: string(key)}},
+				}},
+			}},
+		},
+	}
+}
+
+// reconcileWarmPools releases the warm slices that are no longer needed and tops up the pools of the
+// workloads that have pods. A warm slice is released once it stayed unclaimed past the TTL while its
+// workload has no pods, or when its pool shrank.
+func (r *InstasliceReconciler) reconcileWarmPools(ctx context.Context, instaslices []inferencev1alpha1.Instaslice) error {
+	log := logr.FromContext(ctx)
+	activeWorkloads := make(map[string]bool)
+	isActive := func(workload string) (bool, error) {
+		if active, ok := activeWorkloads[workload]; ok {
+			return active, nil
+		}
+		active, err := r.hasWorkloadPods(ctx, workload)
+		activeWorkloads[workload] = active
+		return active, err
+	}
+
+	warmSlices := make(map[string]int)
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		original := instaslice.DeepCopy()
+		var released bool
+		for key, allocRequest := range instaslice.Spec.PodAllocationRequests {
+			allocResult, ok := instaslice.Status.PodAllocationResults[key]
+			if !ok || !isWarmSlice(allocRequest) ||
+				allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusDeleting ||
+				allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusDeleted {
+				continue
+			}
+			poolKey := warmPoolKey(allocRequest.PodRef.Name, allocRequest.Profile)
+			expired := r.Config.WarmPoolTTL > 0 && allocResult.AllocatedAt != nil && time.Since(allocResult.AllocatedAt.Time) > r.Config.WarmPoolTTL
+			release := warmSlices[poolKey] >= r.Config.WarmPools[poolKey]
+			if !release && expired {
+				active, err := isActive(allocRequest.PodRef.Name)
+				if err != nil {
+					return err
+				}
+				release = !active
+			}
+			if !release {
+				warmSlices[poolKey]++
+				continue
+			}
+			log.Info("releasing warm slice", "instaslice", instaslice.Name, "pool", poolKey, "key", key)
+			allocResult.AllocationStatus.AllocationStatusController = inferencev1alpha1.AllocationStatusDeleting
+			instaslice.Status.PodAllocationResults[key] = allocResult
+			released = true
+		}
+		if released {
+			if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(original)); err != nil {
+				return err
+			}
+		}
+	}
+
+	poolKeys := make([]string, 0, len(r.Config.WarmPools))
+	for poolKey := range r.Config.WarmPools {
+		poolKeys = append(poolKeys, poolKey)
+	}
+	sort.Strings(poolKeys)
+	for _, poolKey := range poolKeys {
+		workload, profileName, found := strings.Cut(poolKey, "/")
+		missing := r.Config.WarmPools[poolKey] - warmSlices[poolKey]
+		if !found || missing <= 0 {
+			continue
+		}
+		active, err := isActive(workload)
+		if err != nil {
+			return err
+		}
+		if !active {
+			continue
+		}
+		for ; missing > 0; missing-- {
+			added, err := r.addWarmSlice(ctx, workload, profileName, instaslices)
+			if err != nil {
+				return err
+			}
+			if !added {
+				log.Info("no capacity left for warm slice", "pool", poolKey)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// hasWorkloadPods reports whether pods labeled with the workload exist that have not terminated
+func (r *InstasliceReconciler) hasWorkloadPods(ctx context.Context, workload string) (bool, error) {
+	var podList v1.PodList
+	if err := r.List(ctx, &podList, client.MatchingLabels{WarmPoolLabel: workload}); err != nil {
+		return false, err
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.DeletionTimestamp.IsZero() && pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addWarmSlice allocates a warm slice of the profile for the workload on the first node with room for it,
+// it reports false when no node has room
+func (r *InstasliceReconciler) addWarmSlice(ctx context.Context, workload, profileName string, instaslices []inferencev1alpha1.Instaslice) (bool, error) {
+	key := types.UID("warm-" + uuid.New().String())
+	pod := warmSlicePod(r.Config.OperatorNamespace, workload, profileName, key)
+	for i := range instaslices {
+		allocRequest, allocResult, err := r.findNodeAndDeviceForASlice(ctx, &instaslices[i], profileName, r.activePolicy(), pod)
+		if err != nil {
+			continue
+		}
+		allocRequest.PodRef.Kind = warmSliceKind
+		if err := utils.AddInstasliceAllocations(ctx, r.Client, r.apiReader(), r.Config.OperatorNamespace, instaslices[i].Name,
+			[]inferencev1alpha1.AllocationResult{*allocResult}, []inferencev1alpha1.AllocationRequest{*allocRequest}); err != nil {
+			return false, err
+		}
+		logr.FromContext(ctx).Info("added warm slice", "instaslice", instaslices[i].Name, "pool", warmPoolKey(workload, profileName), "key", key)
+		return true, nil
+	}
+	return false, nil
+}
+
+// claimWarmSlice hands a realized warm slice of the workload of the pod over to the pod, it reports false
+// when there is none to claim. Only pods requesting a single slice claim warm slices.
+func (r *InstasliceReconciler) claimWarmSlice(ctx context.Context, pod *v1.Pod, profileName string, sliceCount int32, instaslices []inferencev1alpha1.Instaslice) (bool, error) {
+	workload := pod.Labels[WarmPoolLabel]
+	if workload == "" || sliceCount != 1 {
+		return false, nil
+	}
+	container, err := gpuContainer(pod.Spec.Containers)
+	if err != nil || !hasSliceConfigMap(container) {
+		return false, nil
+	}
+	for i := range instaslices {
+		// a pod pinned to a node or GPU only claims a warm slice there
+		if !matchesPin(pod, &instaslices[i]) {
+			continue
+		}
+		// the warm slices of a node draining for its upgrade window are about to be released
+		if _, _, draining, err := r.upgradeDrainStart(ctx, instaslices[i].Name, time.Now()); err != nil || draining {
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+		for key, allocRequest := range instaslices[i].Spec.PodAllocationRequests {
+			allocResult := instaslices[i].Status.PodAllocationResults[key]
+			if !isWarmSlice(allocRequest) || allocRequest.PodRef.Name != workload || allocRequest.Profile != profileName ||
+				!isWarmSliceRealized(allocResult) || len(pinnedGPUs([]string{allocResult.GPUUUID}, pod)) == 0 {
+				continue
+			}
+			claimed, err := r.handOverWarmSlice(ctx, instaslices[i].Name, key, pod, container)
+			if err != nil || claimed {
+				return claimed, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// isWarmSliceRealized reports whether the daemonset created the warm slice and it is not being released
+func isWarmSliceRealized(allocResult inferencev1alpha1.AllocationResult) bool {
+	return allocResult.AllocationStatus.AllocationStatusController == inferencev1alpha1.AllocationStatusCreating &&
+		allocResult.AllocationStatus.AllocationStatusDaemonset == inferencev1alpha1.AllocationStatusCreated
+}
+
+// handOverWarmSlice moves the warm slice to the pod. The allocation of the pod keeps the GPU and placement
+// of the warm slice, the daemonset finds the slice already in place and only creates the ConfigMap of the pod.
+func (r *InstasliceReconciler) handOverWarmSlice(ctx context.Context, instasliceName string, warmKey types.UID, pod *v1.Pod, container *v1.Container) (bool, error) {
+	instaslice, err := r.getInstasliceObject(ctx, instasliceName, r.Config.OperatorNamespace)
+	if err != nil {
+		return false, err
+	}
+	warmRequest, ok := instaslice.Spec.PodAllocationRequests[warmKey]
+	if !ok || !isWarmSliceRealized(instaslice.Status.PodAllocationResults[warmKey]) {
+		// claimed by another pod or released meanwhile
+		return false, nil
+	}
+	warmResult := instaslice.Status.PodAllocationResults[warmKey]
+
+	allocRequest := warmRequest
+	allocRequest.PodRef = v1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	allocRequest.PriorityClassName = pod.Spec.PriorityClassName
+	allocRequest.Resources = v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    container.Resources.Requests[v1.ResourceCPU],
+			v1.ResourceMemory: container.Resources.Requests[v1.ResourceMemory],
+		},
+	}
+	allocResult := warmResult
+	allocResult.AllocationStatus = inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}
+	allocResult.ConfigMapResourceIdentifier = types.UID(container.EnvFrom[0].ConfigMapRef.Name)
+	allocatedAt := metav1.Now()
+	allocResult.AllocatedAt = &allocatedAt
+	podKey := utils.SliceAllocationKey(pod.UID, 0)
+
+	// the results are switched first, the daemonset skips a result without request but would clean up
+	// a realized warm slice whose request is gone
+	statusOriginal := instaslice.DeepCopy()
+	delete(instaslice.Status.PodAllocationResults, warmKey)
+	instaslice.Status.PodAllocationResults[podKey] = allocResult
+	utils.SetGPUStatus(instaslice)
+	if err := r.Status().Patch(ctx, instaslice, client.MergeFrom(statusOriginal)); err != nil {
+		return false, err
+	}
+	utils.RecordAllocationDeleted(warmRequest.Profile)
+	utils.RecordAllocationCreated(allocRequest.Profile)
+	specOriginal := instaslice.DeepCopy()
+	delete(instaslice.Spec.PodAllocationRequests, warmKey)
+	instaslice.Spec.PodAllocationRequests[podKey] = allocRequest
+	if err := r.Patch(ctx, instaslice, client.MergeFrom(specOriginal)); err != nil {
+		return false, err
+	}
+
+	// the ConfigMap created for the warm slice is not used by anyone
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: string(warmResult.ConfigMapResourceIdentifier), Namespace: warmRequest.PodRef.Namespace}}
+	if err := r.Delete(ctx, configMap); err != nil && !errors.IsNotFound(err) {
+		logr.FromContext(ctx).Error(err, "unable to delete the ConfigMap of the warm slice", "configMap", configMap.Name)
+	}
+	logr.FromContext(ctx).Info("claimed warm slice", "pod", pod.Name, "instaslice", instasliceName, "gpuUUID", allocResult.GPUUUID)
+	r.Accounting.Emit(newAccountingRecord(AccountingEventAllocate, &allocRequest, &allocResult))
+	return true, nil
+}
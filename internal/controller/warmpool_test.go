@@ -0,0 +1,260 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "github.com/openshift/instaslice-operator/api/v1alpha1"
+	"github.com/openshift/instaslice-operator/internal/controller/utils"
+)
+
+// newTestWarmSlice adds a warm slice of the workload realized by the daemonset at the start of the first GPU
+func newTestWarmSlice(instaslice *inferencev1alpha1.Instaslice, workload string, key types.UID, start int32, allocatedAt time.Time) {
+	instaslice.Spec.PodAllocationRequests[key] = inferencev1alpha1.AllocationRequest{
+		Profile: "1g.5gb",
+		PodRef:  v1.ObjectReference{Kind: warmSliceKind, Name: workload, Namespace: InstaSliceOperatorNamespace, UID: key},
+	}
+	warmAllocatedAt := metav1.NewTime(allocatedAt)
+	instaslice.Status.PodAllocationResults[key] = inferencev1alpha1.AllocationResult{
+		MigPlacement: inferencev1alpha1.Placement{Start: start, Size: 1},
+		GPUUUID:      instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID,
+		Nodename:     types.NodeName(instaslice.Name),
+		AllocationStatus: inferencev1alpha1.AllocationStatus{
+			AllocationStatusController: inferencev1alpha1.AllocationStatusCreating,
+			AllocationStatusDaemonset:  inferencev1alpha1.AllocationStatusCreated,
+		},
+		ConfigMapResourceIdentifier: key,
+		AllocatedAt:                 &warmAllocatedAt,
+	}
+}
+
+func TestReconcile_WarmSliceClaim(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	newTestWarmSlice(instaslice, "chat", "warm-1", 3, time.Now())
+	warmConfigMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "warm-1", Namespace: InstaSliceOperatorNamespace}}
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	pod.Labels = map[string]string{WarmPoolLabel: "chat"}
+	r, fakeClient := newTestReconciler(t, pod, instaslice, warmConfigMap)
+	created := map[string]string{"event": "created", "profile": "1g.5gb"}
+	createdBefore := metricValue(t, "instaslice_allocations_total", created)
+	deleted := map[string]string{"event": "deleted", "profile": "1g.5gb"}
+	deletedBefore := metricValue(t, "instaslice_allocations_total", deleted)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	// the allocation of the warm slice is replaced by the one of the pod
+	assert.Equal(t, createdBefore+1, metricValue(t, "instaslice_allocations_total", created))
+	assert.Equal(t, deletedBefore+1, metricValue(t, "instaslice_allocations_total", deleted))
+	assert.NotContains(t, current.Spec.PodAllocationRequests, types.UID("warm-1"))
+	assert.NotContains(t, current.Status.PodAllocationResults, types.UID("warm-1"))
+	// the pod takes the place of the warm slice, the daemonset finds it realized and creates the ConfigMap of the pod
+	allocResult := current.Status.PodAllocationResults[pod.UID]
+	assert.Equal(t, int32(3), allocResult.MigPlacement.Start)
+	assert.Equal(t, instaslice.Status.NodeResources.NodeGPUs[0].GPUUUID, allocResult.GPUUUID)
+	assert.Equal(t, types.UID("pod-1-cm"), allocResult.ConfigMapResourceIdentifier)
+	assert.Equal(t, inferencev1alpha1.AllocationStatus{AllocationStatusController: inferencev1alpha1.AllocationStatusCreating}, allocResult.AllocationStatus)
+	assert.Equal(t, pod.Name, current.Spec.PodAllocationRequests[pod.UID].PodRef.Name)
+	assert.True(t, errors.IsNotFound(fakeClient.Get(ctx, client.ObjectKeyFromObject(warmConfigMap), &v1.ConfigMap{})))
+}
+
+func TestReconcile_WarmSliceOtherWorkload(t *testing.T) {
+	ctx := context.TODO()
+	instaslice := utils.GenerateFakeCapacity("node-1")
+	newTestWarmSlice(instaslice, "chat", "warm-1", 3, time.Now())
+	pod := newTestGatedPod("pod-1", "1g.5gb")
+	pod.Finalizers = []string{FinalizerName}
+	pod.Labels = map[string]string{WarmPoolLabel: "search"}
+	r, fakeClient := newTestReconciler(t, pod, instaslice)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	assert.NoError(t, err)
+
+	current := &inferencev1alpha1.Instaslice{}
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+	// the warm slice stays reserved for its workload, the pod gets a slice of its own
+	assert.Contains(t, current.Status.PodAllocationResults, types.UID("warm-1"))
+	assert.NotEqual(t, int32(3), current.Status.PodAllocationResults[pod.UID].MigPlacement.Start)
+}
+
+func TestReconcile_WarmSlicePinnedElsewhere(t *testing.T) {
+	ctx := context.TODO()
+	tests := []struct {
+		name     string
+		annotate func(pod *v1.Pod, instaslice *inferencev1alpha1.Instaslice)
+		node     string
+		// gpu is the index of the GPU the pod is allocated on, any when negative
+		gpu int
+	}{
+		{
+			name: "pinned to another node",
+			annotate: func(pod *v1.Pod, _ *inferencev1alpha1.Instaslice) {
+				pod.Annotations[PinNodeAnnotation] = "node-2"
+			},
+			node: "node-2",
+			gpu:  -1,
+		},
+		{
+			name: "pinned to another GPU",
+			annotate: func(pod *v1.Pod, instaslice *inferencev1alpha1.Instaslice) {
+				pod.Annotations[PinGPUAnnotation] = instaslice.Status.NodeResources.NodeGPUs[1].GPUUUID
+			},
+			node: "node-1",
+			gpu:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			newTestWarmSlice(instaslice, "chat", "warm-1", 3, time.Now())
+			pod := newTestGatedPod("pod-1", "1g.5gb")
+			pod.Finalizers = []string{FinalizerName}
+			pod.Labels = map[string]string{WarmPoolLabel: "chat"}
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			tt.annotate(pod, instaslice)
+			other := utils.GenerateFakeCapacity("node-2")
+			r, fakeClient := newTestReconciler(t, pod, instaslice, other)
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+
+			// the warm slice is not claimed, the pod gets a slice where it is pinned
+			current := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+			assert.Contains(t, current.Status.PodAllocationResults, types.UID("warm-1"))
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: tt.node, Namespace: InstaSliceOperatorNamespace}, current))
+			if allocResult, ok := current.Status.PodAllocationResults[pod.UID]; assert.True(t, ok) && tt.gpu >= 0 {
+				assert.Equal(t, current.Status.NodeResources.NodeGPUs[tt.gpu].GPUUUID, allocResult.GPUUUID)
+			}
+		})
+	}
+}
+
+func TestReconcileWarmPools(t *testing.T) {
+	ctx := context.TODO()
+	workloadPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-0", Namespace: "default", Labels: map[string]string{WarmPoolLabel: "chat"}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	// the sweep releases the allocations of Instaslice objects whose node is gone
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	warmSlices := func(instaslice *inferencev1alpha1.Instaslice) map[types.UID]inferencev1alpha1.AllocationStatus {
+		statuses := make(map[types.UID]inferencev1alpha1.AllocationStatus)
+		for key, allocRequest := range instaslice.Spec.PodAllocationRequests {
+			if isWarmSlice(allocRequest) {
+				statuses[key] = instaslice.Status.PodAllocationResults[key].AllocationStatus
+			}
+		}
+		return statuses
+	}
+
+	t.Run("pool of an active workload is filled", func(t *testing.T) {
+		r, fakeClient := newTestReconciler(t, utils.GenerateFakeCapacity("node-1"), node, workloadPod)
+		r.Config.WarmPools = map[string]int{"chat/1g.5gb": 2}
+		assert.NoError(t, r.sweepInstaslices(ctx))
+
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		warm := warmSlices(current)
+		assert.Len(t, warm, 2)
+		for key, status := range warm {
+			assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, status.AllocationStatusController)
+			assert.Equal(t, "chat", current.Spec.PodAllocationRequests[key].PodRef.Name)
+			assert.Equal(t, key, current.Status.PodAllocationResults[key].ConfigMapResourceIdentifier)
+		}
+
+		// a full pool is left as is
+		assert.NoError(t, r.sweepInstaslices(ctx))
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		assert.Len(t, warmSlices(current), 2)
+	})
+
+	t.Run("pool of a workload without pods is not filled", func(t *testing.T) {
+		r, fakeClient := newTestReconciler(t, utils.GenerateFakeCapacity("node-1"), node)
+		r.Config.WarmPools = map[string]int{"chat/1g.5gb": 2}
+		assert.NoError(t, r.sweepInstaslices(ctx))
+
+		current := &inferencev1alpha1.Instaslice{}
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+		assert.Empty(t, warmSlices(current))
+	})
+
+	tests := []struct {
+		name        string
+		allocatedAt time.Time
+		objs        []client.Object
+		pools       map[string]int
+		wantStatus  inferencev1alpha1.AllocationStatusController
+	}{
+		{
+			name:        "unclaimed past the TTL without workload pods is released",
+			allocatedAt: time.Now().Add(-time.Hour),
+			pools:       map[string]int{"chat/1g.5gb": 1},
+			wantStatus:  inferencev1alpha1.AllocationStatusDeleting,
+		},
+		{
+			name:        "unclaimed within the TTL is kept",
+			allocatedAt: time.Now(),
+			pools:       map[string]int{"chat/1g.5gb": 1},
+			wantStatus:  inferencev1alpha1.AllocationStatusCreating,
+		},
+		{
+			name:        "unclaimed past the TTL while the workload has pods is kept",
+			allocatedAt: time.Now().Add(-time.Hour),
+			objs:        []client.Object{workloadPod},
+			pools:       map[string]int{"chat/1g.5gb": 1},
+			wantStatus:  inferencev1alpha1.AllocationStatusCreating,
+		},
+		{
+			name:        "beyond the size of its pool is released",
+			allocatedAt: time.Now(),
+			objs:        []client.Object{workloadPod},
+			wantStatus:  inferencev1alpha1.AllocationStatusDeleting,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instaslice := utils.GenerateFakeCapacity("node-1")
+			newTestWarmSlice(instaslice, "chat", "warm-1", 0, tt.allocatedAt)
+			r, fakeClient := newTestReconciler(t, append([]client.Object{instaslice, node}, tt.objs...)...)
+			r.Config.WarmPools = tt.pools
+			r.Config.WarmPoolTTL = 15 * time.Minute
+			assert.NoError(t, r.sweepInstaslices(ctx))
+
+			current := &inferencev1alpha1.Instaslice{}
+			assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: InstaSliceOperatorNamespace}, current))
+			assert.Equal(t, tt.wantStatus, current.Status.PodAllocationResults["warm-1"].AllocationStatus.AllocationStatusController)
+		})
+	}
+}